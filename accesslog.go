@@ -0,0 +1,138 @@
+package gas
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AccessEntry describes a request that has been answered, for the access log.
+type AccessEntry struct {
+	Time      time.Time // when the request came in
+	Duration  time.Duration
+	RequestID string
+	Client    string // see Gas.ClientIP
+	User      string // see Gas.SetUser
+	Proto     string
+	Method    string
+	Host      string // without the port
+	Path      string
+	Query     string
+	Route     string // the pattern of the route that matched, if any
+	Status    int
+	Bytes     int64 // of the response body
+	Referer   string
+	UserAgent string
+}
+
+// AccessLog replaces the access log record written for each request with a
+// call to f, e.g. CommonLog or CombinedLog. A nil f turns the access log off.
+// By default, each request gets a "request" record in Logger, which is JSON
+// if GAS_LOG_FORMAT is "json".
+func (r *Router) AccessLog(f func(e *AccessEntry)) *Router {
+	if f == nil {
+		f = func(*AccessEntry) {}
+	}
+	r.root().accessLog = f
+	return r
+}
+
+// NoAccessLog leaves the most recently added route (both of them, after Get)
+// out of the access log, e.g. for a check that load balancers poll.
+func (r *Router) NoAccessLog() *Router {
+	for _, route := range r.lastRoutes() {
+		route.noAccessLog = true
+	}
+	return r
+}
+
+// CommonLog returns an access log func for Router.AccessLog that writes each
+// request to w in the Common Log Format.
+func CommonLog(w io.Writer) func(e *AccessEntry) {
+	return clfLog(w, false)
+}
+
+// CombinedLog is like CommonLog, but uses the Combined Log Format, which adds
+// the referer and user agent.
+func CombinedLog(w io.Writer) func(e *AccessEntry) {
+	return clfLog(w, true)
+}
+
+func clfLog(w io.Writer, combined bool) func(e *AccessEntry) {
+	var mu sync.Mutex
+	return func(e *AccessEntry) {
+		uri := e.Path
+		if e.Query != "" {
+			uri += "?" + e.Query
+		}
+		line := fmt.Sprintf("%s - %s [%s] %s %d %s",
+			clfField(e.Client), clfField(e.User), e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+			strconv.Quote(e.Method+" "+uri+" "+e.Proto), e.Status, clfBytes(e.Bytes))
+		if combined {
+			line += " " + strconv.Quote(clfField(e.Referer)) + " " + strconv.Quote(clfField(e.UserAgent))
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		io.WriteString(w, line+"\n")
+	}
+}
+
+func clfField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func clfBytes(n int64) string {
+	if n == 0 {
+		return "-"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// logAccess writes the access log entry for g, which arrived at start and
+// was answered by route, if any.
+func (r *Router) logAccess(g *Gas, route *route, start time.Time) {
+	if route != nil && route.noAccessLog {
+		return
+	}
+	host, _, err := net.SplitHostPort(g.Host)
+	if err != nil {
+		host = g.Host
+	}
+	status := g.responseCode
+	if status == 0 {
+		status = 200
+	}
+	e := &AccessEntry{
+		Time:      start,
+		Duration:  time.Since(start),
+		RequestID: g.id,
+		Client:    g.ClientIP(),
+		User:      g.User(),
+		Proto:     g.Proto,
+		Method:    g.Method,
+		Host:      host,
+		Path:      g.URL.Path,
+		Query:     g.URL.RawQuery,
+		Route:     g.pattern,
+		Status:    status,
+		Bytes:     g.written,
+		Referer:   g.Referer(),
+		UserAgent: g.UserAgent(),
+	}
+
+	if f := r.root().accessLog; f != nil {
+		f(e)
+		return
+	}
+	g.Log().Info("request", "duration", e.Duration, "proto", e.Proto,
+		"method", e.Method, "status", e.Status, "host", e.Host,
+		"path", e.Path, "bytes", e.Bytes, "referer", e.Referer,
+		"user_agent", e.UserAgent)
+}
@@ -0,0 +1,51 @@
+package gas
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	buf := new(bytes.Buffer)
+	r := New().
+		AccessLog(CombinedLog(buf)).
+		Get("/users/{id}", func(g *Gas) (int, Outputter) {
+			g.SetUser("moshee")
+			g.Write([]byte("hello"))
+			return g.Stop()
+		}).
+		Get("/ping", func(g *Gas) (int, Outputter) {
+			return 204, nil
+		}).NoAccessLog()
+
+	req := httptest.NewRequest("GET", "/users/1?full=1", nil)
+	req.RemoteAddr = "192.0.2.7:4321"
+	req.Header.Set("Referer", "https://example.com/")
+	req.Header.Set("User-Agent", "test/1.0")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/ping", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/nope", nil))
+
+	expected := regexp.MustCompile(`^192\.0\.2\.7 - moshee \[\d\d/\w{3}/\d{4}:\d\d:\d\d:\d\d [-+]\d{4}\] "GET /users/1\?full=1 HTTP/1\.1" 200 5 "https://example\.com/" "test/1\.0"
+192\.0\.2\.1 - - \[[^]]+\] "GET /nope HTTP/1\.1" 404 19 "-" "-"
+$`)
+	if !expected.Match(buf.Bytes()) {
+		t.Errorf("unexpected access log:\n%s", buf)
+	}
+
+	var entries []*AccessEntry
+	r.AccessLog(func(e *AccessEntry) { entries = append(entries, e) })
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/2", nil))
+	if len(entries) != 1 || entries[0].Route != "/users/{id}" || entries[0].Status != 200 || entries[0].Bytes != 5 || entries[0].RequestID == "" {
+		t.Errorf("unexpected entries: %+v", entries)
+	}
+
+	buf.Reset()
+	r.AccessLog(nil)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/3", nil))
+	if buf.Len() > 0 {
+		t.Errorf("expected no access log, got %s", buf)
+	}
+}
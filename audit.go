@@ -0,0 +1,100 @@
+package gas
+
+// audit.go records who changed what, for apps that need to be able to tell.
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ktkr.us/pkg/gas/notify"
+)
+
+// AuditEntry is published by the Audit middleware for each state-changing
+// request once it has been answered.
+type AuditEntry struct {
+	Time      time.Time
+	Actor     string            // see Gas.SetUser
+	Action    string            // the method and route, e.g. "DELETE /users/{id}"
+	Target    map[string]string // the arguments captured from the URL
+	IP        string            // see Gas.ClientIP
+	Status    int
+	RequestID string
+}
+
+// Audit is middleware that publishes an AuditEntry for every POST, PUT, PATCH,
+// and DELETE request it sees. The actor is whoever was given to SetUser by the
+// time the response is written, so Audit can come before or after the
+// authentication middleware. Subscribe to *AuditEntry to store them somewhere,
+// e.g. with db.AuditTable or Forward:
+//
+//	r.Use(requireUser, gas.Audit)
+//	db.AuditTable("audit_log")
+func Audit(g *Gas) (int, Outputter) {
+	switch g.Method {
+	case "POST", "PUT", "PATCH", "DELETE":
+	default:
+		return g.Continue()
+	}
+
+	start := time.Now()
+	code, o := g.Continue()
+	if o == nil {
+		publishAudit(g, start, code)
+		return code, nil
+	}
+	return code, OutputFunc(func(code int, g *Gas) {
+		o.Output(code, g)
+		publishAudit(g, start, code)
+	})
+}
+
+func publishAudit(g *Gas, start time.Time, code int) {
+	status := g.responseCode
+	if status == 0 {
+		status = code
+	}
+	target := make(map[string]string, len(g.args))
+	for _, a := range g.args {
+		target[a.name] = a.value
+	}
+	Publish(&AuditEntry{
+		Time:      start,
+		Actor:     g.User(),
+		Action:    g.Method + " " + g.pattern,
+		Target:    target,
+		IP:        g.ClientIP(),
+		Status:    status,
+		RequestID: g.id,
+	})
+}
+
+// NotifyEvent makes e into an "audit" event.
+func (e *AuditEntry) NotifyEvent() *notify.Event {
+	actor := e.Actor
+	if actor == "" {
+		actor = "anonymous"
+	}
+	fields := map[string]string{
+		"action":     e.Action,
+		"ip":         e.IP,
+		"status":     strconv.Itoa(e.Status),
+		"request_id": e.RequestID,
+	}
+	var target []string
+	for k, v := range e.Target {
+		target = append(target, k+"="+v)
+	}
+	sort.Strings(target)
+	if len(target) > 0 {
+		fields["target"] = strings.Join(target, " ")
+	}
+	return &notify.Event{
+		Kind:   "audit",
+		Time:   e.Time,
+		Title:  actor + ": " + e.Action,
+		User:   e.Actor,
+		Fields: fields,
+	}
+}
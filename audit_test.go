@@ -0,0 +1,57 @@
+package gas
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"ktkr.us/pkg/gas/testutil"
+)
+
+func TestAudit(t *testing.T) {
+	entries := make(chan *AuditEntry, 10)
+	sub := Subscribe(func(e *AuditEntry) { entries <- e })
+	defer sub.Close()
+
+	r := New().Use(Audit).
+		Get("/users/{id}", func(g *Gas) (int, Outputter) {
+			return 200, nil
+		}).
+		Delete("/users/{id}", func(g *Gas) (int, Outputter) {
+			g.SetUser("admin")
+			return 204, OutputFunc(func(code int, g *Gas) {
+				g.WriteHeader(code)
+			})
+		})
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	resp, err := testutil.Client.Get(srv.URL + "/users/5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	req, _ := http.NewRequest("DELETE", srv.URL+"/users/5", nil)
+	if resp, err = testutil.Client.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	e := <-entries
+	if e.Actor != "admin" || e.Action != "DELETE /users/{id}" || e.Status != 204 ||
+		!reflect.DeepEqual(e.Target, map[string]string{"id": "5"}) || e.IP != "127.0.0.1" || e.RequestID == "" {
+		t.Errorf("got %+v", e)
+	}
+	select {
+	case e := <-entries:
+		t.Errorf("expected only the DELETE to be audited, got %+v", e)
+	default:
+	}
+
+	n := e.NotifyEvent()
+	if n.Kind != "audit" || n.Title != "admin: DELETE /users/{id}" || n.Fields["target"] != "id=5" {
+		t.Errorf("got %+v", n)
+	}
+}
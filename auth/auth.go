@@ -2,14 +2,16 @@ package auth
 
 import (
 	"bytes"
+	"crypto/cipher"
 	"crypto/hmac"
 	"crypto/rand"
 	"database/sql"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -17,7 +19,10 @@ import (
 	"time"
 
 	"ktkr.us/pkg/gas"
+	"ktkr.us/pkg/gas/notify"
 
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
 	"golang.org/x/crypto/scrypt"
 	"golang.org/x/crypto/sha3"
 )
@@ -26,8 +31,10 @@ var (
 	ErrBadPassword   = errors.New("invalid username or password")
 	ErrCookieExpired = errors.New("session cookie expired")
 	ErrBadMac        = errors.New("HMAC digests don't match")
+	ErrBadCiphertext = errors.New("cookie can't be decrypted with any of the keys")
 	ErrNoStore       = errors.New("no session store is configured")
 	hmacKeys         [][]byte
+	aeads            []cipher.AEAD
 	store            SessionStore
 )
 
@@ -46,6 +53,15 @@ var Env struct {
 	// right.
 	CookieAuthKey []byte
 
+	// The key used to encrypt cookies with XChaCha20-Poly1305 (see
+	// EncryptCookie), which also keeps them from being tampered with. If
+	// it's blank, cookies aren't encrypted. Like COOKIE_AUTH_KEY, several
+	// os.PathListSeparator-separated keys can be given to rotate them: new
+	// cookies are encrypted with the first, and the rest are only used to
+	// decrypt. Keys can be any length, but should be at least 32 random
+	// bytes.
+	CookieEncryptKey []byte
+
 	// The length of the session ID in bytes
 	SessidLen int `default:"64"`
 
@@ -60,13 +76,24 @@ var Env struct {
 
 func init() {
 	if err := gas.EnvConf(&Env, gas.EnvPrefix); err != nil {
-		log.Fatalf("auth (init): %v", err)
+		gas.Logger().Error("auth (init)", "err", err)
+		os.Exit(1)
 	}
 
 	if len(Env.CookieAuthKey) > 0 {
 		hmacKeys = bytes.Split(Env.CookieAuthKey, []byte{byte(os.PathListSeparator)})
 	}
+	if len(Env.CookieEncryptKey) > 0 {
+		for _, key := range bytes.Split(Env.CookieEncryptKey, []byte{byte(os.PathListSeparator)}) {
+			aeads = append(aeads, newAEAD(key))
+		}
+	}
 
+	// flash messages get signed like the session cookie, or encrypted if
+	// there's a key for it; without either key, gas signs them itself
+	if len(hmacKeys) > 0 || len(aeads) > 0 {
+		gas.UseFlashStore(&gas.CookieFlashStore{Sign: sealCookie, Verify: openCookie})
+	}
 }
 
 // A User is a generic representation of a user with some common traits
@@ -118,7 +145,7 @@ func (s *FileStore) Path(id []byte) string {
 
 func (s *FileStore) Destroy() {
 	if err := os.RemoveAll(s.Root); err != nil {
-		log.Print(err)
+		gas.Logger().Error("auth: destroying file store", "err", err)
 	}
 }
 
@@ -242,6 +269,7 @@ func GetSession(g *gas.Gas) (*Session, error) {
 	}
 
 	g.SetData(sessKey, sess)
+	g.SetUser(sess.Username)
 
 	return sess, nil
 }
@@ -282,6 +310,12 @@ func SignIn(g *gas.Gas, u User, password string) error {
 		return err
 	}
 	if !VerifyHash([]byte(password), pass, salt) {
+		gas.Publish(&Failure{
+			Time:     time.Now(),
+			Username: u.Username(),
+			Err:      ErrBadPassword,
+			Request:  g.RequestInfo(),
+		})
 		return ErrBadPassword
 	}
 
@@ -304,10 +338,31 @@ func SignIn(g *gas.Gas, u User, password string) error {
 	SignCookie(cookie)
 
 	g.SetCookie(cookie)
+	g.SetUser(username)
 
 	return nil
 }
 
+// Failure is published on the gas event bus when somebody fails to sign in.
+type Failure struct {
+	Time     time.Time
+	Username string
+	Err      error
+	Request  gas.RequestInfo
+}
+
+// NotifyEvent makes f into an "auth_failure" event.
+func (f *Failure) NotifyEvent() *notify.Event {
+	return &notify.Event{
+		Kind:    "auth_failure",
+		Time:    f.Time,
+		Title:   fmt.Sprintf("sign in failed for %q", f.Username),
+		Error:   f.Err.Error(),
+		Request: f.Request.NotifyRequest(),
+		User:    f.Username,
+	}
+}
+
 // SignOut signs the user out, destroying the associated session and cookie.
 func SignOut(g *gas.Gas) error {
 	if store == nil {
@@ -401,6 +456,91 @@ func AddHMACKey(key []byte) {
 	hmacKeys = append([][]byte{key}, hmacKeys...)
 }
 
+// EncryptCookie encrypts and authenticates a cookie's value with the first
+// configured encryption key, if there is one. The cookie's name is
+// authenticated along with it, so that the value can't be moved to another
+// cookie.
+func EncryptCookie(cookie *http.Cookie) {
+	if len(aeads) == 0 {
+		return
+	}
+	aead := aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(cookie.Value)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(cookie.Value), []byte(cookie.Name))
+	cookie.Value = base64.RawURLEncoding.EncodeToString(sealed)
+}
+
+// DecryptCookie replaces a cookie's value with its decrypted contents, trying
+// each of the configured encryption keys. It does nothing if there aren't any.
+func DecryptCookie(cookie *http.Cookie) error {
+	if len(aeads) == 0 {
+		return nil
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return err
+	}
+	for _, aead := range aeads {
+		if len(sealed) < aead.NonceSize() {
+			break
+		}
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		if p, err := aead.Open(nil, nonce, ciphertext, []byte(cookie.Name)); err == nil {
+			cookie.Value = string(p)
+			return nil
+		}
+	}
+	return ErrBadCiphertext
+}
+
+// AddEncryptionKey adds a key to encrypt cookies with, ahead of the rest, like
+// AddHMACKey.
+func AddEncryptionKey(key []byte) {
+	aeads = append([]cipher.AEAD{newAEAD(key)}, aeads...)
+}
+
+// newAEAD derives a key of the right size from key, which doesn't have to be
+// one already.
+func newAEAD(key []byte) cipher.AEAD {
+	k := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha3.New256, key, nil, []byte("gas cookie encryption")), k); err != nil {
+		panic(err)
+	}
+	aead, err := chacha20poly1305.NewX(k)
+	if err != nil {
+		panic(err)
+	}
+	return aead
+}
+
+// sealCookie encrypts the cookie if there's a key for it, and otherwise signs
+// it.
+func sealCookie(cookie *http.Cookie) {
+	if len(aeads) > 0 {
+		EncryptCookie(cookie)
+	} else {
+		SignCookie(cookie)
+	}
+}
+
+// openCookie undoes sealCookie. Unlike VerifyCookie, it doesn't let through
+// values that were never signed.
+func openCookie(cookie *http.Cookie) error {
+	if len(aeads) > 0 {
+		return DecryptCookie(cookie)
+	}
+	if len(hmacKeys) == 0 {
+		return ErrBadMac
+	}
+	if p, err := base64.StdEncoding.DecodeString(cookie.Value); err != nil || len(p) < macLength {
+		return ErrBadMac
+	}
+	return VerifyCookie(cookie)
+}
+
 // VerifyHash checks if the supplied passphrase matches the expected hash using
 // the salt.
 func VerifyHash(supplied, expected, salt []byte) bool {
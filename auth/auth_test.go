@@ -183,6 +183,72 @@ func testAuth(t *testing.T, testPass string, r *gas.Router) {
 	tester.try("/hmac", "no", nil)
 }
 
+func TestEncryptCookie(t *testing.T) {
+	t.Cleanup(auth.SaveEncryptionKeys())
+	auth.AddEncryptionKey([]byte("old key"))
+	cookie := &http.Cookie{Name: "prefs", Value: "theme=dark"}
+	auth.EncryptCookie(cookie)
+	old := *cookie
+	sealed := old.Value
+	if cookie.Value == "theme=dark" {
+		t.Fatal("expected the value to be encrypted")
+	}
+
+	// rotated: new cookies use the new key, and old ones can still be read
+	auth.AddEncryptionKey([]byte("new key"))
+	cookie = &http.Cookie{Name: "prefs", Value: "theme=dark"}
+	auth.EncryptCookie(cookie)
+	for _, c := range []*http.Cookie{cookie, &old} {
+		if err := auth.DecryptCookie(c); err != nil || c.Value != "theme=dark" {
+			t.Errorf("expected to decrypt the cookie, got %q (%v)", c.Value, err)
+		}
+	}
+
+	b, _ := base64.RawURLEncoding.DecodeString(sealed)
+	b[len(b)/2] ^= 1
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"prefs", base64.RawURLEncoding.EncodeToString(b)},
+		{"other", ""},
+		{"prefs", "AAAA"},
+	}
+	for _, test := range tests {
+		c := &http.Cookie{Name: "prefs", Value: "theme=dark"}
+		auth.EncryptCookie(c)
+		c.Name = test.name
+		if test.value != "" {
+			c.Value = test.value
+		}
+		if err := auth.DecryptCookie(c); err != auth.ErrBadCiphertext {
+			t.Errorf("%s=%s: expected %v, got %v", test.name, test.value, auth.ErrBadCiphertext, err)
+		}
+	}
+}
+
+func TestOpenCookie(t *testing.T) {
+	t.Cleanup(auth.SaveHMACKeys())
+	t.Cleanup(auth.SaveEncryptionKeys())
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`[{"k":"info","m":"forged"}]`))
+
+	if err := auth.OpenCookie(&http.Cookie{Name: "_flash", Value: forged}); err == nil {
+		t.Error("expected an error without any keys")
+	}
+
+	auth.AddHMACKey([]byte("flash key"))
+	cookie := &http.Cookie{Name: "_flash", Value: forged}
+	auth.SealCookie(cookie)
+	if err := auth.OpenCookie(cookie); err != nil || cookie.Value != forged {
+		t.Errorf("expected to open the signed cookie, got %q (%v)", cookie.Value, err)
+	}
+	for _, value := range []string{forged, "aGk=", "", "not base64!"} {
+		if err := auth.OpenCookie(&http.Cookie{Name: "_flash", Value: value}); err != auth.ErrBadMac {
+			t.Errorf("%q: expected %v, got %v", value, auth.ErrBadMac, err)
+		}
+	}
+}
+
 type authTester struct {
 	srv    *httptest.Server
 	client *http.Client
@@ -0,0 +1,20 @@
+package auth
+
+// SaveEncryptionKeys returns a func that puts back the cookie encryption keys
+// as they are now, for tests that add their own.
+func SaveEncryptionKeys() (restore func()) {
+	saved := aeads
+	return func() { aeads = saved }
+}
+
+// SaveHMACKeys is like SaveEncryptionKeys, for the signing keys.
+func SaveHMACKeys() (restore func()) {
+	saved := hmacKeys
+	return func() { hmacKeys = saved }
+}
+
+// the Sign and Verify of the flash message store
+var (
+	SealCookie = sealCookie
+	OpenCookie = openCookie
+)
@@ -0,0 +1,265 @@
+package gas
+
+// capture.go records whole requests and responses for debugging, when it's
+// turned on with CAPTURE_REQUESTS.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Capture is a request and the response to it, as recorded when
+// Env.CaptureRequests is set. Bodies are cut off at Env.CaptureBodySize, and
+// headers and form or JSON fields that look like secrets are redacted.
+type Capture struct {
+	Time     time.Time
+	Duration time.Duration
+	ID       string // see Gas.RequestID
+
+	Method     string
+	URL        string
+	Proto      string
+	RemoteAddr string
+
+	RequestHeader        http.Header
+	RequestBody          string
+	RequestBodyTruncated bool `json:",omitempty"`
+
+	Status                int
+	ResponseHeader        http.Header
+	ResponseBody          string
+	ResponseBodyTruncated bool `json:",omitempty"`
+}
+
+var captures struct {
+	sync.Mutex
+	ring []*Capture
+	next int
+}
+
+// Captures returns the requests recorded so far, oldest first. At most
+// Env.CaptureRequests are kept.
+func Captures() []*Capture {
+	captures.Lock()
+	defer captures.Unlock()
+	list := make([]*Capture, 0, len(captures.ring))
+	list = append(list, captures.ring[captures.next:]...)
+	return append(list, captures.ring[:captures.next]...)
+}
+
+func addCapture(c *Capture, size int) {
+	captures.Lock()
+	defer captures.Unlock()
+	if len(captures.ring) > size {
+		// the size was lowered since the last one
+		captures.ring = append([]*Capture(nil), captures.ring[len(captures.ring)-size:]...)
+		captures.next = 0
+	}
+	if len(captures.ring) < size {
+		captures.ring = append(captures.ring, c)
+		return
+	}
+	captures.ring[captures.next] = c
+	captures.next = (captures.next + 1) % size
+}
+
+// CaptureHandler serves the list returned by Captures as JSON. Since the
+// requests can still contain sensitive information, it should only be served
+// on an internal or otherwise protected router.
+func CaptureHandler(g *Gas) (int, Outputter) {
+	g.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(g)
+	enc.SetIndent("", "  ")
+	enc.Encode(Captures())
+	return g.Stop()
+}
+
+// names of headers whose values are never recorded
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// parts of form and JSON field names (and headers besides redactedHeaders)
+// whose values are redacted, along with the ones in CAPTURE_REDACT
+var redactedFields = []string{"password", "passwd", "secret", "token", "api-key", "api_key", "apikey"}
+
+const redacted = "[redacted]"
+
+func isSecret(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range append(redactedFields, splitList(strings.ToLower(Env.CaptureRedact))...) {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactHeader(h http.Header) http.Header {
+	h = h.Clone()
+	for name := range h {
+		secret := isSecret(name)
+		for _, r := range redactedHeaders {
+			secret = secret || http.CanonicalHeaderKey(name) == r
+		}
+		if secret {
+			h[name] = []string{redacted}
+		}
+	}
+	return h
+}
+
+// redactBody blanks out the secrets in a form or JSON body. A JSON body that
+// can't be parsed, e.g. because it was cut off, is left out entirely, since
+// there's no telling what's in it. Anything else is returned as is.
+func redactBody(contentType string, body []byte) string {
+	switch {
+	case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			break
+		}
+		for name := range form {
+			if isSecret(name) {
+				form[name] = []string{redacted}
+			}
+		}
+		return form.Encode()
+	case strings.Contains(contentType, "json"):
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err != nil {
+			return redacted
+		}
+		b, err := json.Marshal(redactJSON(v))
+		if err != nil {
+			return redacted
+		}
+		return string(b)
+	}
+	return string(body)
+}
+
+func redactJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, elem := range v {
+			if isSecret(k) {
+				v[k] = redacted
+			} else {
+				v[k] = redactJSON(elem)
+			}
+		}
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = redactJSON(elem)
+		}
+	}
+	return v
+}
+
+// limitedBuffer keeps the first limit bytes written to it and notes whether
+// there were more.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); len(p) > room {
+		b.truncated = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+type captureBody struct {
+	io.ReadCloser
+	buf *limitedBuffer
+}
+
+func (b captureBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	return n, err
+}
+
+// captureWriter copies the response body into buf on its way out. It also
+// passes on flushing and hijacking to the ResponseWriter it wraps.
+type captureWriter struct {
+	http.ResponseWriter
+	buf *limitedBuffer
+}
+
+func (w captureWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+func (w captureWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w captureWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+func (w captureWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// startCapture starts recording g's request and response. The returned func
+// adds them to the list once the response has been written.
+func startCapture(g *Gas) func() {
+	var (
+		now     = time.Now()
+		size    = Env.CaptureRequests
+		reqBody = &limitedBuffer{limit: Env.CaptureBodySize}
+		resBody = &limitedBuffer{limit: Env.CaptureBodySize}
+	)
+	g.Request.Body = captureBody{g.Request.Body, reqBody}
+	g.w = captureWriter{g.w, resBody}
+
+	return func() {
+		u := *g.URL
+		u.Host = g.Host
+		u.Scheme = "http"
+		if g.TLS != nil {
+			u.Scheme = "https"
+		}
+		if u.RawQuery != "" {
+			u.RawQuery = redactBody("application/x-www-form-urlencoded", []byte(u.RawQuery))
+		}
+		status := g.responseCode
+		if status == 0 {
+			status = 200
+		}
+		addCapture(&Capture{
+			Time:                  now,
+			Duration:              time.Since(now),
+			ID:                    g.id,
+			Method:                g.Method,
+			URL:                   u.String(),
+			Proto:                 g.Proto,
+			RemoteAddr:            g.RemoteAddr,
+			RequestHeader:         redactHeader(g.Request.Header),
+			RequestBody:           redactBody(g.Request.Header.Get("Content-Type"), reqBody.Bytes()),
+			RequestBodyTruncated:  reqBody.truncated,
+			Status:                status,
+			ResponseHeader:        redactHeader(g.Header()),
+			ResponseBody:          redactBody(g.Header().Get("Content-Type"), resBody.Bytes()),
+			ResponseBodyTruncated: resBody.truncated,
+		}, size)
+	}
+}
@@ -0,0 +1,75 @@
+package gas
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCapture(t *testing.T) {
+	saved := Env
+	defer func() {
+		Env = saved
+		captures.ring, captures.next = nil, 0
+	}()
+	Env.CaptureRequests = 2
+	Env.CaptureBodySize = 32
+	Env.CaptureRedact = "ssn"
+
+	r := New().Post("/login", func(g *Gas) (int, Outputter) {
+		io.ReadAll(g.Body)
+		g.Header().Set("Set-Cookie", "s=1")
+		g.WriteHeader(201)
+		io.WriteString(g, "welcome back, you look great today, as always")
+		return g.Stop()
+	})
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	post := func(contentType, body string) {
+		req, _ := http.NewRequest("POST", srv.URL+"/login?token=abc&x=1", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Authorization", "Basic Zm9vOmJhcg==")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	post("text/plain", "first")
+	post("application/x-www-form-urlencoded", "user=a&password=hunter2")
+	post("application/json", `{"user":"a","SSN":"123"}`)
+
+	list := Captures()
+	if len(list) != 2 {
+		t.Fatalf("expected 2 captures to be kept, got %d", len(list))
+	}
+	form, json := list[0], list[1]
+
+	if form.RequestBody != "password=%5Bredacted%5D&user=a" {
+		t.Errorf("form body: got %q", form.RequestBody)
+	}
+	if json.RequestBody != `{"SSN":"[redacted]","user":"a"}` {
+		t.Errorf("JSON body: got %q", json.RequestBody)
+	}
+	if !strings.Contains(form.URL, "token=%5Bredacted%5D") || !strings.Contains(form.URL, "x=1") {
+		t.Errorf("URL: got %q", form.URL)
+	}
+	if h := form.RequestHeader.Get("Authorization"); h != redacted {
+		t.Errorf("Authorization: got %q", h)
+	}
+	if h := form.ResponseHeader.Get("Set-Cookie"); h != redacted {
+		t.Errorf("Set-Cookie: got %q", h)
+	}
+	if form.Status != 201 || form.ResponseBody != "welcome back, you look great tod" || !form.ResponseBodyTruncated {
+		t.Errorf("response: got %d %q (truncated: %v)", form.Status, form.ResponseBody, form.ResponseBodyTruncated)
+	}
+	if redactBody("application/json", []byte(`{"password":"hun`)) != redacted {
+		t.Errorf("expected cut off JSON to be left out")
+	}
+	if form.ID == "" || form.ID == json.ID {
+		t.Errorf("expected distinct request IDs, got %q and %q", form.ID, json.ID)
+	}
+}
@@ -0,0 +1,144 @@
+package gas
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+)
+
+// ClientIP returns the address of the client. If the connection comes from
+// one of Env.TrustedProxies, it's taken from the Forwarded, X-Forwarded-For,
+// or X-Real-IP header (the first one given), going back through the proxies
+// the request passed through until one that isn't trusted. Otherwise, and by
+// default, it's the address of the connection, since anybody can send those
+// headers.
+func (g *Gas) ClientIP() string {
+	remote := remoteIP(g.RemoteAddr)
+	trusted := trustedProxies()
+	if len(trusted) == 0 || !isTrusted(trusted, remote) {
+		return remote
+	}
+
+	var hops []string
+	if fwd := g.Request.Header.Values("Forwarded"); len(fwd) > 0 {
+		hops = forwardedFor(fwd)
+	} else if xff := g.Request.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		for _, v := range xff {
+			for _, hop := range strings.Split(v, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+	} else if ip := strings.TrimSpace(g.Request.Header.Get("X-Real-IP")); ip != "" {
+		hops = []string{ip}
+	}
+
+	// the last proxy's address is on the right, and the client's on the left
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(hops[i])
+		if err != nil {
+			// obfuscated ("_hidden") or "unknown", so the one after it is
+			// as far back as we can tell
+			break
+		}
+		client = addr.Unmap().String()
+		if !isTrusted(trusted, client) {
+			break
+		}
+	}
+	return client
+}
+
+// remoteIP is the host part of a connection's address.
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// forwardedFor gives the for= addresses of the elements of Forwarded headers
+// (RFC 7239), without their ports.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, elem := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(elem, ";") {
+				k, v, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				if !strings.EqualFold(k, "for") {
+					continue
+				}
+				v = strings.Trim(v, `"`)
+				if strings.HasPrefix(v, "[") {
+					// [2001:db8::1]:4711
+					v = strings.TrimPrefix(v, "[")
+					v, _, _ = strings.Cut(v, "]")
+				} else if host, _, err := net.SplitHostPort(v); err == nil {
+					v = host
+				}
+				hops = append(hops, v)
+			}
+		}
+	}
+	return hops
+}
+
+type trustedSpec struct {
+	spec     string
+	prefixes []netip.Prefix
+}
+
+var trusted atomic.Pointer[trustedSpec]
+
+// trustedProxies parses Env.TrustedProxies, once for each value it's set to.
+func trustedProxies() []netip.Prefix {
+	spec := Env.TrustedProxies
+	if t := trusted.Load(); t != nil && t.spec == spec {
+		return t.prefixes
+	}
+
+	t := &trustedSpec{spec: spec}
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		switch strings.ToLower(s) {
+		case "":
+			continue
+		case "loopback":
+			t.prefixes = append(t.prefixes, netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128"))
+			continue
+		case "private":
+			for _, p := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"} {
+				t.prefixes = append(t.prefixes, netip.MustParsePrefix(p))
+			}
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				Logger().Warn("envconf: invalid trusted proxy, ignoring it", "var", EnvPrefix+"TRUSTED_PROXIES", "value", s)
+				continue
+			}
+			p = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		t.prefixes = append(t.prefixes, p.Masked())
+	}
+	trusted.Store(t)
+	return t.prefixes
+}
+
+func isTrusted(prefixes []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
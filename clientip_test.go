@@ -0,0 +1,53 @@
+package gas
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	defer func() { Env.TrustedProxies = "" }()
+
+	tests := []struct {
+		trusted string
+		remote  string
+		headers []string
+		ip      string
+	}{
+		// nothing trusted by default
+		{"", "192.0.2.1:1234", []string{"X-Forwarded-For", "203.0.113.7"}, "192.0.2.1"},
+		{"", "[2001:db8::1]:1234", nil, "2001:db8::1"},
+		// not from a trusted proxy
+		{"10.0.0.0/8", "192.0.2.1:1234", []string{"X-Forwarded-For", "203.0.113.7"}, "192.0.2.1"},
+		{"10.0.0.0/8", "10.0.0.2:1234", nil, "10.0.0.2"},
+		{"10.0.0.0/8", "10.0.0.2:1234", []string{"X-Forwarded-For", "203.0.113.7"}, "203.0.113.7"},
+		// a spoofed address on the left is passed over
+		{"10.0.0.0/8", "10.0.0.2:1234", []string{"X-Forwarded-For", "198.51.100.9, 203.0.113.7, 10.0.0.3"}, "203.0.113.7"},
+		{"10.0.0.0/8", "10.0.0.2:1234", []string{"X-Forwarded-For", "198.51.100.9", "X-Forwarded-For", "203.0.113.7"}, "203.0.113.7"},
+		// all of them trusted
+		{"private", "10.0.0.2:1234", []string{"X-Forwarded-For", "192.168.1.1, 10.0.0.3"}, "192.168.1.1"},
+		{"loopback, 192.0.2.1", "192.0.2.1:1234", []string{"X-Real-IP", "203.0.113.7"}, "203.0.113.7"},
+		{"10.0.0.0/8", "[::ffff:10.0.0.2]:1234", []string{"X-Forwarded-For", "::ffff:203.0.113.7"}, "203.0.113.7"},
+		// Forwarded comes first
+		{"10.0.0.0/8", "10.0.0.2:1234", []string{
+			"Forwarded", `for=198.51.100.9, for="[2001:db8::7]:4711";proto=https`,
+			"X-Forwarded-For", "203.0.113.7",
+		}, "2001:db8::7"},
+		{"10.0.0.0/8", "10.0.0.2:1234", []string{"Forwarded", "for=203.0.113.7:80;by=10.0.0.2, for=10.0.0.3"}, "203.0.113.7"},
+		{"10.0.0.0/8", "10.0.0.2:1234", []string{"Forwarded", "for=_hidden, for=10.0.0.3"}, "10.0.0.3"},
+		{"bogus, 10.0.0.0/8", "10.0.0.2:1234", []string{"X-Forwarded-For", "203.0.113.7"}, "203.0.113.7"},
+	}
+
+	for _, test := range tests {
+		Env.TrustedProxies = test.trusted
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = test.remote
+		for i := 0; i < len(test.headers); i += 2 {
+			req.Header.Add(test.headers[i], test.headers[i+1])
+		}
+		g := &Gas{Request: req}
+		if ip := g.ClientIP(); ip != test.ip {
+			t.Errorf("%q from %s with %q: expected %s, got %s", test.trusted, test.remote, test.headers, test.ip, ip)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// checkNames makes sure every task has a name and no two have the same one.
+func checkNames(tasks []*Task) error {
+	seen := make(map[string]bool, len(tasks))
+	for i, t := range tasks {
+		if t.Name == "" {
+			return errors.Errorf("task %d has no name", i+1)
+		}
+		if seen[t.Name] {
+			return errors.Errorf("more than one task is named %q", t.Name)
+		}
+		seen[t.Name] = true
+	}
+	return nil
+}
+
+// check looks for things that would only go wrong once the task is started:
+// a program or working directory that isn't there, and ${VAR}s that aren't
+// set by anything.
+func (t *Task) check() []string {
+	var problems []string
+	env, invoke, _ := t.environ()
+	vars := make(map[string]string, len(env))
+	for _, kv := range env {
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			vars[kv[:i]] = kv[i+1:]
+		}
+	}
+
+	if t.Dir != "" {
+		if fi, err := os.Stat(t.Dir); err != nil {
+			problems = append(problems, err.Error())
+		} else if !fi.IsDir() {
+			problems = append(problems, fmt.Sprintf("Dir %s isn't a directory", t.Dir))
+		}
+	}
+
+	switch {
+	case invoke == "":
+		problems = append(problems, "nothing to invoke")
+	case strings.ContainsRune(invoke, os.PathSeparator):
+		path := invoke
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(t.Dir, path)
+		}
+		if fi, err := os.Stat(path); err != nil {
+			problems = append(problems, err.Error())
+		} else if fi.IsDir() || fi.Mode()&0111 == 0 {
+			problems = append(problems, fmt.Sprintf("%s isn't executable", path))
+		}
+	default:
+		if _, err := exec.LookPath(invoke); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	refs := []string{t.Invoke, t.ReadyURL}
+	refs = append(refs, t.Args...)
+	for _, v := range t.Env {
+		refs = append(refs, v)
+	}
+	missing := make(map[string]bool)
+	for _, s := range refs {
+		for _, name := range varRefs(s) {
+			if _, ok := vars[name]; !ok && !missing[name] {
+				missing[name] = true
+				problems = append(problems, fmt.Sprintf("${%s} isn't set", name))
+			}
+		}
+	}
+	return problems
+}
+
+// varRefs returns the names of the ${VAR}s in s.
+func varRefs(s string) []string {
+	var names []string
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			return names
+		}
+		j := strings.IndexByte(s[i:], '}')
+		if j < 0 {
+			return names
+		}
+		names = append(names, s[i+2:i+j])
+		s = s[i+j+1:]
+	}
+}
+
+// Check the task file for mistakes without loading it
+func (tl *TaskList) Check(args *Args, resp *Response) error {
+	tl2, err := tl.c.loadTasks()
+	if err != nil {
+		return err
+	}
+
+	// loading it picked ports for any new AutoPort tasks
+	tl.mu.RLock()
+	for _, t := range tl2.Tasks {
+		if old, _ := tl.lookup(t.Name); old == nil || !old.AutoPort {
+			ports.release(t.Name)
+		}
+	}
+	tl.mu.RUnlock()
+
+	var problems []string
+	for _, t := range tl2.Tasks {
+		for _, p := range t.check() {
+			problems = append(problems, t.Name+": "+p)
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+	resp.Status = fmt.Sprintf("%s: %d tasks ok", tl.c.taskfilePath, len(tl2.Tasks))
+	return nil
+}
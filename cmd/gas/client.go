@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"net/rpc"
@@ -10,13 +11,45 @@ import (
 	"text/tabwriter"
 )
 
-func handleCommand(name string, args []string) {
-	c, err := userConfig(user.Current())
-	if err != nil {
-		log.Fatal(err)
-	}
-
+func handleCommand(remote, name string, args []string) {
 	rpcArgs := &Args{}
+	follow, watch := false, name == "watch"
+	if watch {
+		name = "events"
+		rpcArgs.Since = -1
+	}
+	if name == "history" {
+		fs := flag.NewFlagSet("history", flag.ExitOnError)
+		fs.IntVar(&rpcArgs.Lines, "n", 20, "number of runs to show")
+		fs.Parse(args)
+		args = fs.Args()
+	}
+	if name == "reload" {
+		fs := flag.NewFlagSet("reload", flag.ExitOnError)
+		fs.BoolVar(&rpcArgs.DryRun, "dry-run", false, "only say what would be done")
+		fs.Parse(args)
+		args = fs.Args()
+	}
+	if name == "run" {
+		fs := flag.NewFlagSet("run", flag.ExitOnError)
+		fs.BoolVar(&rpcArgs.Command, "c", false, "run a command in the task's environment")
+		fs.Parse(args)
+		args = fs.Args()
+	}
+	if name == "upstream" {
+		fs := flag.NewFlagSet("upstream", flag.ExitOnError)
+		fs.StringVar(&rpcArgs.Format, "format", "nginx", "nginx or caddy")
+		fs.Parse(args)
+		args = fs.Args()
+	}
+	if name == "tail" {
+		fs := flag.NewFlagSet("tail", flag.ExitOnError)
+		fs.BoolVar(&follow, "f", false, "keep showing new lines")
+		fs.IntVar(&rpcArgs.Lines, "n", defaultTailLines, "number of lines to show")
+		fs.BoolVar(&rpcArgs.Raw, "raw", false, "keep timestamps and prefixes")
+		fs.Parse(args)
+		args = fs.Args()
+	}
 	if len(args) >= 1 {
 		rpcArgs.Name = args[0]
 
@@ -24,29 +57,174 @@ func handleCommand(name string, args []string) {
 			rpcArgs.Args = args[1:]
 		}
 	}
-	name = "TaskList." + strings.Title(strings.ToLower(name))
+	method := "TaskList." + strings.Title(strings.ToLower(name))
 
-	client, err := rpc.Dial("unix", c.sockPath)
+	client, err := dial(remote)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer client.Close()
+	if remote != "" {
+		rpcArgs.Token = os.Getenv("GAS_TOKEN")
+	}
+
+	// a task name of @group means each of the tasks in it; tail, events and
+	// upstream take any number of them and are sent all at once, other
+	// commands are sent once per task
+	calls := []*Args{rpcArgs}
+	if (name == "tail" || name == "events" || name == "upstream") && rpcArgs.Name != "" {
+		names := expandGroups(client, rpcArgs.Token, append([]string{rpcArgs.Name}, rpcArgs.Args...))
+		rpcArgs.Name, rpcArgs.Args = names[0], names[1:]
+	} else if strings.HasPrefix(rpcArgs.Name, "@") {
+		calls = nil
+		for _, n := range expandGroups(client, rpcArgs.Token, []string{rpcArgs.Name}) {
+			a := *rpcArgs
+			a.Name = n
+			calls = append(calls, &a)
+		}
+	}
 
 	resp := Response{}
-	err = client.Call(name, rpcArgs, &resp)
-	if err != nil {
-		log.Fatal(err)
+	for _, a := range calls {
+		var r Response
+		if err = client.Call(method, a, &r); err != nil {
+			log.Fatal(err)
+		}
+		if r.Status != "" {
+			if resp.Status != "" {
+				resp.Status += "\n"
+			}
+			resp.Status += r.Status
+		}
+		resp.Tasks = append(resp.Tasks, r.Tasks...)
+		resp.Events = append(resp.Events, r.Events...)
+		resp.Offsets = r.Offsets
+		resp.Seq = r.Seq
 	}
 
 	if resp.Status != "" {
 		fmt.Println(resp.Status)
 	}
+	if follow {
+		followLogs(client, rpcArgs, resp.Offsets)
+	}
+	if name == "run" {
+		os.Exit(runOutput(client, rpcArgs, resp.Seq, resp.Offsets))
+	}
+	if watch {
+		watchEvents(client, rpcArgs, resp)
+	}
 	if resp.Tasks != nil {
-		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
-		fmt.Fprintln(tw, "  NAME\tPID\tPORT\tUPTIME")
-		for _, task := range resp.Tasks {
+		printStatus(resp.Tasks)
+	}
+}
+
+// printStatus prints a table of tasks, with those in groups under group
+// headings after the ungrouped ones.
+func printStatus(tasks []TaskStatus) {
+	var groups []string
+	byGroup := make(map[string][]TaskStatus)
+	for _, t := range tasks {
+		if _, ok := byGroup[t.Group]; !ok && t.Group != "" {
+			groups = append(groups, t.Group)
+		}
+		byGroup[t.Group] = append(byGroup[t.Group], t)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "  NAME\tPID\tPORT\tUPTIME")
+	for _, task := range byGroup[""] {
+		fmt.Fprintln(tw, task)
+	}
+	for _, g := range groups {
+		fmt.Fprintf(tw, "@%s\t\t\t\t\n", g)
+		for _, task := range byGroup[g] {
 			fmt.Fprintln(tw, task)
 		}
-		tw.Flush()
 	}
+	tw.Flush()
+}
+
+// expandGroups replaces each @group in names with the names of the tasks in
+// it.
+func expandGroups(client *rpc.Client, token string, names []string) []string {
+	var expanded []string
+	for _, n := range names {
+		if !strings.HasPrefix(n, "@") {
+			expanded = append(expanded, n)
+			continue
+		}
+		var resp Response
+		if err := client.Call("TaskList.Names", &Args{Name: n, Token: token}, &resp); err != nil {
+			log.Fatal(err)
+		}
+		expanded = append(expanded, strings.Fields(resp.Status)...)
+	}
+	return expanded
+}
+
+// followLogs keeps printing new lines from the logs of the tasks in args
+// from offsets until it's interrupted.
+func followLogs(client *rpc.Client, args *Args, offsets map[string]int64) {
+	for {
+		args.Offsets = offsets
+		resp := Response{}
+		if err := client.Call("TaskList.Follow", args, &resp); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(resp.Status)
+		offsets = resp.Offsets
+	}
+}
+
+// runOutput prints the output of the one-shot run id as it comes in, and
+// returns its exit code once it's finished.
+func runOutput(client *rpc.Client, args *Args, id int64, offsets map[string]int64) int {
+	for {
+		a := &Args{Since: id, Offsets: offsets, Token: args.Token}
+		resp := Response{}
+		if err := client.Call("TaskList.Output", a, &resp); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(resp.Status)
+		offsets = resp.Offsets
+		if len(resp.Tasks) > 0 {
+			ts := resp.Tasks[0]
+			if ts.Message != "" {
+				log.Print(ts.Message)
+			}
+			if ts.ExitCode < 0 {
+				return 1
+			}
+			return ts.ExitCode
+		}
+	}
+}
+
+// watchEvents prints events as they come in until it's interrupted, starting
+// with those in resp.
+func watchEvents(client *rpc.Client, args *Args, resp Response) {
+	for {
+		for _, e := range resp.Events {
+			fmt.Println(e)
+		}
+		args.Since = resp.Seq
+		resp = Response{}
+		if err := client.Call("TaskList.Events", args, &resp); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// dial connects to the local server, or to the remote one at addr if it's
+// set.
+func dial(addr string) (*rpc.Client, error) {
+	if addr != "" {
+		return dialRemote(addr)
+	}
+	c, err := userConfig(user.Current())
+	if err != nil {
+		return nil, err
+	}
+	return rpc.Dial("unix", c.sockPath)
 }
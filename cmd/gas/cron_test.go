@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"@every 10ms",
+		"@every nope",
+		"@fortnightly",
+	} {
+		if _, err := parseSchedule(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+
+	s, err := parseSchedule("0,30 9-17/4 * jan-mar mon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.minute != 1|1<<30 {
+		t.Errorf("minute: got %b", s.minute)
+	}
+	if s.hour != 1<<9|1<<13|1<<17 {
+		t.Errorf("hour: got %b", s.hour)
+	}
+	if s.month != 1<<1|1<<2|1<<3 {
+		t.Errorf("month: got %b", s.month)
+	}
+	if s.dow != 1<<1 || !s.anyDom || s.anyDow {
+		t.Errorf("dow: got %b, anyDom %v, anyDow %v", s.dow, s.anyDom, s.anyDow)
+	}
+
+	// 7 is Sunday too
+	if s, err = parseSchedule("* * * * 7"); err != nil || s.dow&1 == 0 {
+		t.Errorf("expected 7 to be Sunday, got %v %v", s, err)
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	at := func(s string) time.Time {
+		tm, err := time.Parse("2006-01-02 15:04:05", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+
+	tests := []struct {
+		spec, from, next string
+	}{
+		{"* * * * *", "2024-03-10 12:00:00", "2024-03-10 12:01:00"},
+		{"* * * * *", "2024-03-10 12:00:59", "2024-03-10 12:01:00"},
+		{"*/15 * * * *", "2024-03-10 12:01:00", "2024-03-10 12:15:00"},
+		{"30 2 * * *", "2024-03-10 12:00:00", "2024-03-11 02:30:00"},
+		{"@hourly", "2024-03-10 23:30:00", "2024-03-11 00:00:00"},
+		{"@monthly", "2024-12-15 00:00:00", "2025-01-01 00:00:00"},
+		{"0 0 29 2 *", "2024-03-01 00:00:00", "2028-02-29 00:00:00"},
+		// Sunday the 10th, or any 15th
+		{"0 0 15 * sun", "2024-03-09 00:00:00", "2024-03-10 00:00:00"},
+		{"0 0 15 * sun", "2024-03-12 00:00:00", "2024-03-15 00:00:00"},
+		{"0 0 * * 1-5", "2024-03-08 12:00:00", "2024-03-11 00:00:00"},
+		{"@every 90m", "2024-03-10 12:00:00", "2024-03-10 13:30:00"},
+		{"0 0 30 2 *", "2024-03-10 12:00:00", ""},
+	}
+	for _, test := range tests {
+		s, err := parseSchedule(test.spec)
+		if err != nil {
+			t.Errorf("%q: %v", test.spec, err)
+			continue
+		}
+		next := s.next(at(test.from))
+		if test.next == "" {
+			if !next.IsZero() {
+				t.Errorf("%q from %s: expected it to never fire, got %s", test.spec, test.from, next)
+			}
+		} else if !next.Equal(at(test.next)) {
+			t.Errorf("%q from %s: expected %s, got %s", test.spec, test.from, test.next, next)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// how long to wait for a dependency to become ready when its task doesn't set
+// ReadyTimeout
+const defaultReadyTimeout = 30 * time.Second
+
+// checkDependencies makes sure every dependency in a task list exists and
+// that there are no cycles.
+func checkDependencies(tasks []*Task) error {
+	names := make(map[string]bool, len(tasks))
+	for _, t := range tasks {
+		names[t.Name] = true
+	}
+	for _, t := range tasks {
+		for _, name := range t.DependsOn {
+			if !names[name] {
+				return fmt.Errorf("%s: depends on unknown task %q", t.Name, name)
+			}
+		}
+	}
+	_, err := order(tasks)
+	return err
+}
+
+// order returns tasks sorted so that every task comes after the ones it
+// depends on, or an error if there's a cycle. Dependencies outside of tasks
+// are ignored.
+func order(tasks []*Task) ([]*Task, error) {
+	byName := make(map[string]*Task, len(tasks))
+	for _, t := range tasks {
+		byName[t.Name] = t
+	}
+
+	const (
+		visiting = 1
+		done     = 2
+	)
+	var (
+		state   = make(map[string]int, len(tasks))
+		ordered = make([]*Task, 0, len(tasks))
+		visit   func(t *Task, path []string) error
+	)
+	visit = func(t *Task, path []string) error {
+		switch state[t.Name] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("dependency cycle: %s", strings.Join(append(path, t.Name), " -> "))
+		}
+		state[t.Name] = visiting
+		for _, name := range t.DependsOn {
+			dep, ok := byName[name]
+			if !ok {
+				continue
+			}
+			if err := visit(dep, append(path, t.Name)); err != nil {
+				return err
+			}
+		}
+		state[t.Name] = done
+		ordered = append(ordered, t)
+		return nil
+	}
+
+	for _, t := range tasks {
+		if err := visit(t, nil); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// ready reports whether t is running and, if it has a ReadyURL, answering it
+// successfully.
+func (t *Task) ready() bool {
+	if !t.Alive() {
+		return false
+	}
+	if ns := t.notify; ns != nil {
+		if ready, _ := ns.state(); !ready {
+			return false
+		}
+	}
+	if t.ReadyURL == "" {
+		return true
+	}
+	client := http.Client{Timeout: time.Second}
+	resp, err := client.Get(expandVars(t.ReadyURL, map[string]string{"GAS_PORT": t.gasPort()}))
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+// waitReady waits until t is ready or its ReadyTimeout runs out, and reports
+// which one happened. Scheduled and disabled tasks aren't waited for.
+func (t *Task) waitReady() bool {
+	if t.cron != nil || !t.Enable {
+		return true
+	}
+	timeout := defaultReadyTimeout
+	if t.ReadyTimeout != "" {
+		// checked when the task file was loaded
+		timeout, _ = time.ParseDuration(t.ReadyTimeout)
+	}
+	deadline := time.Now().Add(timeout)
+	for !t.ready() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return true
+}
+
+// waitDependencies waits for each of t's dependencies to be ready. One that
+// doesn't become ready in time is logged and then ignored, so that a broken
+// dependency doesn't keep everything else down too.
+func (tl *TaskList) waitDependencies(t *Task) {
+	for _, name := range t.DependsOn {
+		dep, err := tl.lookup(name)
+		if err != nil {
+			continue
+		}
+		if !dep.waitReady() {
+			t.Logf("dependency %s isn't ready, starting anyway", name)
+		}
+	}
+}
+
+// startOrdered starts tasks in dependency order, each one once the tasks it
+// depends on are ready.
+func (tl *TaskList) startOrdered(tasks []*Task, ch chan<- *TaskStatus) {
+	ordered, err := order(tasks)
+	if err != nil {
+		// can't happen for a task list that loaded, but start them anyway
+		log.Print(err)
+		ordered = tasks
+	}
+	for _, t := range ordered {
+		if !t.Enable {
+			continue
+		}
+		if len(t.DependsOn) > 0 {
+			tl.waitDependencies(t)
+		}
+		t.start(ch, false)
+	}
+}
+
+// restartDependents waits for the task with the given name to be ready after
+// it has been restarted, and then restarts the tasks depending on it. They
+// are brought back by resuscitation once they've stopped.
+func (tl *TaskList) restartDependents(name string) {
+	t, err := tl.lookup(name)
+	if err != nil {
+		return
+	}
+	// Run may not have gotten around to starting the process yet
+	time.Sleep(100 * time.Millisecond)
+	t.waitReady()
+
+	tl.mu.RLock()
+	defer tl.mu.RUnlock()
+	for _, dep := range tl.Tasks {
+		for _, n := range dep.DependsOn {
+			if n == name && dep.Alive() {
+				dep.Logf("restarting since %s restarted", name)
+				if err := dep.Stop(); err != nil {
+					dep.Log(err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import "fmt"
+
+// gasPort is the GAS_PORT the task runs with.
+func (t *Task) gasPort() string {
+	if t.port != "" {
+		return t.port
+	}
+	return t.Env["GAS_PORT"]
+}
+
+// initPort picks the first of the DeployPorts unless GAS_PORT is already one
+// of them.
+func (t *Task) initPort() {
+	if len(t.DeployPorts) == 0 {
+		return
+	}
+	for _, p := range t.DeployPorts {
+		if p == t.Env["GAS_PORT"] {
+			t.port = p
+			return
+		}
+	}
+	t.port = t.DeployPorts[0]
+}
+
+// nextPort is the one of the DeployPorts after the one t is running on.
+func (t *Task) nextPort() string {
+	for i, p := range t.DeployPorts {
+		if p == t.gasPort() {
+			return t.DeployPorts[(i+1)%len(t.DeployPorts)]
+		}
+	}
+	return t.DeployPorts[0]
+}
+
+// Deploy a new version of a task without downtime: start a new instance from
+// the task file as it is now on the next of its DeployPorts, wait for it to
+// get ready, and then have the old instance drain its connections and exit,
+// by stopping it with its StopSignal, SIGINT by default, which gas servers
+// take as the cue for a graceful shutdown.
+func (tl *TaskList) Deploy(args *Args, resp *Response) error {
+	old, err := tl.lookup(args.Name)
+	if err != nil {
+		return err
+	}
+	if !old.Alive() {
+		return fmt.Errorf("%s: task is not running, start it instead", old.Name)
+	}
+	if len(old.DeployPorts) < 2 {
+		return fmt.Errorf("%s: task needs at least two DeployPorts to be deployed", old.Name)
+	}
+
+	fresh, err := tl.c.loadTasks()
+	if err != nil {
+		return err
+	}
+	t, err := fresh.lookup(old.Name)
+	if err != nil {
+		return fmt.Errorf("%s: task is gone from the task file", old.Name)
+	}
+	if t.cron != nil {
+		return fmt.Errorf("%s: scheduled tasks can't be deployed", t.Name)
+	}
+	if len(t.DeployPorts) < 2 {
+		return fmt.Errorf("%s: task needs at least two DeployPorts to be deployed", t.Name)
+	}
+	t.port = old.nextPort()
+	t.deploying = true
+	t.Enable = true
+
+	t.Logf("deploying on port %s", t.port)
+	t.ch = make(chan *TaskStatus, 1)
+	tl.taskChan <- t
+	stat := <-t.ch
+	t.ch = nil
+	if !stat.Alive {
+		return fmt.Errorf("%s: new instance didn't start: %s", t.Name, stat.Message)
+	}
+	if !t.waitReady() {
+		t.Enable = false
+		t.replaced = true
+		t.Stop()
+		return fmt.Errorf("%s: new instance on port %s didn't get ready", t.Name, t.port)
+	}
+	t.deploying = false
+
+	tl.mu.Lock()
+	for i, task := range tl.Tasks {
+		if task == old {
+			tl.Tasks[i] = t
+		}
+	}
+	old.unwatch()
+	tl.watch(t)
+	tl.mu.Unlock()
+
+	old.Enable = false
+	old.replaced = true
+	old.Logf("draining on port %s after deploy", old.gasPort())
+	if err = old.Stop(); err != nil {
+		return fmt.Errorf("%s: signaling old instance: %v", old.Name, err)
+	}
+	taskEvents.add("deployed", t.Name, "on port %s", t.port)
+	go tl.restartDependents(t.Name)
+
+	resp.addStatus(t.Status())
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// readEnvFile reads KEY=VALUE pairs from a dotenv-style file. Blank lines and
+// lines starting with # are skipped, a leading "export " is allowed, and
+// values may be wrapped in single or double quotes.
+func readEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "env file")
+	}
+	defer f.Close()
+
+	env := make(map[string]string)
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		i := strings.IndexByte(line, '=')
+		if i <= 0 {
+			return nil, errors.Errorf("env file: %s:%d: expected KEY=VALUE", path, n)
+		}
+		key, val := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') && val[len(val)-1] == val[0] {
+			val = val[1 : len(val)-1]
+		}
+		env[key] = val
+	}
+	return env, errors.Wrap(s.Err(), "env file")
+}
+
+// envFilePath is where the EnvFile of t is, relative paths being taken from
+// the task's Dir.
+func (t *Task) envFilePath() string {
+	if t.EnvFile == "" || filepath.IsAbs(t.EnvFile) {
+		return t.EnvFile
+	}
+	return filepath.Join(t.Dir, t.EnvFile)
+}
+
+// loadEnvFile (re)reads the EnvFile of t, if it has one.
+func (t *Task) loadEnvFile() error {
+	if t.EnvFile == "" {
+		t.fileEnv = nil
+		return nil
+	}
+	env, err := readEnvFile(t.envFilePath())
+	if err != nil {
+		return err
+	}
+	t.fileEnv = env
+	return nil
+}
+
+// environ works out the environment the task runs in and its expanded
+// command line. The task's Env takes precedence over its EnvFile, which
+// takes precedence over the environment gas itself is running in. ${VAR} in
+// Env values is expanded from the latter two, and in Invoke and Args from the
+// whole lot. GAS_TASK is set to the name of the task.
+func (t *Task) environ() (env []string, invoke string, args []string) {
+	vars := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			vars[kv[:i]] = kv[i+1:]
+		}
+	}
+	// these are for gas itself, if it's run by systemd
+	delete(vars, "NOTIFY_SOCKET")
+	delete(vars, "WATCHDOG_USEC")
+	delete(vars, "WATCHDOG_PID")
+	for k, v := range t.fileEnv {
+		vars[k] = v
+	}
+	expanded := make(map[string]string, len(t.Env))
+	for k, v := range t.Env {
+		expanded[k] = expandVars(v, vars)
+	}
+	for k, v := range expanded {
+		vars[k] = v
+	}
+	if t.port != "" {
+		vars["GAS_PORT"] = t.port
+	}
+	vars["GAS_TASK"] = t.Name
+
+	invoke = expandVars(t.Invoke, vars)
+	args = make([]string, len(t.Args))
+	for i, arg := range t.Args {
+		args[i] = expandVars(arg, vars)
+	}
+	return formatEnv(vars), invoke, args
+}
+
+// expandVars replaces each ${VAR} in s with its value in vars, or nothing if
+// it isn't set. Unlike os.Expand, $VAR on its own is left alone, since
+// arguments like that are often meant for a shell.
+func expandVars(s string, vars map[string]string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			break
+		}
+		j := strings.IndexByte(s[i:], '}')
+		if j < 0 {
+			break
+		}
+		b.WriteString(s[:i])
+		b.WriteString(vars[s[i+2:i+j]])
+		s = s[i+j+1:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// how many events are kept for clients that fall behind
+const eventLogSize = 1000
+
+// how often the ReadyURLs of running tasks are checked for health changes
+const healthInterval = 10 * time.Second
+
+// A TaskEvent is something that happened to a task, or to the server when
+// Task is empty.
+type TaskEvent struct {
+	Seq     int64
+	Time    time.Time
+	Kind    string // started, stopped, died, crash_loop, deployed, healthy, unhealthy, reloaded
+	Task    string
+	Message string
+}
+
+func (e TaskEvent) String() string {
+	s := e.Time.Format("2006-01-02 15:04:05") + " "
+	if e.Task != "" {
+		s += e.Task + " "
+	}
+	s += e.Kind
+	if e.Message != "" {
+		s += ": " + e.Message
+	}
+	return s
+}
+
+// eventLog keeps the latest events and wakes up whoever's waiting for more.
+type eventLog struct {
+	mu     sync.Mutex
+	events []TaskEvent
+	seq    int64
+	more   chan struct{} // closed when an event is added
+}
+
+var taskEvents = newEventLog()
+
+func newEventLog() *eventLog {
+	return &eventLog{more: make(chan struct{})}
+}
+
+func (l *eventLog) add(kind, task, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	l.events = append(l.events, TaskEvent{
+		Seq:     l.seq,
+		Time:    time.Now(),
+		Kind:    kind,
+		Task:    task,
+		Message: fmt.Sprintf(format, args...),
+	})
+	if len(l.events) > eventLogSize {
+		l.events = l.events[len(l.events)-eventLogSize:]
+	}
+	close(l.more)
+	l.more = make(chan struct{})
+}
+
+// since returns the events after seq about the named tasks (or all of them
+// if there are no names), the latest seq, and a channel that's closed when
+// there are more.
+func (l *eventLog) since(seq int64, names []string) ([]TaskEvent, int64, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var events []TaskEvent
+	for _, e := range l.events {
+		if e.Seq > seq && (len(names) == 0 || e.Task == "" || contains(names, e.Task)) {
+			events = append(events, e)
+		}
+	}
+	return events, l.seq, l.more
+}
+
+// wait waits for up to timeout for events after seq, as for since. A
+// negative seq means events from now on.
+func (l *eventLog) wait(seq int64, names []string, timeout time.Duration) ([]TaskEvent, int64) {
+	if seq < 0 {
+		_, seq, _ = l.since(0, nil)
+	}
+	deadline := time.After(timeout)
+	for {
+		events, latest, more := l.since(seq, names)
+		if len(events) > 0 {
+			return events, latest
+		}
+		seq = latest
+		select {
+		case <-more:
+		case <-deadline:
+			return nil, latest
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// monitorHealth checks the ReadyURLs of running tasks every healthInterval
+// and records when they go from answering to not answering, or back.
+func (tl *TaskList) monitorHealth() {
+	healthy := make(map[string]bool)
+	for range time.Tick(healthInterval) {
+		tl.mu.RLock()
+		tasks := append([]*Task(nil), tl.Tasks...)
+		tl.mu.RUnlock()
+		for _, t := range tasks {
+			if t.ReadyURL == "" || !t.Alive() {
+				delete(healthy, t.Name)
+				continue
+			}
+			ok := t.ready()
+			if was, seen := healthy[t.Name]; !seen && ok || seen && was == ok {
+				healthy[t.Name] = ok
+				continue
+			}
+			healthy[t.Name] = ok
+			if ok {
+				taskEvents.add("healthy", t.Name, "")
+			} else {
+				taskEvents.add("unhealthy", t.Name, "%s isn't answering", t.ReadyURL)
+			}
+		}
+	}
+}
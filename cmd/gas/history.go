@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// how many runs are kept in a task's history
+const historySize = 1000
+
+// A run is one time a task was started, and how it ended.
+type run struct {
+	Started  time.Time
+	Ended    time.Time
+	ExitCode int    // -1 if it didn't exit on its own
+	Message  string // why it ended, if it wasn't cleanly
+	Died     bool   // while it was meant to be running, so it was restarted
+}
+
+func (r run) String() string {
+	s := fmt.Sprintf("%s  up %s", r.Started.Format("2006-01-02 15:04:05"), fmtDuration(r.Ended.Sub(r.Started)))
+	if r.ExitCode >= 0 {
+		s += fmt.Sprintf("  exit %d", r.ExitCode)
+	}
+	if r.Died {
+		s += "  died"
+	}
+	if r.Message != "" {
+		s += "  " + r.Message
+	}
+	return s
+}
+
+// historyPath is where the runs of t are kept, next to its logs so that they
+// outlive the server.
+func (t *Task) historyPath() string {
+	return filepath.Join(t.c.logDirPath, t.Name+".history")
+}
+
+// readHistory reads the runs of t, oldest first.
+func (t *Task) readHistory() ([]run, error) {
+	f, err := os.Open(t.historyPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "history")
+	}
+	defer f.Close()
+	var runs []run
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var r run
+		if json.Unmarshal(s.Bytes(), &r) == nil {
+			runs = append(runs, r)
+		}
+	}
+	return runs, errors.Wrap(s.Err(), "history")
+}
+
+// recordRun adds the run of t that ended with stat to its history, trimming
+// the oldest ones once there are too many.
+func (t *Task) recordRun(stat *TaskStatus) {
+	if t.started.IsZero() {
+		return
+	}
+	r := run{
+		Started:  t.started,
+		Ended:    time.Now(),
+		ExitCode: stat.ExitCode,
+		Message:  stat.Message,
+		Died:     stat.Enable,
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+	f, err := os.OpenFile(t.historyPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Log("history:", err)
+		return
+	}
+	f.Write(append(b, '\n'))
+	fi, err := f.Stat()
+	f.Close()
+
+	// runs are around 150 bytes, so only bother reading them back in to
+	// trim now and then
+	if err == nil && fi.Size() > historySize*200 {
+		runs, err := t.readHistory()
+		if err != nil || len(runs) <= historySize {
+			return
+		}
+		t.writeHistory(runs[len(runs)-historySize:])
+	}
+}
+
+func (t *Task) writeHistory(runs []run) {
+	tmp := t.historyPath() + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Log("history:", err)
+		return
+	}
+	enc := json.NewEncoder(f)
+	for _, r := range runs {
+		enc.Encode(r)
+	}
+	if err = f.Close(); err == nil {
+		err = os.Rename(tmp, t.historyPath())
+	}
+	if err != nil {
+		t.Log("history:", err)
+	}
+}
+
+// Show how often a task has been started and has died, how long it's been up
+// in all, and its last args.Lines (default 20) runs.
+func (tl *TaskList) History(args *Args, resp *Response) error {
+	t, err := tl.lookup(args.Name)
+	if err != nil {
+		return err
+	}
+	runs, err := t.readHistory()
+	if err != nil {
+		return err
+	}
+	if len(runs) == 0 {
+		resp.Status = t.Name + ": no history"
+		return nil
+	}
+
+	var (
+		deaths int
+		uptime time.Duration
+	)
+	for _, r := range runs {
+		if r.Died {
+			deaths++
+		}
+		uptime += r.Ended.Sub(r.Started)
+	}
+	if t.Alive() {
+		uptime += t.Uptime()
+	}
+
+	n := args.Lines
+	if n <= 0 {
+		n = 20
+	}
+	if n > len(runs) {
+		n = len(runs)
+	}
+	lines := []string{fmt.Sprintf("%s: %d runs since %s, %d deaths (restarted), up %s in all",
+		t.Name, len(runs), runs[0].Started.Format("2006-01-02 15:04"), deaths, fmtDuration(uptime))}
+	for _, r := range runs[len(runs)-n:] {
+		lines = append(lines, r.String())
+	}
+	resp.Status = strings.Join(lines, "\n")
+	return nil
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// httpAPI serves the TaskList operations as JSON over HTTP, for things that
+// don't speak Go's rpc encoding:
+//
+//	GET  /tasks                    status of all tasks
+//	GET  /tasks/<task>             status of one
+//	GET  /tasks/<task>/logs        its log files
+//	GET  /tasks/<task>/values      the values published about it
+//	GET, PUT, DELETE /tasks/<task>/values/<key>
+//	                               one of them, with PUT taking the form
+//	                               value "value"
+//	POST /tasks/<task>/<action>    start, stop, kill, or restart it
+//	POST /tasks/<task>/signal      send it the signal in the form value "signal"
+//	POST /startall, /killall, /reload
+//	POST /reload?dry_run=1         what reloading would do, without doing it
+//	GET  /check                    problems with the task file, if any
+//	GET  /tail?task=<task>&n=10    the last lines of the logs of one or more
+//	                               tasks, as text, and with follow=1, new
+//	                               ones as they come until disconnected;
+//	                               raw=1 keeps timestamps and prefixes
+//	GET  /events?task=<task>       TaskEvents about the given tasks (or all
+//	                               of them) as they happen, one JSON object
+//	                               per line, until disconnected
+//
+// Everything but /tail responds with a Response, or {"Error": "..."}. If
+// token is set, requests need to have it as an "Authorization: Bearer" header.
+type httpAPI struct {
+	tl    *TaskList
+	token string
+}
+
+// serveHTTP serves the API on the unix socket at path and, if addr is set, on
+// TCP as well, which needs a GAS_HTTP_TOKEN.
+func serveHTTP(tl *TaskList, path, addr string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		log.Print(http.Serve(l, &httpAPI{tl: tl}))
+	}()
+
+	if addr == "" {
+		return
+	}
+	token := os.Getenv("GAS_HTTP_TOKEN")
+	if token == "" {
+		log.Fatal("GAS_HTTP_TOKEN must be set to serve the HTTP API over TCP")
+	}
+	go func() {
+		log.Print(http.ListenAndServe(addr, &httpAPI{tl: tl, token: token}))
+	}()
+}
+
+func (h *httpAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.token != "" {
+		auth := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(auth), []byte(h.token)) != 1 {
+			apiError(w, http.StatusUnauthorized, "bad or missing token")
+			return
+		}
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	args := new(Args)
+	if len(parts) > 1 && parts[0] == "tasks" {
+		args.Name = parts[1]
+	}
+	switch {
+	case len(parts) == 1 && parts[0] == "tail":
+		if method(w, r, "GET") {
+			h.tail(w, r)
+		}
+	case len(parts) == 1 && parts[0] == "events":
+		if method(w, r, "GET") {
+			h.events(w, r)
+		}
+	case len(parts) == 1 && parts[0] == "tasks",
+		len(parts) == 2 && parts[0] == "tasks":
+		if method(w, r, "GET") {
+			h.do(w, "status", args)
+		}
+	case len(parts) == 1 && (parts[0] == "startall" || parts[0] == "killall" || parts[0] == "reload"):
+		if method(w, r, "POST") {
+			args.DryRun = r.FormValue("dry_run") != ""
+			h.do(w, parts[0], args)
+		}
+	case len(parts) == 1 && parts[0] == "check":
+		if method(w, r, "GET") {
+			h.do(w, "check", args)
+		}
+	case len(parts) == 4 && parts[0] == "tasks" && parts[2] == "values":
+		args.Args = []string{parts[3]}
+		switch r.Method {
+		case "GET", "HEAD":
+			h.do(w, "get", args)
+		case "PUT":
+			args.Args = append(args.Args, r.FormValue("value"))
+			h.do(w, "set", args)
+		case "DELETE":
+			h.do(w, "set", args)
+		default:
+			method(w, r, "GET")
+		}
+	case len(parts) == 3 && parts[0] == "tasks" && parts[2] == "values":
+		if method(w, r, "GET") {
+			h.do(w, "get", args)
+		}
+	case len(parts) == 3 && parts[0] == "tasks" && parts[2] == "logs":
+		if method(w, r, "GET") {
+			h.do(w, "logs", args)
+		}
+	case len(parts) == 3 && parts[0] == "tasks":
+		if parts[2] == "signal" {
+			args.Args = []string{r.FormValue("signal")}
+		}
+		if method(w, r, "POST") {
+			h.do(w, parts[2], args)
+		}
+	default:
+		apiError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// do does the TaskList operation named op with args and writes the response.
+func (h *httpAPI) do(w http.ResponseWriter, op string, args *Args) {
+	ops := map[string]func(*Args, *Response) error{
+		"status":   h.tl.Status,
+		"logs":     h.tl.Logs,
+		"start":    h.tl.Start,
+		"stop":     h.tl.Stop,
+		"kill":     h.tl.Kill,
+		"restart":  h.tl.Restart,
+		"signal":   h.tl.Signal,
+		"startall": h.tl.StartAll,
+		"killall":  h.tl.Killall,
+		"reload":   h.tl.Reload,
+		"check":    h.tl.Check,
+		"get":      h.tl.Get,
+		"set":      h.tl.Set,
+	}
+	fn, ok := ops[op]
+	if !ok {
+		apiError(w, http.StatusNotFound, "no such operation: "+op)
+		return
+	}
+	resp := new(Response)
+	if err := fn(args, resp); err != nil {
+		code := http.StatusBadRequest
+		if err == ErrNoTask || strings.HasPrefix(err.Error(), "no such task") {
+			code = http.StatusNotFound
+		}
+		apiError(w, code, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// method checks that r was made with the method m, responding with an error
+// if not.
+func method(w http.ResponseWriter, r *http.Request, m string) bool {
+	if r.Method == m || (m == "GET" && r.Method == "HEAD") {
+		return true
+	}
+	w.Header().Set("Allow", m)
+	apiError(w, http.StatusMethodNotAllowed, r.Method+" not allowed")
+	return false
+}
+
+func apiError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct{ Error string }{msg})
+}
+
+// events streams TaskEvents as they come in until the client goes away.
+func (h *httpAPI) events(w http.ResponseWriter, r *http.Request) {
+	names := r.URL.Query()["task"]
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+
+	_, seq, _ := taskEvents.since(0, nil)
+	for {
+		if flusher != nil {
+			flusher.Flush()
+		}
+		events, latest, more := taskEvents.since(seq, names)
+		for _, e := range events {
+			if enc.Encode(e) != nil {
+				return
+			}
+		}
+		seq = latest
+		if len(events) > 0 {
+			continue
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-more:
+		}
+	}
+}
+
+// tail writes the last lines of the logs of the tasks in the query and, if
+// asked to follow them, streams the new ones until the client goes away.
+func (h *httpAPI) tail(w http.ResponseWriter, r *http.Request) {
+	names := r.URL.Query()["task"]
+	if len(names) == 0 {
+		apiError(w, http.StatusBadRequest, ErrNoName.Error())
+		return
+	}
+	args := &Args{Name: names[0], Args: names[1:]}
+	args.Lines, _ = strconv.Atoi(r.FormValue("n"))
+	args.Raw = r.FormValue("raw") != "" && r.FormValue("raw") != "0"
+	resp := new(Response)
+	if err := h.tl.Tail(args, resp); err != nil {
+		apiError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if resp.Status != "" {
+		io.WriteString(w, resp.Status+"\n")
+	}
+	if r.FormValue("follow") == "" || r.FormValue("follow") == "0" {
+		return
+	}
+
+	tasks, err := h.tl.tailTasks(names)
+	if err != nil {
+		return
+	}
+	flusher, _ := w.(http.Flusher)
+	tick := time.NewTicker(followInterval)
+	defer tick.Stop()
+	for {
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-tick.C:
+		}
+		s, err := readNew(tasks, resp.Offsets, args.Raw)
+		if err != nil {
+			return
+		}
+		if s != "" {
+			if _, err := io.WriteString(w, s); err != nil {
+				return
+			}
+		}
+	}
+}
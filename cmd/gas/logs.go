@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"ktkr.us/pkg/logrotate/rotator"
+)
+
+// the size the rotator moves a task's log aside at if LogSize isn't set, in
+// kilobytes
+const defaultLogSize = 5 * 1024
+
+// logStamp is prepended to each line of a task's output if LogTimestamp is set
+const logStamp = "2006-01-02 15:04:05.000 "
+
+func (t *Task) logSize() int64 {
+	if t.LogSize > 0 {
+		return t.LogSize
+	}
+	return defaultLogSize
+}
+
+// newRotator returns a rotator reading the output of t from r into its log
+// at path. newFunc is always rotator.New; it's taken as an argument so that
+// the size can be converted to whichever integer type New declares for it.
+// Old logs are compressed by gas itself, so New is never asked to.
+func newRotator[S ~int | ~int32 | ~int64 | ~uint | ~uint32 | ~uint64](newFunc func(io.Reader, string, S, bool) (*rotator.Rotator, error), t *Task, r io.Reader, path string) (*rotator.Rotator, error) {
+	return newFunc(t.logSource(r), path, S(t.logSize()), false)
+}
+
+// logPrefix is what each line of t's output is prefixed with if LogPrefix is
+// set.
+func (t *Task) logPrefix() string {
+	return "[" + t.Name + "] "
+}
+
+// logSource is what the rotator should read the output of t from, which is
+// r itself unless the lines need timestamping or prefixing or old logs need
+// compressing or pruning as they pile up.
+func (t *Task) logSource(r io.Reader) io.Reader {
+	if !t.LogTimestamp && !t.LogPrefix && t.LogKeep <= 0 && !t.LogCompress {
+		return r
+	}
+	t.tidyLogs()
+
+	pr, pw := io.Pipe()
+	var w io.Writer = &pruningWriter{w: pw, t: t}
+	if t.LogTimestamp || t.LogPrefix {
+		ts := &timestamper{w: w}
+		if t.LogTimestamp {
+			ts.now = time.Now
+		}
+		if t.LogPrefix {
+			ts.prefix = t.logPrefix()
+		}
+		w = ts
+	}
+	go func() {
+		_, err := io.Copy(w, r)
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// timestamper prefixes each line written through it with the time at which
+// its first byte arrived, if now is set, followed by prefix.
+type timestamper struct {
+	w       io.Writer
+	now     func() time.Time
+	prefix  string
+	midLine bool
+}
+
+func (ts *timestamper) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		if !ts.midLine {
+			head := ts.prefix
+			if ts.now != nil {
+				head = ts.now().Format(logStamp) + head
+			}
+			if _, err = io.WriteString(ts.w, head); err != nil {
+				return
+			}
+		}
+		line := p
+		if i := bytes.IndexByte(p, '\n'); i >= 0 {
+			line = p[:i+1]
+		}
+		var m int
+		m, err = ts.w.Write(line)
+		n += m
+		if err != nil {
+			return
+		}
+		ts.midLine = line[len(line)-1] != '\n'
+		p = p[len(line):]
+	}
+	return
+}
+
+// displayLines takes the timestamps and prefixes that t's output was logged
+// with back off of the lines in b, for showing them with tail.
+func (t *Task) displayLines(b []byte) []byte {
+	if !t.LogTimestamp && !t.LogPrefix || len(b) == 0 {
+		return b
+	}
+	prefix := []byte(t.logPrefix())
+	out := make([]byte, 0, len(b))
+	for _, line := range bytes.SplitAfter(b, []byte{'\n'}) {
+		if t.LogTimestamp && len(line) >= len(logStamp) {
+			if _, err := time.Parse(logStamp, string(line[:len(logStamp)])); err == nil {
+				line = line[len(logStamp):]
+			}
+		}
+		if t.LogPrefix {
+			line = bytes.TrimPrefix(line, prefix)
+		}
+		out = append(out, line...)
+	}
+	return out
+}
+
+// pruningWriter tidies up the old logs of t every time about enough has been
+// written through it for the rotator to have moved the log aside.
+type pruningWriter struct {
+	w       io.Writer
+	t       *Task
+	written int64
+}
+
+func (pw *pruningWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	if pw.written += int64(n); pw.written >= pw.t.logSize()*1024 {
+		pw.written = 0
+		pw.t.tidyLogs()
+	}
+	return n, err
+}
+
+// rotatedLogs returns the old logs of t, newest first.
+func (t *Task) rotatedLogs() ([]os.FileInfo, error) {
+	paths, err := filepath.Glob(t.LogPath() + ".*")
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(paths))
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		infos = append(infos, fi)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().After(infos[j].ModTime())
+	})
+	return infos, nil
+}
+
+// tidyLogs compresses the old logs of t if LogCompress is set, then prunes
+// them.
+func (t *Task) tidyLogs() {
+	if t.LogCompress {
+		t.compressLogs()
+	}
+	t.pruneLogs()
+}
+
+// compressLogs gzips the old logs of t that aren't already, keeping their
+// modification times so that they still sort by age.
+func (t *Task) compressLogs() {
+	infos, err := t.rotatedLogs()
+	if err != nil {
+		t.Log("compress logs:", err)
+		return
+	}
+	dir := filepath.Dir(t.LogPath())
+	for _, fi := range infos {
+		if strings.HasSuffix(fi.Name(), ".gz") {
+			continue
+		}
+		if err := compressLog(filepath.Join(dir, fi.Name()), fi.ModTime()); err != nil {
+			t.Log("compress logs:", err)
+		}
+	}
+}
+
+// compressLog replaces the file at path with a gzipped copy at path + ".gz"
+// modified at mtime.
+func compressLog(path string, mtime time.Time) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	_, err = io.Copy(gz, in)
+	if err == nil {
+		err = gz.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chtimes(path+".gz", mtime, mtime)
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+// pruneLogs removes all but the newest LogKeep old logs of t.
+func (t *Task) pruneLogs() {
+	if t.LogKeep <= 0 {
+		return
+	}
+	infos, err := t.rotatedLogs()
+	if err != nil {
+		t.Log("prune logs:", err)
+		return
+	}
+	if len(infos) <= t.LogKeep {
+		return
+	}
+	dir := filepath.Dir(t.LogPath())
+	for _, fi := range infos[t.LogKeep:] {
+		if err := os.Remove(filepath.Join(dir, fi.Name())); err != nil {
+			t.Log("prune logs:", err)
+		}
+	}
+}
+
+// listLogs describes the current and old logs of t, one per line.
+func (t *Task) listLogs() (string, error) {
+	infos, err := t.rotatedLogs()
+	if err != nil {
+		return "", err
+	}
+	if fi, err := os.Stat(t.LogPath()); err == nil {
+		infos = append([]os.FileInfo{fi}, infos...)
+	}
+	if len(infos) == 0 {
+		return "no logs", nil
+	}
+	dir := filepath.Dir(t.LogPath())
+	lines := make([]string, len(infos))
+	for i, fi := range infos {
+		lines[i] = fmt.Sprintf("%s  %d  %s",
+			filepath.Join(dir, fi.Name()), fi.Size(), fi.ModTime().Format(time.RFC3339))
+	}
+	return strings.Join(lines, "\n"), nil
+}
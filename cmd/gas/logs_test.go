@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimestamper(t *testing.T) {
+	now := func() time.Time { return time.Date(2017, 6, 4, 17, 7, 40, 0, time.UTC) }
+	stamp := now().Format(logStamp)
+
+	for _, test := range []struct {
+		writes []string
+		out    string
+	}{
+		{[]string{"a\n"}, stamp + "a\n"},
+		{[]string{"a\nb\n"}, stamp + "a\n" + stamp + "b\n"},
+		{[]string{"a", "b\n", "c\n"}, stamp + "ab\n" + stamp + "c\n"},
+		{[]string{"a\n", "b"}, stamp + "a\n" + stamp + "b"},
+		{[]string{"\n\n"}, stamp + "\n" + stamp + "\n"},
+	} {
+		var buf bytes.Buffer
+		ts := &timestamper{w: &buf, now: now}
+		for _, w := range test.writes {
+			if n, err := ts.Write([]byte(w)); n != len(w) || err != nil {
+				t.Errorf("%q: write %q: got %d, %v", test.writes, w, n, err)
+			}
+		}
+		if buf.String() != test.out {
+			t.Errorf("%q: expected %q, got %q", test.writes, test.out, buf.String())
+		}
+	}
+}
+
+func TestLogSource(t *testing.T) {
+	task := testTask(t, "logs", "true")
+	r := strings.NewReader("a\nb\n")
+	if task.logSource(r) != r {
+		t.Error("expected the output to be logged as it is")
+	}
+
+	task.LogTimestamp = true
+	b, err := ioutil.ReadAll(task.logSource(strings.NewReader("a\nb\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(b), "\n")
+	if len(lines) != 3 || lines[2] != "" {
+		t.Fatalf("expected two lines, got %q", b)
+	}
+	for i, want := range []string{"a\n", "b\n"} {
+		line := lines[i]
+		if len(line) < len(logStamp) || line[len(logStamp):] != want {
+			t.Errorf("expected a timestamp and %q, got %q", want, line)
+			continue
+		}
+		if _, err := time.Parse(logStamp, line[:len(logStamp)]); err != nil {
+			t.Errorf("%q: %v", line, err)
+		}
+	}
+}
+
+// writeOldLogs makes the rotated logs of task named after each of suffixes,
+// each one an hour older than the one before it.
+func writeOldLogs(t *testing.T, task *Task, suffixes ...string) {
+	for i, suffix := range suffixes {
+		path := task.LogPath() + suffix
+		if err := ioutil.WriteFile(path, []byte(suffix), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Now().Add(-time.Duration(i+1) * time.Hour)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func logNames(t *testing.T, task *Task) []string {
+	paths, err := filepath.Glob(task.LogPath() + "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(paths))
+	for i, path := range paths {
+		names[i] = filepath.Base(path)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestPruneLogs(t *testing.T) {
+	task := testTask(t, "logs", "true")
+	if err := ioutil.WriteFile(task.LogPath(), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeOldLogs(t, task, ".c", ".a", ".d", ".b")
+
+	task.pruneLogs()
+	if names := logNames(t, task); len(names) != 5 {
+		t.Errorf("expected nothing pruned without LogKeep, got %v", names)
+	}
+
+	task.LogKeep = 2
+	task.pruneLogs()
+	if names := strings.Join(logNames(t, task), " "); names != "logs.log logs.log.a logs.log.c" {
+		t.Errorf("expected the two newest old logs kept, got %s", names)
+	}
+}
+
+func TestCompressLogs(t *testing.T) {
+	task := testTask(t, "logs", "true")
+	task.LogCompress = true
+	task.LogKeep = 2
+	if err := ioutil.WriteFile(task.LogPath(), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeOldLogs(t, task, ".b", ".a.gz", ".c")
+
+	task.tidyLogs()
+	if names := strings.Join(logNames(t, task), " "); names != "logs.log logs.log.a.gz logs.log.b.gz" {
+		t.Fatalf("expected the newest two old logs kept and compressed, got %s", names)
+	}
+
+	// still the newest, now that it's compressed
+	infos, err := task.rotatedLogs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if infos[0].Name() != "logs.log.b.gz" {
+		t.Errorf("expected logs.log.b.gz to be the newest, got %s", infos[0].Name())
+	}
+
+	f, err := os.Open(task.LogPath() + ".b.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(gz); err != nil || string(b) != ".b" {
+		t.Errorf("expected the old log compressed, got %q, %v", b, err)
+	}
+}
+
+func TestDisplayLines(t *testing.T) {
+	now := func() time.Time { return time.Date(2017, 6, 4, 17, 7, 40, 0, time.UTC) }
+	stamp := now().Format(logStamp)
+
+	for _, test := range []struct {
+		timestamp, prefix bool
+		writes            []string
+		logged, shown     string
+	}{
+		{false, true, []string{"a\n", "b"}, "[logs] a\n[logs] b", "a\nb"},
+		{true, true, []string{"a", "b\nc\n"}, stamp + "[logs] ab\n" + stamp + "[logs] c\n", "ab\nc\n"},
+		{true, false, []string{"a\n", "b"}, stamp + "a\n" + stamp + "b", "a\nb"},
+		// only what was added when logging is taken off
+		{false, true, []string{"[logs] a\n"}, "[logs] [logs] a\n", "[logs] a\n"},
+		{true, false, []string{stamp + "a\n"}, stamp + stamp + "a\n", stamp + "a\n"},
+		{true, true, []string{"[other] a\n"}, stamp + "[logs] [other] a\n", "[other] a\n"},
+	} {
+		task := testTask(t, "logs", "true")
+		task.LogTimestamp, task.LogPrefix = test.timestamp, test.prefix
+
+		var buf bytes.Buffer
+		ts := &timestamper{w: &buf}
+		if test.timestamp {
+			ts.now = now
+		}
+		if test.prefix {
+			ts.prefix = task.logPrefix()
+		}
+		for _, w := range test.writes {
+			ts.Write([]byte(w))
+		}
+		if buf.String() != test.logged {
+			t.Errorf("%q: expected it logged as %q, got %q", test.writes, test.logged, buf.String())
+			continue
+		}
+		if shown := string(task.displayLines(buf.Bytes())); shown != test.shown {
+			t.Errorf("%q: expected it shown as %q, got %q", test.writes, test.shown, shown)
+		}
+
+		// tail only shows complete lines, as they're logged unless asked for
+		// them raw
+		if err := ioutil.WriteFile(task.LogPath(), buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		complete := func(s string) string { return s[:strings.LastIndexByte(s, '\n')+1] }
+		for _, raw := range []bool{false, true} {
+			want := complete(test.shown)
+			if raw {
+				want = complete(test.logged)
+			}
+			got, err := readNew([]*Task{task}, map[string]int64{}, raw)
+			if err != nil || got != want {
+				t.Errorf("%q: expected tail (raw=%v) to show %q, got %q, %v", test.writes, raw, want, got, err)
+			}
+		}
+	}
+
+	task := testTask(t, "logs", "true")
+	if b := "[logs] a\n"; string(task.displayLines([]byte(b))) != b {
+		t.Error("expected nothing taken off the lines of a task logged as they are")
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/signal"
 	"os/user"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
@@ -24,12 +25,18 @@ func main() {
 		flagServer = flag.Bool("s", false, "Run as unprivileged server")
 		flagUser   = flag.String("u", "", "Set up environment for `USER`")
 		flagFile   = flag.String("f", "", "Use named task file")
+		flagHTTP   = flag.String("http", "", "Also serve the HTTP API over TCP on `ADDR` (needs GAS_HTTP_TOKEN)")
+		flagListen = flag.String("listen", "", "Also serve remote clients over TLS on `ADDR`")
+		flagRules  = flag.String("rules", "", "Read what remote clients may do from `FILE` (default ~/.gas_remote.json)")
+		flagRemote = flag.String("r", "", "Send the command to the server listening on `ADDR` (with GAS_TOKEN)")
 	)
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage:\n")
-		fmt.Fprintf(os.Stderr, "  server: %s -s [-f <taskfilepath>] [sockpath]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  server: %s -s [-f <taskfilepath>] [-http <addr>] [-listen <addr> [-rules <file>]] [sockpath]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  remote: %s -r <addr> <command> [args...]\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  client: %s -u <username>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  unit:   %s [-u <username>] [-f <taskfilepath>] systemd-unit\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "Options:\n")
 		flag.PrintDefaults()
 	}
@@ -39,13 +46,31 @@ func main() {
 	log.SetFlags(0)
 
 	if *flagServer {
-		runServer(*flagFile, flag.Arg(0))
+		runServer(*flagFile, flag.Arg(0), *flagHTTP, *flagListen, *flagRules)
+		return
+	}
+
+	if flag.Arg(0) == "systemd-unit" {
+		u, err := user.Current()
+		if *flagUser != "" {
+			u, err = user.Lookup(*flagUser)
+		}
+		c, err := userConfig(u, err)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *flagFile != "" {
+			c.taskfilePath = *flagFile
+		}
+		if err = writeUnit(os.Stdout, c); err != nil {
+			log.Fatal(err)
+		}
 		return
 	}
 
 	// run subcommand
 	if flag.NArg() > 0 {
-		handleCommand(flag.Arg(0), flag.Args()[1:])
+		handleCommand(*flagRemote, flag.Arg(0), flag.Args()[1:])
 		return
 	}
 
@@ -95,7 +120,7 @@ func main() {
 	log.Println("setup done - please launch with -s flag")
 }
 
-func runServer(flagFile string, sockpath string) {
+func runServer(flagFile string, sockpath string, httpAddr, listenAddr, rulesPath string) {
 	c, err := userConfig(user.Current())
 	if err != nil {
 		log.Fatal(err)
@@ -105,6 +130,7 @@ func runServer(flagFile string, sockpath string) {
 	}
 	if sockpath != "" {
 		c.sockPath = sockpath
+		c.httpSockPath = filepath.Join(filepath.Dir(sockpath), "gas-http.sock")
 	}
 
 	if c.u.Uid == "0" {
@@ -114,10 +140,20 @@ func runServer(flagFile string, sockpath string) {
 	log.SetPrefix("")
 	log.SetFlags(log.LstdFlags)
 
+	if pool := os.Getenv("GAS_PORT_POOL"); pool != "" {
+		if err = ports.configure(pool); err != nil {
+			log.Fatal(err)
+		}
+	}
+	adopting, err := readAdoptState()
+	if err != nil {
+		log.Print(err)
+	}
 	tasks, err := c.loadTasks()
 	if err != nil {
 		log.Fatal(err)
 	}
+	tasks.publishPorts()
 	n := 0
 	for _, t := range tasks.Tasks {
 		if t.Enable {
@@ -143,46 +179,75 @@ func runServer(flagFile string, sockpath string) {
 	}
 	defer os.Remove(c.sockPath)
 
-	for _, task := range tasks.Tasks {
-		if task.Enable {
-			go task.Run(statusChan)
+	toStart := tasks.Tasks
+	if adopted := tasks.adoptTasks(adopting, statusChan); len(adopted) > 0 {
+		toStart = nil
+		for _, t := range tasks.Tasks {
+			if !adopted[t.Name] {
+				toStart = append(toStart, t)
+			}
 		}
 	}
+	go tasks.startOrdered(toStart, statusChan)
+	for _, t := range tasks.Tasks {
+		tasks.watch(t)
+	}
+	go tasks.monitorHealth()
 
 	rpc.Register(&tasks)
 	go rpc.Accept(l)
+	serveHTTP(&tasks, c.httpSockPath, httpAddr)
+	if listenAddr != "" {
+		if rulesPath == "" {
+			rulesPath = filepath.Join(c.u.HomeDir, ".gas_remote.json")
+		}
+		if err = serveRemote(listenAddr, rulesPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	defer os.Remove(c.httpSockPath)
 
 	sigchan := make(chan os.Signal, 2)
 	signal.Notify(sigchan, os.Interrupt, syscall.SIGHUP)
 
+	sinks := notifySinks()
+
+	sdNotify("READY=1")
+	go sdWatchdog()
+
 	for {
 		select {
 		case ts := <-statusChan:
 			if !ts.Alive {
 				if ts.Enable {
 					log.Printf("%s died: %s", ts.Name, ts.Message)
-					go tasks.save(ts.Name, statusChan)
+					taskEvents.add("died", ts.Name, "%s", ts.Message)
+					var logPath string
+					if t, err := tasks.lookup(ts.Name); err == nil {
+						logPath = t.LogPath()
+					}
+					go notifyTaskDeath(sinks, ts, logPath)
+					go tasks.save(ts.Name, statusChan, sinks)
 				} else {
 					log.Printf("%s killed: %s", ts.Name, ts.Message)
+					taskEvents.add("stopped", ts.Name, "%s", ts.Message)
 				}
 			}
 
 		case sig := <-sigchan:
 			switch sig {
 			case os.Interrupt:
-				log.Print("killing tasks...")
-				for _, task := range tasks.Tasks {
-					if task.Alive() {
-						if err = task.Kill(); err != nil {
-							log.Print(err)
-						}
-					}
-				}
+				sdNotify("STOPPING=1")
+				log.Print("stopping tasks...")
+				stopAll(tasks.Tasks)
+				flushSinks(sinks)
 				log.Print("bye")
 				return
 
 			case syscall.SIGHUP:
-				res, err := tasks.reload()
+				sdNotify("RELOADING=1")
+				res, err := tasks.reload(false)
+				sdNotify("READY=1")
 				if err != nil {
 					log.Print(err)
 					break
@@ -202,18 +267,23 @@ func runServer(flagFile string, sockpath string) {
 			switch v := tasksToStart.(type) {
 			case []*Task:
 				for _, task := range v {
-					if task.Enable {
-						go task.Run(statusChan)
-					} else {
-						task.Signal(signalMap["TERM"])
+					if !task.Enable {
+						task.Stop()
 					}
 				}
+				go tasks.startOrdered(v, statusChan)
 
 			case *Task:
 				if v.Enable {
-					go v.Run(statusChan)
+					v.start(statusChan, true)
 				} else {
-					v.Signal(signalMap["TERM"])
+					v.Stop()
+				}
+
+			case upgradeRequest:
+				flushSinks(sinks)
+				if err = tasks.upgrade(string(v)); err != nil {
+					log.Print(err)
 				}
 			}
 		}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"ktkr.us/pkg/gas/notify"
+)
+
+const defaultAlertLogLines = 20
+
+// taskKinds are the kinds of events sent about tasks.
+var taskKinds = []string{"task_death", "crash_loop"}
+
+// A taskSink is a sink along with the comma-separated kinds of events it
+// wants, or "" for all of them.
+type taskSink struct {
+	notify.Sink
+	events string
+}
+
+func (s taskSink) wants(kind string) bool {
+	return s.events == "" || wantsKind(s.events, kind)
+}
+
+// notifySinks sets up the notification sinks given in the environment, using
+// the same variables as the framework does, for telling somebody about tasks
+// that die.
+func notifySinks() []taskSink {
+	var sinks []taskSink
+
+	events := os.Getenv("GAS_WEBHOOK_EVENTS")
+	if wantsAny(events, taskKinds) {
+		secret := []byte(os.Getenv("GAS_WEBHOOK_SECRET"))
+		for _, u := range strings.Split(os.Getenv("GAS_WEBHOOK_URL"), ",") {
+			if u = strings.TrimSpace(u); u != "" {
+				sinks = append(sinks, taskSink{&notify.Webhook{URL: u, Secret: secret}, events})
+			}
+		}
+	}
+
+	events = os.Getenv("GAS_CHAT_EVENTS")
+	if wantsAny(events, taskKinds) {
+		for _, u := range strings.Split(os.Getenv("GAS_CHAT_WEBHOOK_URL"), ",") {
+			if u = strings.TrimSpace(u); u != "" {
+				sinks = append(sinks, taskSink{&notify.Chat{URL: u}, events})
+			}
+		}
+	}
+
+	events = os.Getenv("GAS_EMAIL_EVENTS")
+	addr, to := os.Getenv("GAS_SMTP_ADDR"), os.Getenv("GAS_EMAIL_TO")
+	if addr != "" && to != "" && wantsAny(events, taskKinds) {
+		m := &notify.Email{
+			Addr:     addr,
+			Username: os.Getenv("GAS_SMTP_USERNAME"),
+			Password: os.Getenv("GAS_SMTP_PASSWORD"),
+			From:     os.Getenv("GAS_EMAIL_FROM"),
+		}
+		for _, addr := range strings.Split(to, ",") {
+			m.To = append(m.To, strings.TrimSpace(addr))
+		}
+		if digest := os.Getenv("GAS_EMAIL_DIGEST"); digest != "" {
+			d, err := time.ParseDuration(digest)
+			if err != nil {
+				log.Printf("GAS_EMAIL_DIGEST: %v", err)
+			}
+			m.Digest = d
+		}
+		sinks = append(sinks, taskSink{m, events})
+	}
+
+	events = os.Getenv("GAS_ALERT_COMMAND_EVENTS")
+	if command := os.Getenv("GAS_ALERT_COMMAND"); command != "" && wantsAny(events, taskKinds) {
+		sinks = append(sinks, taskSink{&notify.Command{Command: command}, events})
+	}
+
+	return sinks
+}
+
+// flushSinks sends off any events that sinks are still holding on to.
+func flushSinks(sinks []taskSink) {
+	for _, sink := range sinks {
+		if f, ok := sink.Sink.(interface{ Flush() error }); ok {
+			if err := f.Flush(); err != nil {
+				log.Printf("notify: %v", err)
+			}
+		}
+	}
+}
+
+func wantsKind(list, kind string) bool {
+	for _, k := range strings.Split(list, ",") {
+		if strings.TrimSpace(k) == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// wantsAny is whether an events list wants any of kinds; an empty list wants
+// everything.
+func wantsAny(list string, kinds []string) bool {
+	if list == "" {
+		return true
+	}
+	for _, kind := range kinds {
+		if wantsKind(list, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyTaskDeath sends a "task_death" event for ts to every sink, logging
+// any failures. The event has the exit status, if there was one, and the last
+// GAS_ALERT_LOG_LINES (default 20) lines of the log at logPath.
+func notifyTaskDeath(sinks []taskSink, ts *TaskStatus, logPath string) {
+	fields := map[string]string{"task": ts.Name}
+	if ts.ExitCode >= 0 {
+		fields["exit_status"] = strconv.Itoa(ts.ExitCode)
+	}
+	n := defaultAlertLogLines
+	if s := os.Getenv("GAS_ALERT_LOG_LINES"); s != "" {
+		var err error
+		if n, err = strconv.Atoi(s); err != nil {
+			log.Printf("GAS_ALERT_LOG_LINES: %v", err)
+			n = defaultAlertLogLines
+		}
+	}
+	if n > 0 && logPath != "" {
+		if b, _, err := lastLines(logPath, n); err == nil && len(b) > 0 {
+			fields["log"] = string(b)
+		}
+	}
+	send(sinks, &notify.Event{
+		Kind:   "task_death",
+		Title:  "task " + ts.Name + " died",
+		Error:  ts.Message,
+		Fields: fields,
+	})
+}
+
+// notifyCrashLoop sends a "crash_loop" event for t, which has just been
+// disabled, to every sink that wants it.
+func notifyCrashLoop(sinks []taskSink, t *Task) {
+	send(sinks, &notify.Event{
+		Kind:  "crash_loop",
+		Title: "task " + t.Name + " is crash looping and has been disabled",
+		Fields: map[string]string{
+			"task":     t.Name,
+			"restarts": strconv.Itoa(t.policy.max),
+			"window":   t.policy.window.String(),
+		},
+	})
+}
+
+func send(sinks []taskSink, e *notify.Event) {
+	for _, sink := range sinks {
+		if !sink.wants(e.Kind) {
+			continue
+		}
+		if err := notify.Send(sink.Sink, e); err != nil {
+			log.Printf("notify: %s: %v", e.Kind, err)
+		}
+	}
+}
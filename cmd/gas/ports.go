@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// portPool hands out ports from a range to the tasks with AutoPort, keeping
+// the same one for a task for as long as it's in the task file.
+type portPool struct {
+	mu       sync.Mutex
+	lo, hi   int
+	assigned map[string]int
+}
+
+var ports = &portPool{assigned: make(map[string]int)}
+
+// configure sets the range ports are handed out from, given as "lo-hi".
+func (p *portPool) configure(spec string) error {
+	lo, hi, ok := strings.Cut(spec, "-")
+	if !ok {
+		return errors.Errorf("port pool %q: expected lo-hi", spec)
+	}
+	l, err := strconv.Atoi(strings.TrimSpace(lo))
+	if err != nil {
+		return errors.Wrapf(err, "port pool %q", spec)
+	}
+	h, err := strconv.Atoi(strings.TrimSpace(hi))
+	if err != nil {
+		return errors.Wrapf(err, "port pool %q", spec)
+	}
+	if l <= 0 || h > 65535 || l > h {
+		return errors.Errorf("port pool %q: bad range", spec)
+	}
+	p.mu.Lock()
+	p.lo, p.hi = l, h
+	p.mu.Unlock()
+	return nil
+}
+
+// assign returns the port for the named task, picking a free one if it
+// doesn't have one yet.
+func (p *portPool) assign(name string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if port, ok := p.assigned[name]; ok {
+		return strconv.Itoa(port), nil
+	}
+	if p.lo == 0 {
+		return "", errors.New("AutoPort needs a port pool (GAS_PORT_POOL=lo-hi)")
+	}
+	taken := make(map[int]bool, len(p.assigned))
+	for _, port := range p.assigned {
+		taken[port] = true
+	}
+	for port := p.lo; port <= p.hi; port++ {
+		if taken[port] {
+			continue
+		}
+		// something outside of gas may be using it
+		l, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+		if err != nil {
+			continue
+		}
+		l.Close()
+		p.assigned[name] = port
+		return strconv.Itoa(port), nil
+	}
+	return "", errors.Errorf("port pool %d-%d is used up", p.lo, p.hi)
+}
+
+// claim gives the named task the port it had before gas was upgraded.
+func (p *portPool) claim(name, port string) {
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		return
+	}
+	p.mu.Lock()
+	p.assigned[name] = n
+	p.mu.Unlock()
+}
+
+// release gives the port of the named task back to the pool.
+func (p *portPool) release(name string) {
+	p.mu.Lock()
+	delete(p.assigned, name)
+	p.mu.Unlock()
+}
+
+// publishPorts records the ports picked for the tasks in the registry.
+func (tl *TaskList) publishPorts() {
+	for _, t := range tl.Tasks {
+		if t.AutoPort {
+			tl.kv.set(t.Name, "port", t.port)
+		}
+	}
+}
+
+// Print configuration in args.Format, nginx (the default) or caddy, for a
+// reverse proxy in front of the named tasks, or all of them that have a port.
+// Tasks in the same group share an upstream named after it.
+func (tl *TaskList) Upstream(args *Args, resp *Response) error {
+	format := args.Format
+	if format == "" {
+		format = "nginx"
+	}
+	if format != "nginx" && format != "caddy" {
+		return fmt.Errorf("unknown upstream format %q, expected nginx or caddy", format)
+	}
+
+	var names []string
+	if args.Name != "" {
+		names = append([]string{args.Name}, args.Args...)
+	}
+
+	tl.mu.RLock()
+	upstreams := make(map[string][]string)
+	var keys []string
+	for _, t := range tl.Tasks {
+		if len(names) > 0 && !contains(names, t.Name) {
+			continue
+		}
+		taskPorts := t.DeployPorts
+		if len(taskPorts) == 0 && t.gasPort() != "" {
+			taskPorts = []string{t.gasPort()}
+		}
+		if len(taskPorts) == 0 {
+			continue
+		}
+		name := t.Name
+		if t.Group != "" {
+			name = t.Group
+		}
+		if _, ok := upstreams[name]; !ok {
+			keys = append(keys, name)
+		}
+		for _, port := range taskPorts {
+			upstreams[name] = append(upstreams[name], "127.0.0.1:"+port)
+		}
+	}
+	tl.mu.RUnlock()
+	if len(keys) == 0 {
+		return fmt.Errorf("no tasks with ports")
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for i, name := range keys {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		switch format {
+		case "nginx":
+			fmt.Fprintf(&b, "upstream %s {\n", name)
+			for _, addr := range upstreams[name] {
+				fmt.Fprintf(&b, "    server %s;\n", addr)
+			}
+			b.WriteString("}\n")
+		case "caddy":
+			fmt.Fprintf(&b, "# %s\nreverse_proxy %s\n", name, strings.Join(upstreams[name], " "))
+		}
+	}
+	resp.Status = strings.TrimSuffix(b.String(), "\n")
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// registry holds the values published about each task, like the port it's
+// listening on or the version it's running. It belongs to the TaskList, so
+// values outlive reloads.
+type registry struct {
+	mu sync.RWMutex
+	m  map[string]map[string]string
+}
+
+func newRegistry() *registry {
+	return &registry{m: make(map[string]map[string]string)}
+}
+
+func (r *registry) get(task, key string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	v, ok := r.m[task][key]
+	return v, ok
+}
+
+// keys returns the keys set for task, sorted.
+func (r *registry) keys(task string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	keys := make([]string, 0, len(r.m[task]))
+	for k := range r.m[task] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// set sets key for task to value, or removes it if value is empty.
+func (r *registry) set(task, key, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if value == "" {
+		delete(r.m[task], key)
+		return
+	}
+	if r.m[task] == nil {
+		r.m[task] = make(map[string]string)
+	}
+	r.m[task][key] = value
+}
+
+// status fills in the values of the StatusKeys of t in ts.
+func (r *registry) status(t *Task, ts *TaskStatus) {
+	for _, k := range t.StatusKeys {
+		if v, ok := r.get(t.Name, k); ok {
+			if ts.Values == nil {
+				ts.Values = make(map[string]string)
+			}
+			ts.Values[k] = v
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/gob"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/rpc"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// A remoteRule lets clients connecting over TCP that present Token, or a
+// verified client certificate for the common name Client, run Commands
+// (names as given to the gas command, or "*" for all of them). Any of them
+// can be given an @group instead of a task name.
+type remoteRule struct {
+	Token    string
+	Client   string
+	Commands []string
+}
+
+func (r *remoteRule) allows(command string) bool {
+	// @group arguments are looked up before the command itself is run
+	if command == "names" {
+		return len(r.Commands) > 0
+	}
+	for _, c := range r.Commands {
+		if c == "*" || strings.EqualFold(c, command) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadRemoteRules reads a JSON list of remoteRules from path.
+func loadRemoteRules(path string) ([]remoteRule, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "remote rules")
+	}
+	var rules []remoteRule
+	if err = json.Unmarshal(b, &rules); err != nil {
+		return nil, errors.Wrap(err, "remote rules")
+	}
+	for _, r := range rules {
+		if r.Token == "" && r.Client == "" {
+			return nil, errors.New("remote rules: each rule needs a Token or a Client")
+		}
+	}
+	return rules, nil
+}
+
+// the RPC methods called by the gas command for others, e.g. "tail -f", and
+// those commands
+var remoteCommands = map[string]string{
+	"follow": "tail",
+	"events": "watch",
+	"output": "run",
+}
+
+// remoteCommand gives the command, as rules name it, that the RPC method is
+// called for.
+func remoteCommand(method string) string {
+	command := strings.ToLower(strings.TrimPrefix(method, "TaskList."))
+	if c, ok := remoteCommands[command]; ok {
+		return c
+	}
+	return command
+}
+
+// authorize finds out whether a client with the given token and verified
+// certificate name may run command.
+func authorize(rules []remoteRule, token, client, command string) bool {
+	for _, r := range rules {
+		if r.Token != "" && subtle.ConstantTimeCompare([]byte(r.Token), []byte(token)) != 1 {
+			continue
+		}
+		if r.Client != "" && r.Client != client {
+			continue
+		}
+		if r.allows(command) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveRemote serves the task RPC over TLS on addr, for operating the tasks
+// from other hosts. The certificate and key are read from GAS_TLS_CERT and
+// GAS_TLS_KEY, and if GAS_TLS_CLIENT_CA is set, clients with certificates
+// signed by it are identified by their common name. What clients may do is
+// up to the rules in the file at rulesPath.
+func serveRemote(addr, rulesPath string) error {
+	rules, err := loadRemoteRules(rulesPath)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(os.Getenv("GAS_TLS_CERT"), os.Getenv("GAS_TLS_KEY"))
+	if err != nil {
+		return errors.Wrap(err, "remote: GAS_TLS_CERT/GAS_TLS_KEY")
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if ca := os.Getenv("GAS_TLS_CLIENT_CA"); ca != "" {
+		pool, err := loadCertPool(ca)
+		if err != nil {
+			return errors.Wrap(err, "remote: GAS_TLS_CLIENT_CA")
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	l, err := tls.Listen("tcp", addr, conf)
+	if err != nil {
+		return errors.Wrap(err, "remote")
+	}
+	log.Printf("serving remote clients on %s", addr)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				log.Print("remote: ", err)
+				return
+			}
+			go serveRemoteConn(conn.(*tls.Conn), rules)
+		}
+	}()
+	return nil
+}
+
+func serveRemoteConn(conn *tls.Conn, rules []remoteRule) {
+	if err := conn.Handshake(); err != nil {
+		log.Printf("remote: %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	var client string
+	if chains := conn.ConnectionState().VerifiedChains; len(chains) > 0 {
+		client = chains[0][0].Subject.CommonName
+	}
+	buf := bufio.NewWriter(conn)
+	rpc.ServeCodec(&authCodec{
+		rwc:    conn,
+		dec:    gob.NewDecoder(conn),
+		enc:    gob.NewEncoder(buf),
+		encBuf: buf,
+		rules:  rules,
+		client: client,
+		addr:   conn.RemoteAddr().String(),
+	})
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificates found")
+	}
+	return pool, nil
+}
+
+// authCodec is the gob codec net/rpc uses by default, which turns away calls
+// the client isn't allowed to make. The error is sent back as the call's
+// response and the connection carries on.
+type authCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	closed bool
+
+	rules  []remoteRule
+	client string // verified certificate name, if any
+	addr   string
+	method string // of the request being read
+}
+
+func (c *authCodec) ReadRequestHeader(r *rpc.Request) error {
+	if err := c.dec.Decode(r); err != nil {
+		return err
+	}
+	c.method = r.ServiceMethod
+	return nil
+}
+
+func (c *authCodec) ReadRequestBody(body interface{}) error {
+	var args Args
+	if err := c.dec.Decode(&args); err != nil {
+		return err
+	}
+	command := remoteCommand(c.method)
+	if !authorize(c.rules, args.Token, c.client, command) {
+		log.Printf("remote: %s: %s denied", c.addr, command)
+		return errors.Errorf("%s: permission denied", command)
+	}
+	args.Token = ""
+	if a, ok := body.(*Args); ok {
+		*a = args
+	}
+	return nil
+}
+
+func (c *authCodec) WriteResponse(r *rpc.Response, body interface{}) (err error) {
+	if err = c.enc.Encode(r); err != nil {
+		if c.encBuf.Flush() == nil {
+			c.Close()
+		}
+		return
+	}
+	if err = c.enc.Encode(body); err != nil {
+		if c.encBuf.Flush() == nil {
+			c.Close()
+		}
+		return
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *authCodec) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
+
+// dialRemote connects to a gas server's remote listener at addr. The server
+// certificate is checked against GAS_TLS_CA if it's set (or the system roots
+// otherwise), and GAS_TLS_CERT and GAS_TLS_KEY are presented as the client
+// certificate if they're set.
+func dialRemote(addr string) (*rpc.Client, error) {
+	conf := &tls.Config{MinVersion: tls.VersionTLS12}
+	if ca := os.Getenv("GAS_TLS_CA"); ca != "" {
+		pool, err := loadCertPool(ca)
+		if err != nil {
+			return nil, errors.Wrap(err, "GAS_TLS_CA")
+		}
+		conf.RootCAs = pool
+	}
+	if certFile := os.Getenv("GAS_TLS_CERT"); certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, os.Getenv("GAS_TLS_KEY"))
+		if err != nil {
+			return nil, errors.Wrap(err, "GAS_TLS_CERT/GAS_TLS_KEY")
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	conn, err := tls.Dial("tcp", addr, conf)
+	if err != nil {
+		return nil, err
+	}
+	return rpc.NewClient(conn), nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestAuthorize(t *testing.T) {
+	rules := []remoteRule{
+		{Token: "ops", Commands: []string{"*"}},
+		{Token: "ci", Commands: []string{"Deploy", "status"}},
+		{Client: "monitor", Commands: []string{"status", "tail", "watch"}},
+		{Token: "both", Client: "runner", Commands: []string{"run"}},
+		{Token: "nothing"},
+	}
+
+	tests := []struct {
+		token, client, method string
+		ok                    bool
+	}{
+		{"ops", "", "TaskList.Kill", true},
+		{"ci", "", "TaskList.Deploy", true},
+		{"ci", "", "TaskList.Status", true},
+		{"ci", "", "TaskList.Stop", false},
+		{"ci", "monitor", "TaskList.Follow", true},
+		{"", "monitor", "TaskList.Tail", true},
+		{"", "monitor", "TaskList.Follow", true},
+		{"", "monitor", "TaskList.Events", true},
+		{"", "monitor", "TaskList.Output", false},
+		{"", "monitor", "TaskList.Names", true},
+		{"both", "runner", "TaskList.Run", true},
+		{"both", "runner", "TaskList.Output", true},
+		{"both", "", "TaskList.Run", false},
+		{"", "runner", "TaskList.Run", false},
+		{"nothing", "", "TaskList.Names", false},
+		{"wrong", "", "TaskList.Status", false},
+		{"", "", "TaskList.Names", false},
+	}
+	for _, test := range tests {
+		if ok := authorize(rules, test.token, test.client, remoteCommand(test.method)); ok != test.ok {
+			t.Errorf("%q/%q calling %s: expected %v, got %v", test.token, test.client, test.method, test.ok, ok)
+		}
+	}
+}
+
+func TestRemoteCommand(t *testing.T) {
+	for method, command := range map[string]string{
+		"TaskList.Status":   "status",
+		"TaskList.StartAll": "startall",
+		"TaskList.Follow":   "tail",
+		"TaskList.Events":   "watch",
+		"TaskList.Output":   "run",
+		"TaskList.Names":    "names",
+	} {
+		if c := remoteCommand(method); c != command {
+			t.Errorf("%s: expected %q, got %q", method, command, c)
+		}
+	}
+}
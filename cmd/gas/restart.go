@@ -0,0 +1,124 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// defaults for the restart policy fields of Task
+const (
+	defaultRestartDelay    = 5 * time.Second
+	defaultRestartMaxDelay = 5 * time.Minute
+	defaultRestartWindow   = 10 * time.Minute
+	defaultMaxRestarts     = 10
+)
+
+// restartPolicy is the parsed form of a task's restart fields.
+type restartPolicy struct {
+	delay, maxDelay, window time.Duration
+	max                     int // negative for no limit
+}
+
+func (t *Task) parseRestartPolicy() error {
+	p := restartPolicy{defaultRestartDelay, defaultRestartMaxDelay, defaultRestartWindow, t.MaxRestarts}
+	for _, f := range []struct {
+		s string
+		d *time.Duration
+	}{
+		{t.RestartDelay, &p.delay},
+		{t.RestartMaxDelay, &p.maxDelay},
+		{t.RestartWindow, &p.window},
+	} {
+		if f.s == "" {
+			continue
+		}
+		d, err := time.ParseDuration(f.s)
+		if err != nil {
+			return err
+		}
+		*f.d = d
+	}
+	if p.max == 0 {
+		p.max = defaultMaxRestarts
+	}
+	t.policy = p
+	return nil
+}
+
+// nextRestart decides how long to wait before bringing t back after it died
+// at now, recording the restart. It returns false if t has died too often
+// lately and should be given up on.
+func (t *Task) nextRestart(now time.Time) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// forget about the restarts that have fallen out of the window
+	recent := t.restarts[:0]
+	for _, r := range t.restarts {
+		if now.Sub(r) < t.policy.window {
+			recent = append(recent, r)
+		}
+	}
+	t.restarts = recent
+
+	if t.policy.max >= 0 && len(t.restarts) >= t.policy.max {
+		t.crashLoop = true
+		t.restartAt = time.Time{}
+		return 0, false
+	}
+
+	delay := t.policy.delay
+	for i := 0; i < len(t.restarts) && delay < t.policy.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > t.policy.maxDelay {
+		delay = t.policy.maxDelay
+	}
+	// up to a fifth either way, so that tasks that died together don't all
+	// come back at the same moment
+	if jitter := int64(delay / 5); jitter > 0 {
+		delay += time.Duration(rand.Int63n(2*jitter) - jitter)
+	}
+
+	t.restarts = append(t.restarts, now)
+	t.restartAt = now.Add(delay)
+	return delay, true
+}
+
+// resetRestarts forgets t's restart history, e.g. when it's started by hand.
+func (t *Task) resetRestarts() {
+	t.mu.Lock()
+	t.restarts = nil
+	t.restartAt = time.Time{}
+	t.crashLoop = false
+	t.mu.Unlock()
+}
+
+// restartDue clears the pending restart once its time has come.
+func (t *Task) restartDue() {
+	t.mu.Lock()
+	t.restartAt = time.Time{}
+	t.mu.Unlock()
+}
+
+// inheritRestarts carries the restart history of old over to t, which is
+// replacing it in a reload.
+func (t *Task) inheritRestarts(old *Task) {
+	old.mu.Lock()
+	defer old.mu.Unlock()
+	t.mu.Lock()
+	t.restarts = append([]time.Time(nil), old.restarts...)
+	t.restartAt = old.restartAt
+	t.mu.Unlock()
+}
+
+// restartStatus fills in the restart state of ts.
+func (t *Task) restartStatus(ts *TaskStatus) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ts.Restarts = len(t.restarts)
+	ts.CrashLoop = t.crashLoop
+	if !ts.Alive {
+		ts.RestartAt = t.restartAt
+	}
+}
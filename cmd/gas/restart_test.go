@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextRestart(t *testing.T) {
+	task := &Task{MaxRestarts: 4, RestartDelay: "10s", RestartMaxDelay: "35s", RestartWindow: "1m"}
+	if err := task.parseRestartPolicy(); err != nil {
+		t.Fatal(err)
+	}
+	within := func(d, expected time.Duration) bool {
+		return d >= expected-expected/5 && d <= expected+expected/5
+	}
+
+	now := time.Now()
+	// doubling each time, up to the maximum delay
+	for i, expected := range []time.Duration{10 * time.Second, 20 * time.Second, 35 * time.Second} {
+		d, ok := task.nextRestart(now)
+		if !ok || !within(d, expected) {
+			t.Errorf("restart %d: expected about %v, got %v %v", i, expected, d, ok)
+		}
+		if got := task.restartAt; !got.Equal(now.Add(d)) {
+			t.Errorf("restart %d: expected it at %v, got %v", i, now.Add(d), got)
+		}
+	}
+
+	// the earlier ones fall out of the window
+	now = now.Add(time.Minute)
+	if d, ok := task.nextRestart(now); !ok || !within(d, 10*time.Second) || len(task.restarts) != 1 {
+		t.Errorf("expected the window to be pruned, got %v %v with %d restarts", d, ok, len(task.restarts))
+	}
+
+	// and then it's given up on
+	for i := 0; i < 3; i++ {
+		if _, ok := task.nextRestart(now); !ok {
+			t.Fatalf("restart %d: expected it to be allowed", i+2)
+		}
+	}
+	if _, ok := task.nextRestart(now); ok || !task.crashLoop || !task.restartAt.IsZero() {
+		t.Errorf("expected a crash loop after %d restarts", len(task.restarts))
+	}
+
+	task.resetRestarts()
+	if _, ok := task.nextRestart(now); !ok {
+		t.Error("expected a restart after resetting")
+	}
+
+	// no limit
+	task = &Task{MaxRestarts: -1}
+	task.parseRestartPolicy()
+	for i := 0; i < 50; i++ {
+		if _, ok := task.nextRestart(now); !ok {
+			t.Fatalf("restart %d: expected no limit", i)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// how long a finished one-shot run is kept around for its output to be
+// collected
+const oneShotKeep = 10 * time.Minute
+
+// A oneShot is a run of a task (or of another command in its environment)
+// started with gas run, outside of the task's own lifecycle.
+type oneShot struct {
+	t    *Task
+	done chan struct{} // closed when it's finished
+	stat *TaskStatus   // how it finished
+}
+
+var oneShots = struct {
+	sync.Mutex
+	seq  int64
+	runs map[int64]*oneShot
+}{runs: make(map[int64]*oneShot)}
+
+// newOneShot makes a task with t's settings that runs args instead of its
+// Args, or with command, args[0] with the rest of them instead of its Invoke.
+// Its output goes to its own log next to t's, and it leaves t's pid file
+// alone.
+func newOneShot(t *Task, command bool, args []string) (*Task, error) {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return nil, errors.Wrap(err, "run")
+	}
+	o := new(Task)
+	if err = json.Unmarshal(b, o); err != nil {
+		return nil, errors.Wrap(err, "run")
+	}
+	if command {
+		if len(args) == 0 {
+			return nil, errors.New("run: no command given")
+		}
+		o.Invoke, o.Args = args[0], args[1:]
+	} else if len(args) > 0 {
+		o.Args = args
+	}
+	o.Notify = false
+	o.c = t.c
+	o.port = t.port
+	o.oneShot = true
+	return o, nil
+}
+
+// Run a task once in the foreground, with args.Args in place of its Args, or
+// with args.Command, as the command in args.Args. The client collects its
+// output with Output using the Seq in the response.
+func (tl *TaskList) Run(args *Args, resp *Response) error {
+	tl.mu.RLock()
+	t, err := tl.lookup(args.Name)
+	tl.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	o, err := newOneShot(t, args.Command, args.Args)
+	if err != nil {
+		return err
+	}
+
+	var off int64
+	if fi, err := os.Stat(o.LogPath()); err == nil {
+		off = fi.Size()
+	}
+
+	run := &oneShot{t: o, done: make(chan struct{})}
+	oneShots.Lock()
+	oneShots.seq++
+	id := oneShots.seq
+	oneShots.runs[id] = run
+	oneShots.Unlock()
+
+	ch := make(chan *TaskStatus, 1)
+	go o.Run(ch)
+	go func() {
+		run.stat = <-ch
+		close(run.done)
+		taskEvents.add("ran", o.Name, "%s %v exited with %d", o.Invoke, o.Args, run.stat.ExitCode)
+		time.AfterFunc(oneShotKeep, func() {
+			oneShots.Lock()
+			delete(oneShots.runs, id)
+			oneShots.Unlock()
+		})
+	}()
+
+	resp.Seq = id
+	resp.Offsets = map[string]int64{o.Name: off}
+	return nil
+}
+
+// Output returns what the one-shot run args.Since has logged since
+// args.Offsets, waiting a while for there to be something. Once it has
+// finished and all of it has been read, its final status is in resp.Tasks.
+func (tl *TaskList) Output(args *Args, resp *Response) error {
+	oneShots.Lock()
+	run, ok := oneShots.runs[args.Since]
+	oneShots.Unlock()
+	if !ok {
+		return fmt.Errorf("no such run: %d", args.Since)
+	}
+	name := run.t.Name
+	resp.Seq = args.Since
+	resp.Offsets = map[string]int64{name: args.Offsets[name]}
+
+	deadline := time.Now().Add(followWait)
+	for {
+		var finished bool
+		select {
+		case <-run.done:
+			finished = true
+		default:
+		}
+		b, off, err := readLines(run.t.LogPath(), resp.Offsets[name])
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		resp.Offsets[name] = off
+		resp.Status = string(run.t.displayLines(b))
+		if finished && len(b) == 0 {
+			resp.addStatus(*run.stat)
+			return nil
+		}
+		if len(b) > 0 || time.Now().After(deadline) {
+			return nil
+		}
+		time.Sleep(followInterval)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// what to do when a scheduled task is due while its last run is still going
+const (
+	overlapSkip  = "skip"  // don't run it this time
+	overlapQueue = "queue" // run it again as soon as the last run finishes
+	overlapKill  = "kill"  // kill the last run and start a new one
+)
+
+// A scheduler runs a task with a Schedule each time it comes due.
+type scheduler struct {
+	sched *schedule
+	now   chan struct{} // run right away
+	stop  chan struct{}
+
+	mu         sync.Mutex
+	task       *Task // replaced when the task list is reloaded
+	nextRun    time.Time
+	lastRun    time.Time
+	lastResult string
+}
+
+func newScheduler(t *Task) *scheduler {
+	return &scheduler{
+		sched: t.cron,
+		now:   make(chan struct{}, 1),
+		stop:  make(chan struct{}),
+		task:  t,
+	}
+}
+
+func (s *scheduler) getTask() *Task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.task
+}
+
+// setTask hands s over to t, which replaces the task it was started for.
+func (s *scheduler) setTask(t *Task) {
+	s.mu.Lock()
+	s.task = t
+	s.mu.Unlock()
+}
+
+// trigger runs the task now, on top of its schedule.
+func (s *scheduler) trigger() {
+	select {
+	case s.now <- struct{}{}:
+	default:
+	}
+}
+
+func (s *scheduler) run() {
+	var (
+		running bool
+		queued  bool
+		done    = make(chan *TaskStatus, 1)
+		timer   = time.NewTimer(0)
+	)
+	<-timer.C
+	defer timer.Stop()
+
+	schedule := func() {
+		next := s.sched.next(time.Now())
+		s.mu.Lock()
+		s.nextRun = next
+		s.mu.Unlock()
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+		}
+	}
+	start := func() {
+		t := s.getTask()
+		running = true
+		s.mu.Lock()
+		s.lastRun = time.Now()
+		s.lastResult = "running"
+		s.mu.Unlock()
+		go t.Run(done)
+	}
+	due := func() {
+		t := s.getTask()
+		switch {
+		case !t.Enable:
+		case !running:
+			start()
+		case t.Overlap == overlapQueue:
+			queued = true
+		case t.Overlap == overlapKill:
+			t.Log("still running when due again, killing it")
+			t.Kill()
+			queued = true
+		default:
+			t.Log("still running when due again, skipping this run")
+		}
+	}
+
+	schedule()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-timer.C:
+			schedule()
+			due()
+		case <-s.now:
+			due()
+		case ts := <-done:
+			running = false
+			result := "ok"
+			if ts.Message != "" {
+				result = ts.Message
+			}
+			s.mu.Lock()
+			s.lastResult = result
+			s.mu.Unlock()
+			if queued {
+				queued = false
+				start()
+			}
+		}
+	}
+}
+
+// status fills in the scheduling details of ts.
+func (s *scheduler) status(ts *TaskStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ts.NextRun = s.nextRun
+	ts.LastRun = s.lastRun
+	ts.LastResult = s.lastResult
+}
+
+// Stop stops running the task on schedule. A run in progress is left alone.
+func (s *scheduler) Stop() {
+	close(s.stop)
+}
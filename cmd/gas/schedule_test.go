@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testTask(t *testing.T, name, invoke string, args ...string) *Task {
+	dir := t.TempDir()
+	c := &config{
+		logDirPath:  filepath.Join(dir, "log"),
+		sockDirPath: filepath.Join(dir, "sock"),
+	}
+	os.MkdirAll(c.logDirPath, 0700)
+	return &Task{Name: name, Invoke: invoke, Args: args, Enable: true, c: c}
+}
+
+func TestSchedulerRun(t *testing.T) {
+	task := testTask(t, "cron", "sh", "-c", "exit 0")
+	task.Schedule = "@yearly"
+	var err error
+	if task.cron, err = parseSchedule(task.Schedule); err != nil {
+		t.Fatal(err)
+	}
+	// someone waiting for the task to start, as with "gas start", must not
+	// keep it from reporting that it's done
+	task.ch = make(chan *TaskStatus, 1)
+
+	task.start(nil, true)
+	defer task.unschedule()
+
+	var ts TaskStatus
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		task.sched.status(&ts)
+		if ts.LastResult != "" && ts.LastResult != "running" {
+			break
+		}
+	}
+	if ts.LastResult != "ok" {
+		t.Fatalf("expected the run to finish, got %q", ts.LastResult)
+	}
+	if ts.LastRun.IsZero() || !ts.NextRun.After(time.Now()) {
+		t.Errorf("unexpected last run %v or next run %v", ts.LastRun, ts.NextRun)
+	}
+	if stat := <-task.ch; stat.Name != "cron" {
+		t.Errorf("unexpected status on t.ch: %+v", stat)
+	}
+}
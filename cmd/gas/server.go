@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"strconv"
 	"strings"
 	"sync"
@@ -21,11 +20,36 @@ var (
 type Args struct {
 	Name string
 	Args []string
+
+	// for tail: how many lines to show, and for following, where each log
+	// has been read up to. With Raw, lines keep their timestamps and prefixes.
+	Lines   int
+	Offsets map[string]int64
+	Raw     bool
+
+	// for events: the Seq they've been received up to, and for output: the
+	// Seq of the run
+	Since int64
+
+	// for upstream: nginx or caddy
+	Format string
+
+	// for reload: only say what would be done
+	DryRun bool
+
+	// for run: Args is a command to run instead of the task's Args
+	Command bool
+
+	// for remote clients, checked against the server's rules
+	Token string
 }
 
 type Response struct {
-	Status string
-	Tasks  []TaskStatus
+	Status  string
+	Tasks   []TaskStatus
+	Offsets map[string]int64
+	Events  []TaskEvent
+	Seq     int64
 }
 
 func (r *Response) addStatus(t TaskStatus) {
@@ -41,20 +65,35 @@ type TaskList struct {
 	mu       *sync.RWMutex
 	taskChan chan interface{}
 	c        *config
+	kv       *registry
 }
 
-func (tl *TaskList) save(name string, ch chan<- *TaskStatus) {
-	// TODO: add retry timer with backoff and eventually give up if failures
-	// per time reaches a certain threshold (and maybe email somebody if that
-	// happens)
-	log.Printf("attempting to resuscitate %q in 5 seconds...", name)
-	time.Sleep(5 * time.Second)
-	for _, t := range tl.Tasks {
-		if t.Name == name {
-			t.Run(ch)
-			return
-		}
-	}
+// bring a task that died back after waiting according to its restart policy,
+// or disable it and tell the sinks if it's crash looping
+func (tl *TaskList) save(name string, ch chan<- *TaskStatus, sinks []taskSink) {
+	t, err := tl.lookup(name)
+	if err != nil {
+		return
+	}
+	delay, ok := t.nextRestart(time.Now())
+	if !ok {
+		log.Printf("%q died %d times in %v, disabling it", name, t.policy.max+1, t.policy.window)
+		taskEvents.add("crash_loop", name, "disabled after %d restarts in %v", t.policy.max, t.policy.window)
+		t.Enable = false
+		notifyCrashLoop(sinks, t)
+		return
+	}
+	log.Printf("attempting to resuscitate %q in %v...", name, delay.Round(time.Millisecond))
+	time.Sleep(delay)
+	t.restartDue()
+
+	// it may have been replaced by a reload, or stopped, in the meantime
+	if t, err = tl.lookup(name); err != nil || !t.Enable || t.Alive() {
+		return
+	}
+	tl.waitDependencies(t)
+	go tl.restartDependents(name)
+	t.Run(ch)
 }
 
 type ReloadResult struct {
@@ -67,7 +106,8 @@ type ReloadResult struct {
 // * enable and disable
 // * add and remove
 // * change parameters? (env, argv)
-func (tl *TaskList) reload() (*ReloadResult, error) {
+// With dryRun, it only reports what it would do.
+func (tl *TaskList) reload(dryRun bool) (*ReloadResult, error) {
 	log.Print("reload tasks")
 
 	tl2, err := tl.c.loadTasks()
@@ -97,22 +137,30 @@ func (tl *TaskList) reload() (*ReloadResult, error) {
 					continue
 				}
 				if !newtask.Enable && oldtask.Alive() {
-					err = oldtask.Signal(os.Interrupt)
-					if err != nil {
-						return
+					if !dryRun {
+						oldtask.unschedule()
+						err = oldtask.Stop()
+						if err != nil {
+							return
+						}
 					}
 					result.Killed = append(result.Killed, oldtask.Name)
 					continue
 				} else if newtask.Enable && !oldtask.Enable {
+					if !dryRun {
+						oldtask.unschedule()
+					}
 					tasksToStart = append(tasksToStart, newtask)
 					continue
 				}
 
 				foundTask = true
-				start := false
-				start, err = merge(newtask, oldtask)
-				if err != nil {
-					return
+				start := changed(newtask, oldtask)
+				if !dryRun {
+					start, err = merge(newtask, oldtask)
+					if err != nil {
+						return
+					}
 				}
 				if start {
 					tasksToStart = append(tasksToStart, newtask)
@@ -132,9 +180,15 @@ func (tl *TaskList) reload() (*ReloadResult, error) {
 		// find tasks that were in the old list but not in the new one
 		for _, oldtask := range tl.Tasks {
 			if _, ok := visited[oldtask.Name]; !ok {
-				err = oldtask.Signal(os.Interrupt)
-				if err != nil {
-					return
+				if !dryRun {
+					ports.release(oldtask.Name)
+					oldtask.unschedule()
+					if oldtask.Alive() {
+						err = oldtask.Stop()
+						if err != nil {
+							return
+						}
+					}
 				}
 				result.Killed = append(result.Killed, oldtask.Name)
 			}
@@ -143,12 +197,38 @@ func (tl *TaskList) reload() (*ReloadResult, error) {
 	if err != nil {
 		return nil, err
 	}
+	if dryRun {
+		// don't hold on to ports for tasks that weren't really added
+		tl.mu.RLock()
+		for _, t := range tl2.Tasks {
+			if old, _ := tl.lookup(t.Name); old == nil || !old.AutoPort {
+				ports.release(t.Name)
+			}
+		}
+		tl.mu.RUnlock()
+		return result, nil
+	}
 
 	tl.mu.Lock()
+	for _, t := range tl.Tasks {
+		t.unwatch()
+	}
 	tl.Tasks = tl2.Tasks
+	for _, t := range tl.Tasks {
+		tl.watch(t)
+		if !t.AutoPort {
+			ports.release(t.Name)
+		}
+	}
 	tl.mu.Unlock()
+	tl.publishPorts()
 
 	tl.taskChan <- tasksToStart
+	taskEvents.add("reloaded", "", "killed %d, started %d, restarted %d",
+		len(result.Killed), len(result.Started), len(result.Restarted))
+	for _, name := range result.Restarted {
+		go tl.restartDependents(name)
+	}
 
 	return result, nil
 }
@@ -156,14 +236,26 @@ func (tl *TaskList) reload() (*ReloadResult, error) {
 // restart process with new params if necessary
 // if nothing changed, copy the old data to the new one (process, time started,
 // etc.)
-func merge(newtask, oldtask *Task) (start bool, err error) {
-	if newtask.Invoke != oldtask.Invoke ||
+// changed is whether newtask differs from oldtask in a way that needs it to
+// be restarted.
+func changed(newtask, oldtask *Task) bool {
+	return newtask.Invoke != oldtask.Invoke ||
 		!mapequal(newtask.Env, oldtask.Env) ||
-		!stringsequal(newtask.Args, oldtask.Args) {
+		newtask.EnvFile != oldtask.EnvFile ||
+		!mapequal(newtask.fileEnv, oldtask.fileEnv) ||
+		!stringsequal(newtask.Args, oldtask.Args) ||
+		newtask.Schedule != oldtask.Schedule ||
+		newtask.Overlap != oldtask.Overlap
+}
 
-		err = oldtask.Signal(os.Interrupt)
-		if err != nil {
-			return
+func merge(newtask, oldtask *Task) (start bool, err error) {
+	if changed(newtask, oldtask) {
+		oldtask.unschedule()
+		if oldtask.Alive() {
+			err = oldtask.Stop()
+			if err != nil {
+				return
+			}
 		}
 
 		return true, nil
@@ -172,6 +264,12 @@ func merge(newtask, oldtask *Task) (start bool, err error) {
 	newtask.cmd = oldtask.cmd
 	newtask.lr = oldtask.lr
 	newtask.started = oldtask.started
+	newtask.outputReader = oldtask.outputReader
+	newtask.port = oldtask.port
+	newtask.inheritRestarts(oldtask)
+	if newtask.sched = oldtask.sched; newtask.sched != nil {
+		newtask.sched.setTask(newtask)
+	}
 
 	return false, nil
 }
@@ -198,17 +296,49 @@ Commands:
   status          query status of all running tasks
   startall        start all tasks
   killall         kill all tasks
-  names           get all task names, space separated
-  reload          reload task list and update currently running tasks
+  names [@group]  get all task names (or those in a group), space separated
+  reload [-dry-run]
+                  reload task list and update currently running tasks, or
+                  with -dry-run, say what would be killed, started and
+                  restarted
+  check           check the task file for mistakes, like programs that
+                  aren't there and ${VAR}s that aren't set
   start <task>    start a task
-  stop <task>     stop a task with SIGINT
+  stop <task>     stop a task with its StopSignal (SIGINT), and SIGKILL if
+                  it's still running after its StopTimeout
   kill <task>     stop a task with SIGKILL
   restart <task>  restart a task
+  run [-c] <task> [args...]
+                  run a task once in the foreground with the given args, or
+                  with -c, run a command in its environment and directory
+  upgrade [binary]
+                  replace the server with a new gas binary (or the one it was
+                  started from), keeping the tasks running
+  deploy <task>   start a new instance of a task on its next DeployPort and
+                  drain the old one once the new one is ready
   signal <task> <signal>
                   send a signal to a task using kill(1) names
-  tail <task>     tail the logs of a task
+  loglevel <task> step a gas server's log level through debug, info, warn,
+                  and error (SIGUSR1)
+  tail [-f] [-n lines] [-raw] <task>...
+                  show the last lines of the logs of tasks, and with -f,
+                  keep showing new ones as they come; with -raw, lines keep
+                  the timestamps and prefixes they were logged with
   logpath <task>  get the path to the current log file of a task
-  help            print this message`, os.Args[0])
+  logs <task>     list the current and rotated log files of a task
+  get <task> [key]
+                  get a value published about a task, or all of them
+  set <task> <key> [value]
+                  publish a value about a task, or remove it
+  systemd-unit    print a systemd unit that runs a gas server for the user
+                  (or the one given with -u), instead of setting up with -u
+  history [-n runs] <task>
+                  show the restart history and total uptime of a task
+  upstream [-format nginx|caddy] [task...]
+                  print reverse proxy configuration for tasks with ports
+  watch [task...] print events about tasks (or the given ones) as they happen
+  help            print this message
+A task name of @group stands for all of the tasks in the group.`, os.Args[0])
 
 	return nil
 }
@@ -225,11 +355,14 @@ func (tl *TaskList) Status(args *Args, resp *Response) error {
 		if task == nil {
 			return fmt.Errorf("no such task: %s", args.Name)
 		}
-		resp.addStatus(task.Status())
+		ts := task.Status()
+		tl.kv.status(task, &ts)
+		resp.addStatus(ts)
 	} else {
 		resp.Tasks = make([]TaskStatus, len(tl.Tasks))
 		for i, task := range tl.Tasks {
 			resp.Tasks[i] = task.Status()
+			tl.kv.status(task, &resp.Tasks[i])
 		}
 	}
 
@@ -245,12 +378,13 @@ func (tl *TaskList) Start(args *Args, resp *Response) error {
 		return fmt.Errorf("%s: task is already alive", t.Name)
 	}
 	t.Enable = true
+	t.resetRestarts()
 
 	// send channel to send back status to this goroutine in addition to the
 	// main one. Since the task is not started, it shouldn't generate any
 	// events and we should have full control in the current goroutine for
 	// mutation
-	t.ch = make(chan *TaskStatus)
+	t.ch = make(chan *TaskStatus, 1)
 	tl.taskChan <- t
 	resp.addStatus(*<-t.ch)
 
@@ -271,7 +405,8 @@ func (tl *TaskList) StartAll(args *Args, resp *Response) error {
 	return nil
 }
 
-// Stop a task with SIGINT and disable it so it doesn't try to resuscitate
+// Stop a task with its StopSignal and disable it so it doesn't try to
+// resuscitate
 func (tl *TaskList) Stop(args *Args, resp *Response) error {
 	t, err := tl.lookup(args.Name)
 	if err != nil {
@@ -284,16 +419,13 @@ func (tl *TaskList) Stop(args *Args, resp *Response) error {
 	t.Enable = false
 	t.ch = make(chan *TaskStatus, 1)
 
-	err = t.Signal(signalMap["TERM"])
+	err = t.Stop()
 	if err != nil {
 		return err
 	}
 	t.outputReader.Close()
 
-	// TODO: if the task fails to die with SIGTERM, we will block here forever
-	// waiting for it to end so the task thread can return its status. Seems to
-	// be something to do with I/O buffers not getting flushed. Need to find a
-	// way around this.
+	// if the task doesn't die, it's killed after its StopTimeout
 	resp.addStatus(*<-t.ch)
 	t.ch = nil
 	return nil
@@ -384,48 +516,137 @@ func (tl *TaskList) Signal(args *Args, resp *Response) error {
 	return fmt.Errorf("unknown signal: %s", signame)
 }
 
+// Cycle the log level of a task using the gas framework
+func (tl *TaskList) Loglevel(args *Args, resp *Response) error {
+	t, err := tl.lookup(args.Name)
+	if err != nil {
+		return err
+	}
+	if err := t.Signal(signalMap["USR1"]); err != nil {
+		return err
+	}
+	resp.Status = "log level changed, see the task's log for the new one"
+	return nil
+}
+
 // Get all task names (used for e.g. bash autocomplete)
 func (tl *TaskList) Names(args *Args, resp *Response) error {
 	tl.mu.RLock()
 	defer tl.mu.RUnlock()
-	names := make([]string, len(tl.Tasks))
-	for i, task := range tl.Tasks {
-		names[i] = task.Name
+	group := strings.TrimPrefix(args.Name, "@")
+	names := make([]string, 0, len(tl.Tasks))
+	for _, task := range tl.Tasks {
+		if group == "" || task.Group == group {
+			names = append(names, task.Name)
+		}
+	}
+	if len(names) == 0 && group != "" {
+		return fmt.Errorf("no tasks in group %s", group)
 	}
 	resp.Status = strings.Join(names, " ")
 	return nil
 }
 
-// Perform tail(1) on the logs of a task
+// Get the last lines of the logs of one or more tasks, along with the offsets
+// to Follow them from. With more than one task, lines are prefixed with the
+// task name.
 func (tl *TaskList) Tail(args *Args, resp *Response) error {
-	t, err := tl.lookup(args.Name)
+	names := tailNames(args)
+	n := args.Lines
+	if n <= 0 {
+		n = defaultTailLines
+	}
+	resp.Offsets = make(map[string]int64, len(names))
+	var out strings.Builder
+	for _, name := range names {
+		t, err := tl.lookup(name)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		b, size, err := lastLines(t.LogPath(), n)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if !args.Raw {
+			b = t.displayLines(b)
+		}
+		if len(names) > 1 {
+			out.WriteString(prefixLines(name, b))
+		} else {
+			out.Write(b)
+		}
+		resp.Offsets[name] = size
+	}
+	resp.Status = strings.TrimSuffix(out.String(), "\n")
+	return nil
+}
+
+// Wait for new lines in the logs of one or more tasks after args.Offsets and
+// return them with the new offsets, or nothing if there aren't any for a
+// while. Calling it in a loop follows the logs like tail -f.
+func (tl *TaskList) Follow(args *Args, resp *Response) error {
+	names := tailNames(args)
+	tasks, err := tl.tailTasks(names)
 	if err != nil {
 		return err
 	}
-	path := t.LogPath()
-	cmd := exec.Command("tail", path)
-	out, err := cmd.CombinedOutput()
+	resp.Offsets = make(map[string]int64, len(names))
+	for _, name := range names {
+		resp.Offsets[name] = args.Offsets[name]
+	}
+
+	deadline := time.Now().Add(followWait)
+	for {
+		resp.Status, err = readNew(tasks, resp.Offsets, args.Raw)
+		if err != nil || resp.Status != "" || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(followInterval)
+	}
+}
+
+func (tl *TaskList) Logpath(args *Args, resp *Response) error {
+	t, err := tl.lookup(args.Name)
 	if err != nil {
 		return err
 	}
-	resp.Status = string(out)
+	resp.Status = t.LogPath()
 	return nil
 }
 
-func (tl *TaskList) Logpath(args *Args, resp *Response) error {
+// List the log files of a task with their sizes and modification times
+func (tl *TaskList) Logs(args *Args, resp *Response) error {
 	t, err := tl.lookup(args.Name)
 	if err != nil {
 		return err
 	}
-	resp.Status = t.LogPath()
+	resp.Status, err = t.listLogs()
+	return err
+}
+
+// Wait for events after args.Since about the tasks named in args (or any of
+// them), returning nothing if there aren't any for a while. A negative Since
+// waits for new ones. Calling it in a loop with the returned Seq watches the
+// events as they happen.
+func (tl *TaskList) Events(args *Args, resp *Response) error {
+	var names []string
+	if args.Name != "" {
+		names = tailNames(args)
+	}
+	resp.Events, resp.Seq = taskEvents.wait(args.Since, names, followWait)
 	return nil
 }
 
+// Reload the task file, or with args.DryRun, only say what reloading would do
 func (tl *TaskList) Reload(args *Args, resp *Response) error {
-	res, err := tl.reload()
+	res, err := tl.reload(args.DryRun)
 	if err != nil {
 		return err
 	}
+	if args.DryRun && len(res.Killed)+len(res.Started)+len(res.Restarted) == 0 {
+		resp.Status = "nothing to do"
+		return nil
+	}
 
 	if len(res.Killed) > 0 {
 		resp.Status += fmt.Sprintf("kill %s\n", strings.Join(res.Killed, " "))
@@ -440,34 +661,43 @@ func (tl *TaskList) Reload(args *Args, resp *Response) error {
 	return nil
 }
 
+// Get a value published about a task, or with no key, all of them
 func (tl *TaskList) Get(args *Args, resp *Response) error {
 	t, err := tl.lookup(args.Name)
 	if err != nil {
 		return err
 	}
-	_ = t
 
 	if len(args.Args) < 1 {
-		// return all keys
-	} else {
-		// return value of given key
+		keys := tl.kv.keys(t.Name)
+		lines := make([]string, len(keys))
+		for i, k := range keys {
+			v, _ := tl.kv.get(t.Name, k)
+			lines[i] = k + "=" + v
+		}
+		resp.Status = strings.Join(lines, "\n")
+		return nil
+	}
+	v, ok := tl.kv.get(t.Name, args.Args[0])
+	if !ok {
+		return fmt.Errorf("%s: %s is not set", t.Name, args.Args[0])
 	}
+	resp.Status = v
 	return nil
 }
 
+// Publish a value about a task, or remove it if no value is given. Tasks can
+// publish about themselves by running gas set $GAS_TASK <key> <value>.
 func (tl *TaskList) Set(args *Args, resp *Response) error {
 	t, err := tl.lookup(args.Name)
 	if err != nil {
 		return err
 	}
-	_ = t
 
-	if len(args.Args) < 2 {
-		resp.Status = "usage: set <name> <key> <value>"
+	if len(args.Args) < 1 {
+		resp.Status = "usage: set <name> <key> [value]"
 		return nil
 	}
+	tl.kv.set(t.Name, args.Args[0], strings.Join(args.Args[1:], " "))
 	return nil
 }
-
-// TODO: restart daemon, transferring child pids
-// TODO: try using dup(2) (syscall.Dup) to rewrite fds
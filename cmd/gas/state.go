@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// how often a reattached process is checked on
+const orphanInterval = time.Second
+
+// A taskState is what's kept on disk about a running task next to its pid
+// file, so that if gas goes away without stopping it, the next one can tell
+// it's still the same process and take it back.
+type taskState struct {
+	Pid       int
+	Started   time.Time // when gas started it
+	ProcStart time.Time // when the system says it started, to tell it from a reused pid
+	Port      string
+}
+
+func (t *Task) statePath() string {
+	return strings.TrimSuffix(t.PidFile(), ".pid") + ".state"
+}
+
+// saveState records the running process of t.
+func (t *Task) saveState() error {
+	st := taskState{Pid: t.Pid(), Started: t.started, Port: t.port}
+	st.ProcStart, _ = processStart(st.Pid)
+	b, err := json.Marshal(&st)
+	if err != nil {
+		return errors.Wrap(err, "save state")
+	}
+	return errors.Wrap(ioutil.WriteFile(t.statePath(), b, 0600), "save state")
+}
+
+// readState reads what was recorded about the last process of t, returning
+// nil if there's nothing, or it's not running anymore.
+func (t *Task) readState() *taskState {
+	b, err := ioutil.ReadFile(t.statePath())
+	if err != nil {
+		return nil
+	}
+	st := new(taskState)
+	if err = json.Unmarshal(b, st); err != nil || !st.running() {
+		return nil
+	}
+	return st
+}
+
+// running is whether the process in st is still there, and not another one
+// that has been given the same pid since.
+func (st *taskState) running() bool {
+	if st.Pid <= 0 || syscall.Kill(st.Pid, 0) == syscall.ESRCH {
+		return false
+	}
+	start, err := processStart(st.Pid)
+	if err != nil || st.ProcStart.IsZero() {
+		return false
+	}
+	d := start.Sub(st.ProcStart)
+	return d < time.Second && d > -time.Second
+}
+
+// removeState removes the pid file and state of t.
+func (t *Task) removeState() {
+	os.Remove(t.PidFile())
+	os.Remove(t.statePath())
+}
+
+// reattach takes back the process in st, left running by a gas that went
+// away, and reports on ch once it's gone like Run. It isn't a child of this
+// gas, so it's checked on every so often instead of waited for, and what it
+// writes from here on is lost, since its output went to the old gas.
+func (t *Task) reattach(st *taskState, ch chan<- *TaskStatus) {
+	proc, err := os.FindProcess(st.Pid)
+	if err != nil {
+		stat := t.Status()
+		stat.Message = err.Error()
+		ch <- &stat
+		return
+	}
+	t.cmd = &exec.Cmd{
+		Path:    t.Invoke,
+		Args:    append([]string{t.Invoke}, t.Args...),
+		Process: proc,
+	}
+	t.exited = false
+	t.started = st.Started
+	if st.Port != "" {
+		t.port = st.Port
+	}
+	t.Logf("reattached to pid %d, running since %s; its output can't be logged until it's restarted",
+		st.Pid, st.Started.Format("2006-01-02 15:04:05"))
+	taskEvents.add("reattached", t.Name, "pid %d", st.Pid)
+	if t.ch != nil {
+		stat := t.Status()
+		t.report(&stat)
+	}
+
+	taskError := make(chan error, 1)
+	go func() {
+		for st.running() {
+			time.Sleep(orphanInterval)
+		}
+		t.exited = true
+		taskError <- errors.New("reattached process exited")
+	}()
+	t.wait(ch, nil, taskError)
+}
+
+// statePort is the port the last process of t is still running on, if it is.
+func (t *Task) statePort() string {
+	if st := t.readState(); st != nil {
+		return st.Port
+	}
+	return ""
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// how long a task has to exit after its StopSignal if StopTimeout isn't set
+const defaultStopTimeout = 10 * time.Second
+
+// parseStop checks StopSignal and StopTimeout.
+func (t *Task) parseStop() error {
+	t.stopSignal = os.Interrupt
+	if t.StopSignal != "" {
+		sig, ok := signalMap[strings.TrimPrefix(strings.ToUpper(t.StopSignal), "SIG")]
+		if !ok {
+			return errors.Errorf("StopSignal: unknown signal %q", t.StopSignal)
+		}
+		t.stopSignal = sig
+	}
+	t.stopTimeout = defaultStopTimeout
+	if t.StopTimeout != "" {
+		d, err := time.ParseDuration(t.StopTimeout)
+		if err != nil {
+			return errors.Wrap(err, "StopTimeout")
+		}
+		if d < 0 {
+			return errors.New("StopTimeout can't be negative")
+		}
+		t.stopTimeout = d
+	}
+	return nil
+}
+
+// Stop sends t its StopSignal, and SIGKILL if it's still running StopTimeout
+// later.
+func (t *Task) Stop() error {
+	if t.stopSignal == nil {
+		// a task that didn't come from the task file
+		t.stopSignal, t.stopTimeout = os.Interrupt, defaultStopTimeout
+	}
+	cmd := t.cmd
+	if err := t.Signal(t.stopSignal); err != nil {
+		return err
+	}
+	if t.stopTimeout == 0 {
+		return nil
+	}
+	go func() {
+		deadline := time.Now().Add(t.stopTimeout)
+		for time.Now().Before(deadline) {
+			if t.cmd != cmd || !t.Alive() {
+				return
+			}
+			time.Sleep(followInterval)
+		}
+		if t.cmd == cmd && t.Alive() {
+			t.Logf("still running %s after %v, killing it", t.stopTimeout, t.stopSignal)
+			t.Kill()
+		}
+	}()
+	return nil
+}
+
+// stopAll stops each of tasks that's running and waits for them all to have
+// exited, which they will have by the longest of their StopTimeouts.
+func stopAll(tasks []*Task) {
+	var wait time.Duration
+	for _, t := range tasks {
+		if !t.Alive() {
+			continue
+		}
+		if err := t.Stop(); err != nil {
+			t.Log(err)
+		}
+		if t.stopTimeout > wait {
+			wait = t.stopTimeout
+		}
+	}
+	deadline := time.Now().Add(wait + time.Second)
+	for time.Now().Before(deadline) {
+		alive := false
+		for _, t := range tasks {
+			alive = alive || t.Alive()
+		}
+		if !alive {
+			return
+		}
+		time.Sleep(followInterval)
+	}
+}
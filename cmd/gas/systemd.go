@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// sdNotify sends state to the service manager if gas was started by one that
+// asked for it (with Type=notify).
+func sdNotify(state string) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return
+	}
+	if path[0] == '@' {
+		path = "\x00" + path[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		log.Print("sd_notify: ", err)
+		return
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(state)); err != nil {
+		log.Print("sd_notify: ", err)
+	}
+}
+
+// sdWatchdog pings the service manager's watchdog at half the interval it
+// asked for, if it did.
+func sdWatchdog() {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	for range time.Tick(time.Duration(usec) * time.Microsecond / 2) {
+		sdNotify("WATCHDOG=1")
+	}
+}
+
+// notifySocket is a socket like systemd's NOTIFY_SOCKET, for tasks with
+// Notify set to say when they're ready and what they're up to.
+type notifySocket struct {
+	conn *net.UnixConn
+	path string
+
+	mu     sync.Mutex
+	ready  bool
+	status string
+}
+
+// listenNotify opens a notify socket for an instance of t.
+func (t *Task) listenNotify() (*notifySocket, error) {
+	dir := filepath.Join(t.c.sockDirPath, "gas")
+	os.MkdirAll(dir, 0700)
+	path := filepath.Join(dir, fmt.Sprintf("%s-%d.notify", t.Name, time.Now().UnixNano()))
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, errors.Wrap(err, "notify socket")
+	}
+	ns := &notifySocket{conn: conn, path: path}
+	go ns.read()
+	return ns, nil
+}
+
+func (ns *notifySocket) read() {
+	buf := make([]byte, 4096)
+	for {
+		n, err := ns.conn.Read(buf)
+		if err != nil {
+			return
+		}
+		ns.mu.Lock()
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			switch {
+			case line == "READY=1":
+				ns.ready = true
+			case strings.HasPrefix(line, "STATUS="):
+				ns.status = strings.TrimPrefix(line, "STATUS=")
+			}
+		}
+		ns.mu.Unlock()
+	}
+}
+
+func (ns *notifySocket) state() (ready bool, status string) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	return ns.ready, ns.status
+}
+
+func (ns *notifySocket) Close() error {
+	err := ns.conn.Close()
+	os.Remove(ns.path)
+	return err
+}
+
+var unitTemplate = template.Must(template.New("unit").Parse(`[Unit]
+Description=gas task server for {{.User}}
+After=network.target user-runtime-dir@{{.UID}}.service
+Requires=user-runtime-dir@{{.UID}}.service
+
+[Service]
+Type=notify
+NotifyAccess=main
+User={{.User}}
+Group={{.Group}}
+LogsDirectory=gas/{{.User}}
+LogsDirectoryMode=0700
+ExecStart={{.Exe}} -s -f {{.Taskfile}}
+ExecReload=/bin/kill -HUP $MAINPID
+KillSignal=SIGINT
+WatchdogSec=30
+Restart=on-failure
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+// writeUnit writes a systemd unit running a gas server for c, which takes
+// care of the directories that setting up with -u would otherwise make.
+func writeUnit(w io.Writer, c *config) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "systemd unit")
+	}
+	g, err := user.LookupGroupId(c.u.Gid)
+	if err != nil {
+		return errors.Wrap(err, "systemd unit")
+	}
+	return unitTemplate.Execute(w, map[string]string{
+		"User":     c.u.Username,
+		"UID":      c.u.Uid,
+		"Group":    g.Name,
+		"Exe":      exe,
+		"Taskfile": c.taskfilePath,
+	})
+}
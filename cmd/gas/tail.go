@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTailLines = 10
+
+	// how long a Follow call waits for new lines before returning empty
+	// handed, and how often it looks for them meanwhile
+	followWait     = 10 * time.Second
+	followInterval = 250 * time.Millisecond
+)
+
+// lastLines returns the last n lines of the file at path, and its size.
+func lastLines(path string, n int) ([]byte, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	size := fi.Size()
+
+	// read backwards a chunk at a time until there are enough newlines (one
+	// more than n, since the file probably ends in one)
+	const chunk = 8192
+	var buf []byte
+	off := size
+	for off > 0 && bytes.Count(buf, []byte{'\n'}) <= n {
+		m := int64(chunk)
+		if m > off {
+			m = off
+		}
+		off -= m
+		b := make([]byte, m)
+		if _, err := f.ReadAt(b, off); err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		buf = append(b, buf...)
+	}
+
+	lines := bytes.SplitAfter(buf, []byte{'\n'})
+	if len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return bytes.Join(lines, nil), size, nil
+}
+
+// readLines returns the complete lines in the file at path after off, and
+// the offset after them. If the file has shrunk since, it's been rotated, so
+// it's read from the start.
+func readLines(path string, off int64) ([]byte, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, off, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, off, err
+	}
+	if fi.Size() < off {
+		off = 0
+	}
+	if fi.Size() == off {
+		return nil, off, nil
+	}
+	b := make([]byte, fi.Size()-off)
+	n, err := f.ReadAt(b, off)
+	if err != nil && err != io.EOF {
+		return nil, off, err
+	}
+	b = b[:n]
+	// leave a partial line for next time
+	i := bytes.LastIndexByte(b, '\n')
+	if i < 0 {
+		return nil, off, nil
+	}
+	return b[:i+1], off + int64(i+1), nil
+}
+
+// prefixLines puts "[name] " in front of each line of b.
+func prefixLines(name string, b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	for _, line := range bytes.SplitAfter(b, []byte{'\n'}) {
+		if len(line) > 0 {
+			sb.WriteString("[" + name + "] ")
+			sb.Write(line)
+		}
+	}
+	return sb.String()
+}
+
+// tailNames is the tasks a tail is for: its Name and any other Args.
+func tailNames(args *Args) []string {
+	return append([]string{args.Name}, args.Args...)
+}
+
+// tailTasks looks up the named tasks.
+func (tl *TaskList) tailTasks(names []string) ([]*Task, error) {
+	tasks := make([]*Task, len(names))
+	for i, name := range names {
+		t, err := tl.lookup(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		tasks[i] = t
+	}
+	return tasks, nil
+}
+
+// readNew reads whatever complete lines have been added to the logs of tasks
+// since offsets, updating them. With more than one task, lines are prefixed
+// with the task name. Unless raw is set, they're shown without the timestamps
+// and prefixes they were logged with.
+func readNew(tasks []*Task, offsets map[string]int64, raw bool) (string, error) {
+	var out strings.Builder
+	for _, t := range tasks {
+		b, off, err := readLines(t.LogPath(), offsets[t.Name])
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		offsets[t.Name] = off
+		if !raw {
+			b = t.displayLines(b)
+		}
+		if len(tasks) > 1 {
+			out.WriteString(prefixLines(t.Name, b))
+		} else {
+			out.Write(b)
+		}
+	}
+	return out.String(), nil
+}
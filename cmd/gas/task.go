@@ -7,8 +7,10 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -24,6 +26,24 @@ type TaskStatus struct {
 	Message string
 	Enable  bool
 	Port    string
+	Group   string
+	Values  map[string]string // of the StatusKeys that are set
+
+	// of the process the last time it exited, or -1 if it didn't (e.g. it
+	// was killed by a signal or never started)
+	ExitCode int
+
+	// restarts within the RestartWindow, when the next one is due if the
+	// task is down, and whether it was disabled for crash looping
+	Restarts  int
+	RestartAt time.Time
+	CrashLoop bool
+
+	// for tasks with a Schedule
+	Schedule   string
+	NextRun    time.Time
+	LastRun    time.Time
+	LastResult string
 }
 
 func (ts TaskStatus) String() string {
@@ -43,7 +63,36 @@ func (ts TaskStatus) String() string {
 	if !ts.Enable {
 		name += " (disabled)"
 	}
-	return fmt.Sprintf("%s %s\t%s\t%s\t%s\t%s", alive, name, pid, port, uptime, ts.Message)
+	msg := ts.Message
+	switch {
+	case ts.CrashLoop:
+		msg = fmt.Sprintf("crash loop, disabled after %d restarts", ts.Restarts)
+	case !ts.RestartAt.IsZero():
+		msg = fmt.Sprintf("restart %d in %s", ts.Restarts, fmtDuration(time.Until(ts.RestartAt).Round(time.Second)))
+	}
+	if ts.Schedule != "" && msg == "" {
+		msg = "schedule " + ts.Schedule
+		if !ts.LastRun.IsZero() {
+			msg += ", last run " + ts.LastRun.Format("2006-01-02 15:04") + " (" + ts.LastResult + ")"
+		}
+		if !ts.NextRun.IsZero() {
+			msg += ", next " + ts.NextRun.Format("2006-01-02 15:04")
+		}
+	}
+	if len(ts.Values) > 0 {
+		keys := make([]string, 0, len(ts.Values))
+		for k := range ts.Values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if msg != "" {
+				msg += " "
+			}
+			msg += k + "=" + ts.Values[k]
+		}
+	}
+	return fmt.Sprintf("%s %s\t%s\t%s\t%s\t%s", alive, name, pid, port, uptime, msg)
 }
 
 type Task struct {
@@ -54,6 +103,109 @@ type Task struct {
 	Enable bool
 	Dir    string
 
+	// Tasks with the same Group can be operated on together by giving
+	// @group in place of a task name, and are shown together in status.
+	Group string
+
+	// User is who the task runs as instead of gas's user: a user name or
+	// uid, optionally followed by :group, as with chown. Switching needs gas
+	// to be privileged enough to (CAP_SETUID and CAP_SETGID), or GAS_SETPRIV
+	// to name a command that is, like "sudo -n setpriv".
+	User string
+
+	// KEY=VALUE pairs to add to the environment, read from this file
+	// (relative to Dir) whenever the task is started or reloaded. Env
+	// overrides them. ${VAR} in Invoke, Args, and Env values is replaced with
+	// its value from the environment.
+	EnvFile string
+
+	// Paths or globs (relative to Dir) to watch for changes, e.g. to the
+	// task's binary or config. Directories are watched recursively. When
+	// something changes, the task is restarted once things have stayed the
+	// same for WatchDebounce (default 1s).
+	Watch         []string
+	WatchDebounce string
+
+	// Ports to move GAS_PORT between on each deploy, which starts up a new
+	// instance on the next port before letting the old one go. Whatever's
+	// in front of the task should send requests to all of them.
+	DeployPorts []string
+
+	// With AutoPort, GAS_PORT is set to a free port from the server's pool
+	// (GAS_PORT_POOL, e.g. 9000-9099), which the task keeps as long as it's
+	// in the task file, and which is published as its "port" value.
+	AutoPort bool
+
+	// With Notify, the task is given a NOTIFY_SOCKET like under systemd with
+	// Type=notify, and it isn't ready until it sends READY=1 on it. What it
+	// sends as STATUS= is shown in status.
+	Notify bool
+
+	// Keys of the values published about the task (see gas set, which the
+	// task can run with its name in $GAS_TASK) to show in status.
+	StatusKeys []string
+
+	// Run the task periodically on this schedule instead of keeping it
+	// running, in cron syntax (e.g. "*/5 * * * *", "@daily", or
+	// "@every 90m"). Overlap says what to do if it's still running when it's
+	// due again: "skip" (the default), "queue", or "kill".
+	Schedule string
+	Overlap  string
+
+	// Stopping the task sends it StopSignal (default SIGINT), and if it's
+	// still running StopTimeout (default 10s, or 0 for never) later, SIGKILL.
+	// This is how it's stopped by stop, reload, deploy, and when gas itself
+	// shuts down.
+	StopSignal  string
+	StopTimeout string
+
+	// Names of tasks that have to be up before this one is started, and
+	// which restart this one when they are restarted. A task counts as up
+	// once it's running and, if it has a ReadyURL, answering it with a 2xx
+	// status, which is waited for for up to ReadyTimeout (default 30s).
+	// ${GAS_PORT} in ReadyURL is replaced with the port the task runs on.
+	DependsOn    []string
+	ReadyURL     string
+	ReadyTimeout string
+
+	// When the task dies, it's brought back after RestartDelay (default
+	// 5s), doubling each time it dies again within RestartWindow (default
+	// 10m) up to RestartMaxDelay (default 5m), give or take up to a fifth
+	// at random. If it has already been restarted MaxRestarts times
+	// (default 10) within the window, it's crash looping, so it's disabled
+	// and reported instead. A negative MaxRestarts never gives up.
+	RestartDelay    string
+	RestartMaxDelay string
+	RestartWindow   string
+	MaxRestarts     int
+
+	// The task's output is logged to LogPath, which is moved aside once it
+	// reaches LogSize kilobytes (default 5120) and gzipped if LogCompress is
+	// set. Only the newest LogKeep old logs are kept, or all of them if it's
+	// zero. With LogTimestamp, each line is prefixed with the time it was
+	// written, and with LogPrefix, with the task's name in brackets after
+	// that. Both are taken off again for tail unless it's asked for raw
+	// lines.
+	LogSize      int64
+	LogKeep      int
+	LogCompress  bool
+	LogTimestamp bool
+	LogPrefix    bool
+
+	fileEnv      map[string]string // read from EnvFile
+	debounce     time.Duration     // parsed WatchDebounce
+	watchStop    chan struct{}     // closed to stop watching
+	port         string            // GAS_PORT, if picked from DeployPorts
+	deploying    bool              // started alongside the running instance
+	replaced     bool              // by a deployed instance
+	oneShot      bool              // run with gas run, not the task itself
+	exited       bool              // a reattached process has gone away
+	notify       *notifySocket     // for the running instance, with Notify
+	policy       restartPolicy     // parsed restart fields
+	stopSignal   os.Signal         // parsed StopSignal
+	stopTimeout  time.Duration     // parsed StopTimeout
+	cron         *schedule         // parsed Schedule
+	sched        *scheduler        // running it, once started
 	cmd          *exec.Cmd
 	lr           *rotator.Rotator // for logs from task itself
 	started      time.Time        // time at which task was started
@@ -61,6 +213,11 @@ type Task struct {
 	c            *config
 	prefix       string
 	outputReader *os.File
+
+	mu        sync.Mutex  // for the restart state below
+	restarts  []time.Time // recent restarts
+	restartAt time.Time   // when the pending restart is due, if any
+	crashLoop bool
 }
 
 func (t *Task) Log(x ...interface{}) {
@@ -71,22 +228,91 @@ func (t *Task) Logf(format string, x ...interface{}) {
 	log.Printf(t.prefix+" "+format, x...)
 }
 
+// start runs t in the background. A task with a Schedule is started on its
+// schedule instead, and if that has already begun, it's run right away if now
+// is set.
+func (t *Task) start(ch chan<- *TaskStatus, now bool) {
+	if t.cron == nil {
+		go t.Run(ch)
+		return
+	}
+	if t.sched == nil {
+		t.sched = newScheduler(t)
+		go t.sched.run()
+	}
+	if now {
+		t.sched.trigger()
+	}
+}
+
+// unschedule stops running t on its schedule, if it has one.
+func (t *Task) unschedule() {
+	if t.sched != nil {
+		t.sched.Stop()
+		t.sched = nil
+	}
+}
+
 func (t *Task) Run(ch chan<- *TaskStatus) {
 	t.prefix = fmt.Sprintf("[%s]", t.Name)
 
-	// copy current environment to child process
-	// TODO: make optional?
-	env := formatEnv(t.Env)
-
-	t.Logf("starting %v %s %v", env, t.Invoke, t.Args)
+	// values are logged before expansion so that secrets from the env file
+	// stay out of the logs
+	t.Logf("starting %v %s %v", formatEnv(t.Env), t.Invoke, t.Args)
 
 	stat := t.Status()
 
-	for _, val := range os.Environ() {
-		env = append(env, val)
+	// a deployed instance runs alongside the one it's replacing
+	if !t.deploying && !t.oneShot {
+		st, err := t.CheckRunningTask()
+		if err != nil {
+			stat.Message = err.Error()
+			ch <- &stat
+			return
+		}
+		if st != nil {
+			t.reattach(st, ch)
+			return
+		}
+	}
+
+	if err := t.loadEnvFile(); err != nil {
+		stat.Message = err.Error()
+		ch <- &stat
+		return
+	}
+
+	// copy current environment to child process
+	// TODO: make optional?
+	env, invoke, args := t.environ()
+	if t.Notify {
+		ns, err := t.listenNotify()
+		if err != nil {
+			stat.Message = err.Error()
+			ch <- &stat
+			return
+		}
+		defer ns.Close()
+		t.notify = ns
+		env = append(env, "NOTIFY_SOCKET="+ns.path)
+	}
+
+	ra, err := t.lookupRunAs()
+	if err != nil {
+		stat.Message = err.Error()
+		ch <- &stat
+		return
+	}
+	if ra != nil {
+		env = ra.environ(t, env)
+		invoke, args = ra.command(invoke, args)
 	}
 
-	t.cmd = exec.Command(t.Invoke, t.Args...)
+	t.cmd = exec.Command(invoke, args...)
+	t.exited = false
+	if ra != nil {
+		t.cmd.SysProcAttr = ra.attr()
+	}
 
 	// see golang/go issue #10338
 	r, w, err := os.Pipe()
@@ -104,7 +330,7 @@ func (t *Task) Run(ch chan<- *TaskStatus) {
 	t.cmd.Dir = t.Dir
 
 	logpath := t.LogPath()
-	t.lr, err = rotator.New(t.outputReader, logpath, 5*1024, false)
+	t.lr, err = newRotator(rotator.New, t, t.outputReader, logpath)
 	if err != nil {
 		stat.Message = err.Error()
 		ch <- &stat
@@ -118,11 +344,6 @@ func (t *Task) Run(ch chan<- *TaskStatus) {
 	}()
 
 	go func() {
-		if err = t.CheckRunningTask(); err != nil {
-			taskError <- err
-			return
-		}
-
 		t.started = time.Now()
 		err = t.cmd.Start()
 
@@ -131,26 +352,39 @@ func (t *Task) Run(ch chan<- *TaskStatus) {
 			t.cmd.Process.Release()
 			taskError <- errors.Wrap(err, "start task")
 			stat.Message = err.Error()
-			if t.ch != nil {
-				t.ch <- &stat
-			}
+			t.report(&stat)
 			return
+		} else if t.oneShot {
+			t.Logf("running once with pid %d", t.Pid())
 		} else {
 			if err = t.MakePidFile(); err != nil {
 				stat.Message = err.Error()
+			} else if err = t.saveState(); err != nil {
+				stat.Message = err.Error()
 			}
-			t.Logf("started with pid %d", t.Pid())
-			if t.ch != nil {
-				// report status of started task to sender
-				t.ch <- &stat
+			if ra != nil {
+				ra.chown(t)
 			}
+			t.Logf("started with pid %d", t.Pid())
+			taskEvents.add("started", t.Name, "pid %d", t.Pid())
+			// report status of started task to sender
+			t.report(&stat)
 		}
 
 		err = t.cmd.Wait()
 		taskError <- err
 	}()
 
-	// block in this goroutine until task is done or something dies
+	t.wait(ch, logError, taskError)
+}
+
+// wait blocks until the task is done or its logging dies, and then reports
+// how it went on ch.
+func (t *Task) wait(ch chan<- *TaskStatus, logError, taskError <-chan error) {
+	var (
+		err  error
+		stat TaskStatus
+	)
 	select {
 	case err = <-logError:
 		// XXX: how certain can we be that the process should be shutting down
@@ -174,59 +408,79 @@ func (t *Task) Run(ch chan<- *TaskStatus) {
 		t.Log("task finished")
 	}
 
-	os.Remove(t.PidFile())
+	if !t.oneShot {
+		t.recordRun(&stat)
+	}
 
-	// report back to main thread
-	if t.ch != nil {
-		t.ch <- &stat
+	// the pid file belongs to the new instance after a deploy
+	if !t.replaced && !t.oneShot {
+		t.removeState()
 	}
+
+	// report back to main thread
+	t.report(&stat)
 	ch <- &stat
 }
 
-// check if process was already running and process manager crashed
-func (t *Task) CheckRunningTask() error {
-	p := t.PidFile()
-	_, err := os.Stat(p)
-	if err != nil {
-		if !os.IsNotExist(err) {
-			return errors.Wrap(err, "check pid file")
-		} else {
-			return nil
+// report hands stat to whoever is waiting on t.ch for it. Only the first
+// status after t.ch is made is wanted, so once its buffer is full any others
+// are dropped rather than holding up the task.
+func (t *Task) report(stat *TaskStatus) {
+	if ch := t.ch; ch != nil {
+		select {
+		case ch <- stat:
+		default:
 		}
 	}
+}
 
-	f, err := os.Open(p)
+// CheckRunningTask looks for a process of t left running by a gas that went
+// away without stopping it. If it's still the process gas started, going by
+// the state saved with the pid file, it's returned to be reattached to. A pid
+// file without state is from a gas that didn't save any, so whatever is
+// running with its pid is killed as before. Pid files of processes that are
+// gone, or whose pid belongs to something else now, are just removed.
+func (t *Task) CheckRunningTask() (*taskState, error) {
+	buf, err := ioutil.ReadFile(t.PidFile())
 	if err != nil {
-		return errors.Wrap(err, "check pid file")
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "check pid file")
 	}
-	defer f.Close()
-	buf, err := ioutil.ReadAll(f)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(buf)))
 	if err != nil {
-		return errors.Wrap(err, "check pid file")
+		t.removeState()
+		return nil, errors.Wrap(err, "check pid file")
 	}
-	pidString := strings.TrimSpace(string(buf))
-	pid, err := strconv.Atoi(pidString)
-	if err != nil {
-		return errors.Wrap(err, "check pid file")
+
+	if st := t.readState(); st != nil && st.Pid == pid {
+		return st, nil
+	}
+	if _, err := os.Stat(t.statePath()); err == nil {
+		t.Logf("pid %d from the pid file isn't the task anymore", pid)
+		t.removeState()
+		return nil, nil
 	}
+
 	t.Logf("task already running at pid %d", pid)
 	proc, err := os.FindProcess(pid)
 	if err != nil {
-		return errors.Wrap(err, "check pid file")
+		return nil, errors.Wrap(err, "check pid file")
 	}
-
-	// TODO: this is where we would attempt to regain ownership of the process
 	if err = proc.Kill(); err != nil {
 		t.Logf("kill %d: %v", pid, err)
 	} else {
 		t.Logf("killed %d", pid)
 	}
-	f.Truncate(0)
-
-	return nil
+	t.removeState()
+	return nil, nil
 }
 
 func (t *Task) LogPath() string {
+	if t.oneShot {
+		return filepath.Join(t.c.logDirPath, t.Name+".run.log")
+	}
 	return filepath.Join(t.c.logDirPath, t.Name+".log")
 }
 
@@ -247,19 +501,32 @@ func (t *Task) MakePidFile() error {
 }
 
 func (t *Task) Status() TaskStatus {
-	return TaskStatus{
-		t.Name,
-		t.Alive(),
-		t.Pid(),
-		t.Uptime(),
-		"",
-		t.Enable,
-		t.Env["GAS_PORT"],
+	ts := TaskStatus{
+		Name:     t.Name,
+		Alive:    t.Alive(),
+		PID:      t.Pid(),
+		Uptime:   t.Uptime(),
+		Enable:   t.Enable,
+		Port:     t.gasPort(),
+		Group:    t.Group,
+		ExitCode: -1,
+		Schedule: t.Schedule,
+	}
+	if t.cmd != nil && t.cmd.ProcessState != nil {
+		ts.ExitCode = t.cmd.ProcessState.ExitCode()
+	}
+	if t.sched != nil {
+		t.sched.status(&ts)
+	}
+	t.restartStatus(&ts)
+	if ns := t.notify; ns != nil && ts.Alive {
+		_, ts.Message = ns.state()
 	}
+	return ts
 }
 
 func (t *Task) Alive() bool {
-	return t.cmd != nil && t.cmd.ProcessState == nil
+	return t.cmd != nil && t.cmd.ProcessState == nil && !t.exited
 }
 
 func (t *Task) Pid() int {
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// taskFile is the layout of a task file that isn't just a list of tasks. The
+// Include globs are relative to the directory the file is in, and each file
+// they match holds one task (or a list of them) in any of the formats.
+//
+// TOML has no top-level lists, so TOML task files always look like this:
+//
+//	include = ["tasks.d/*.toml"]
+//
+//	[[tasks]]
+//	name = "web"
+//	invoke = "/srv/web/web"
+type taskFile struct {
+	Include []string
+	Tasks   []*Task
+}
+
+// readTasks reads the tasks out of the task file at path, along with those in
+// the files it includes.
+func readTasks(path string) ([]*Task, error) {
+	b, err := readTaskJSON(path)
+	if err != nil {
+		return nil, err
+	}
+	if isList(b) {
+		var tasks []*Task
+		err = json.Unmarshal(b, &tasks)
+		return tasks, errors.Wrap(err, path)
+	}
+
+	var tf taskFile
+	if err = json.Unmarshal(b, &tf); err != nil {
+		return nil, errors.Wrap(err, path)
+	}
+	seen := make(map[string]string)
+	for _, t := range tf.Tasks {
+		seen[t.Name] = path
+	}
+	dir := filepath.Dir(path)
+	for _, pattern := range tf.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s: include", path)
+		}
+		for _, p := range paths {
+			tasks, err := readIncluded(p)
+			if err != nil {
+				return nil, err
+			}
+			for _, t := range tasks {
+				if prev, ok := seen[t.Name]; ok {
+					return nil, errors.Errorf("%s: task %q is already defined in %s", p, t.Name, prev)
+				}
+				seen[t.Name] = p
+			}
+			tf.Tasks = append(tf.Tasks, tasks...)
+		}
+	}
+	return tf.Tasks, nil
+}
+
+// readIncluded reads an included file holding either one task or a list.
+func readIncluded(path string) ([]*Task, error) {
+	b, err := readTaskJSON(path)
+	if err != nil {
+		return nil, err
+	}
+	var tasks []*Task
+	if isList(b) {
+		err = json.Unmarshal(b, &tasks)
+	} else {
+		t := new(Task)
+		err = json.Unmarshal(b, t)
+		tasks = []*Task{t}
+	}
+	return tasks, errors.Wrap(err, path)
+}
+
+// readTaskJSON reads the file at path and turns it into JSON according to
+// its extension, so that tasks decode the same way, with the field names
+// matched case-insensitively, whatever format they're written in.
+func readTaskJSON(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var v interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &v)
+	case ".toml":
+		err = toml.Unmarshal(b, &v)
+	default:
+		return b, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, path)
+	}
+	b, err = json.Marshal(v)
+	return b, errors.Wrap(err, path)
+}
+
+func isList(b []byte) bool {
+	b = bytes.TrimSpace(b)
+	return len(b) > 0 && b[0] == '['
+}
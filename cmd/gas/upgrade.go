@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	"ktkr.us/pkg/logrotate/rotator"
+)
+
+// adoptEnv names the file handed to the new gas by the one it replaces.
+const adoptEnv = "GAS_ADOPT"
+
+// An adoptState is what the new gas needs to know to take over a running
+// task. Since gas execs itself in place, the processes stay its children and
+// the read ends of their output pipes stay open.
+type adoptState struct {
+	Name     string
+	Pid      int
+	Started  time.Time
+	Output   int // fd of the output pipe
+	Port     string
+	AutoPort bool
+}
+
+// upgradeRequest asks the main loop to replace the server with the gas
+// binary at path.
+type upgradeRequest string
+
+// Replace the running server with a new gas binary (args.Name, or the one
+// it was started from), which takes over the running tasks instead of
+// bouncing them.
+func (tl *TaskList) Upgrade(args *Args, resp *Response) error {
+	exe := args.Name
+	if exe == "" {
+		var err error
+		if exe, err = os.Executable(); err != nil {
+			return errors.Wrap(err, "upgrade")
+		}
+	}
+	fi, err := os.Stat(exe)
+	if err != nil {
+		return errors.Wrap(err, "upgrade")
+	}
+	if fi.IsDir() || fi.Mode()&0111 == 0 {
+		return errors.Errorf("upgrade: %s isn't executable", exe)
+	}
+
+	oneShots.Lock()
+	for _, run := range oneShots.runs {
+		select {
+		case <-run.done:
+		default:
+			oneShots.Unlock()
+			return errors.Errorf("upgrade: gas run %s is still going", run.t.Name)
+		}
+	}
+	oneShots.Unlock()
+
+	// answer before the connection goes away
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		tl.taskChan <- upgradeRequest(exe)
+	}()
+	resp.Status = "upgrading to " + exe
+	return nil
+}
+
+// upgrade hands the running tasks over to the gas binary at exe by exec'ing
+// it in place of this one. It only returns if that fails.
+func (tl *TaskList) upgrade(exe string) error {
+	var states []adoptState
+	tl.mu.RLock()
+	for _, t := range tl.Tasks {
+		if !t.Alive() || t.outputReader == nil {
+			continue
+		}
+		fd := int(t.outputReader.Fd())
+		if _, err := unix.FcntlInt(uintptr(fd), unix.F_SETFD, 0); err != nil {
+			tl.mu.RUnlock()
+			return errors.Wrapf(err, "upgrade: %s", t.Name)
+		}
+		states = append(states, adoptState{
+			Name:     t.Name,
+			Pid:      t.Pid(),
+			Started:  t.started,
+			Output:   fd,
+			Port:     t.port,
+			AutoPort: t.AutoPort,
+		})
+	}
+	tl.mu.RUnlock()
+
+	// don't leak the pipes into the tasks started if this fails
+	defer func() {
+		for _, st := range states {
+			unix.FcntlInt(uintptr(st.Output), unix.F_SETFD, unix.FD_CLOEXEC)
+		}
+	}()
+
+	path := filepath.Join(tl.c.sockDirPath, "gas", "upgrade.json")
+	b, err := json.Marshal(states)
+	if err != nil {
+		return errors.Wrap(err, "upgrade")
+	}
+	os.MkdirAll(filepath.Dir(path), 0700)
+	if err = ioutil.WriteFile(path, b, 0600); err != nil {
+		return errors.Wrap(err, "upgrade")
+	}
+
+	env := []string{adoptEnv + "=" + path}
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, adoptEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	log.Printf("upgrading to %s, handing over %d tasks", exe, len(states))
+	sdNotify("RELOADING=1")
+	err = syscall.Exec(exe, os.Args, env)
+	sdNotify("READY=1")
+	os.Remove(path)
+	return errors.Wrap(err, "upgrade")
+}
+
+// readAdoptState reads the tasks handed over by the gas this one replaced,
+// if it was started by upgrade, and claims the ports picked for them.
+func readAdoptState() ([]adoptState, error) {
+	path := os.Getenv(adoptEnv)
+	if path == "" {
+		return nil, nil
+	}
+	os.Unsetenv(adoptEnv)
+	b, err := ioutil.ReadFile(path)
+	os.Remove(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "adopt tasks")
+	}
+	var states []adoptState
+	if err = json.Unmarshal(b, &states); err != nil {
+		return nil, errors.Wrap(err, "adopt tasks")
+	}
+	for _, st := range states {
+		unix.FcntlInt(uintptr(st.Output), unix.F_SETFD, unix.FD_CLOEXEC)
+		if st.AutoPort && st.Port != "" {
+			ports.claim(st.Name, st.Port)
+		}
+	}
+	return states, nil
+}
+
+// adoptTasks takes over the running tasks in states, returning the names of
+// the ones it did. Those that have been taken out of the task file or
+// disabled in the meantime are stopped.
+func (tl *TaskList) adoptTasks(states []adoptState, ch chan<- *TaskStatus) map[string]bool {
+	adopted := make(map[string]bool, len(states))
+	for _, st := range states {
+		r := os.NewFile(uintptr(st.Output), st.Name+" output")
+		t, err := tl.lookup(st.Name)
+		if err != nil || !t.Enable {
+			log.Printf("[%s] not in the task file anymore, stopping %d", st.Name, st.Pid)
+			if proc, err := os.FindProcess(st.Pid); err == nil {
+				proc.Signal(os.Interrupt)
+				go func() {
+					io.Copy(ioutil.Discard, r)
+					proc.Wait()
+				}()
+			}
+			continue
+		}
+		go t.adopt(st, r, ch)
+		adopted[t.Name] = true
+	}
+	if len(states) > 0 {
+		taskEvents.add("upgraded", "", "adopted %d tasks", len(adopted))
+	}
+	return adopted
+}
+
+// adopt takes over t's process as described by st, with r the read end of
+// its output pipe, reporting on ch once it's done like Run.
+func (t *Task) adopt(st adoptState, r *os.File, ch chan<- *TaskStatus) {
+	t.prefix = "[" + t.Name + "]"
+	proc, err := os.FindProcess(st.Pid)
+	if err != nil {
+		t.Logf("adopt %d: %v", st.Pid, err)
+		stat := t.Status()
+		stat.Message = err.Error()
+		ch <- &stat
+		return
+	}
+	t.cmd = &exec.Cmd{
+		Path:    t.Invoke,
+		Args:    append([]string{t.Invoke}, t.Args...),
+		Process: proc,
+	}
+	t.started = st.Started
+	t.outputReader = r
+	if st.Port != "" {
+		t.port = st.Port
+	}
+	t.Logf("adopted pid %d", st.Pid)
+
+	logError := make(chan error, 1)
+	taskError := make(chan error, 1)
+	t.lr, err = newRotator(rotator.New, t, r, t.LogPath())
+	if err != nil {
+		logError <- errors.Wrap(err, "logrotate")
+	} else {
+		go func() {
+			logError <- errors.Wrap(t.lr.Run(), "logrotate")
+		}()
+	}
+	go func() {
+		taskError <- t.cmd.Wait()
+	}()
+	t.wait(ch, logError, taskError)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// runAs is who a task with a User runs as.
+type runAs struct {
+	uid, gid uint32
+	groups   []uint32
+	name     string
+	home     string
+}
+
+// lookupRunAs works out who t runs as from its User, which is a user name or
+// uid, optionally followed by :group to use that group instead of the user's
+// own. With just :group, the task runs as gas's user in that group. It
+// returns nil if t has no User.
+func (t *Task) lookupRunAs() (*runAs, error) {
+	if t.User == "" {
+		return nil, nil
+	}
+	name, group, _ := strings.Cut(t.User, ":")
+	ra := new(runAs)
+
+	var (
+		u   *user.User
+		err error
+	)
+	if name == "" {
+		u, err = user.Current()
+	} else if _, numeric := strconv.Atoi(name); numeric == nil {
+		u, err = user.LookupId(name)
+	} else {
+		u, err = user.Lookup(name)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "User %s", t.User)
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, errors.Wrapf(err, "User %s", t.User)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, errors.Wrapf(err, "User %s", t.User)
+	}
+	ra.uid, ra.gid, ra.name, ra.home = uint32(uid), uint32(gid), u.Username, u.HomeDir
+
+	if group != "" {
+		var g *user.Group
+		if _, numeric := strconv.Atoi(group); numeric == nil {
+			g, err = user.LookupGroupId(group)
+		} else {
+			g, err = user.LookupGroup(group)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "User %s", t.User)
+		}
+		if gid, err = strconv.ParseUint(g.Gid, 10, 32); err != nil {
+			return nil, errors.Wrapf(err, "User %s", t.User)
+		}
+		ra.gid = uint32(gid)
+	} else if ids, err := u.GroupIds(); err == nil {
+		for _, id := range ids {
+			if gid, err := strconv.ParseUint(id, 10, 32); err == nil {
+				ra.groups = append(ra.groups, uint32(gid))
+			}
+		}
+	}
+	return ra, nil
+}
+
+// environ sets HOME, USER, and LOGNAME in env to those of the user, unless the
+// task's own Env has them.
+func (ra *runAs) environ(t *Task, env []string) []string {
+	set := map[string]string{"HOME": ra.home, "USER": ra.name, "LOGNAME": ra.name}
+	out := env[:0]
+	for _, kv := range env {
+		k := kv[:strings.IndexByte(kv+"=", '=')]
+		if _, ok := set[k]; ok {
+			if _, own := t.Env[k]; !own {
+				continue
+			}
+			delete(set, k)
+		}
+		out = append(out, kv)
+	}
+	for k, v := range set {
+		out = append(out, k+"="+v)
+	}
+	return out
+}
+
+// command returns the command line to run. With GAS_SETPRIV set to a command
+// like "sudo -n setpriv", the task is run through it, for when gas itself
+// can't switch users. Otherwise it's run as is, and attr sets the
+// credentials.
+func (ra *runAs) command(invoke string, args []string) (string, []string) {
+	setpriv := strings.Fields(os.Getenv("GAS_SETPRIV"))
+	if len(setpriv) == 0 {
+		return invoke, args
+	}
+	groups := "--clear-groups"
+	if len(ra.groups) > 0 {
+		ids := make([]string, len(ra.groups))
+		for i, g := range ra.groups {
+			ids[i] = strconv.FormatUint(uint64(g), 10)
+		}
+		groups = "--groups=" + strings.Join(ids, ",")
+	}
+	cmd := append(setpriv[1:],
+		"--reuid="+strconv.FormatUint(uint64(ra.uid), 10),
+		"--regid="+strconv.FormatUint(uint64(ra.gid), 10),
+		groups, "--", invoke)
+	return setpriv[0], append(cmd, args...)
+}
+
+// attr is how the process should be started, which needs gas to be
+// privileged enough to switch to the user unless GAS_SETPRIV is doing it.
+func (ra *runAs) attr() *syscall.SysProcAttr {
+	if os.Getenv("GAS_SETPRIV") != "" {
+		return nil
+	}
+	return &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: ra.uid, Gid: ra.gid, Groups: ra.groups},
+	}
+}
+
+// chown gives the user the files gas keeps for t, so that it can read its
+// logs. It's not an error if gas isn't allowed to.
+func (ra *runAs) chown(t *Task) {
+	// the rotator may not have gotten around to making the log yet
+	if f, err := os.OpenFile(t.LogPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err == nil {
+		f.Close()
+	}
+	paths, _ := filepath.Glob(t.LogPath() + "*")
+	paths = append(paths, t.PidFile())
+	for _, path := range paths {
+		if err := os.Chown(path, int(ra.uid), int(ra.gid)); err != nil && !os.IsNotExist(err) {
+			t.Logf("chown: %v", err)
+			return
+		}
+	}
+}
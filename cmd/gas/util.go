@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -21,6 +20,7 @@ type config struct {
 	logDirPath   string
 	sockDirPath  string
 	sockPath     string
+	httpSockPath string
 	taskfilePath string
 	u            *user.User
 }
@@ -34,33 +34,116 @@ func userConfig(u *user.User, err error) (*config, error) {
 		logDirPath:   filepath.Join(logDirBase, u.Username),
 		sockDirPath:  sockDirPath,
 		sockPath:     filepath.Join(sockDirPath, "gas.sock"),
-		taskfilePath: filepath.Join(u.HomeDir, ".gas_tasks.json"),
+		httpSockPath: filepath.Join(sockDirPath, "gas-http.sock"),
+		taskfilePath: defaultTaskfile(u.HomeDir),
 		u:            u,
 	}, nil
 }
 
+// defaultTaskfile is ~/.gas_tasks.json, or the YAML or TOML one if that's
+// what there is instead.
+func defaultTaskfile(home string) string {
+	json := filepath.Join(home, ".gas_tasks.json")
+	if _, err := os.Stat(json); err == nil {
+		return json
+	}
+	for _, ext := range []string{".yaml", ".yml", ".toml"} {
+		path := filepath.Join(home, ".gas_tasks"+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return json
+}
+
 func (c *config) loadTasks() (tasks TaskList, err error) {
 	log.Println("loading tasks")
 
-	taskfile, err := os.Open(c.taskfilePath)
+	tasks.Tasks, err = readTasks(c.taskfilePath)
 	if err != nil {
 		err = errors.Wrap(err, "load tasks")
 		return
 	}
-	defer taskfile.Close()
-
-	err = json.NewDecoder(taskfile).Decode(&tasks.Tasks)
-	if err != nil {
+	if err = checkNames(tasks.Tasks); err != nil {
 		err = errors.Wrap(err, "load tasks")
 		return
 	}
 	tasks.mu = new(sync.RWMutex)
 	for _, t := range tasks.Tasks {
-		t.ch = make(chan *TaskStatus, 1)
 		t.c = c
+		if t.Schedule != "" {
+			if t.cron, err = parseSchedule(t.Schedule); err != nil {
+				err = errors.Wrapf(err, "load tasks: %s", t.Name)
+				return
+			}
+		}
+		switch t.Overlap {
+		case "", overlapSkip, overlapQueue, overlapKill:
+		default:
+			err = errors.Errorf("load tasks: %s: unknown overlap policy %q", t.Name, t.Overlap)
+			return
+		}
+		if t.LogSize < 0 || t.LogKeep < 0 {
+			err = errors.Errorf("load tasks: %s: LogSize and LogKeep can't be negative", t.Name)
+			return
+		}
+		if err = t.loadEnvFile(); err != nil {
+			err = errors.Wrapf(err, "load tasks: %s", t.Name)
+			return
+		}
+		if _, err = t.lookupRunAs(); err != nil {
+			err = errors.Wrapf(err, "load tasks: %s", t.Name)
+			return
+		}
+		if t.User != "" && t.Notify {
+			// the notify socket is in gas's own directory
+			err = errors.Errorf("load tasks: %s: Notify and User don't go together", t.Name)
+			return
+		}
+		t.initPort()
+		if t.AutoPort {
+			if len(t.DeployPorts) > 0 {
+				err = errors.Errorf("load tasks: %s: AutoPort and DeployPorts don't go together", t.Name)
+				return
+			}
+			// it may still be running from before gas went away
+			if port := t.statePort(); port != "" {
+				ports.claim(t.Name, port)
+			}
+			if t.port, err = ports.assign(t.Name); err != nil {
+				err = errors.Wrapf(err, "load tasks: %s", t.Name)
+				return
+			}
+		}
+		t.debounce = defaultWatchDebounce
+		if t.WatchDebounce != "" {
+			if t.debounce, err = time.ParseDuration(t.WatchDebounce); err != nil {
+				err = errors.Wrapf(err, "load tasks: %s: WatchDebounce", t.Name)
+				return
+			}
+		}
+		if err = t.parseStop(); err != nil {
+			err = errors.Wrapf(err, "load tasks: %s", t.Name)
+			return
+		}
+		if err = t.parseRestartPolicy(); err != nil {
+			err = errors.Wrapf(err, "load tasks: %s", t.Name)
+			return
+		}
+		if t.ReadyTimeout != "" {
+			if _, err = time.ParseDuration(t.ReadyTimeout); err != nil {
+				err = errors.Wrapf(err, "load tasks: %s: ReadyTimeout", t.Name)
+				return
+			}
+		}
+	}
+	if err = checkDependencies(tasks.Tasks); err != nil {
+		err = errors.Wrap(err, "load tasks")
+		return
 	}
 	tasks.taskChan = make(chan interface{}, 1)
 	tasks.c = c
+	tasks.kv = newRegistry()
 	return
 }
 
@@ -2,10 +2,24 @@ package main
 
 import (
 	"os"
+	"time"
 
+	"github.com/pkg/errors"
 	"golang.org/x/sys/unix"
 )
 
+// processStart returns when the process with the given pid started.
+func processStart(pid int) (time.Time, error) {
+	kp, err := unix.SysctlKinfoProc("kern.proc.pid", pid)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if int(kp.Proc.P_pid) != pid {
+		return time.Time{}, errors.Errorf("no process %d", pid)
+	}
+	return time.Unix(kp.Proc.P_starttime.Unix()), nil
+}
+
 var signalMap = map[string]os.Signal{
 	"ABRT":   unix.SIGABRT,
 	"ALRM":   unix.SIGALRM,
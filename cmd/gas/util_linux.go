@@ -1,11 +1,58 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/pkg/errors"
 	"golang.org/x/sys/unix"
 )
 
+// clock ticks per second in /proc/<pid>/stat (USER_HZ, which is 100 everywhere)
+const clockTicks = 100
+
+// processStart returns when the process with the given pid started.
+func processStart(pid int) (time.Time, error) {
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return time.Time{}, err
+	}
+	// the command name in parentheses can have spaces in it; starttime is
+	// the 22nd field, the 20th after it
+	i := bytes.LastIndexByte(b, ')')
+	if i < 0 {
+		return time.Time{}, errors.Errorf("/proc/%d/stat: no command name", pid)
+	}
+	fields := strings.Fields(string(b[i+1:]))
+	if len(fields) < 20 {
+		return time.Time{}, errors.Errorf("/proc/%d/stat: too few fields", pid)
+	}
+	ticks, err := strconv.ParseInt(fields[19], 10, 64)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "/proc/%d/stat", pid)
+	}
+
+	b, err = ioutil.ReadFile("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(line, "btime ") {
+			boot, err := strconv.ParseInt(strings.TrimSpace(line[len("btime "):]), 10, 64)
+			if err != nil {
+				return time.Time{}, errors.Wrap(err, "/proc/stat")
+			}
+			return time.Unix(boot, 0).Add(time.Duration(ticks) * time.Second / clockTicks), nil
+		}
+	}
+	return time.Time{}, errors.New("/proc/stat: no btime")
+}
+
 var signalMap = map[string]os.Signal{
 	"ABRT":   unix.SIGABRT,
 	"ALRM":   unix.SIGALRM,
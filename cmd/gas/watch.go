@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// how often the paths a task watches are checked for changes
+	watchInterval = 500 * time.Millisecond
+
+	defaultWatchDebounce = time.Second
+)
+
+// a fileStamp is what's compared to tell whether a watched file changed
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// watchedFiles stats everything matched by the Watch globs of t, walking into
+// directories.
+func (t *Task) watchedFiles() map[string]fileStamp {
+	files := make(map[string]fileStamp)
+	for _, pattern := range t.Watch {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(t.Dir, pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			continue
+		}
+		for _, m := range matches {
+			filepath.Walk(m, func(path string, fi os.FileInfo, err error) error {
+				if err == nil && !fi.IsDir() {
+					files[path] = fileStamp{fi.ModTime(), fi.Size()}
+				}
+				return nil
+			})
+		}
+	}
+	return files
+}
+
+// changedFile returns the name of a file that differs between a and b, or ""
+// if they're the same.
+func changedFile(a, b map[string]fileStamp) string {
+	for path, s := range a {
+		if s2, ok := b[path]; !ok || s2 != s {
+			return path
+		}
+	}
+	for path := range b {
+		if _, ok := a[path]; !ok {
+			return path
+		}
+	}
+	return ""
+}
+
+// watch restarts t whenever the files it watches change, once they've stopped
+// changing for its WatchDebounce, until t is unwatched.
+func (tl *TaskList) watch(t *Task) {
+	if len(t.Watch) == 0 || t.watchStop != nil {
+		return
+	}
+	t.watchStop = make(chan struct{})
+	go tl.runWatch(t, t.watchStop)
+}
+
+func (tl *TaskList) runWatch(t *Task, stop <-chan struct{}) {
+	last := t.watchedFiles()
+	tick := time.NewTicker(watchInterval)
+	defer tick.Stop()
+
+	var (
+		changed time.Time // when the last change not yet acted on was seen
+		path    string
+	)
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-tick.C:
+			cur := t.watchedFiles()
+			if p := changedFile(last, cur); p != "" {
+				last, changed, path = cur, now, p
+				continue
+			}
+			if changed.IsZero() || now.Sub(changed) < t.debounce {
+				continue
+			}
+			changed = time.Time{}
+			tl.watchRestart(t, path)
+		}
+	}
+}
+
+// watchRestart restarts t because path changed. Scheduled tasks are left to
+// pick the change up on their next run. A task that was disabled for crash
+// looping is started again, since the change may well be the fix.
+func (tl *TaskList) watchRestart(t *Task, path string) {
+	if t.cron != nil {
+		return
+	}
+	args := &Args{Name: t.Name}
+	var err error
+	switch {
+	case t.Alive():
+		t.Logf("%s changed, restarting", path)
+		err = tl.Restart(args, new(Response))
+	case t.Status().CrashLoop:
+		t.Logf("%s changed, starting again", path)
+		err = tl.Start(args, new(Response))
+	}
+	if err != nil {
+		t.Log("watch:", err)
+	}
+}
+
+// unwatch stops watching the files of t.
+func (t *Task) unwatch() {
+	if t.watchStop != nil {
+		close(t.watchStop)
+		t.watchStop = nil
+	}
+}
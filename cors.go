@@ -0,0 +1,135 @@
+package gas
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS sets out which cross-origin requests a router allows. Once it's given
+// to Router.CORS, responses to requests from allowed origins get the
+// Access-Control headers, and preflight OPTIONS requests are answered for any
+// path that has a route with the requested method, unless there's an OPTIONS
+// route for the path already.
+type CORS struct {
+	// The origins allowed to make requests, e.g. "https://example.com", or
+	// "*" for any origin.
+	Origins []string
+
+	// The methods allowed in preflight requests. If it's empty, the methods
+	// of the routes matching the path are allowed.
+	Methods []string
+
+	// The request headers allowed in preflight requests, or "*" to allow
+	// whichever ones the client asks for.
+	Headers []string
+
+	// The response headers that scripts are allowed to read.
+	ExposedHeaders []string
+
+	// Whether requests may include cookies and HTTP authentication.
+	Credentials bool
+
+	// How long clients may cache the answer to a preflight request. Zero
+	// leaves it up to the client.
+	MaxAge time.Duration
+}
+
+// CORS makes r allow the cross-origin requests described by c.
+func (r *Router) CORS(c *CORS) *Router {
+	r.cors = c
+	return r
+}
+
+// the methods of the routes that match path, sorted
+func (r *Router) methods(path string) []string {
+	var methods []string
+	for method, tree := range r.trees {
+		for _, route := range tree.lookup(path, nil) {
+			if _, _, ok := route.capture(method, path, nil, nil); ok {
+				methods = append(methods, method)
+				break
+			}
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+func (c *CORS) allowsOrigin(origin string) bool {
+	return contains(c.Origins, "*") || contains(c.Origins, origin)
+}
+
+// allow adds the headers letting the request's origin see the response, if
+// it's allowed to.
+func (c *CORS) allow(g *Gas) {
+	origin := g.Request.Header.Get("Origin")
+	if c == nil || origin == "" {
+		return
+	}
+	h := g.Header()
+	h.Add("Vary", "Origin")
+	if !c.allowsOrigin(origin) {
+		return
+	}
+	if contains(c.Origins, "*") && !c.Credentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.Credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if len(c.ExposedHeaders) > 0 {
+		h.Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+	}
+}
+
+// preflight answers the request if it's a preflight request for a method
+// that r has a route for, returning whether it did.
+func (c *CORS) preflight(g *Gas, r *Router) bool {
+	req := g.Request
+	method := req.Header.Get("Access-Control-Request-Method")
+	if c == nil || req.Method != "OPTIONS" || method == "" || req.Header.Get("Origin") == "" {
+		return false
+	}
+
+	methods := r.methods(req.URL.Path)
+	if len(methods) == 0 {
+		return false
+	}
+	if len(c.Methods) > 0 {
+		methods = c.Methods
+	}
+
+	h := g.Header()
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	c.allow(g)
+	if c.allowsOrigin(req.Header.Get("Origin")) && contains(methods, method) {
+		h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		if contains(c.Headers, "*") {
+			if headers := req.Header.Get("Access-Control-Request-Headers"); headers != "" {
+				h.Set("Access-Control-Allow-Headers", headers)
+			}
+		} else if len(c.Headers) > 0 {
+			h.Set("Access-Control-Allow-Headers", strings.Join(c.Headers, ", "))
+		}
+		if c.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge/time.Second)))
+		}
+	}
+	g.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package gas
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+	h := func(g *Gas) (int, Outputter) {
+		g.Write([]byte("ok"))
+		return g.Stop()
+	}
+	r := New().
+		Get("/api/{id}", h).
+		Post("/api/{id}", h).
+		Add("/custom", "OPTIONS", h).
+		CORS(&CORS{
+			Origins:        []string{"https://example.com"},
+			Headers:        []string{"Content-Type"},
+			ExposedHeaders: []string{"X-Request-ID"},
+			Credentials:    true,
+			MaxAge:         time.Hour,
+		})
+
+	serve := func(method, url string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		for i := 0; i < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("GET", "/api/1", "Origin", "https://example.com")
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://example.com" ||
+		w.Header().Get("Access-Control-Allow-Credentials") != "true" ||
+		w.Header().Get("Access-Control-Expose-Headers") != "X-Request-ID" {
+		t.Errorf("simple request: got headers %v", w.Header())
+	}
+
+	w = serve("GET", "/api/1", "Origin", "https://evil.com")
+	if w.Header().Get("Access-Control-Allow-Origin") != "" || w.Body.String() != "ok" {
+		t.Errorf("disallowed origin: got %v %q", w.Header(), w.Body)
+	}
+
+	w = serve("OPTIONS", "/api/1", "Origin", "https://example.com", "Access-Control-Request-Method", "POST")
+	if w.Code != 204 ||
+		w.Header().Get("Access-Control-Allow-Headers") != "Content-Type" ||
+		w.Header().Get("Access-Control-Max-Age") != "3600" ||
+		w.Header().Get("Access-Control-Allow-Methods") != "GET, HEAD, POST" {
+		t.Errorf("preflight: got %d %v", w.Code, w.Header())
+	}
+
+	w = serve("OPTIONS", "/api/1", "Origin", "https://example.com", "Access-Control-Request-Method", "DELETE")
+	if w.Code != 204 || w.Header().Get("Access-Control-Allow-Methods") != "" {
+		t.Errorf("preflight for a missing method: got %d %v", w.Code, w.Header())
+	}
+
+	w = serve("OPTIONS", "/nope", "Origin", "https://example.com", "Access-Control-Request-Method", "GET")
+	if w.Code != 404 {
+		t.Errorf("preflight for a missing route: got %d", w.Code)
+	}
+
+	w = serve("OPTIONS", "/custom", "Origin", "https://example.com", "Access-Control-Request-Method", "GET")
+	if w.Body.String() != "ok" {
+		t.Errorf("explicit OPTIONS route: got %q", w.Body)
+	}
+}
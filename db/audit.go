@@ -0,0 +1,34 @@
+package db
+
+import (
+	"encoding/json"
+
+	"ktkr.us/pkg/gas"
+)
+
+// AuditTable stores every gas.AuditEntry published from now on in the given
+// table, which is created if it doesn't exist yet. Entries are never dropped,
+// so requests wait for the inserts if the database falls too far behind.
+func AuditTable(table string) (*gas.Subscription, error) {
+	err := execStmt("CREATE TABLE IF NOT EXISTS " + table + ` (
+		time       timestamptz NOT NULL,
+		actor      text NOT NULL,
+		action     text NOT NULL,
+		target     jsonb NOT NULL,
+		ip         text NOT NULL,
+		status     integer NOT NULL,
+		request_id text NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	insert := "INSERT INTO " + table + " VALUES ( $1, $2, $3, $4, $5, $6, $7 )"
+	return gas.SubscribeWith(func(e *gas.AuditEntry) {
+		target, _ := json.Marshal(e.Target)
+		err := execStmt(insert, e.Time, e.Actor, e.Action, string(target), e.IP, e.Status, e.RequestID)
+		if err != nil {
+			gas.Logger().Error("db: audit", "err", err, "action", e.Action, "request_id", e.RequestID)
+		}
+	}, gas.SubscribeOptions{QueueSize: 1024, Overflow: gas.Block}), nil
+}
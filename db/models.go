@@ -1,10 +1,11 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
+	"os"
 	"reflect"
 	"strconv"
 	"strings"
@@ -39,22 +40,24 @@ var Env struct {
 func init() {
 	err := gas.EnvConf(&Env, gas.EnvPrefix)
 	if err != nil {
-		log.Fatalf("db (init): %v", err)
+		gas.Logger().Error("db (init)", "err", err)
+		os.Exit(1)
 	}
 
 	if Env.DBName == "" {
-		log.Printf("%sDB_NAME is not set, database support disabled", gas.EnvPrefix)
+		gas.Logger().Info("db: " + gas.EnvPrefix + "DB_NAME is not set, database support disabled")
 		return
 	}
 
 	if Env.DBParams == "" {
-		log.Printf("%sDB_PARAMS is not set, database support disabled", gas.EnvPrefix)
+		gas.Logger().Info("db: " + gas.EnvPrefix + "DB_PARAMS is not set, database support disabled")
 		return
 	}
 
 	DB, err = sql.Open(Env.DBName, Env.DBParams)
 	if err != nil {
-		log.Fatalf("db (init): %v", err)
+		gas.Logger().Error("db (init)", "err", err)
+		os.Exit(1)
 	}
 
 	gas.AddDestructor(func() {
@@ -290,8 +293,41 @@ func Register(t reflect.Type) (*model, error) {
 	return m, nil
 }
 
+// QueryExecuted is published on the gas event bus (see gas.Subscribe) after
+// each query made with Query, QueryJoin, or a Store.
+type QueryExecuted struct {
+	Time     time.Time // when the query started
+	Duration time.Duration
+	Query    string
+	Err      error
+}
+
+// publish a QueryExecuted for a query that started at start
+func queryExecuted(query string, start time.Time, err error) {
+	gas.Publish(&QueryExecuted{
+		Time:     start,
+		Duration: time.Since(start),
+		Query:    query,
+		Err:      err,
+	})
+}
+
 // Query into a single row or a slice.
 func Query(dest interface{}, query string, args ...interface{}) error {
+	return QueryContext(context.Background(), dest, query, args...)
+}
+
+// QueryContext is like Query, but gives up on the query once ctx is done. In
+// a handler, use g.Context() so that the query is cancelled along with the
+// request, e.g. when the client goes away or the handler times out.
+func QueryContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	start := time.Now()
+	err := doQuery(ctx, dest, query, args...)
+	queryExecuted(query, start, err)
+	return err
+}
+
+func doQuery(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
 	t := reflect.TypeOf(dest)
 	model, err := Register(t)
 	if err != nil {
@@ -308,7 +344,7 @@ func Query(dest interface{}, query string, args ...interface{}) error {
 		return err
 	}
 
-	rows, err := stmt.Query(args...)
+	rows, err := stmt.QueryContext(ctx, args...)
 	if err != nil {
 		return err
 	}
@@ -397,6 +433,19 @@ func querySlice(model *model, slice interface{}, rows *sql.Rows) error {
 // The structs of the slice must each have a slice field at the end with their
 // own slices of pointers to structs, etc.
 func QueryJoin(dest interface{}, query string, args ...interface{}) error {
+	return QueryJoinContext(context.Background(), dest, query, args...)
+}
+
+// QueryJoinContext is like QueryJoin, but gives up on the query once ctx is
+// done, like QueryContext.
+func QueryJoinContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	start := time.Now()
+	err := doQueryJoin(ctx, dest, query, args...)
+	queryExecuted(query, start, err)
+	return err
+}
+
+func doQueryJoin(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
 	t := reflect.TypeOf(dest)
 	if t.Kind() != reflect.Ptr {
 		return fmt.Errorf(errNotPtr, dest)
@@ -428,10 +477,11 @@ func QueryJoin(dest interface{}, query string, args ...interface{}) error {
 	if err != nil {
 		return err
 	}
-	rows, err := stmt.Query(args...)
+	rows, err := stmt.QueryContext(ctx, args...)
 	if err != nil {
 		return err
 	}
+	defer rows.Close()
 
 	return f(t, dest, rows)
 }
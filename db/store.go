@@ -7,7 +7,7 @@ import (
 )
 
 func NewStore(table string) (*Store, error) {
-	_, err := DB.Exec("CREATE TABLE IF NOT EXISTS " + table +
+	err := execStmt("CREATE TABLE IF NOT EXISTS " + table +
 		" ( id bytea, expires timestamptz, username text )")
 	if err != nil {
 		return nil, err
@@ -15,6 +15,15 @@ func NewStore(table string) (*Store, error) {
 	return &Store{table}, nil
 }
 
+// execStmt runs a statement that doesn't return rows, publishing a QueryExecuted
+// for it
+func execStmt(query string, args ...interface{}) error {
+	start := time.Now()
+	_, err := DB.Exec(query, args...)
+	queryExecuted(query, start, err)
+	return err
+}
+
 // Store is a session store that stores sessions in a database table.
 type Store struct {
 	// The name of the table.
@@ -22,10 +31,8 @@ type Store struct {
 }
 
 func (s *Store) Create(id []byte, expires time.Time, username string) error {
-	_, err := DB.Exec("INSERT INTO "+s.table+" VALUES ( $1, $2, $3 )",
+	return execStmt("INSERT INTO "+s.table+" VALUES ( $1, $2, $3 )",
 		id, expires, username)
-
-	return err
 }
 
 func (s *Store) Read(id []byte) (*auth.Session, error) {
@@ -36,11 +43,9 @@ func (s *Store) Read(id []byte) (*auth.Session, error) {
 
 func (s *Store) Update(id []byte) error {
 	exp := time.Now().Add(auth.Env.MaxCookieAge)
-	_, err := DB.Exec("UPDATE "+s.table+" SET expires = $1 WHERE id = $2", exp, id)
-	return err
+	return execStmt("UPDATE "+s.table+" SET expires = $1 WHERE id = $2", exp, id)
 }
 
 func (s *Store) Delete(id []byte) error {
-	_, err := DB.Exec("DELETE FROM "+s.table+" WHERE id = $1", id)
-	return err
+	return execStmt("DELETE FROM "+s.table+" WHERE id = $1", id)
 }
@@ -0,0 +1,58 @@
+package gas
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+)
+
+// Debug serves the runtime's profiling and debugging endpoints under prefix,
+// so that a running server can be looked into without starting a second one:
+//
+//	prefix/pprof/          index of profiles, as with net/http/pprof
+//	prefix/pprof/{name}    a profile, e.g. heap, goroutine, or allocs
+//	prefix/pprof/profile   CPU profile (?seconds=30)
+//	prefix/pprof/trace     execution trace (?seconds=1)
+//	prefix/vars            expvar variables as JSON
+//
+// These give away a lot about the server, so on anything reachable from
+// outside, auth should be given to turn away anybody who shouldn't see them.
+// It runs before each endpoint like group middleware:
+//
+//	r.Debug("/debug", func(g *gas.Gas) (int, gas.Outputter) {
+//		if !isAdmin(g) {
+//			return 403, nil
+//		}
+//		return g.Continue()
+//	})
+func (r *Router) Debug(prefix string, auth ...Handler) *Router {
+	debug := r.Group(prefix, auth...)
+	debug.Get("/pprof", redirectHandler(debug.prefix+"/pprof/")).
+		Get("/pprof/", serveHTTP(http.HandlerFunc(pprof.Index))).
+		Get("/pprof/cmdline", serveHTTP(http.HandlerFunc(pprof.Cmdline))).
+		Get("/pprof/profile", serveHTTP(http.HandlerFunc(pprof.Profile))).
+		Get("/pprof/symbol", serveHTTP(http.HandlerFunc(pprof.Symbol))).
+		Post("/pprof/symbol", serveHTTP(http.HandlerFunc(pprof.Symbol))).
+		Get("/pprof/trace", serveHTTP(http.HandlerFunc(pprof.Trace))).
+		Get("/pprof/{name}", func(g *Gas) (int, Outputter) {
+			pprof.Handler(g.Arg("name")).ServeHTTP(g, g.Request)
+			return g.Stop()
+		}).
+		Get("/vars", serveHTTP(expvar.Handler()))
+	return r
+}
+
+// serveHTTP makes h into a Handler.
+func serveHTTP(h http.Handler) Handler {
+	return func(g *Gas) (int, Outputter) {
+		h.ServeHTTP(g, g.Request)
+		return g.Stop()
+	}
+}
+
+func redirectHandler(path string) Handler {
+	return func(g *Gas) (int, Outputter) {
+		http.Redirect(g, g.Request, path, http.StatusMovedPermanently)
+		return g.Stop()
+	}
+}
@@ -0,0 +1,42 @@
+package gas
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDebug(t *testing.T) {
+	r := New().Debug("/debug", func(g *Gas) (int, Outputter) {
+		if g.Request.Header.Get("X-Admin") == "" {
+			return 403, nil
+		}
+		return g.Continue()
+	})
+
+	for _, test := range []struct {
+		url, body string
+		code      int
+	}{
+		{"/debug/pprof/", "goroutine", 200},
+		{"/debug/pprof", "", 301},
+		{"/debug/pprof/goroutine?debug=1", "goroutine profile", 200},
+		{"/debug/pprof/cmdline", "", 200},
+		{"/debug/pprof/nope", "Unknown profile", 404},
+		{"/debug/vars", `"memstats"`, 200},
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", test.url, nil)
+		req.Header.Set("X-Admin", "yes")
+		r.ServeHTTP(w, req)
+		if w.Code != test.code || !strings.Contains(w.Body.String(), test.body) {
+			t.Errorf("%s: expected %d %q, got %d %.100q", test.url, test.code, test.body, w.Code, w.Body)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/debug/vars", nil))
+	if w.Code != 403 {
+		t.Errorf("expected auth to turn the request away, got %d", w.Code)
+	}
+}
@@ -2,7 +2,6 @@ package gas
 
 import (
 	"fmt"
-	"log"
 	"os"
 	"reflect"
 	"strconv"
@@ -15,6 +14,51 @@ import (
 // in SHOUTING_SNAKE_CASE. They may be overridden during runtime, but note that
 // some are only used on startup (after init() and before Ignition).
 var Env struct {
+	// ENV selects the profile of defaults used by the rest of the framework.
+	// It must be one of "dev", "staging", or "prod". See Profile for what
+	// each one implies.
+	Env string `default:"dev"`
+
+	// What to answer with when a handler panics: "debug" for a page with the
+	// stack trace and the source code around the panic, or "plain" for a
+	// bare 500. Empty follows Profile.DebugPanics, and anything else is
+	// taken as "plain". Stack traces and source code are for developers
+	// only, so set this to "plain" on anything public that might end up
+	// running with the dev profile.
+	PanicPage string
+
+	// The least severe level of log record to write ("debug", "info",
+	// "warn", or "error") and the format to write them in ("text" or
+	// "json"). The level defaults to debug with a Verbose profile and info
+	// otherwise. Sending SIGUSR1 to the server steps the level through
+	// debug, info, warn, and error; see also SetLogLevel and LogLevelHandler.
+	LogLevel  string
+	LogFormat string `default:"text"`
+
+	// Write the logs to LOG_FILE instead of standard error. The file is
+	// moved aside when it would grow past LOG_MAX_SIZE bytes or has been
+	// open for LOG_ROTATE_INTERVAL, whichever comes first (zero disables
+	// either one), and the new file picks up where it left off. Old files
+	// get the time they were rotated appended to their names, are gzipped if
+	// LOG_COMPRESS is set, and are deleted once there are more than LOG_KEEP
+	// of them, unless it's zero.
+	LogFile           string
+	LogMaxSize        int64         `default:"0"`
+	LogRotateInterval time.Duration `default:"0s"`
+	LogCompress       bool          `default:"false"`
+	LogKeep           int           `default:"0"`
+
+	// LOG_DEST picks where the logs go: "stderr", "file" (LOG_FILE),
+	// "syslog", or "journald". It defaults to file if LOG_FILE is set and
+	// stderr otherwise. Syslog messages go to the local daemon, or to
+	// SYSLOG_ADDR (network!address, with the network defaulting to "udp")
+	// if it's set. Journald gets each attribute of a record as a field of its
+	// own. Both tag records with LOG_TAG, which defaults to the name of the
+	// executable, and ignore LOG_FORMAT.
+	LogDest    string
+	LogTag     string
+	SyslogAddr string
+
 	// The port for the server to listen on.
 	//
 	// PORT and TLS_PORT determine whether to use normal HTTP and/or HTTPS via
@@ -35,20 +79,234 @@ var Env struct {
 	//
 	// The server will listen concurrently on all listed interfaces. LISTEN
 	// supercedes PORT and TLS_PORT, which are now deprecated.
+	//
+	// Unix sockets accept the options "mode", "owner", and "group", which are
+	// applied to the socket file after it is created so that e.g. a reverse
+	// proxy running as another user can connect to it:
+	//
+	//     GAS_LISTEN="unix!/run/app.sock;mode=0660;group=www-data"
+	//
+	// The "proxy" option makes the listener expect every connection to start
+	// with a HAProxy PROXY protocol (v1 or v2) header, which is then used as
+	// the client's address. Only use it behind a load balancer that sends
+	// the header, since connections without one are rejected.
+	//
+	// The "reuseport" option sets SO_REUSEPORT on a TCP listener so that
+	// several server processes can bind the same address, e.g. to overlap
+	// old and new processes during a rolling restart or to run one per core.
+	//
+	// Sending SIGUSR2 to a server listening with LISTEN makes it start a new
+	// copy of its executable that inherits the listening sockets, after
+	// which the old process stops accepting connections, waits up to
+	// SHUTDOWN_TIMEOUT for the ones in progress, and returns from Ignition.
+	// This allows the binary to be replaced without refusing connections.
+	//
+	// The "router" option serves the listener with another router attached
+	// with Router.Bind instead of the one Ignition is called on, e.g.
+	// "unix!/run/admin.sock;router=admin".
+	//
+	// The "h2c" option allows HTTP/2 without TLS (prior knowledge or
+	// "Upgrade: h2c") on a plain listener, e.g. for gRPC-web or behind a
+	// proxy that terminates TLS and talks HTTP/2 to its backends. TLS
+	// listeners always offer HTTP/2 through ALPN.
+	//
+	// The "h3" option also serves HTTP/3 over QUIC on the UDP port with the
+	// same number as a TLS listener, and advertises it to clients on the TCP
+	// side with an Alt-Svc header. Connection counts don't include QUIC
+	// connections. To keep serving HTTP/3 across a restart, give the listener
+	// the "reuseport" option as well.
+	//
+	// The "tag" option names a listener for use with Gas.Listener and
+	// OnListener, e.g. to apply middleware only to requests from the public
+	// listeners: ":https;tls;tag=public".
+	//
+	// The "limit" option caps the number of requests from a listener that
+	// can be in progress at once (see also MAX_REQUESTS): ":http;limit=100".
+	//
+	// The "redirect=https" option makes a plain listener answer every request
+	// with a redirect to HTTPS instead of serving the router, the same as
+	// listing it in REDIRECT_HTTP: ":http;redirect=https, :https;tls".
+	//
+	// The "fd" network uses an already open socket inherited from the parent
+	// process, named either by its file descriptor number or by its name in
+	// LISTEN_FDNAMES, e.g. "fd!3;tls" or "fd!web". If LISTEN isn't given but
+	// the process was started with systemd-style socket activation
+	// (LISTEN_PID and LISTEN_FDS), the server will listen on all of the
+	// passed sockets.
 	Listen string
 
+	// Limits applied to the HTTP server(s) started by Ignition, with the same
+	// meaning as the equivalent fields of net/http.Server. They are only
+	// applied where the Server given to the router doesn't set its own value.
+	// Zero means no limit (or the net/http default, for MAX_HEADER_BYTES).
+	ReadTimeout    time.Duration `default:"0s"`
+	WriteTimeout   time.Duration `default:"0s"`
+	IdleTimeout    time.Duration `default:"0s"`
+	MaxHeaderBytes int           `default:"0"`
+
+	// The most requests that can be in progress at once across all
+	// listeners. Requests over the limit are turned away with 503 Service
+	// Unavailable and a Retry-After of RETRY_AFTER. Zero means no limit.
+	MaxRequests int           `default:"0"`
+	RetryAfter  time.Duration `default:"1s"`
+
+	// How long the handlers of a route have to finish before the request is
+	// answered with 503 Service Unavailable and the context of the request is
+	// cancelled, unless the route sets its own with Router.Timeout. The
+	// response is held back until the handlers are done, so routes that
+	// stream their output shouldn't have a timeout. Zero means no limit.
+	HandlerTimeout time.Duration `default:"0s"`
+
+	// How long to wait for connections in progress to finish when shutting
+	// down before closing them forcibly.
+	ShutdownTimeout time.Duration `default:"30s"`
+
+	// Paths of the built-in liveness and readiness checks, answered ahead of
+	// any routes. The readiness check starts failing as soon as shutdown
+	// begins, and the listeners stay open for DRAIN_DELAY after that so that
+	// load balancers have time to take the server out of rotation. Set a path
+	// to "-" to disable its check.
+	HealthzPath string        `default:"/healthz"`
+	ReadyzPath  string        `default:"/readyz"`
+	DrainDelay  time.Duration `default:"0s"`
+
+	// Path of a JSON report of readiness and per-listener connection counts
+	// (see Stats), answered like the health checks. Disabled by default.
+	StatusPath string `default:"-"`
+
+	// Path of request and connection metrics in the Prometheus text format,
+	// answered like the health checks. Requests are counted per route, so
+	// they're only tracked while it's enabled. Disabled by default.
+	MetricsPath string `default:"-"`
+
+	// Comma separated addresses or CIDR ranges of the reverse proxies whose
+	// Forwarded, X-Forwarded-For, and X-Real-IP headers are believed when
+	// working out the client's address (see Gas.ClientIP), such as
+	// "10.0.0.0/8, 192.0.2.1". "loopback" and "private" stand for the
+	// loopback and private address ranges. Nothing is trusted by default.
+	TrustedProxies string
+
+	// Defaults for the cookies set with Gas.SetCookie, each applied where
+	// the cookie doesn't set its own: COOKIE_SECURE marks them all Secure,
+	// which should be set whenever the site is served over HTTPS, even if
+	// TLS is terminated by a proxy in front of it. COOKIE_SAME_SITE is
+	// "lax", "strict", or "none" (which browsers only accept on Secure
+	// cookies). COOKIE_DOMAIN and COOKIE_PATH are the Domain and Path
+	// attributes.
+	CookieSecure   bool `default:"false"`
+	CookieSameSite string
+	CookieDomain   string
+	CookiePath     string
+
+	// Keep the last CAPTURE_REQUESTS requests and responses, with their
+	// headers and up to CAPTURE_BODY_SIZE bytes of their bodies, for
+	// debugging (see Captures and CaptureHandler). Headers and form or JSON
+	// fields with names like "password" or "token", or containing one of the
+	// comma separated words in CAPTURE_REDACT, are redacted. Zero, the
+	// default, turns capturing off.
+	CaptureRequests int `default:"0"`
+	CaptureBodySize int `default:"4096"`
+	CaptureRedact   string
+
+	// A subscriber to the event bus (see Subscribe) that has been handling a
+	// single event for longer than this is reported as stalled by
+	// StalledSubscribers and in the report at STATUS_PATH. Zero turns the
+	// reports off.
+	SubscriberStallTimeout time.Duration `default:"30s"`
+
+	// REDIRECT_HTTP is a list of addresses in the same format as LISTEN on
+	// which to serve nothing but redirects to the HTTPS version of the
+	// requested URL (at TLS_HOST, if set). Requests for ACME HTTP-01
+	// challenges under /.well-known/acme-challenge/ are passed through to the
+	// router instead. Example:
+	//
+	//     GAS_LISTEN=":https;tls" GAS_REDIRECT_HTTP=":http"
+	RedirectHTTP string
+
 	// When set, the server will listen using FastCGI on the given network.
 	//
 	// Deprecated.
 	FastCGI string
 
 	// Paths to the TLS certificate and key files, if TLS is enabled. Same
-	// rules as net/http.(*Server).ListenAndServeTLS.
-	TLSCert string
-	TLSKey  string
+	// rules as net/http.(*Server).ListenAndServeTLS. Several pairs can be
+	// given as os.PathListSeparator-separated lists, and TLS_CERT_DIR can
+	// name a directory where each name.crt has its key in name.key. The
+	// certificate is picked using the host name the client asks for with
+	// SNI, falling back to the first one. With the dev profile, leaving them
+	// all unset uses a self-signed certificate made up on startup.
+	TLSCert    string
+	TLSKey     string
+	TLSCertDir string
 
 	// The hostname to send in the TLS handshake
 	TLSHost string
+
+	// The version of the application, e.g. a commit hash, to tag error
+	// reports with
+	Release string
+
+	// Report panics and 5xx responses to the Sentry (or compatible) project
+	// with this DSN. SENTRY_SAMPLE_RATE is the fraction of them to send.
+	SentryDSN        string
+	SentrySampleRate float64 `default:"1"`
+
+	// Comma separated URLs to POST events to as JSON (see notify.Webhook),
+	// signed with WEBHOOK_SECRET if it's set. WEBHOOK_EVENTS limits them to
+	// a comma separated list of kinds, such as "panic,server_error".
+	WebhookURL    string
+	WebhookSecret []byte
+	WebhookEvents string
+
+	// Comma separated Slack or Discord incoming webhook URLs to post short
+	// messages about events to, of the comma separated kinds in CHAT_EVENTS.
+	ChatWebhookURL string
+	ChatEvents     string `default:"panic,task_death"`
+
+	// Email events through the SMTP server at SMTP_ADDR (host:port) from
+	// EMAIL_FROM to the comma separated addresses in EMAIL_TO, logging in
+	// with SMTP_USERNAME and SMTP_PASSWORD if they're set. If EMAIL_DIGEST
+	// is nonzero, events are collected and sent together at most that
+	// often instead of one at a time. EMAIL_EVENTS is the comma separated
+	// kinds of events to send.
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+	EmailFrom    string
+	EmailTo      string
+	EmailDigest  time.Duration `default:"0s"`
+	EmailEvents  string        `default:"panic,task_death"`
+
+	// Run this shell command for each event of the comma separated kinds in
+	// ALERT_COMMAND_EVENTS, with the event as JSON on its standard input
+	// (see notify.Command).
+	AlertCommand       string
+	AlertCommandEvents string `default:"panic,task_death"`
+
+	// The range of TLS versions to accept, as "1.0" to "1.3". No maximum is
+	// set by default.
+	TLSMinVersion string `default:"1.2"`
+	TLSMaxVersion string
+
+	// Comma separated lists of the TLS 1.2 cipher suites (by their names in
+	// package crypto/tls, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256) and key
+	// exchange curves (X25519, P256, P384, P521) to offer, in order of
+	// preference. The cipher suites default to the forward secret AEAD ones
+	// and the curves to the crypto/tls defaults.
+	TLSCipherSuites string
+	TLSCurves       string
+
+	// How often to replace the session ticket key. Zero leaves it up to
+	// crypto/tls, which rotates automatically every day.
+	TLSTicketKeyRotation time.Duration `default:"0s"`
+
+	// Client certificates on TLS listeners are checked against the PEM
+	// encoded CA certificates in TLS_CLIENT_CA. TLS_CLIENT_AUTH is "require"
+	// (the default when there's a CA) to turn away clients without a valid
+	// certificate, "verify" to check them only if they're given, or
+	// "request" to ask for them without checking. See Gas.ClientCert.
+	TLSClientCA   string
+	TLSClientAuth string
 }
 
 // EnvPrefix is the prefix append to the field name in Env, e.g. Env.DBName
@@ -61,6 +319,9 @@ const EnvPrefix = "GAS_"
 //
 //     envconf:"required" // an error will be returned if this var isn't given
 //     default:"<default value>" // provide a default if this var isn't given
+//
+// The values are logged at debug level, except for those of variables ending
+// in _PASSWORD, _SECRET, _KEY, or _DSN.
 func EnvConf(conf interface{}, prefix string) error {
 	val := reflect.ValueOf(conf).Elem()
 	typ := val.Type()
@@ -69,7 +330,11 @@ func EnvConf(conf interface{}, prefix string) error {
 		fieldVal := val.Field(i)
 		name := prefix + strings.ToUpper(ToSnake(field.Name))
 		v := os.Getenv(name)
-		log.Printf("[envconf] %s = '%s'", name, v)
+		if v != "" && isSecretVar(name) {
+			Logger().Debug("envconf", "var", name, "value", redacted)
+		} else {
+			Logger().Debug("envconf", "var", name, "value", v)
+		}
 
 		if v == "" {
 			if field.Tag.Get("envconf") == "required" {
@@ -89,6 +354,18 @@ func EnvConf(conf interface{}, prefix string) error {
 	return nil
 }
 
+// the suffixes of the variables whose values shouldn't end up in the logs
+var secretSuffixes = []string{"_PASSWORD", "_SECRET", "_KEY", "_DSN"}
+
+func isSecretVar(name string) bool {
+	for _, suffix := range secretSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
 func stringValue(s string, fieldVal interface{}) error {
 	var err error
 
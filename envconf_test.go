@@ -1,8 +1,11 @@
 package gas
 
 import (
+	"bytes"
+	"log/slog"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -93,3 +96,41 @@ func TestEnvConf(t *testing.T) {
 	assertEqual(conf.Float64, 3.14159265358979323846264833)
 	assertEqual(conf.Duration, time.Hour+2*time.Second+3*time.Millisecond+4*time.Microsecond+5*time.Nanosecond)
 }
+
+func TestEnvConfSecrets(t *testing.T) {
+	saved := Logger()
+	defer SetLogger(saved)
+	buf := new(bytes.Buffer)
+	SetLogger(slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	conf := struct {
+		SMTPPassword  string
+		WebhookSecret string
+		SentryDSN     string
+		SMTPUsername  string
+	}{}
+	for name, val := range map[string]string{
+		"GAS_TEST_SMTP_PASSWORD":  "hunter2",
+		"GAS_TEST_WEBHOOK_SECRET": "shh",
+		"GAS_TEST_SENTRY_DSN":     "https://abc@sentry.example/1",
+		"GAS_TEST_SMTP_USERNAME":  "gas",
+	} {
+		t.Setenv(name, val)
+	}
+	if err := EnvConf(&conf, "GAS_TEST_"); err != nil {
+		t.Fatal(err)
+	}
+	if conf.SMTPPassword != "hunter2" {
+		t.Errorf("expected the password to be set, got %q", conf.SMTPPassword)
+	}
+
+	logged := buf.String()
+	for _, secret := range []string{"hunter2", "shh", "abc@sentry"} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("%q was logged:\n%s", secret, logged)
+		}
+	}
+	if !strings.Contains(logged, "value=gas") || strings.Count(logged, "value="+redacted) != 3 {
+		t.Errorf("expected only the secrets redacted, got:\n%s", logged)
+	}
+}
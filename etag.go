@@ -0,0 +1,120 @@
+package gas
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ETags is middleware that answers conditional GET and HEAD requests. The
+// response from the rest of the chain is held back until it's done, and if it
+// was a 200, it gets an ETag made from a hash of its body unless the handler
+// gave it one. If the request's If-None-Match has that tag, or failing that,
+// its If-Modified-Since isn't before the response's Last-Modified (if the
+// handler set one), the response is replaced with 304 Not Modified. Handlers
+// that know their tag without rendering the body can also check for
+// themselves with NotModified.
+//
+// Responses aren't streamed under ETags, so it shouldn't be used on routes
+// that stream their output.
+func ETags(g *Gas) (int, Outputter) {
+	if g.Method != "GET" && g.Method != "HEAD" {
+		return g.Continue()
+	}
+
+	w := g.w
+	buf := &bufferedWriter{h: w.Header()}
+	g.w = buf
+	g.run()
+	g.w = w
+
+	if buf.code == 0 || buf.code == http.StatusOK {
+		h := w.Header()
+		if h.Get("ETag") == "" {
+			sum := sha256.Sum256(buf.body.Bytes())
+			h.Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+		}
+		if g.NotModified() {
+			return g.Stop()
+		}
+	}
+
+	if buf.code != 0 {
+		w.WriteHeader(buf.code)
+	}
+	w.Write(buf.body.Bytes())
+	return g.Stop()
+}
+
+// NotModified checks the request's If-None-Match and If-Modified-Since
+// against the ETag and Last-Modified headers set on the response so far, and
+// if they say the client's copy is up to date, writes 304 Not Modified and
+// returns true. It only applies to GET and HEAD requests.
+func (g *Gas) NotModified() bool {
+	if g.Method != "GET" && g.Method != "HEAD" {
+		return false
+	}
+	h := g.Header()
+
+	modified := true
+	if inm := g.Request.Header.Get("If-None-Match"); inm != "" {
+		modified = !etagMatch(inm, h.Get("ETag"))
+	} else if ims := g.Request.Header.Get("If-Modified-Since"); ims != "" {
+		since, err1 := http.ParseTime(ims)
+		lastmod, err2 := http.ParseTime(h.Get("Last-Modified"))
+		modified = err1 != nil || err2 != nil || lastmod.Truncate(time.Second).After(since)
+	}
+	if modified {
+		return false
+	}
+
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+	g.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatch is whether the If-None-Match list matches tag, using the weak
+// comparison.
+func etagMatch(list, tag string) bool {
+	if tag == "" {
+		return false
+	}
+	tag = strings.TrimPrefix(tag, "W/")
+	for _, t := range strings.Split(list, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferedWriter holds a response body and status while sharing the headers
+// of the real writer.
+type bufferedWriter struct {
+	h    http.Header
+	body bytes.Buffer
+	code int
+}
+
+func (b *bufferedWriter) Header() http.Header {
+	return b.h
+}
+
+func (b *bufferedWriter) WriteHeader(code int) {
+	if b.code == 0 {
+		b.code = code
+	}
+}
+
+func (b *bufferedWriter) Write(p []byte) (int, error) {
+	if b.code == 0 {
+		b.code = http.StatusOK
+	}
+	return b.body.Write(p)
+}
@@ -0,0 +1,68 @@
+package gas
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestETags(t *testing.T) {
+	modtime := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	r := New().Use(ETags).
+		Get("/page", func(g *Gas) (int, Outputter) {
+			g.Header().Set("Content-Type", "text/plain")
+			g.Write([]byte("hello"))
+			return g.Stop()
+		}).
+		Get("/tagged", func(g *Gas) (int, Outputter) {
+			g.Header().Set("ETag", `"v1"`)
+			g.Header().Set("Last-Modified", modtime.Format(http.TimeFormat))
+			g.Write([]byte("tagged"))
+			return g.Stop()
+		}).
+		Get("/missing", func(g *Gas) (int, Outputter) {
+			g.WriteHeader(404)
+			g.Write([]byte("nope"))
+			return g.Stop()
+		})
+
+	serve := func(url string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		for i := 0; i < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("/page")
+	tag := w.Header().Get("ETag")
+	if w.Code != 200 || w.Body.String() != "hello" || tag == "" {
+		t.Fatalf("expected 200 hello with an ETag, got %d %q %q", w.Code, w.Body, tag)
+	}
+	if w = serve("/page", "If-None-Match", tag); w.Code != 304 || w.Body.Len() != 0 || w.Header().Get("Content-Type") != "" {
+		t.Errorf("expected 304 for a matching tag, got %d %q %v", w.Code, w.Body, w.Header())
+	}
+	if w = serve("/page", "If-None-Match", `"other", W/`+tag); w.Code != 304 {
+		t.Errorf("expected 304 for a weakly matching tag in a list, got %d", w.Code)
+	}
+	if w = serve("/page", "If-None-Match", `"other"`); w.Code != 200 {
+		t.Errorf("expected 200 for another tag, got %d", w.Code)
+	}
+
+	if w = serve("/tagged", "If-None-Match", `"v1"`); w.Code != 304 || w.Header().Get("ETag") != `"v1"` {
+		t.Errorf("expected 304 with the handler's tag, got %d %v", w.Code, w.Header())
+	}
+	if w = serve("/tagged", "If-Modified-Since", modtime.Format(http.TimeFormat)); w.Code != 304 {
+		t.Errorf("expected 304 for an unmodified page, got %d", w.Code)
+	}
+	if w = serve("/tagged", "If-Modified-Since", modtime.Add(-time.Hour).Format(http.TimeFormat)); w.Code != 200 {
+		t.Errorf("expected 200 for a modified page, got %d", w.Code)
+	}
+
+	if w = serve("/missing"); w.Code != 404 || w.Header().Get("ETag") != "" || w.Body.String() != "nope" {
+		t.Errorf("expected an untouched 404, got %d %v %q", w.Code, w.Header(), w.Body)
+	}
+}
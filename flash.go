@@ -0,0 +1,191 @@
+package gas
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// A FlashMessage is a message to show the user on the next page they see, e.g.
+// "Saved" after a form is posted and the browser is redirected.
+type FlashMessage struct {
+	Kind    string `json:"k"` // e.g. "info" or "error", for styling
+	Message string `json:"m"`
+}
+
+// A FlashStore keeps flash messages between requests. It must be safe for
+// concurrent use.
+type FlashStore interface {
+	// SaveFlashes replaces the messages kept for the client of g with
+	// flashes, or clears them if there are none.
+	SaveFlashes(g *Gas, flashes []FlashMessage) error
+
+	// LoadFlashes returns the messages kept for the client of g.
+	LoadFlashes(g *Gas) ([]FlashMessage, error)
+}
+
+var flashStore FlashStore = &CookieFlashStore{}
+
+// UseFlashStore keeps flash messages in s instead of the default, a
+// CookieFlashStore signing with a key of its own. Importing package auth
+// replaces the default with one that signs the cookie with its
+// COOKIE_AUTH_KEY, or encrypts it with its COOKIE_ENCRYPT_KEY, if either is
+// set. Must be called during app init, not during runtime.
+func UseFlashStore(s FlashStore) {
+	flashStore = s
+}
+
+// A CookieFlashStore keeps flash messages in a cookie.
+type CookieFlashStore struct {
+	// Sign and Verify are applied to the cookie before it's set and after
+	// it's read. Without them, the cookie is signed with a key made up when
+	// the process starts, so messages don't survive a restart and aren't
+	// shared between instances.
+	Sign   func(cookie *http.Cookie)
+	Verify func(cookie *http.Cookie) error
+}
+
+const flashCookie = "_flash"
+
+var (
+	flashKey          = make([]byte, 32)
+	errFlashSignature = errors.New("flash: bad signature")
+)
+
+func init() {
+	if _, err := rand.Read(flashKey); err != nil {
+		panic(err)
+	}
+}
+
+func flashMAC(value string) string {
+	mac := hmac.New(sha256.New, flashKey)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signFlash(cookie *http.Cookie) {
+	cookie.Value += "." + flashMAC(cookie.Value)
+}
+
+func verifyFlash(cookie *http.Cookie) error {
+	i := strings.LastIndexByte(cookie.Value, '.')
+	if i < 0 || !hmac.Equal([]byte(cookie.Value[i+1:]), []byte(flashMAC(cookie.Value[:i]))) {
+		return errFlashSignature
+	}
+	cookie.Value = cookie.Value[:i]
+	return nil
+}
+
+// SaveFlashes sets the cookie, replacing it if it was already set in this
+// response.
+func (s *CookieFlashStore) SaveFlashes(g *Gas, flashes []FlashMessage) error {
+	h := g.Header()
+	var cookies []string
+	for _, c := range h["Set-Cookie"] {
+		if !strings.HasPrefix(c, flashCookie+"=") {
+			cookies = append(cookies, c)
+		}
+	}
+	if len(cookies) > 0 {
+		h["Set-Cookie"] = cookies
+	} else {
+		h.Del("Set-Cookie")
+	}
+
+	cookie := &http.Cookie{
+		Name:     flashCookie,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if len(flashes) == 0 {
+		if _, err := g.Cookie(flashCookie); err != nil {
+			return nil // nothing to clear
+		}
+		cookie.MaxAge = -1
+	} else {
+		b, err := json.Marshal(flashes)
+		if err != nil {
+			return err
+		}
+		cookie.Value = base64.RawURLEncoding.EncodeToString(b)
+		if s.Sign != nil {
+			s.Sign(cookie)
+		} else {
+			signFlash(cookie)
+		}
+	}
+	g.SetCookie(cookie)
+	return nil
+}
+
+// LoadFlashes reads the cookie.
+func (s *CookieFlashStore) LoadFlashes(g *Gas) ([]FlashMessage, error) {
+	cookie, err := g.Cookie(flashCookie)
+	if err != nil || cookie.Value == "" {
+		return nil, nil
+	}
+	verify := s.Verify
+	if verify == nil {
+		verify = verifyFlash
+	}
+	if err := verify(cookie); err != nil {
+		return nil, err
+	}
+	b, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+	var flashes []FlashMessage
+	if err := json.Unmarshal(b, &flashes); err != nil {
+		return nil, err
+	}
+	return flashes, nil
+}
+
+// Flash keeps a message of the given kind to show the user with Flashes on
+// the next page, which is usually after a redirect:
+//
+//	g.Flash("info", "Your post was saved.")
+//	return 303, out.Redirect("/posts")
+//
+// It sets a cookie with the default store, so it has to be called before the
+// response is written.
+func (g *Gas) Flash(kind, msg string) {
+	g.flashes = append(g.flashes, FlashMessage{kind, msg})
+	if err := flashStore.SaveFlashes(g, g.flashes); err != nil {
+		g.Log().Error("flash: saving messages", "err", err)
+	}
+}
+
+// Flashes returns the messages kept with Flash, on an earlier request or this
+// one, and clears them so they're only shown once. Calling it again during the
+// same request returns the same messages. If the stored messages can't be
+// read, e.g. because their signature doesn't match, they're dropped.
+//
+// Templates can use the "flashes" function from package out, which calls it:
+//
+//	{{ range flashes $.G }}<p class="{{ .Kind }}">{{ .Message }}</p>{{ end }}
+func (g *Gas) Flashes() []FlashMessage {
+	if g.flashesShown == nil {
+		saved, err := flashStore.LoadFlashes(g)
+		if err != nil {
+			g.Log().Warn("flash: loading messages", "err", err)
+		}
+		g.flashesShown = append([]FlashMessage{}, saved...)
+	}
+	if len(g.flashes) > 0 || len(g.flashesShown) > 0 {
+		g.flashesShown = append(g.flashesShown, g.flashes...)
+		g.flashes = nil
+		if err := flashStore.SaveFlashes(g, nil); err != nil {
+			g.Log().Error("flash: clearing messages", "err", err)
+		}
+	}
+	return g.flashesShown
+}
@@ -0,0 +1,134 @@
+package gas
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFlash(t *testing.T) {
+	var shown, again []FlashMessage
+	r := New().
+		Post("/save", func(g *Gas) (int, Outputter) {
+			g.Flash("info", "Saved.")
+			g.Flash("warn", "But not published; yet")
+			http.Redirect(g, g.Request, "/", http.StatusSeeOther)
+			return g.Stop()
+		}).
+		Get("/", func(g *Gas) (int, Outputter) {
+			shown, again = g.Flashes(), g.Flashes()
+			return 204, nil
+		}).
+		Get("/now", func(g *Gas) (int, Outputter) {
+			g.Flash("info", "Right away.")
+			shown = g.Flashes()
+			return 204, nil
+		})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/save", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != flashCookie {
+		t.Fatalf("expected one flash cookie, got %v", cookies)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	expected := []FlashMessage{{"info", "Saved."}, {"warn", "But not published; yet"}}
+	if !reflect.DeepEqual(shown, expected) || !reflect.DeepEqual(again, expected) {
+		t.Errorf("got %v and %v, expected %v", shown, again, expected)
+	}
+	if c := w.Result().Cookies(); len(c) != 1 || c[0].MaxAge >= 0 {
+		t.Errorf("expected the cookie to be cleared, got %v", c)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if len(shown) != 0 || len(w.Result().Cookies()) != 0 {
+		t.Errorf("expected no messages or cookies, got %v and %v", shown, w.Result().Cookies())
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/now", nil))
+	if !reflect.DeepEqual(shown, []FlashMessage{{"info", "Right away."}}) {
+		t.Errorf("expected the message set during the request, got %v", shown)
+	}
+	if c := w.Result().Cookies(); len(c) != 0 {
+		t.Errorf("expected a message already shown not to be kept, got %v", c)
+	}
+}
+
+func TestFlashSigned(t *testing.T) {
+	defer UseFlashStore(flashStore)
+	UseFlashStore(&CookieFlashStore{
+		Sign: func(c *http.Cookie) { c.Value += ".sig" },
+		Verify: func(c *http.Cookie) error {
+			if !strings.HasSuffix(c.Value, ".sig") {
+				return errors.New("bad signature")
+			}
+			c.Value = strings.TrimSuffix(c.Value, ".sig")
+			return nil
+		},
+	})
+
+	var shown []FlashMessage
+	r := New().
+		Get("/set", func(g *Gas) (int, Outputter) {
+			g.Flash("info", "hi")
+			return 204, nil
+		}).
+		Get("/", func(g *Gas) (int, Outputter) {
+			shown = g.Flashes()
+			return 204, nil
+		})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/set", nil))
+	cookie := w.Result().Cookies()[0]
+	if !strings.HasSuffix(cookie.Value, ".sig") {
+		t.Errorf("expected the cookie to be signed, got %q", cookie.Value)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookie)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if !reflect.DeepEqual(shown, []FlashMessage{{"info", "hi"}}) {
+		t.Errorf("expected the signed message, got %v", shown)
+	}
+
+	cookie.Value = strings.TrimSuffix(cookie.Value, ".sig")
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookie)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if len(shown) != 0 {
+		t.Errorf("expected a forged message to be dropped, got %v", shown)
+	}
+}
+
+func TestFlashForged(t *testing.T) {
+	var shown []FlashMessage
+	r := New().Get("/", func(g *Gas) (int, Outputter) {
+		shown = g.Flashes()
+		return 204, nil
+	})
+
+	// what would be a valid cookie if it were signed
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: flashCookie, Value: "W3siayI6ImluZm8iLCJtIjoiZm9yZ2VkIn1d"})
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if len(shown) != 0 {
+		t.Errorf("expected an unsigned message to be dropped, got %v", shown)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: flashCookie, Value: "W3siayI6ImluZm8iLCJtIjoiZm9yZ2VkIn1d.AAAA"})
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if len(shown) != 0 {
+		t.Errorf("expected a badly signed message to be dropped, got %v", shown)
+	}
+}
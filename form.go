@@ -0,0 +1,407 @@
+package gas
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errNotStructPointer = errors.New("UnmarshalForm: dst must be a pointer to a struct value")
+	errUnsupportedKind  = "UnmarshalForm: cannot unmarshal form value into field '%s' of type %T"
+
+	// ErrFormTooLarge is returned by UnmarshalForm when the request body or a
+	// file in it is over the limit given by FormMaxSize or FormMaxFileSize.
+	// Handlers can check for it with errors.Is to answer 413 Request Entity
+	// Too Large.
+	ErrFormTooLarge = errors.New("UnmarshalForm: form too large")
+)
+
+// the memory used by ParseMultipartForm before spilling to disk, same as
+// http.Request.FormValue uses
+const defaultMaxMemory = 32 << 20
+
+// A FormOption changes how UnmarshalForm reads the request body. Options only
+// apply if the form hasn't been parsed yet, e.g. by FormValue in middleware,
+// except for FormMaxFileSize, which is checked on binding.
+type FormOption func(*formOptions)
+
+type formOptions struct {
+	maxSize   int64
+	maxFile   int64
+	maxMemory int64
+}
+
+// FormMaxSize limits the whole request body to n bytes.
+func FormMaxSize(n int64) FormOption {
+	return func(o *formOptions) { o.maxSize = n }
+}
+
+// FormMaxFileSize limits each file bound to a field to n bytes.
+func FormMaxFileSize(n int64) FormOption {
+	return func(o *formOptions) { o.maxFile = n }
+}
+
+// FormMemory keeps up to n bytes of a multipart form's files in memory and
+// spools the rest to temporary files, which are removed once the request is
+// done. The default is 32 MB; FormMemory(0) writes every file to disk.
+func FormMemory(n int64) FormOption {
+	return func(o *formOptions) { o.maxMemory = n }
+}
+
+// UnmarshalForm pulls values from a request's form (multipart or query string)
+// and places them into a struct, like encoding/json. It honors
+// encoding.TextUnmarshaler, but the part about copying the bytes is
+// irrelevant.
+//
+// A "form" struct tag can be used to refer to any named field in the form for
+// a given struct field.
+//
+// If the field is a time.Time, it will try to parse it as a UNIX timestamp
+// unless a "timeFormat" tag is present, in which case it will parse the time
+// using that. If the field is a numeric type, an empty string as the field
+// value will become a zero value. If you wish to customize this behavior,
+// either specify the field as a string and parse it yourself, or make a type
+// that satisfies TextUnmarshaler.
+//
+// A struct field (or pointer to one, which is only allocated if the form has
+// values for it) is filled in from the names under its own, written either
+// dotted or bracketed, so that address.city and address[city] both go to the
+// City field of an Address field. A slice field gets every value of a
+// repeated name, e.g. tags=a&tags=b or tags[]=a&tags[]=b.
+//
+// Uploaded files in a multipart form are bound to fields of type
+// *multipart.FileHeader, or []*multipart.FileHeader for all of the files under
+// the name:
+//
+//	var upload struct {
+//		Title  string                  `form:"title"`
+//		Cover  *multipart.FileHeader   `form:"cover"`
+//		Photos []*multipart.FileHeader `form:"photos"`
+//	}
+//	err := g.UnmarshalForm(&upload, gas.FormMaxSize(50<<20), gas.FormMaxFileSize(10<<20))
+//	if errors.Is(err, gas.ErrFormTooLarge) {
+//		return 413, nil
+//	}
+//
+// Once it's filled in, dst is checked with Validate, with fields named as they
+// are in the form.
+func (g *Gas) UnmarshalForm(dst interface{}, opts ...FormOption) error {
+	o := formOptions{maxMemory: defaultMaxMemory}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if g.MultipartForm == nil {
+		if g.Form == nil && o.maxSize > 0 {
+			g.Body = http.MaxBytesReader(g, g.Body, o.maxSize)
+		}
+		err := g.ParseMultipartForm(o.maxMemory)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return fmt.Errorf("%w: body is over %d bytes", ErrFormTooLarge, tooLarge.Limit)
+		}
+		if err != nil && err != http.ErrNotMultipart {
+			return err
+		}
+	}
+
+	u := &unmarshaler{
+		tag:      "form",
+		values:   make(map[string][]string, len(g.Form)),
+		maxFile:  o.maxFile,
+		unescape: true,
+	}
+	for k, v := range g.Form {
+		k = formKey(k)
+		u.values[k] = append(u.values[k], v...)
+	}
+	if g.MultipartForm != nil {
+		u.files = make(map[string][]*multipart.FileHeader, len(g.MultipartForm.File))
+		for k, v := range g.MultipartForm.File {
+			k = formKey(k)
+			u.files[k] = append(u.files[k], v...)
+		}
+	}
+	return u.unmarshal(dst)
+}
+
+// formKey turns the brackets in a form name into dots, e.g. a[b][c] into
+// a.b.c, and drops the empty ones that mark a list, e.g. tags[] into tags.
+func formKey(k string) string {
+	if strings.IndexByte(k, '[') < 0 {
+		return k
+	}
+	var b strings.Builder
+	for k != "" {
+		i := strings.IndexByte(k, '[')
+		j := strings.IndexByte(k, ']')
+		if i < 0 || j < i {
+			b.WriteString(k)
+			break
+		}
+		b.WriteString(k[:i])
+		if j > i+1 {
+			b.WriteString("." + k[i+1:j])
+		}
+		k = k[j+1:]
+	}
+	return b.String()
+}
+
+// UnmarshalArgs places the parameters captured from the URL by the route into
+// a struct, converting them the same way as UnmarshalForm. An "arg" struct tag
+// names the parameter for a field, which defaults to the field's name:
+//
+//	// for /users/{user}/posts/{id:[0-9]+}
+//	var args struct {
+//		User string `arg:"user"`
+//		ID   int64  `arg:"id"`
+//	}
+//	err := g.UnmarshalArgs(&args)
+//
+// Like UnmarshalForm, it checks dst with Validate afterward.
+func (g *Gas) UnmarshalArgs(dst interface{}) error {
+	u := &unmarshaler{tag: "arg", values: make(map[string][]string, len(g.args))}
+	for _, a := range g.args {
+		u.values[a.name] = []string{a.value}
+	}
+	return u.unmarshal(dst)
+}
+
+// An unmarshaler fills in the fields of structs with values by their names,
+// given by the tag or else the field names.
+type unmarshaler struct {
+	tag      string
+	values   map[string][]string
+	files    map[string][]*multipart.FileHeader
+	maxFile  int64 // 0 for no limit
+	unescape bool  // whether strings get URL query unescaped
+}
+
+func (u *unmarshaler) unmarshal(dst interface{}) error {
+	dv := reflect.ValueOf(dst)
+	if dv.Kind() != reflect.Ptr {
+		return errNotStructPointer
+	}
+	dv = dv.Elem()
+	if dv.Kind() != reflect.Struct {
+		return errNotStructPointer
+	}
+	if err := u.unmarshalStruct(dv, ""); err != nil {
+		return err
+	}
+	return validate(dst, u.tag)
+}
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	fileHeaderType      = reflect.TypeOf((*multipart.FileHeader)(nil))
+	fileHeadersType     = reflect.TypeOf([]*multipart.FileHeader(nil))
+)
+
+func (u *unmarshaler) unmarshalStruct(dv reflect.Value, prefix string) error {
+	dt := dv.Type()
+
+	for i := 0; i < dv.NumField(); i++ {
+		field := dv.Field(i)
+		tf := dt.Field(i)
+		if tf.PkgPath != "" {
+			continue // unexported
+		}
+		key := tf.Tag.Get(u.tag)
+		if key == "" {
+			key = tf.Name
+		}
+		key = prefix + key
+
+		if t := field.Type(); t == fileHeaderType || t == fileHeadersType {
+			if err := u.setFiles(field, key); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if nested(field.Type()) {
+			if field.Kind() == reflect.Ptr {
+				if !u.hasPrefix(key + ".") {
+					continue
+				}
+				if field.IsNil() {
+					field.Set(reflect.New(field.Type().Elem()))
+				}
+				field = field.Elem()
+			}
+			if err := u.unmarshalStruct(field, key+"."); err != nil {
+				return err
+			}
+			continue
+		}
+
+		vals := u.values[key]
+		if len(vals) == 0 {
+			continue
+		}
+		if field.Kind() == reflect.Slice && !reflect.PointerTo(field.Type()).Implements(textUnmarshalerType) {
+			slice := reflect.MakeSlice(field.Type(), len(vals), len(vals))
+			for j, val := range vals {
+				if err := u.setValue(slice.Index(j), tf, key, val); err != nil {
+					return err
+				}
+			}
+			field.Set(slice)
+			continue
+		}
+		if len(vals[0]) == 0 {
+			continue
+		}
+		if err := u.setValue(field, tf, key, vals[0]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// whether fields of type t are structs to fill in field by field, rather than
+// from a single value
+func nested(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && t != timeType && !reflect.PointerTo(t).Implements(textUnmarshalerType)
+}
+
+func (u *unmarshaler) hasPrefix(prefix string) bool {
+	for k := range u.values {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	for k := range u.files {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// setFiles sets a *multipart.FileHeader field to the first file named key, or
+// a []*multipart.FileHeader field to all of them.
+func (u *unmarshaler) setFiles(field reflect.Value, key string) error {
+	files := u.files[key]
+	if len(files) == 0 {
+		return nil
+	}
+	for _, fh := range files {
+		if u.maxFile > 0 && fh.Size > u.maxFile {
+			return fmt.Errorf("%w: file '%s' for field '%s' is over %d bytes", ErrFormTooLarge, fh.Filename, key, u.maxFile)
+		}
+	}
+	if field.Type() == fileHeaderType {
+		field.Set(reflect.ValueOf(files[0]))
+	} else {
+		field.Set(reflect.ValueOf(files))
+	}
+	return nil
+}
+
+// setValue converts val for field, described by tf, which has the form name
+// key.
+func (u *unmarshaler) setValue(field reflect.Value, tf reflect.StructField, key, val string) error {
+	if field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			field.Set(reflect.New(field.Type().Elem()))
+		}
+		return u.setValue(field.Elem(), tf, key, val)
+	}
+
+	// handle common non-core types; time.Time is a TextUnmarshaler too, but
+	// only of RFC 3339
+	if field.Type() == timeType {
+		format := tf.Tag.Get("timeFormat")
+		var t time.Time
+		if format == "" {
+			n, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return err
+			}
+			t = time.Unix(n, 0)
+		} else {
+			var err error
+			t, err = time.Parse(format, val)
+			if err != nil {
+				return err
+			}
+		}
+		field.Set(reflect.ValueOf(t))
+		return nil
+	}
+	if u, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(val))
+	}
+
+	// handle core types
+	switch field.Kind() {
+	case reflect.Bool:
+		x, err := strconv.ParseBool(val)
+		if err != nil {
+			if val == "on" {
+				field.SetBool(true)
+				break
+			}
+			return err
+		}
+		field.SetBool(x)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if val == "" {
+			field.SetInt(0)
+		} else {
+			x, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return err
+			}
+			field.SetInt(x)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if val == "" {
+			field.SetUint(0)
+		} else {
+			x, err := strconv.ParseUint(val, 10, 64)
+			if err != nil {
+				return err
+			}
+			field.SetUint(x)
+		}
+	case reflect.Float32, reflect.Float64:
+		if val == "" {
+			field.SetFloat(0.0)
+		} else {
+			x, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				return err
+			}
+			field.SetFloat(x)
+		}
+	case reflect.String:
+		if !u.unescape {
+			field.SetString(val)
+			break
+		}
+		s, err := url.QueryUnescape(val)
+		if err != nil {
+			return err
+		}
+		field.SetString(s)
+	default:
+		return fmt.Errorf(errUnsupportedKind, key, field.Interface())
+	}
+	return nil
+}
@@ -5,36 +5,57 @@ package gas
 // belong in or are too small for their own files
 
 import (
+	"bufio"
+	"context"
+	"crypto/rand"
 	"crypto/tls"
-	"encoding"
-	"errors"
+	"crypto/x509"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"mime"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
-	"os/signal"
 	"path"
-	"reflect"
 	"sort"
 	"strconv"
 	"strings"
-	"time"
+	"sync"
 	"unicode"
 )
 
 var (
 	sigchan = make(chan os.Signal, 2)
+
+	// envErr is an error from reading the environment in init, which is
+	// returned from Ignition
+	envErr error
 )
 
 func init() {
 	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
-	signal.Notify(sigchan)
 
-	if err := EnvConf(&Env, EnvPrefix); err != nil {
-		log.Fatalf("envconf: %v", err)
+	if envErr = EnvConf(&Env, EnvPrefix); envErr != nil {
+		Logger().Error("envconf", "err", envErr)
+	}
+	if err := setupLogger(); err != nil {
+		Logger().Error("envconf", "err", err)
+		if envErr == nil {
+			envErr = err
+		}
+	}
+	if _, ok := profiles[strings.ToLower(Env.Env)]; !ok {
+		Logger().Warn("envconf: unknown profile, using dev", "var", EnvPrefix+"ENV", "value", Env.Env)
+	}
+	if p := strings.ToLower(Env.PanicPage); p != "" && p != "debug" && p != "plain" {
+		Logger().Warn("envconf: unknown panic page, using plain", "var", EnvPrefix+"PANIC_PAGE", "value", Env.PanicPage)
+	}
+	if _, ok := sameSiteModes[strings.ToLower(Env.CookieSameSite)]; !ok {
+		Logger().Warn("envconf: unknown SameSite mode, ignoring it", "var", EnvPrefix+"COOKIE_SAME_SITE", "value", Env.CookieSameSite)
 	}
 }
 
@@ -42,17 +63,62 @@ func init() {
 // into a *Gas and passed to handler functions. Comes with embedded standard
 // net/http arguments as well as the captured URL variables (if any), and has
 // some convenience methods attached.
+//
+// Once a request has been answered, its *Gas is reset and used again for a
+// later one, so handlers mustn't hold on to it, e.g. in a goroutine that
+// outlives them. Copy out whatever is needed first.
 type Gas struct {
 	w http.ResponseWriter
 	*http.Request
-	args map[string]string      // named url args
+	args []arg                  // named url args
 	data map[string]interface{} // arbitrary data
 
-	responseCode int // the response code that will be/has been written
+	responseCode int   // the response code that will be/has been written
+	written      int64 // bytes of the response body written so far
+	err          error // see SetError
+
+	id      string // see RequestID
+	pattern string // of the matched route, if any
+
+	flashes      []FlashMessage // set with Flash, not shown yet
+	flashesShown []FlashMessage // returned by Flashes, once it's been called
 
 	// the handler chain, first element is always the next one to execute (not
 	// guaranteed to be nonzero length)
 	handlers []Handler
+
+	// set if something might still be using g or its data after the
+	// request is done, such as handlers that timed out, so it can't be
+	// reused
+	retained bool
+}
+
+var gasPool = sync.Pool{New: func() interface{} { return new(Gas) }}
+
+// newGas gets a Gas for a request from the pool.
+func newGas(w http.ResponseWriter, req *http.Request) *Gas {
+	g := gasPool.Get().(*Gas)
+	g.w, g.Request, g.id = w, req, requestID(req)
+	return g
+}
+
+// release resets g and puts it back in the pool, keeping its data map to save
+// making a new one next time. A retained Gas is left out of the pool, map and
+// all, since whatever still has it might be using them.
+func (g *Gas) release() {
+	if g.retained {
+		g.data = nil
+		return
+	}
+	// the server only cleans up after the request it passed in, which isn't
+	// this one if the context was changed
+	if g.Request != nil && g.MultipartForm != nil {
+		g.MultipartForm.RemoveAll()
+	}
+	data := g.data
+	clear(data)
+	*g = Gas{data: data}
+	gasPool.Put(g)
 }
 
 func (g *Gas) Write(p []byte) (int, error) {
@@ -60,7 +126,9 @@ func (g *Gas) Write(p []byte) (int, error) {
 		g.responseCode = 200
 	}
 
-	return g.w.Write(p)
+	n, err := g.w.Write(p)
+	g.written += int64(n)
+	return n, err
 }
 
 // WriteHeader and Header implement the http.ResponseWriter interface.
@@ -74,10 +142,80 @@ func (g *Gas) Header() http.Header {
 	return g.w.Header()
 }
 
+// Flush sends any buffered response data to the client, if the underlying
+// ResponseWriter can.
+func (g *Gas) Flush() {
+	if g.responseCode == 0 {
+		g.responseCode = 200
+	}
+	http.NewResponseController(g.w).Flush()
+}
+
+// ReadFrom copies r to the response, using the underlying ResponseWriter's
+// ReadFrom if it has one, so that io.Copy from a file can use sendfile.
+func (g *Gas) ReadFrom(r io.Reader) (int64, error) {
+	if g.responseCode == 0 {
+		g.responseCode = 200
+	}
+
+	var (
+		n   int64
+		err error
+	)
+	if rf, ok := g.w.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		// hide g's ReadFrom so io.Copy doesn't come back here
+		n, err = io.Copy(struct{ io.Writer }{g.w}, r)
+	}
+	g.written += n
+	return n, err
+}
+
+// Hijack lets the caller take over the connection, e.g. for a WebSocket. The
+// response is recorded as 101 Switching Protocols, since anything else will be
+// written straight to the connection.
+func (g *Gas) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(g.w).Hijack()
+	if err == nil {
+		g.responseCode = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the ResponseWriter under g, for http.ResponseController.
+func (g *Gas) Unwrap() http.ResponseWriter {
+	return g.w
+}
+
+// Push starts an HTTP/2 server push of target, so that the client has it by
+// the time it sees the link to it in the response. It does nothing if the
+// connection doesn't support push, such as one over HTTP/1.1 or from a client
+// that turned it off, so it's always safe to call.
+func (g *Gas) Push(target string, opts *http.PushOptions) error {
+	w := g.w
+	for {
+		if p, ok := w.(http.Pusher); ok {
+			err := p.Push(target, opts)
+			if err == http.ErrNotSupported {
+				return nil
+			}
+			return err
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return nil
+		}
+		w = u.Unwrap()
+	}
+}
+
 // Arg returns the URL parameter named by key
 func (g *Gas) Arg(key string) string {
-	if g.args != nil {
-		return g.args[key]
+	for _, a := range g.args {
+		if a.name == key {
+			return a.value
+		}
 	}
 	return ""
 }
@@ -105,6 +243,113 @@ func (g *Gas) Data(key string) interface{} {
 	return nil
 }
 
+// the Data key holding the name given to SetUser
+const userKey = "_gas_user"
+
+// SetUser records who is making the request, so that it can be included in
+// the events and logs about it. Authentication middleware is expected to call
+// it once it knows.
+func (g *Gas) SetUser(name string) {
+	g.SetData(userKey, name)
+}
+
+// User returns the name given to SetUser, or "" if it hasn't been called.
+func (g *Gas) User() string {
+	name, _ := g.Data(userKey).(string)
+	return name
+}
+
+// Context returns the request's context. It's cancelled when the client goes
+// away or the route's timeout (see Router.Timeout) runs out, so it should be
+// passed on to anything slow that a handler calls, such as db.QueryContext.
+// Once it's cancelled, Continue stops running the handler chain and templates
+// from package out stop rendering.
+func (g *Gas) Context() context.Context {
+	return g.Request.Context()
+}
+
+// SetContext replaces the request's context with ctx, which should be derived
+// from g.Context(), for the rest of the handler chain. This is how middleware
+// attaches values or a tighter deadline for the handlers after it:
+//
+//	ctx, cancel := context.WithTimeout(g.Context(), time.Second)
+//	defer cancel()
+//	g.SetContext(ctx)
+//	return g.Continue()
+func (g *Gas) SetContext(ctx context.Context) {
+	g.Request = g.Request.WithContext(ctx)
+}
+
+// SetError records the error behind a failed response, so that it's included
+// in the ServerError event published if the status is 5xx:
+//
+//	if err := save(post); err != nil {
+//		g.SetError(err)
+//		return 500, nil
+//	}
+func (g *Gas) SetError(err error) {
+	g.err = err
+}
+
+// RequestID returns the identifier of the request, which is sent back in the
+// X-Request-ID header and included in its logs. It's taken from the request's
+// own X-Request-ID header if it has one, e.g. from a load balancer, so that
+// logs can be followed across services, and made up otherwise.
+func (g *Gas) RequestID() string {
+	return g.id
+}
+
+// Route returns the pattern of the route the request matched, e.g.
+// "/users/{id}", or "" if none did.
+func (g *Gas) Route() string {
+	return g.pattern
+}
+
+// ClientCert returns the certificate the client authenticated itself with, if
+// it sent one over TLS that passed verification against GAS_TLS_CLIENT_CA, or
+// nil otherwise.
+func (g *Gas) ClientCert() *x509.Certificate {
+	if g.Request.TLS == nil || len(g.Request.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+	return g.Request.TLS.VerifiedChains[0][0]
+}
+
+// Log returns Logger with the request ID, route, client IP, and user (if
+// they're known yet) attached, so that logs from handlers can be matched up
+// with the access log entry for the request.
+func (g *Gas) Log() *slog.Logger {
+	args := []interface{}{"request_id", g.id}
+	if g.pattern != "" {
+		args = append(args, "route", g.pattern)
+	}
+	args = append(args, "client", g.ClientIP())
+	if user := g.User(); user != "" {
+		args = append(args, "user", user)
+	}
+	return Logger().With(args...)
+}
+
+// the longest X-Request-ID accepted from a client
+const maxRequestIDLen = 128
+
+// requestID uses the X-Request-ID passed by the client, if it's reasonable, or
+// makes up a new one.
+func requestID(req *http.Request) string {
+	if id := req.Header.Get("X-Request-ID"); id != "" && len(id) <= maxRequestIDLen {
+		ok := true
+		for i := 0; i < len(id) && ok; i++ {
+			ok = id[i] > ' ' && id[i] < 0x7f
+		}
+		if ok {
+			return id
+		}
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
 // SetFilename adds a Content-Disposition header to the response instructing
 // the browser to use the given filename for the resource.
 func (g *Gas) SetFilename(filename string) {
@@ -113,14 +358,36 @@ func (g *Gas) SetFilename(filename string) {
 	g.Header().Add("Content-Disposition", disposition)
 }
 
-// SetCookie sets a cookie in the response, adding an HMAC digest to the end of
-// the value if that's enabled. If value isn't nil, it'll be interpreted as the
-// value destined for the cookie, the sum calculated off of it, and whatever
-// value the Cookie has already will be replaced.
+// SetCookie sets a cookie in the response. The Secure, SameSite, Domain, and
+// Path attributes default to Env.CookieSecure, Env.CookieSameSite,
+// Env.CookieDomain, and Env.CookiePath where the cookie leaves them unset.
 func (g *Gas) SetCookie(cookie *http.Cookie) {
+	if Env.CookieSecure || Env.CookieSameSite != "" || Env.CookieDomain != "" || Env.CookiePath != "" {
+		c := *cookie
+		if Env.CookieSecure {
+			c.Secure = true
+		}
+		if c.SameSite == 0 {
+			c.SameSite = sameSiteModes[strings.ToLower(Env.CookieSameSite)]
+		}
+		if c.Domain == "" {
+			c.Domain = Env.CookieDomain
+		}
+		if c.Path == "" {
+			c.Path = Env.CookiePath
+		}
+		cookie = &c
+	}
 	http.SetCookie(g, cookie)
 }
 
+var sameSiteModes = map[string]http.SameSite{
+	"":       0,
+	"lax":    http.SameSiteLaxMode,
+	"strict": http.SameSiteStrictMode,
+	"none":   http.SameSiteNoneMode,
+}
+
 // AcceptHeader is an accepted media type with associated q-value.
 type AcceptHeader struct {
 	Type string
@@ -187,7 +454,7 @@ func (g *Gas) Wants() string {
 
 	a, err := ParseAcceptHeader(accept)
 	if err != nil {
-		log.Print(err)
+		Logger().Debug("bad Accept header", "header", accept, "err", err)
 	}
 	return a[0].Type
 }
@@ -269,35 +536,20 @@ func Hook(sig os.Signal, f func()) {
 	signalFuncs[sig] = append(sigs, f)
 }
 
-func handleSignals(c chan os.Signal) {
+func handleSignals(c chan os.Signal, done chan struct{}) {
 	for {
-		if funcs := signalFuncs[<-c]; funcs != nil {
-			for _, f := range funcs {
+		select {
+		case sig := <-c:
+			for _, f := range signalFuncs[sig] {
 				f()
 			}
+		case <-done:
+			return
 		}
 	}
 }
 
-func tlsConfig(certPath, keyPath, hostName string) (*tls.Config, error) {
-	cfg := &tls.Config{}
-
-	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
-	if err != nil {
-		return nil, err
-	}
-	cfg.Certificates = []tls.Certificate{cert}
-	cfg.ServerName = hostName
-	cfg.BuildNameToCertificate()
-
-	if cfg.NextProtos == nil {
-		cfg.NextProtos = []string{"h2", "http/1.1"}
-	}
-
-	return cfg, nil
-}
-
-func listenTLS(srv *http.Server, c chan error, quit chan struct{}) {
+func listenTLS(ctx context.Context, srv *http.Server, c chan error) {
 	var (
 		cfg *tls.Config
 		err error
@@ -305,8 +557,12 @@ func listenTLS(srv *http.Server, c chan error, quit chan struct{}) {
 
 	if srv.TLSConfig == nil {
 		cfg, err = tlsConfig(Env.TLSCert, Env.TLSKey, Env.TLSHost)
+		if err == nil {
+			err = rotateTicketKeys(ctx, cfg)
+		}
 		if err != nil {
-			c <- err
+			c <- fmt.Errorf("tls: %v", err)
+			return
 		}
 		srv.TLSConfig = cfg
 	} else {
@@ -316,40 +572,47 @@ func listenTLS(srv *http.Server, c chan error, quit chan struct{}) {
 	l, err := net.Listen("tcp", ":"+strconv.Itoa(Env.TLSPort))
 	if err != nil {
 		c <- err
+		return
 	}
 
 	t := tls.NewListener(l, cfg)
-	log.Printf("Server listening on port %d (TLS)", Env.TLSPort)
+	Logger().Info("serving", "port", Env.TLSPort, "tls", true)
 
-	select {
-	case c <- srv.Serve(t):
-	case <-quit:
-		l.Close()
+	if err = srv.Serve(t); err != http.ErrServerClosed {
+		c <- err
 	}
 }
 
-func listen(srv *http.Server, c chan error, quit chan struct{}) {
+func listen(srv *http.Server, c chan error) {
 	l, err := net.Listen("tcp", ":"+strconv.Itoa(Env.Port))
 	if err != nil {
 		c <- err
+		return
 	}
-	log.Printf("Server listening on port %d", Env.Port)
+	Logger().Info("serving", "port", Env.Port)
 
-	select {
-	case c <- srv.Serve(l):
-	case <-quit:
-		l.Close()
+	if err = srv.Serve(l); err != http.ErrServerClosed {
+		c <- err
 	}
 }
 
-var initFuncs []func()
+var initFuncs []func() error
 
 // Init adds a func to the list of funcs to run before server is launched.
 // They are run in the order that they are added.
 func Init(funcs ...func()) {
-	if initFuncs == nil {
-		initFuncs = make([]func(), 0, len(funcs))
+	for _, f := range funcs {
+		f := f
+		initFuncs = append(initFuncs, func() error {
+			f()
+			return nil
+		})
 	}
+}
+
+// InitErr is like Init, but if one of the funcs returns an error the rest
+// aren't run and Ignition returns that error without starting the server.
+func InitErr(funcs ...func() error) {
 	initFuncs = append(initFuncs, funcs...)
 }
 
@@ -398,131 +661,3 @@ func exit(code int) {
 	}
 	os.Exit(code)
 }
-
-var (
-	errNotStructPointer = errors.New("UnmarshalForm: dst must be a pointer to a struct value")
-	errUnsupportedKind  = "UnmarshalForm: cannot unmarshal form value into field '%s' of type %T"
-)
-
-// UnmarshalForm pulls values from a request's form (multipart or query string)
-// and places them into a struct, like encoding/json. It honors
-// encoding.TextUnmarshaler, but the part about copying the bytes is
-// irrelevant.
-//
-// A "form" struct tag can be used to refer to any named field in the form for
-// a given struct field.
-//
-// If the field is a time.Time, it will try to parse it as a UNIX timestamp
-// unless a "timeFormat" tag is present, in which case it will parse the time
-// using that. If the field is a numeric type, an empty string as the field
-// value will become a zero value. If you wish to customize this behavior,
-// either specify the field as a string and parse it yourself, or make a type
-// that satisfies TextUnmarshaler.
-func (g *Gas) UnmarshalForm(dst interface{}) error {
-	dv := reflect.ValueOf(dst)
-	if dv.Kind() != reflect.Ptr {
-		return errNotStructPointer
-	}
-	dv = dv.Elem()
-	if dv.Kind() != reflect.Struct {
-		return errNotStructPointer
-	}
-	dt := dv.Type()
-
-	for i := 0; i < dv.NumField(); i++ {
-		field := dv.Field(i)
-		tf := dt.Field(i)
-		key := tf.Tag.Get("form")
-		if key == "" {
-			key = tf.Name
-		}
-		val := g.FormValue(key)
-		if len(val) == 0 {
-			continue
-		}
-
-		// handle common non-core types
-		fi := field.Interface()
-		switch v := fi.(type) {
-		case encoding.TextUnmarshaler:
-			err := v.UnmarshalText([]byte(val))
-			if err != nil {
-				return err
-			}
-			continue
-		case time.Time:
-			format := tf.Tag.Get("timeFormat")
-			var t time.Time
-			if format == "" {
-				n, err := strconv.ParseInt(val, 10, 64)
-				if err != nil {
-					return err
-				}
-				t = time.Unix(n, 0)
-			} else {
-				var err error
-				t, err = time.Parse(format, val)
-				if err != nil {
-					return err
-				}
-			}
-			field.Set(reflect.ValueOf(t))
-			continue
-		}
-
-		// handle core types
-		switch field.Kind() {
-		case reflect.Bool:
-			x, err := strconv.ParseBool(val)
-			if err != nil {
-				if val == "on" {
-					field.SetBool(true)
-					break
-				}
-				return err
-			}
-			field.SetBool(x)
-		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-			if val == "" {
-				field.SetInt(0)
-			} else {
-				x, err := strconv.ParseInt(val, 10, 64)
-				if err != nil {
-					return err
-				}
-				field.SetInt(x)
-			}
-		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-			if val == "" {
-				field.SetUint(0)
-			} else {
-				x, err := strconv.ParseUint(val, 10, 64)
-				if err != nil {
-					return err
-				}
-				field.SetUint(x)
-			}
-		case reflect.Float32, reflect.Float64:
-			if val == "" {
-				field.SetFloat(0.0)
-			} else {
-				x, err := strconv.ParseFloat(val, 64)
-				if err != nil {
-					return err
-				}
-				field.SetFloat(x)
-			}
-		case reflect.String:
-			s, err := url.QueryUnescape(val)
-			if err != nil {
-				return err
-			}
-			field.SetString(s)
-		//case reflect.Slice: // byte slice
-		default:
-			return fmt.Errorf(errUnsupportedKind, key, fi)
-		}
-	}
-
-	return nil
-}
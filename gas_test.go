@@ -1,12 +1,23 @@
 package gas
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -60,27 +71,218 @@ type unmarshalFormTest struct {
 }
 
 func TestUnmarshalForm(t *testing.T) {
-	now := time.Now()
+	now := time.Now().Truncate(time.Second)
 	now1123 := url.QueryEscape(now.Format(time.RFC1123))
 	nowUnix := url.QueryEscape(strconv.FormatInt(now.Unix(), 10))
 
-	expected := unmarshalFormTest{42, "asdf", now, 3.1415, now, 0, true, &T{"ayy lmao"}}
+	expected := unmarshalFormTest{42, "asdf", time.Time{}, 3.1415, time.Time{}, 0, true, &T{"ayy lmao"}}
 
 	r := New().Get("/", func(g *Gas) (int, Outputter) {
 		var v unmarshalFormTest
 		if err := g.UnmarshalForm(&v); err != nil {
-			t.Fatal(err)
+			t.Error(err)
+			return g.Stop()
+		}
+		// the times come back in whatever location they were parsed in
+		if !v.Time.Equal(now) || !v.Time2.Equal(now) {
+			t.Errorf("got times %v and %v, expected %v", v.Time, v.Time2, now)
 		}
+		v.Time, v.Time2 = time.Time{}, time.Time{}
 		if !reflect.DeepEqual(v, expected) {
-			t.Fatalf("got: %#v, expected: %#v", v, expected)
+			t.Errorf("got: %#v, expected: %#v", v, expected)
 		}
 		return g.Stop()
 	})
 
-	srv := httptest.NewServer(r)
-	defer srv.Close()
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?Int=42&String=asdf&Time="+nowUnix+"&f=3.1415&t="+now1123+"&Bool=1&T=ayy", nil))
+}
+
+func TestUnmarshalFormNested(t *testing.T) {
+	type address struct {
+		Street string `form:"street"`
+		City   string `form:"city"`
+	}
+	type profile struct {
+		Name    string   `form:"name"`
+		Tags    []string `form:"tags"`
+		IDs     []int    `form:"ids"`
+		Home    address  `form:"home"`
+		Work    *address `form:"work"`
+		Billing *address `form:"billing"`
+		Spouse  *string  `form:"spouse"`
+		Addr    net.IP   `form:"addr"`
+		Peers   []net.IP `form:"peers"`
+	}
+	var got profile
+	r := New().Post("/", func(g *Gas) (int, Outputter) {
+		if err := g.UnmarshalForm(&got); err != nil {
+			t.Error(err)
+		}
+		return g.Stop()
+	})
+
+	form := url.Values{
+		"name":        {"alice"},
+		"tags":        {"a", "b"},
+		"ids[]":       {"1", "2", "3"},
+		"home.street": {"1 Main St"},
+		"home[city]":  {"Springfield"},
+		"work[city]":  {"Shelbyville"},
+		"addr":        {"192.0.2.1"},
+		"peers":       {"192.0.2.2", "2001:db8::1"},
+	}
+	req := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	expected := profile{
+		Name:  "alice",
+		Tags:  []string{"a", "b"},
+		IDs:   []int{1, 2, 3},
+		Home:  address{"1 Main St", "Springfield"},
+		Work:  &address{City: "Shelbyville"},
+		Addr:  net.ParseIP("192.0.2.1"),
+		Peers: []net.IP{net.ParseIP("192.0.2.2"), net.ParseIP("2001:db8::1")},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %+v, expected %+v", got, expected)
+	}
+
+	for _, test := range []struct{ in, out string }{
+		{"a", "a"},
+		{"a[b]", "a.b"},
+		{"a[b][c]", "a.b.c"},
+		{"tags[]", "tags"},
+		{"a[b][]", "a.b"},
+		{"a[", "a["},
+	} {
+		if s := formKey(test.in); s != test.out {
+			t.Errorf("formKey(%q): expected %q, got %q", test.in, test.out, s)
+		}
+	}
+}
+
+func multipartRequest(t *testing.T, fields map[string]string, files map[string][]string) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	for k, contents := range files {
+		for i, content := range contents {
+			fw, err := mw.CreateFormFile(k, k+strconv.Itoa(i)+".txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(fw, content)
+		}
+	}
+	mw.Close()
+	req := httptest.NewRequest("POST", "/", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestUnmarshalFormFiles(t *testing.T) {
+	type upload struct {
+		Title  string                  `form:"title"`
+		Cover  *multipart.FileHeader   `form:"cover"`
+		Photos []*multipart.FileHeader `form:"photos"`
+		None   *multipart.FileHeader   `form:"none"`
+	}
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	var (
+		got     upload
+		err     error
+		content []string
+		spooled bool
+	)
+	opts := []FormOption{}
+	r := New().Post("/", func(g *Gas) (int, Outputter) {
+		got, content = upload{}, nil
+		if err = g.UnmarshalForm(&got, opts...); err != nil {
+			return g.Stop()
+		}
+		entries, _ := os.ReadDir(tmp)
+		spooled = len(entries) > 0
+		for _, fh := range append([]*multipart.FileHeader{got.Cover}, got.Photos...) {
+			f, err := fh.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := io.ReadAll(f)
+			f.Close()
+			content = append(content, string(b))
+		}
+		return g.Stop()
+	})
+
+	fields := map[string]string{"title": "trip"}
+	files := map[string][]string{"cover": {"front"}, "photos": {"one", "two"}}
+	r.ServeHTTP(httptest.NewRecorder(), multipartRequest(t, fields, files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "trip" || got.None != nil || len(got.Photos) != 2 {
+		t.Errorf("got %+v", got)
+	}
+	if !reflect.DeepEqual(content, []string{"front", "one", "two"}) {
+		t.Errorf("expected the files' contents, got %q", content)
+	}
+	if spooled {
+		t.Error("expected small files to be kept in memory")
+	}
 
-	http.Get(srv.URL + "?Int=42&String=asdf&Time=" + nowUnix + "&f=3.1415&t=" + now1123 + "&Bool=1&T=ayy")
+	opts = []FormOption{FormMemory(0)}
+	r.ServeHTTP(httptest.NewRecorder(), multipartRequest(t, fields, files))
+	if err != nil || !spooled {
+		t.Errorf("expected files to be spooled to disk (%v)", err)
+	}
+	if entries, _ := os.ReadDir(tmp); len(entries) > 0 {
+		t.Errorf("expected temporary files to be removed, found %d", len(entries))
+	}
+
+	opts = []FormOption{FormMaxFileSize(4)}
+	r.ServeHTTP(httptest.NewRecorder(), multipartRequest(t, fields, files))
+	if !errors.Is(err, ErrFormTooLarge) {
+		t.Errorf("expected %v for a 5 byte file, got %v", ErrFormTooLarge, err)
+	}
+
+	opts = []FormOption{FormMaxSize(64)}
+	r.ServeHTTP(httptest.NewRecorder(), multipartRequest(t, fields, files))
+	if !errors.Is(err, ErrFormTooLarge) {
+		t.Errorf("expected %v for a large body, got %v", ErrFormTooLarge, err)
+	}
+}
+
+func TestUnmarshalArgs(t *testing.T) {
+	type args struct {
+		User  string `arg:"user"`
+		ID    int64  `arg:"id"`
+		Draft bool
+		Path  string `arg:"path"`
+	}
+	var got args
+	r := New().Get("/users/{user}/posts/{id:[0-9]+}/{Draft}/{path...}", func(g *Gas) (int, Outputter) {
+		if err := g.UnmarshalArgs(&got); err != nil {
+			t.Error(err)
+		}
+		return g.Stop()
+	}).Get("/bad/{id}", func(g *Gas) (int, Outputter) {
+		var v args
+		if err := g.UnmarshalArgs(&v); err == nil {
+			t.Error("expected an error for a non-numeric id")
+		}
+		return g.Stop()
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/a+b/posts/12/true/x/100%25.txt", nil))
+	expected := args{"a+b", 12, true, "x/100%.txt"}
+	if got != expected {
+		t.Errorf("got %+v, expected %+v", got, expected)
+	}
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/bad/x", nil))
 }
 
 func TestUserAgents(t *testing.T) {
@@ -128,3 +330,265 @@ func TestUserAgents(t *testing.T) {
 		}
 	}
 }
+
+func TestProfile(t *testing.T) {
+	defer func(env string) { Env.Env = env }(Env.Env)
+
+	r := New().Get("/panic", func(g *Gas) (int, Outputter) {
+		panic("lol")
+	})
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	Env.Env = "prod"
+	if !IsProd() || IsDev() {
+		t.Fatalf("expected prod profile, got %q", CurrentProfile().Name)
+	}
+	testutil.TestGet(t, srv, "/panic", "500 internal server error\n")
+
+	resp, err := testutil.Client.Get(srv.URL + "/panic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if h := resp.Header.Get("X-Content-Type-Options"); h != "nosniff" {
+		t.Errorf("expected strict headers in prod, got X-Content-Type-Options %q", h)
+	}
+
+	Env.Env = "nonsense"
+	if !IsDev() {
+		t.Errorf("expected unknown profile to fall back to dev, got %q", CurrentProfile().Name)
+	}
+}
+
+func TestRequestLog(t *testing.T) {
+	saved := Logger()
+	defer SetLogger(saved)
+	buf := new(bytes.Buffer)
+	var mu sync.Mutex
+	SetLogger(slog.New(slog.NewJSONHandler(lockedWriter{&mu, buf}, nil)))
+	Env.TrustedProxies = "loopback, 10.0.0.0/8"
+	defer func() { Env.TrustedProxies = "" }()
+
+	r := New().Get("/users/{id}", func(g *Gas) (int, Outputter) {
+		g.SetUser("moshee")
+		g.Log().Info("handler")
+		return 204, nil
+	})
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL+"/users/1", nil)
+	req.Header.Set("X-Request-ID", "abc123")
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+	resp, err := testutil.Client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if id := resp.Header.Get("X-Request-ID"); id != "abc123" {
+		t.Errorf("X-Request-ID: got %q, expected the client's", id)
+	}
+
+	mu.Lock()
+	dec := json.NewDecoder(bytes.NewReader(buf.Bytes()))
+	mu.Unlock()
+	for _, msg := range []string{"handler", "request"} {
+		var rec map[string]interface{}
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatal(err)
+		}
+		expected := map[string]interface{}{
+			"msg":        msg,
+			"request_id": "abc123",
+			"route":      "/users/{id}",
+			"client":     "203.0.113.7",
+			"user":       "moshee",
+		}
+		for k, v := range expected {
+			if rec[k] != v {
+				t.Errorf("%s: %s: got %v, expected %v", msg, k, rec[k], v)
+			}
+		}
+	}
+
+	resp, err = testutil.Client.Get(srv.URL + "/users/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if id := resp.Header.Get("X-Request-ID"); len(id) != 16 {
+		t.Errorf("expected a generated X-Request-ID, got %q", id)
+	}
+}
+
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (w lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+type pushRecorder struct {
+	*httptest.ResponseRecorder
+	pushed []string
+}
+
+func (w *pushRecorder) Push(target string, opts *http.PushOptions) error {
+	w.pushed = append(w.pushed, target)
+	return nil
+}
+
+func TestPush(t *testing.T) {
+	r := New().Get("/", func(g *Gas) (int, Outputter) {
+		if err := g.Push("/site.css", nil); err != nil {
+			t.Error(err)
+		}
+		return 204, nil
+	})
+
+	w := &pushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if !reflect.DeepEqual(w.pushed, []string{"/site.css"}) {
+		t.Errorf("expected /site.css to be pushed, got %v", w.pushed)
+	}
+
+	// no pusher to be found; nothing should happen
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
+
+var _ interface {
+	http.Flusher
+	http.Hijacker
+	io.ReaderFrom
+} = (*Gas)(nil)
+
+type readFromRecorder struct {
+	*httptest.ResponseRecorder
+	used bool
+}
+
+func (w *readFromRecorder) ReadFrom(r io.Reader) (int64, error) {
+	w.used = true
+	return io.Copy(w.ResponseRecorder, r)
+}
+
+func TestReadFrom(t *testing.T) {
+	var written int64
+	r := New().Get("/", func(g *Gas) (int, Outputter) {
+		// hide WriteTo, which io.Copy would use first
+		io.Copy(g, struct{ io.Reader }{strings.NewReader("hello")})
+		written = g.written
+		return g.Stop()
+	})
+
+	w := &readFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if !w.used || w.Body.String() != "hello" || written != 5 {
+		t.Errorf("expected the writer's ReadFrom to copy 5 bytes, got %v %q %d", w.used, w.Body.String(), written)
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Body.String() != "hello" || written != 5 {
+		t.Errorf("expected 5 bytes to be copied, got %q %d", rec.Body.String(), written)
+	}
+}
+
+func TestContext(t *testing.T) {
+	type key struct{}
+	ran := false
+	r := New().Get("/", func(g *Gas) (int, Outputter) {
+		g.SetContext(context.WithValue(g.Context(), key{}, "value"))
+		return g.Continue()
+	}, func(g *Gas) (int, Outputter) {
+		g.Write([]byte(g.Context().Value(key{}).(string)))
+		return g.Stop()
+	}).Get("/cancelled", func(g *Gas) (int, Outputter) {
+		ctx, cancel := context.WithCancel(g.Context())
+		cancel()
+		g.SetContext(ctx)
+		return g.Continue()
+	}, func(g *Gas) (int, Outputter) {
+		ran = true
+		return 204, nil
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Body.String() != "value" {
+		t.Errorf("expected the context value to be passed down, got %q", w.Body)
+	}
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/cancelled", nil))
+	if ran {
+		t.Error("expected the chain to stop once the context was cancelled")
+	}
+}
+
+func TestGasReuse(t *testing.T) {
+	r := New().Get("/{id}", func(g *Gas) (int, Outputter) {
+		if g.Data("seen") != nil || g.User() != "" {
+			t.Errorf("%s: got data left over from another request", g.URL.Path)
+		}
+		g.SetData("seen", true)
+		g.SetUser(g.Arg("id"))
+		return 204, nil
+	})
+	for i := 0; i < 10; i++ {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/"+strconv.Itoa(i), nil))
+	}
+}
+
+func TestGasRetained(t *testing.T) {
+	g := newGas(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	g.SetData("mine", true)
+	data := g.data
+	g.retained = true
+	g.release()
+
+	for i := 0; i < 10; i++ {
+		g := newGas(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		if g.data != nil && reflect.ValueOf(g.data).UnsafePointer() == reflect.ValueOf(data).UnsafePointer() {
+			t.Fatal("expected a retained Gas's data not to be reused")
+		}
+		defer g.release()
+	}
+	if data["mine"] != true {
+		t.Error("expected a retained Gas's data to be left alone")
+	}
+}
+
+func TestSetCookieDefaults(t *testing.T) {
+	defer func() {
+		Env.CookieSecure, Env.CookieSameSite, Env.CookieDomain, Env.CookiePath = false, "", "", ""
+	}()
+	set := func(c *http.Cookie) string {
+		w := httptest.NewRecorder()
+		g := newGas(w, httptest.NewRequest("GET", "/", nil))
+		defer g.release()
+		g.SetCookie(c)
+		return w.Header().Get("Set-Cookie")
+	}
+
+	if s := set(&http.Cookie{Name: "a", Value: "1"}); s != "a=1" {
+		t.Errorf("expected no attributes by default, got %q", s)
+	}
+
+	Env.CookieSecure, Env.CookieSameSite, Env.CookieDomain, Env.CookiePath = true, "Strict", "example.com", "/app"
+	c := &http.Cookie{Name: "a", Value: "1"}
+	if s := set(c); s != "a=1; Path=/app; Domain=example.com; Secure; SameSite=Strict" {
+		t.Errorf("expected the defaults, got %q", s)
+	}
+	if c.Secure || c.Path != "" {
+		t.Error("expected the cookie passed in to be left alone")
+	}
+	s := set(&http.Cookie{Name: "a", Value: "1", Path: "/", Domain: "other.example", SameSite: http.SameSiteLaxMode})
+	if s != "a=1; Path=/; Domain=other.example; Secure; SameSite=Lax" {
+		t.Errorf("expected the cookie's own attributes to win, got %q", s)
+	}
+}
@@ -1,14 +1,24 @@
 module ktkr.us/pkg/gas
 
-go 1.20
+go 1.21
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/lib/pq v1.10.9
 	github.com/pkg/errors v0.9.1
+	github.com/quic-go/quic-go v0.40.1
 	github.com/russross/blackfriday/v2 v2.1.0
 	golang.org/x/crypto v0.9.0
+	golang.org/x/net v0.10.0
 	golang.org/x/sys v0.8.0
 	ktkr.us/pkg/fmtutil v0.1.0
 	ktkr.us/pkg/logrotate v0.0.0-20170604170740-8e2cddb212b1
+	gopkg.in/yaml.v3 v3.0.1
 	ktkr.us/pkg/vfs v0.1.0
 )
+
+require (
+	github.com/quic-go/qpack v0.4.0 // indirect
+	golang.org/x/exp v0.0.0-20221205204356-47842c84f3db // indirect
+	golang.org/x/text v0.9.0 // indirect
+)
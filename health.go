@@ -0,0 +1,70 @@
+package gas
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// healthHandler answers the liveness and readiness checks configured with
+// GAS_HEALTHZ_PATH and GAS_READYZ_PATH, and the status report and metrics at
+// GAS_STATUS_PATH and GAS_METRICS_PATH, passing every other request on to
+// next. Liveness succeeds for as long as the process can serve requests at
+// all; readiness starts failing as soon as r begins shutting down, so that a
+// load balancer stops sending new requests while the ones in flight drain.
+func (r *Router) healthHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case isHealthPath(req, Env.HealthzPath):
+			healthStatus(w, http.StatusOK, "ok")
+		case isHealthPath(req, Env.ReadyzPath):
+			if r.Ready() {
+				healthStatus(w, http.StatusOK, "ok")
+			} else {
+				healthStatus(w, http.StatusServiceUnavailable, "shutting down")
+			}
+		case isHealthPath(req, Env.StatusPath):
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Header().Set("Cache-Control", "no-store")
+			json.NewEncoder(w).Encode(struct {
+				Ready       bool
+				Listeners   []ListenerStats
+				Subscribers []SubscriberStats
+			}{r.Ready(), Stats(), Subscribers()})
+		case isHealthPath(req, Env.MetricsPath):
+			writeMetrics(w)
+		default:
+			next.ServeHTTP(w, req)
+		}
+	})
+}
+
+// isHealthPath reports whether req is for the check at p, which is disabled
+// if p is empty or "-".
+func isHealthPath(req *http.Request, p string) bool {
+	return p != "" && p != "-" && req.URL.Path == p
+}
+
+func healthStatus(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	w.Write([]byte(msg + "\n"))
+}
+
+// Ready reports whether r is accepting new traffic, i.e. whether its
+// readiness check passes. It becomes false for good once shutdown begins.
+func (r *Router) Ready() bool {
+	return atomic.LoadInt32(&r.root().draining) == 0
+}
+
+// drain marks r as not ready and then keeps serving for GAS_DRAIN_DELAY, to
+// give load balancers polling the readiness check time to notice before the
+// listeners are closed.
+func (r *Router) drain() {
+	atomic.StoreInt32(&r.draining, 1)
+	if Env.DrainDelay > 0 {
+		time.Sleep(Env.DrainDelay)
+	}
+}
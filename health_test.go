@@ -0,0 +1,45 @@
+package gas
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthHandler(t *testing.T) {
+	r := New().Get("/", func(g *Gas) (int, Outputter) {
+		g.Write([]byte("index"))
+		return g.Stop()
+	})
+	h := r.healthHandler(r)
+
+	check := func(path string, code int) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != code {
+			t.Errorf("%s: expected %d, got %d", path, code, rec.Code)
+		}
+	}
+
+	check("/", http.StatusOK)
+	check("/healthz", http.StatusOK)
+	check("/readyz", http.StatusOK)
+
+	r.Quit()
+	if r.Ready() {
+		t.Error("expected router not to be ready after Quit")
+	}
+	check("/healthz", http.StatusOK)
+	check("/readyz", http.StatusServiceUnavailable)
+	check("/", http.StatusOK)
+
+	defer func(ready, status string) {
+		Env.ReadyzPath, Env.StatusPath = ready, status
+	}(Env.ReadyzPath, Env.StatusPath)
+	Env.ReadyzPath = "-"
+	check("/readyz", http.StatusNotFound)
+
+	check("/status", http.StatusNotFound)
+	Env.StatusPath = "/status"
+	check("/status", http.StatusOK)
+}
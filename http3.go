@@ -0,0 +1,52 @@
+package gas
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// listenQUIC binds the UDP socket for the HTTP/3 side of a listener with the
+// "h3" option, on the same address and port the TCP listener l is bound to.
+func (spec *listenSpec) listenQUIC(l net.Listener) (net.PacketConn, error) {
+	if spec.isUnix() || !spec.has("tls") {
+		return nil, errors.Errorf("GAS_LISTEN: %s: h3 is only for TCP listeners with TLS", spec)
+	}
+
+	var lc net.ListenConfig
+	if spec.has("reuseport") {
+		lc.Control = reusePort
+	}
+	pc, err := lc.ListenPacket(context.Background(), "udp", l.Addr().String())
+	return pc, errors.Wrapf(err, "GAS_LISTEN: %s", spec)
+}
+
+// newQUICServer returns an HTTP/3 server for spec that serves handler with
+// the same TLS certificates and limits as srv. Requests coming in over it
+// carry the listenSpec in their context just like the ones from the TCP
+// listener.
+func newQUICServer(spec *listenSpec, srv *http.Server, cfg *tls.Config, handler http.Handler) *http3.Server {
+	return &http3.Server{
+		TLSConfig:      cfg,
+		MaxHeaderBytes: srv.MaxHeaderBytes,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			ctx := context.WithValue(req.Context(), listenerKey, spec)
+			handler.ServeHTTP(w, req.WithContext(ctx))
+		}),
+	}
+}
+
+// advertiseQUIC tells clients that came in over TCP on a listener with the
+// "h3" option that they can switch to HTTP/3.
+func advertiseQUIC(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if spec := requestSpec(req); spec != nil && spec.quic != nil && req.ProtoMajor < 3 {
+			spec.quic.SetQuicHeaders(w.Header())
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,79 @@
+package gas
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+func TestHTTP3(t *testing.T) {
+	specs, err := parseListen("127.0.0.1:0;tls;h3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := specs[0]
+	l, err := spec.listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc, err := spec.listenQUIC(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	handler := advertiseQUIC(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if requestSpec(req) != spec {
+			t.Errorf("%s: request doesn't have its listener in its context", req.Proto)
+		}
+		w.Write([]byte(req.Proto))
+	}))
+	srv := httptest.NewUnstartedServer(handler)
+	srv.Listener.Close()
+	srv.Listener = specListener{l, spec}
+	srv.Config.ConnContext = connContext(nil)
+	srv.StartTLS()
+	defer srv.Close()
+
+	spec.quic = newQUICServer(spec, srv.Config, srv.TLS, handler)
+	go spec.quic.Serve(pc)
+	defer spec.quic.Close()
+
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("Alt-Svc") == "" {
+		t.Error("expected HTTP/3 to be advertised over TCP")
+	}
+
+	client := &http.Client{Transport: &http3.RoundTripper{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	resp, err = client.Get("https://" + pc.LocalAddr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != "HTTP/3.0" {
+		t.Errorf("expected request over HTTP/3.0, got %q", body)
+	}
+	if resp.Header.Get("Alt-Svc") != "" {
+		t.Error("didn't expect HTTP/3 to be advertised over HTTP/3")
+	}
+
+	specs, _ = parseListen("127.0.0.1:0;h3")
+	if l, err = specs[0].listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if _, err = specs[0].listenQUIC(l); err == nil {
+		t.Error("expected error for h3 without tls")
+	}
+}
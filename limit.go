@@ -0,0 +1,87 @@
+package gas
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A semaphore limits how many requests may be in progress at once. A nil
+// semaphore has no limit.
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return make(semaphore, n)
+}
+
+// acquire takes a slot without waiting, reporting whether there was one free.
+func (s semaphore) acquire() bool {
+	if s == nil {
+		return true
+	}
+	select {
+	case s <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+// parseLimit sets up the semaphore for a listener's ";limit=" option.
+func (spec *listenSpec) parseLimit() error {
+	limit, ok := spec.opts["limit"]
+	if !ok {
+		return nil
+	}
+	n, err := strconv.Atoi(limit)
+	if err != nil || n <= 0 {
+		return errors.Errorf("GAS_LISTEN: %s: invalid limit %q", spec, limit)
+	}
+	spec.limit = newSemaphore(n)
+	return nil
+}
+
+// limitRequests rejects requests with 503 Service Unavailable while the
+// number already in progress is at GAS_MAX_REQUESTS, or at the limit of the
+// listener they came in on, instead of letting them queue up behind slow
+// handlers.
+func limitRequests(next http.Handler) http.Handler {
+	global := newSemaphore(Env.MaxRequests)
+	retry := strconv.Itoa(int((Env.RetryAfter + time.Second - 1) / time.Second))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var local semaphore
+		if spec := requestSpec(req); spec != nil {
+			local = spec.limit
+		}
+
+		if !global.acquire() {
+			overloaded(w, retry)
+			return
+		}
+		defer global.release()
+		if !local.acquire() {
+			overloaded(w, retry)
+			return
+		}
+		defer local.release()
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+func overloaded(w http.ResponseWriter, retry string) {
+	w.Header().Set("Retry-After", retry)
+	http.Error(w, "503 service unavailable", http.StatusServiceUnavailable)
+}
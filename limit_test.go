@@ -0,0 +1,83 @@
+package gas
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestLimitRequests(t *testing.T) {
+	defer func(max int) { Env.MaxRequests = max }(Env.MaxRequests)
+
+	specs, err := parseListen(":0;limit=1,:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, spec := range specs {
+		if err = spec.parseLimit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		entered = make(chan struct{})
+		unblock = make(chan struct{})
+	)
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow" {
+			entered <- struct{}{}
+			<-unblock
+		}
+	})
+
+	do := func(h http.Handler, spec *listenSpec, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req = req.WithContext(context.WithValue(req.Context(), listenerKey, spec))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// occupy the only slot of the first listener
+	Env.MaxRequests = 2
+	limited := limitRequests(h)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		do(limited, specs[0], "/slow")
+	}()
+	<-entered
+
+	rec := do(limited, specs[0], "/")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("expected 503 with Retry-After over the listener limit, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec = do(limited, specs[1], "/"); rec.Code != http.StatusOK {
+		t.Errorf("expected other listener to be unaffected, got %d", rec.Code)
+	}
+
+	// fill up the global limit from the unlimited listener
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		do(limited, specs[1], "/slow")
+	}()
+	<-entered
+	if rec = do(limited, specs[1], "/"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 over the global limit, got %d", rec.Code)
+	}
+
+	close(unblock)
+	wg.Wait()
+	if rec = do(limited, specs[0], "/"); rec.Code != http.StatusOK {
+		t.Errorf("expected slots to be released, got %d", rec.Code)
+	}
+
+	specs, _ = parseListen(":0;limit=none")
+	if err = specs[0].parseLimit(); err == nil {
+		t.Error("expected error for invalid limit")
+	}
+}
@@ -0,0 +1,713 @@
+package gas
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// A listenSpec is a single parsed entry of GAS_LISTEN.
+type listenSpec struct {
+	network string
+	addr    string
+	opts    map[string]string
+
+	// redirect everything to HTTPS instead of serving the router
+	redirect bool
+
+	// counts of the connections accepted on this listener
+	stats connStats
+
+	// serves HTTP/3 alongside the TCP listener if the h3 option is given
+	quic *http3.Server
+
+	// caps the requests in progress from this listener if limit is given
+	limit semaphore
+}
+
+// listenOptions lists the options that may be appended to a GAS_LISTEN entry
+// with ";" and whether each one takes a value (";opt=value") or is a flag.
+var listenOptions = map[string]bool{
+	"tls":   false,
+	"mode":  true,
+	"owner": true,
+	"group": true,
+	"proxy": false,
+
+	"reuseport": false,
+
+	"router": true,
+	"h2c":    false,
+	"h3":     false,
+	"tag":    true,
+	"limit":  true,
+
+	"redirect": true,
+}
+
+// parse a GAS_LISTEN value into its entries
+func parseListen(listenenv string) ([]*listenSpec, error) {
+	var specs []*listenSpec
+
+	for _, entry := range strings.Split(listenenv, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		var (
+			parts   = strings.Split(entry, ";")
+			netaddr = strings.SplitN(strings.TrimSpace(parts[0]), "!", 2)
+			spec    = &listenSpec{opts: make(map[string]string)}
+		)
+
+		if len(netaddr) == 1 {
+			spec.network, spec.addr = "tcp", netaddr[0]
+		} else {
+			spec.network, spec.addr = netaddr[0], netaddr[1]
+		}
+		if spec.network == "" {
+			return nil, errors.Errorf("GAS_LISTEN: invalid listen syntax: %q", entry)
+		}
+
+		for _, opt := range parts[1:] {
+			kv := strings.SplitN(strings.TrimSpace(opt), "=", 2)
+			takesValue, ok := listenOptions[kv[0]]
+			if !ok {
+				return nil, errors.Errorf("GAS_LISTEN: invalid option: %q", opt)
+			}
+			if takesValue != (len(kv) == 2) {
+				if takesValue {
+					return nil, errors.Errorf("GAS_LISTEN: option %q needs a value", kv[0])
+				}
+				return nil, errors.Errorf("GAS_LISTEN: option %q doesn't take a value", kv[0])
+			}
+			if len(kv) == 2 {
+				spec.opts[kv[0]] = kv[1]
+			} else {
+				spec.opts[kv[0]] = ""
+			}
+		}
+
+		if to, ok := spec.opts["redirect"]; ok {
+			if to != "https" {
+				return nil, errors.Errorf("GAS_LISTEN: can only redirect to https, not %q", to)
+			}
+			if spec.has("tls") {
+				return nil, errors.Errorf("GAS_LISTEN: %q redirects to https but is already using TLS", entry)
+			}
+			spec.redirect = true
+		}
+
+		specs = append(specs, spec)
+	}
+
+	if len(specs) == 0 {
+		return nil, errors.Errorf("GAS_LISTEN: no addresses given in %q", listenenv)
+	}
+
+	return specs, nil
+}
+
+func (spec *listenSpec) has(opt string) bool {
+	_, ok := spec.opts[opt]
+	return ok
+}
+
+func (spec *listenSpec) String() string {
+	return spec.network + "!" + spec.addr
+}
+
+func (spec *listenSpec) isUnix() bool {
+	return strings.HasPrefix(spec.network, "unix")
+}
+
+// open the listener described by spec
+func (spec *listenSpec) listen() (net.Listener, error) {
+	if spec.network == "fd" {
+		return fdListener(spec.addr)
+	}
+
+	if !spec.isUnix() {
+		for _, opt := range []string{"mode", "owner", "group"} {
+			if spec.has(opt) {
+				return nil, errors.Errorf("GAS_LISTEN: %s: option %q only applies to unix sockets", spec, opt)
+			}
+		}
+
+		var lc net.ListenConfig
+		if spec.has("reuseport") {
+			lc.Control = reusePort
+		}
+		return lc.Listen(context.Background(), spec.network, spec.addr)
+	}
+
+	if spec.has("reuseport") {
+		return nil, errors.Errorf("GAS_LISTEN: %s: option \"reuseport\" doesn't apply to unix sockets", spec)
+	}
+
+	// a socket file left behind by an unclean exit would make the bind fail
+	if fi, err := os.Stat(spec.addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(spec.addr)
+	}
+
+	l, err := net.Listen(spec.network, spec.addr)
+	if err != nil {
+		return nil, err
+	}
+	if err = spec.chmod(); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// apply the mode, owner, and group options to a freshly bound unix socket
+func (spec *listenSpec) chmod() error {
+	if mode, ok := spec.opts["mode"]; ok {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return errors.Errorf("GAS_LISTEN: %s: invalid mode %q", spec, mode)
+		}
+		if err = os.Chmod(spec.addr, os.FileMode(m)); err != nil {
+			return errors.Wrap(err, "GAS_LISTEN")
+		}
+	}
+
+	uid, gid := -1, -1
+
+	if owner, ok := spec.opts["owner"]; ok {
+		id, err := strconv.Atoi(owner)
+		if err != nil {
+			u, err := user.Lookup(owner)
+			if err != nil {
+				return errors.Wrap(err, "GAS_LISTEN")
+			}
+			if id, err = strconv.Atoi(u.Uid); err != nil {
+				return errors.Wrap(err, "GAS_LISTEN")
+			}
+		}
+		uid = id
+	}
+
+	if group, ok := spec.opts["group"]; ok {
+		id, err := strconv.Atoi(group)
+		if err != nil {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return errors.Wrap(err, "GAS_LISTEN")
+			}
+			if id, err = strconv.Atoi(g.Gid); err != nil {
+				return errors.Wrap(err, "GAS_LISTEN")
+			}
+		}
+		gid = id
+	}
+
+	if uid != -1 || gid != -1 {
+		return errors.Wrap(os.Chown(spec.addr, uid, gid), "GAS_LISTEN")
+	}
+	return nil
+}
+
+// The first file descriptor passed in by a service manager, as defined by
+// sd_listen_fds(3).
+const listenFdsStart = 3
+
+var (
+	activationOnce  sync.Once
+	activatedFiles  []*os.File
+	activatedByName map[string]*os.File
+)
+
+// activationFiles returns the sockets passed to this process by a service
+// manager such as systemd using the LISTEN_PID/LISTEN_FDS protocol. The
+// variables are cleared after the first call so that child processes don't
+// mistake the sockets as meant for them.
+func activationFiles() []*os.File {
+	activationOnce.Do(func() {
+		defer func() {
+			os.Unsetenv("LISTEN_PID")
+			os.Unsetenv("LISTEN_FDS")
+			os.Unsetenv("LISTEN_FDNAMES")
+		}()
+
+		pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+		if err != nil || pid != os.Getpid() {
+			return
+		}
+		n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if err != nil || n <= 0 {
+			return
+		}
+
+		names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+		activatedByName = make(map[string]*os.File, n)
+
+		for i := 0; i < n; i++ {
+			fd := listenFdsStart + i
+			name := "LISTEN_FD_" + strconv.Itoa(fd)
+			if i < len(names) && names[i] != "" {
+				name = names[i]
+			}
+			f := os.NewFile(uintptr(fd), name)
+			activatedFiles = append(activatedFiles, f)
+			activatedByName[name] = f
+		}
+	})
+
+	return activatedFiles
+}
+
+// fdListener makes a listener out of an inherited file descriptor, given
+// either as a number or as a name listed in LISTEN_FDNAMES.
+func fdListener(addr string) (net.Listener, error) {
+	activationFiles()
+
+	var f *os.File
+
+	if fd, err := strconv.Atoi(addr); err == nil {
+		for _, af := range activatedFiles {
+			if af.Fd() == uintptr(fd) {
+				f = af
+				break
+			}
+		}
+		if f == nil {
+			f = os.NewFile(uintptr(fd), "fd"+addr)
+		}
+	} else if f = activatedByName[addr]; f == nil {
+		return nil, errors.Errorf("GAS_LISTEN: no socket named %q was passed in", addr)
+	}
+
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fd!%s", addr)
+	}
+	// FileListener dups the descriptor, so the original isn't needed anymore
+	f.Close()
+	return l, nil
+}
+
+// a specListener remembers which GAS_LISTEN entry its connections came from
+type specListener struct {
+	net.Listener
+	spec *listenSpec
+}
+
+func (l specListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return specConn{c, l.spec}, nil
+}
+
+type specConn struct {
+	net.Conn
+	spec *listenSpec
+}
+
+type ctxKey int
+
+const listenerKey ctxKey = iota
+
+// connContext returns an http.Server.ConnContext func which stores the
+// listenSpec of each connection's listener in its context, chaining to next
+// if it isn't nil.
+func connContext(next func(context.Context, net.Conn) context.Context) func(context.Context, net.Conn) context.Context {
+	return func(ctx context.Context, c net.Conn) context.Context {
+		if next != nil {
+			ctx = next(ctx, c)
+		}
+		if tc, ok := c.(*tls.Conn); ok {
+			c = tc.NetConn()
+		}
+		if sc, ok := c.(specConn); ok {
+			ctx = context.WithValue(ctx, listenerKey, sc.spec)
+		}
+		return ctx
+	}
+}
+
+// the listenSpec of the listener that req came in on, if it's known
+func requestSpec(req *http.Request) *listenSpec {
+	spec, _ := req.Context().Value(listenerKey).(*listenSpec)
+	return spec
+}
+
+// Listener returns the tag given with the ";tag=<name>" option to the
+// GAS_LISTEN entry that the request came in on, or "" if it has none.
+func (g *Gas) Listener() string {
+	if spec := requestSpec(g.Request); spec != nil {
+		return spec.opts["tag"]
+	}
+	return ""
+}
+
+// OnListener returns middleware that runs handlers, in order, only for
+// requests coming in on listeners tagged with tag, and skips straight to the
+// rest of the chain for all others. For example, to require authentication on
+// every listener except a trusted internal one:
+//
+//	GAS_LISTEN="unix:/run/app.sock;tag=internal,:https;tls;tag=public"
+//	r.Use(gas.OnListener("public", requireAuth))
+func OnListener(tag string, handlers ...Handler) Handler {
+	return func(g *Gas) (int, Outputter) {
+		if g.Listener() == tag {
+			chain := make([]Handler, 0, len(handlers)+len(g.handlers))
+			g.handlers = append(append(chain, handlers...), g.handlers...)
+		}
+		return g.Continue()
+	}
+}
+
+// Bind attaches another router to r which will serve the requests coming in
+// on listeners given the option ";router=<name>" in GAS_LISTEN, instead of r.
+// This allows a single process to serve e.g. a public site on one listener
+// and an internal admin router on a unix socket:
+//
+//	admin := gas.New().Get("/stats", stats)
+//	gas.New().Get("/", index).Bind("admin", admin).Ignition()
+//
+// with
+//
+//	GAS_LISTEN=":https;tls, unix!/run/app-admin.sock;router=admin"
+//
+// Only the router that Ignition is called on can have routers bound to it.
+func (r *Router) Bind(name string, other *Router) *Router {
+	if r.bound == nil {
+		r.bound = make(map[string]*Router)
+	}
+	r.bound[name] = other
+	return r
+}
+
+// serve a request with whichever router is bound to its listener
+func (r *Router) dispatch(w http.ResponseWriter, req *http.Request) {
+	if spec := requestSpec(req); spec != nil {
+		if name, ok := spec.opts["router"]; ok {
+			r.bound[name].ServeHTTP(w, req)
+			return
+		}
+	}
+	r.ServeHTTP(w, req)
+}
+
+// fill in the limits from Env that srv doesn't already have set
+func configureServer(srv *http.Server) {
+	if srv.ReadTimeout == 0 {
+		srv.ReadTimeout = Env.ReadTimeout
+	}
+	if srv.WriteTimeout == 0 {
+		srv.WriteTimeout = Env.WriteTimeout
+	}
+	if srv.IdleTimeout == 0 {
+		srv.IdleTimeout = Env.IdleTimeout
+	}
+	if srv.MaxHeaderBytes == 0 {
+		srv.MaxHeaderBytes = Env.MaxHeaderBytes
+	}
+}
+
+// inheritFdsEnv tells a process started by restart how many listening
+// sockets it has inherited, starting at file descriptor 3 in GAS_LISTEN order.
+const inheritFdsEnv = "GAS_INHERIT_FDS"
+
+// return the listening sockets handed down by a parent process in the middle
+// of a restart, if any
+func inheritedListeners() ([]net.Listener, error) {
+	s := os.Getenv(inheritFdsEnv)
+	if s == "" {
+		return nil, nil
+	}
+	os.Unsetenv(inheritFdsEnv)
+
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, errors.Wrap(err, inheritFdsEnv)
+	}
+
+	ll := make([]net.Listener, n)
+	for i := range ll {
+		f := os.NewFile(uintptr(listenFdsStart+i), "inherited")
+		ll[i], err = net.FileListener(f)
+		f.Close()
+		if err != nil {
+			closeListeners(ll[:i])
+			return nil, errors.Wrap(err, inheritFdsEnv)
+		}
+	}
+	return ll, nil
+}
+
+func closeListeners(ll []net.Listener) {
+	for _, l := range ll {
+		l.Close()
+	}
+}
+
+// restart starts a new copy of the running executable with the same arguments
+// and environment, handing over all of r's listening sockets so that no
+// connections are refused while the current process drains and exits.
+func (r *Router) restart() (*os.Process, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, errors.Wrap(err, "restart")
+	}
+
+	files := make([]*os.File, len(r.listeners))
+	for i, l := range r.listeners {
+		fl, ok := l.(interface {
+			File() (*os.File, error)
+		})
+		if !ok {
+			return nil, errors.Errorf("restart: can't hand over %v", l.Addr())
+		}
+		f, err := fl.File()
+		if err != nil {
+			return nil, errors.Wrap(err, "restart")
+		}
+		defer f.Close()
+		files[i] = f
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), inheritFdsEnv+"="+strconv.Itoa(len(files)))
+	cmd.ExtraFiles = files
+
+	if err = cmd.Start(); err != nil {
+		return nil, errors.Wrap(err, "restart")
+	}
+
+	// the socket file now belongs to the new process too, so don't remove it
+	// when closing our copy
+	for _, l := range r.listeners {
+		if ul, ok := l.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+
+	return cmd.Process, nil
+}
+
+func (r *Router) listen(ctx context.Context, listenenv string) error {
+	var (
+		cfg *tls.Config
+		srv *http.Server
+	)
+
+	specs, err := parseListen(listenenv)
+	if err != nil {
+		return err
+	}
+	// for what has to keep going only as long as the listeners do
+	lctx, cancelListen := context.WithCancel(ctx)
+	defer cancelListen()
+	if Env.RedirectHTTP != "" {
+		rspecs, err := parseListen(Env.RedirectHTTP)
+		if err != nil {
+			return err
+		}
+		for _, spec := range rspecs {
+			spec.redirect = true
+		}
+		specs = append(specs, rspecs...)
+	}
+	for _, spec := range specs {
+		if name, ok := spec.opts["router"]; ok && r.bound[name] == nil {
+			return errors.Errorf("GAS_LISTEN: %s: no router is bound as %q", spec, name)
+		}
+		if err = spec.parseLimit(); err != nil {
+			return err
+		}
+		if spec.has("h2c") && spec.has("tls") {
+			return errors.Errorf("GAS_LISTEN: %s: h2c is for listeners without TLS", spec)
+		}
+	}
+
+	ll := make([]net.Listener, len(specs))
+	pcs := make([]net.PacketConn, len(specs))
+
+	inherited, err := inheritedListeners()
+	if err != nil {
+		return err
+	}
+	if inherited != nil && len(inherited) != len(specs) {
+		closeListeners(inherited)
+		return errors.Errorf("%s: inherited %d sockets but GAS_LISTEN and GAS_REDIRECT_HTTP have %d entries",
+			inheritFdsEnv, len(inherited), len(specs))
+	}
+	r.listeners = make([]net.Listener, len(specs))
+
+	if r.Server != nil {
+		srv = r.Server
+	} else {
+		srv = &http.Server{}
+	}
+
+	srv.ConnContext = connContext(srv.ConnContext)
+	srv.ConnState = connState(srv.ConnState)
+	configureServer(srv)
+
+	// HTTP/2 is negotiated with ALPN on TLS listeners; the ones marked h2c
+	// have to be able to speak it in cleartext
+	limited := limitRequests(http.HandlerFunc(r.dispatch))
+	h2cHandler := h2c.NewHandler(limited, &http2.Server{
+		IdleTimeout: srv.IdleTimeout,
+	})
+	srv.Handler = advertiseQUIC(r.healthHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if spec := requestSpec(req); spec != nil && spec.has("h2c") {
+			h2cHandler.ServeHTTP(w, req)
+			return
+		}
+		limited.ServeHTTP(w, req)
+	})))
+
+	// plain listeners that only redirect to HTTPS get their own minimal server
+	redirectSrv := &http.Server{
+		Handler:   r.healthHandler(httpsRedirect(r)),
+		ConnState: connState(nil),
+	}
+	configureServer(redirectSrv)
+
+	// if one of the listeners can't be set up, the ones opened before it
+	// are closed so that their addresses are free again
+	abort := func(err error) error {
+		for i, l := range r.listeners {
+			if l != nil {
+				l.Close()
+			}
+			if pcs[i] != nil {
+				pcs[i].Close()
+			}
+		}
+		closeListeners(inherited)
+		r.listeners = nil
+		return err
+	}
+
+	for i, spec := range specs {
+		var l net.Listener
+		if inherited != nil {
+			l = inherited[i]
+		} else if l, err = spec.listen(); err != nil {
+			return abort(err)
+		}
+		r.listeners[i] = l
+
+		// this has to come before TLS, since the PROXY header is sent
+		// in the clear ahead of the handshake
+		if spec.has("proxy") {
+			l = proxyListener{l}
+		}
+
+		l = specListener{l, spec}
+
+		if spec.has("tls") {
+			if cfg == nil {
+				cfg, err = tlsConfig(Env.TLSCert, Env.TLSKey, Env.TLSHost)
+				if err != nil {
+					return abort(err)
+				}
+				if err = rotateTicketKeys(lctx, cfg); err != nil {
+					return abort(err)
+				}
+			}
+			l = tls.NewListener(l, cfg)
+		}
+
+		if spec.has("h3") {
+			if pcs[i], err = spec.listenQUIC(r.listeners[i]); err != nil {
+				return abort(err)
+			}
+			spec.quic = newQUICServer(spec, srv, cfg, srv.Handler)
+		}
+
+		ll[i] = l
+	}
+
+	trackStats(specs)
+
+	// every server can fail at most once, so none of them will block on
+	// sending their error after the first one has been received
+	errchan := make(chan error, len(ll)+len(pcs))
+	for i, l := range ll {
+		s := srv
+		if specs[i].redirect {
+			s = redirectSrv
+		}
+		go func(l net.Listener, spec *listenSpec, srv *http.Server) {
+			Logger().Info("serving", "addr", l.Addr(), "listener", spec)
+			if err := srv.Serve(l); err != http.ErrServerClosed {
+				errchan <- errors.Wrapf(err, "serve %s", spec)
+			}
+		}(l, specs[i], s)
+	}
+	for i, pc := range pcs {
+		if pc == nil {
+			continue
+		}
+		go func(pc net.PacketConn, spec *listenSpec) {
+			Logger().Info("serving HTTP/3", "addr", pc.LocalAddr(), "listener", spec)
+			if err := spec.quic.Serve(pc); err != http.ErrServerClosed {
+				errchan <- errors.Wrapf(err, "serve %s (HTTP/3)", spec)
+			}
+		}(pc, specs[i])
+	}
+
+	sig := make(chan os.Signal, 1)
+	if len(restartSignals) > 0 {
+		signal.Notify(sig, restartSignals...)
+		defer signal.Stop(sig)
+	}
+
+wait:
+	for {
+		select {
+		case err = <-errchan:
+			break wait
+		case <-sig:
+			proc, rerr := r.restart()
+			if rerr != nil {
+				Logger().Error("restart", "err", rerr)
+				continue
+			}
+			Logger().Info("restart: started new process, draining connections", "pid", proc.Pid)
+			break wait
+		case <-r.quit:
+			break wait
+		case <-ctx.Done():
+			break wait
+		}
+	}
+
+	r.drain()
+
+	ctx, cancel := context.WithTimeout(context.Background(), Env.ShutdownTimeout)
+	defer cancel()
+	redirectSrv.Shutdown(ctx)
+	for _, spec := range specs {
+		if spec.quic != nil {
+			spec.quic.Close()
+		}
+	}
+	srv.Shutdown(ctx)
+	return err
+}
@@ -0,0 +1,337 @@
+package gas
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"ktkr.us/pkg/gas/testutil"
+)
+
+func TestParseListen(t *testing.T) {
+	tests := []struct {
+		in    string
+		specs []string
+		tls   []bool
+		ok    bool
+	}{
+		{":80", []string{"tcp!:80"}, []bool{false}, true},
+		{":80, tcp![::1]:8080, unix!/var/run/website.sock, tcp!:https;tls",
+			[]string{"tcp!:80", "tcp![::1]:8080", "unix!/var/run/website.sock", "tcp!:https"},
+			[]bool{false, false, false, true}, true},
+		{"fd!3;tls", []string{"fd!3"}, []bool{true}, true},
+		{":80;bogus", nil, nil, false},
+		{":80;tls=yes", nil, nil, false},
+		{"!:80", nil, nil, false},
+		{" , ", nil, nil, false},
+		{":80;redirect=https", []string{"tcp!:80"}, []bool{false}, true},
+		{":80;redirect=ftp", nil, nil, false},
+		{":443;tls;redirect=https", nil, nil, false},
+	}
+
+	for _, test := range tests {
+		specs, err := parseListen(test.in)
+		if (err == nil) != test.ok {
+			t.Errorf("%q: expected ok=%v, got err=%v", test.in, test.ok, err)
+			continue
+		}
+		if len(specs) != len(test.specs) {
+			t.Errorf("%q: expected %d specs, got %d", test.in, len(test.specs), len(specs))
+			continue
+		}
+		for i, spec := range specs {
+			if spec.String() != test.specs[i] || spec.has("tls") != test.tls[i] || spec.redirect != spec.has("redirect") {
+				t.Errorf("%q: expected %s (tls=%v), got %s (tls=%v)",
+					test.in, test.specs[i], test.tls[i], spec, spec.has("tls"))
+			}
+		}
+	}
+}
+
+func TestFdListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fl, err := fdListener(strconv.Itoa(int(f.Fd())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fl.Close()
+
+	if fl.Addr().String() != l.Addr().String() {
+		t.Errorf("expected inherited listener on %v, got %v", l.Addr(), fl.Addr())
+	}
+
+	if _, err = fdListener("nonexistent"); err == nil {
+		t.Error("expected error for unknown socket name")
+	}
+}
+
+func TestUnixListenerMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gas-listen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sock := filepath.Join(dir, "test.sock")
+	specs, err := parseListen("unix!" + sock + ";mode=0600")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// twice, to make sure a stale socket file doesn't prevent binding
+	for i := 0; i < 2; i++ {
+		l, err := specs[0].listen()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(sock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := fi.Mode().Perm(); perm != 0600 {
+			t.Errorf("expected mode 0600, got %#o", perm)
+		}
+		l.(*net.UnixListener).SetUnlinkOnClose(false)
+		l.Close()
+	}
+
+	specs, _ = parseListen("127.0.0.1:0;mode=0600")
+	if _, err = specs[0].listen(); err == nil {
+		t.Error("expected error for mode on a tcp listener")
+	}
+}
+
+func TestReusePort(t *testing.T) {
+	specs, err := parseListen("127.0.0.1:0;reuseport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l1, err := specs[0].listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+
+	specs[0].addr = l1.Addr().String()
+	l2, err := specs[0].listen()
+	if err != nil {
+		t.Fatalf("expected second bind on %s to succeed: %v", specs[0].addr, err)
+	}
+	l2.Close()
+}
+
+func TestConfigureServer(t *testing.T) {
+	defer func(read, idle time.Duration, max int) {
+		Env.ReadTimeout, Env.IdleTimeout, Env.MaxHeaderBytes = read, idle, max
+	}(Env.ReadTimeout, Env.IdleTimeout, Env.MaxHeaderBytes)
+
+	Env.ReadTimeout = 5 * time.Second
+	Env.IdleTimeout = time.Minute
+	Env.MaxHeaderBytes = 4096
+
+	srv := &http.Server{IdleTimeout: time.Hour}
+	configureServer(srv)
+
+	if srv.ReadTimeout != 5*time.Second || srv.MaxHeaderBytes != 4096 {
+		t.Errorf("expected limits from Env, got %v %v", srv.ReadTimeout, srv.MaxHeaderBytes)
+	}
+	if srv.IdleTimeout != time.Hour {
+		t.Errorf("expected the server's own IdleTimeout to be kept, got %v", srv.IdleTimeout)
+	}
+}
+
+func TestBind(t *testing.T) {
+	admin := New().Get("/", func(g *Gas) (int, Outputter) {
+		g.Write([]byte("admin"))
+		return g.Stop()
+	})
+	r := New().Get("/", func(g *Gas) (int, Outputter) {
+		g.Write([]byte("public"))
+		return g.Stop()
+	}).Bind("admin", admin)
+
+	for _, test := range []struct {
+		listen   string
+		expected string
+	}{
+		{"127.0.0.1:0", "public"},
+		{"127.0.0.1:0;router=admin", "admin"},
+	} {
+		specs, err := parseListen(test.listen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := specs[0].listen()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(r.dispatch))
+		srv.Listener.Close()
+		srv.Listener = specListener{l, specs[0]}
+		srv.Config.ConnContext = connContext(nil)
+		srv.Start()
+
+		testutil.TestGet(t, srv, "/", test.expected)
+		srv.Close()
+	}
+}
+
+func TestH2C(t *testing.T) {
+	r := New().Get("/", func(g *Gas) (int, Outputter) {
+		g.Write([]byte(g.Proto))
+		return g.Stop()
+	})
+
+	specs, err := parseListen("127.0.0.1:0;h2c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := specs[0].listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h2cHandler := h2c.NewHandler(http.HandlerFunc(r.dispatch), &http2.Server{})
+	srv := httptest.NewUnstartedServer(h2cHandler)
+	srv.Listener.Close()
+	srv.Listener = specListener{l, specs[0]}
+	srv.Config.ConnContext = connContext(nil)
+	srv.Start()
+	defer srv.Close()
+
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != "HTTP/2.0" {
+		t.Errorf("expected request over HTTP/2.0, got %q", body)
+	}
+
+	if err = New().listen(context.Background(), ":0;h2c;tls"); err == nil {
+		t.Error("expected error for h2c with tls")
+	}
+}
+
+func TestIgnitionContext(t *testing.T) {
+	defer func(listen string, funcs []func() error) {
+		Env.Listen, initFuncs = listen, funcs
+	}(Env.Listen, initFuncs)
+	initFuncs = nil
+
+	Env.Listen = "bogus!addr"
+	if err := New().IgnitionContext(context.Background()); err == nil {
+		t.Error("expected error for invalid GAS_LISTEN")
+	}
+
+	Env.Listen = "127.0.0.1:0"
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- New().IgnitionContext(ctx) }()
+	cancel()
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("expected nil error after cancel, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("IgnitionContext didn't return after its context was canceled")
+	}
+}
+
+func TestListenAbort(t *testing.T) {
+	defer func(cert, key string) {
+		Env.TLSCert, Env.TLSKey = cert, key
+	}(Env.TLSCert, Env.TLSKey)
+	Env.TLSCert, Env.TLSKey = "/nonexistent/cert.pem", "/nonexistent/key.pem"
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	sock := filepath.Join(t.TempDir(), "gas.sock")
+
+	r := New()
+	if err = r.listen(context.Background(), addr+", unix!"+sock+", 127.0.0.1:0;tls"); err == nil {
+		t.Fatal("expected error for missing certificate")
+	}
+	if r.listeners != nil {
+		t.Errorf("expected no listeners to be kept, got %v", r.listeners)
+	}
+	if l, err = net.Listen("tcp", addr); err != nil {
+		t.Errorf("expected %s to be closed: %v", addr, err)
+	} else {
+		l.Close()
+	}
+	if _, err = os.Stat(sock); !os.IsNotExist(err) {
+		t.Errorf("expected the socket file to be removed, got %v", err)
+	}
+}
+
+func TestOnListener(t *testing.T) {
+	specs, err := parseListen("unix:/tmp/gas.sock;tag=internal,:0;tag=public,:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requireAuth := func(g *Gas) (int, Outputter) {
+		return 403, nil
+	}
+	r := New().Use(OnListener("public", requireAuth)).Get("/", func(g *Gas) (int, Outputter) {
+		g.Write([]byte(g.Listener()))
+		return g.Stop()
+	})
+
+	tests := []struct {
+		spec *listenSpec
+		code int
+		body string
+	}{
+		{specs[0], 200, "internal"},
+		{specs[1], 403, ""},
+		{specs[2], 200, ""},
+		{nil, 200, ""},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if test.spec != nil {
+			req = req.WithContext(context.WithValue(req.Context(), listenerKey, test.spec))
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != test.code || rec.Body.String() != test.body {
+			t.Errorf("%v: expected %d %q, got %d %q", test.spec, test.code, test.body, rec.Code, rec.Body.String())
+		}
+	}
+}
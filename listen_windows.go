@@ -0,0 +1,11 @@
+package gas
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+func reusePort(network, address string, c syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT is not supported on windows")
+}
@@ -0,0 +1,203 @@
+package gas
+
+// log.go sets up the structured logger used throughout gas and its packages.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"ktkr.us/pkg/gas/notify"
+)
+
+// LogLevel is the least severe level of record written by the logger gas sets
+// up. It starts out as LOG_LEVEL and can be changed while the server runs.
+var LogLevel = new(slog.LevelVar)
+
+var logger atomic.Pointer[slog.Logger]
+
+// Logger returns the logger that gas and its packages write to.
+func Logger() *slog.Logger {
+	if l := logger.Load(); l != nil {
+		return l
+	}
+	return slog.Default()
+}
+
+// SetLogger replaces the logger returned by Logger, e.g. to send records to a
+// different slog.Handler. LogLevel only applies to the logger gas sets up
+// itself, so l is responsible for its own level. A nil l goes back to
+// slog.Default.
+func SetLogger(l *slog.Logger) {
+	logger.Store(l)
+	notify.SetLogger(l)
+}
+
+// newLogger makes the logger described by Env, writing to w
+func newLogger(w io.Writer) (*slog.Logger, error) {
+	if err := levelFromEnv(); err != nil {
+		return nil, err
+	}
+
+	opts := &slog.HandlerOptions{Level: LogLevel}
+	switch strings.ToLower(Env.LogFormat) {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, errors.Errorf("%sLOG_FORMAT: unknown format %q", EnvPrefix, Env.LogFormat)
+}
+
+// levelFromEnv sets LogLevel from Env
+func levelFromEnv() error {
+	level := Env.LogLevel
+	if level == "" {
+		level = "info"
+		if CurrentProfile().Verbose {
+			level = "debug"
+		}
+	}
+	if err := LogLevel.UnmarshalText([]byte(level)); err != nil {
+		return errors.Errorf("%sLOG_LEVEL: unknown level %q", EnvPrefix, level)
+	}
+	return nil
+}
+
+// setupLogger replaces the default logger with the one described by Env.
+func setupLogger() error {
+	dest := strings.ToLower(Env.LogDest)
+	if dest == "" {
+		dest = "stderr"
+		if Env.LogFile != "" {
+			dest = "file"
+		}
+	}
+
+	var (
+		l   *slog.Logger
+		h   slog.Handler
+		err error
+	)
+	switch dest {
+	case "stderr":
+		l, err = newLogger(os.Stderr)
+	case "file":
+		if Env.LogFile == "" {
+			return errors.Errorf("%sLOG_DEST is file but %sLOG_FILE isn't set", EnvPrefix, EnvPrefix)
+		}
+		var lf *logFile
+		lf, err = openLogFile(Env.LogFile, Env.LogMaxSize, Env.LogRotateInterval, Env.LogCompress, Env.LogKeep)
+		if err == nil {
+			l, err = newLogger(lf)
+		}
+	case "syslog", "journald":
+		if err = levelFromEnv(); err != nil {
+			return err
+		}
+		if dest == "syslog" {
+			h, err = newSyslogHandler(LogLevel, logTag(), Env.SyslogAddr)
+		} else {
+			h, err = newJournalHandler(LogLevel, logTag())
+		}
+		if err == nil {
+			l = slog.New(h)
+		}
+	default:
+		return errors.Errorf("%sLOG_DEST: unknown destination %q", EnvPrefix, Env.LogDest)
+	}
+	if err != nil {
+		return err
+	}
+	SetLogger(l)
+	return nil
+}
+
+// LogLevelChanged is published when the log level is changed while the server
+// is running.
+type LogLevelChanged struct {
+	Time     time.Time
+	From, To slog.Level
+
+	// What changed it: "signal", "http", or "api" for a call to SetLogLevel
+	Source string
+}
+
+// NotifyEvent makes e into a "log_level" event.
+func (e *LogLevelChanged) NotifyEvent() *notify.Event {
+	return &notify.Event{
+		Kind:  "log_level",
+		Time:  e.Time,
+		Title: fmt.Sprintf("log level changed from %v to %v", e.From, e.To),
+		Fields: map[string]string{
+			"from":   e.From.String(),
+			"to":     e.To.String(),
+			"source": e.Source,
+		},
+	}
+}
+
+// SetLogLevel changes LogLevel, logging the change and publishing a
+// LogLevelChanged event.
+func SetLogLevel(level slog.Level) {
+	changeLogLevel(level, "api")
+}
+
+func changeLogLevel(level slog.Level, source string) {
+	from := LogLevel.Level()
+	LogLevel.Set(level)
+	// logged at the new level so that it always shows up
+	Logger().Log(context.Background(), level, "log level changed",
+		"from", from, "to", level, "source", source)
+	Publish(&LogLevelChanged{
+		Time:   time.Now(),
+		From:   from,
+		To:     level,
+		Source: source,
+	})
+}
+
+// the levels that SIGUSR1 steps through
+var logLevels = []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelWarn, slog.LevelError}
+
+// cycleLogLevel moves LogLevel to the next least verbose level, wrapping
+// around from error to debug.
+func cycleLogLevel() {
+	current := LogLevel.Level()
+	next := logLevels[0]
+	for _, level := range logLevels {
+		if level > current {
+			next = level
+			break
+		}
+	}
+	changeLogLevel(next, "signal")
+}
+
+// LogLevelHandler reports the current log level in answer to GET, and sets it
+// to the form value "level" (e.g. "debug") in answer to POST or PUT. It should
+// only be served on an internal or otherwise protected router:
+//
+//	admin.Get("/loglevel", gas.LogLevelHandler)
+//	admin.Post("/loglevel", gas.LogLevelHandler)
+func LogLevelHandler(g *Gas) (int, Outputter) {
+	if g.Method == "POST" || g.Method == "PUT" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(g.FormValue("level"))); err != nil {
+			g.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(g, "unknown level %q\n", g.FormValue("level"))
+			return g.Stop()
+		}
+		changeLogLevel(level, "http")
+	}
+	g.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(g, LogLevel.Level())
+	return g.Stop()
+}
@@ -0,0 +1,135 @@
+package gas
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"ktkr.us/pkg/gas/testutil"
+)
+
+func TestNewLogger(t *testing.T) {
+	saved, savedLevel := Env, LogLevel.Level()
+	defer func() {
+		Env = saved
+		LogLevel.Set(savedLevel)
+	}()
+
+	Env.Env = "prod"
+	Env.LogLevel = ""
+	Env.LogFormat = "json"
+	buf := new(bytes.Buffer)
+	l, err := newLogger(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Debug("hidden")
+	l.Info("shown", "n", 1)
+
+	var rec map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("%v: %q", err, buf)
+	}
+	if rec["msg"] != "shown" || rec["n"] != 1.0 {
+		t.Errorf("got %v", rec)
+	}
+
+	// the level can be changed after the fact
+	LogLevel.Set(slog.LevelDebug)
+	buf.Reset()
+	l.Debug("now shown")
+	if !strings.Contains(buf.String(), "now shown") {
+		t.Errorf("debug record not written with LogLevel = debug")
+	}
+
+	Env.Env = "dev"
+	Env.LogFormat = "text"
+	buf.Reset()
+	if l, err = newLogger(buf); err != nil {
+		t.Fatal(err)
+	}
+	l.Debug("verbose")
+	if !strings.Contains(buf.String(), "msg=verbose") {
+		t.Errorf("dev profile should log at debug level, got %q", buf)
+	}
+
+	for _, env := range []struct{ level, format string }{
+		{"loud", "text"},
+		{"warn", "xml"},
+	} {
+		Env.LogLevel, Env.LogFormat = env.level, env.format
+		if _, err := newLogger(buf); err == nil {
+			t.Errorf("level %q, format %q: expected error", env.level, env.format)
+		}
+	}
+}
+
+func TestSetLogger(t *testing.T) {
+	saved := Logger()
+	defer SetLogger(saved)
+
+	buf := new(bytes.Buffer)
+	SetLogger(slog.New(slog.NewTextHandler(buf, nil)))
+	Logger().Info("hello")
+	if !strings.Contains(buf.String(), "msg=hello") {
+		t.Errorf("got %q", buf)
+	}
+
+	SetLogger(nil)
+	if Logger() != slog.Default() {
+		t.Errorf("SetLogger(nil) should go back to slog.Default")
+	}
+}
+
+func TestChangeLogLevel(t *testing.T) {
+	saved, savedLevel := Logger(), LogLevel.Level()
+	defer func() {
+		SetLogger(saved)
+		LogLevel.Set(savedLevel)
+	}()
+	SetLogger(slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: LogLevel})))
+
+	events := make(chan *LogLevelChanged, 10)
+	sub := Subscribe(func(e *LogLevelChanged) { events <- e })
+	defer sub.Close()
+
+	LogLevel.Set(slog.LevelWarn)
+	for _, expected := range []slog.Level{slog.LevelError, slog.LevelDebug, slog.LevelInfo} {
+		cycleLogLevel()
+		if level := LogLevel.Level(); level != expected {
+			t.Errorf("cycled to %v, expected %v", level, expected)
+		}
+		if e := <-events; e.To != expected || e.Source != "signal" {
+			t.Errorf("got event %+v", e)
+		}
+	}
+
+	r := New().Get("/loglevel", LogLevelHandler).Post("/loglevel", LogLevelHandler)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	testutil.TestGet(t, srv, "/loglevel", "INFO\n")
+	resp, err := testutil.Client.PostForm(srv.URL+"/loglevel", url.Values{"level": {"warn"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if e := <-events; e.From != slog.LevelInfo || e.To != slog.LevelWarn || e.Source != "http" {
+		t.Errorf("got event %+v", e)
+	}
+	testutil.TestGet(t, srv, "/loglevel", "WARN\n")
+
+	resp, err = testutil.Client.PostForm(srv.URL+"/loglevel", url.Values{"level": {"loud"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Errorf("unknown level: got %d, expected 400", resp.StatusCode)
+	}
+}
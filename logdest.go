@@ -0,0 +1,175 @@
+package gas
+
+// logdest.go has the slog handlers for the log destinations other than plain
+// streams, which need each record broken down into its fields.
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// fieldHandler is a slog.Handler that flattens each record's attributes,
+// including the ones from WithAttrs and WithGroup, into a list with keys like
+// "group.key", and passes them to emit.
+type fieldHandler struct {
+	level  slog.Leveler
+	prefix string // of the current group, ending in "."
+	attrs  []slog.Attr
+	emit   func(r slog.Record, attrs []slog.Attr) error
+}
+
+func (h *fieldHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *fieldHandler) Handle(_ context.Context, r slog.Record) error {
+	attrs := append([]slog.Attr(nil), h.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = flattenAttr(attrs, h.prefix, a)
+		return true
+	})
+	return h.emit(r, attrs)
+}
+
+func (h *fieldHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		h2.attrs = flattenAttr(h2.attrs, h.prefix, a)
+	}
+	return &h2
+}
+
+func (h *fieldHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.prefix += name + "."
+	return &h2
+}
+
+func flattenAttr(attrs []slog.Attr, prefix string, a slog.Attr) []slog.Attr {
+	a.Value = a.Value.Resolve()
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			attrs = flattenAttr(attrs, prefix, ga)
+		}
+		return attrs
+	}
+	if a.Equal(slog.Attr{}) {
+		return attrs
+	}
+	return append(attrs, slog.Attr{Key: prefix + a.Key, Value: a.Value})
+}
+
+// formatFields formats a record in the style of slog.TextHandler, minus the
+// time and level, which syslog records separately.
+func formatFields(msg string, attrs []slog.Attr) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	for _, a := range attrs {
+		b.WriteByte(' ')
+		b.WriteString(a.Key)
+		b.WriteByte('=')
+		s := a.Value.String()
+		if s == "" || strings.ContainsAny(s, " =\"\n\t") || !utf8.ValidString(s) {
+			s = strconv.Quote(s)
+		}
+		b.WriteString(s)
+	}
+	return b.String()
+}
+
+// the syslog priority closest to a slog level
+func syslogPriority(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	}
+	return 7
+}
+
+// the name records are tagged with when LOG_TAG isn't set
+func logTag() string {
+	if Env.LogTag != "" {
+		return Env.LogTag
+	}
+	return filepath.Base(os.Args[0])
+}
+
+// the socket of the systemd journal's native protocol
+var journalSocket = "/run/systemd/journal/socket"
+
+// newJournalHandler makes a handler sending records straight to the systemd
+// journal, with each attribute as a field of its own.
+func newJournalHandler(level slog.Leveler, tag string) (slog.Handler, error) {
+	conn, err := net.Dial("unixgram", journalSocket)
+	if err != nil {
+		return nil, errors.Wrap(err, "journald")
+	}
+
+	return &fieldHandler{
+		level: level,
+		emit: func(r slog.Record, attrs []slog.Attr) error {
+			var b bytes.Buffer
+			writeJournalField(&b, "MESSAGE", r.Message)
+			writeJournalField(&b, "PRIORITY", strconv.Itoa(syslogPriority(r.Level)))
+			writeJournalField(&b, "SYSLOG_IDENTIFIER", tag)
+			for _, a := range attrs {
+				writeJournalField(&b, journalFieldName(a.Key), a.Value.String())
+			}
+			_, err := conn.Write(b.Bytes())
+			return err
+		},
+	}, nil
+}
+
+// writeJournalField appends a field in the journal's native format, which
+// needs values with line breaks in them to have their length spelled out.
+func writeJournalField(b *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(b, "%s=%s\n", name, value)
+		return
+	}
+	b.WriteString(name)
+	b.WriteByte('\n')
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+// journalFieldName converts an attribute key into a valid journal field name:
+// upper case letters, digits, and underscores, not starting with an
+// underscore (which is reserved for fields set by journald itself).
+func journalFieldName(key string) string {
+	name := []byte(strings.ToUpper(key))
+	for i, c := range name {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			name[i] = '_'
+		}
+	}
+	s := strings.TrimLeft(string(name), "_")
+	if s == "" || s[0] >= '0' && s[0] <= '9' {
+		s = "F_" + s
+	}
+	return s
+}
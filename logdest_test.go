@@ -0,0 +1,87 @@
+package gas
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestJournalHandler(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no unix datagram sockets")
+	}
+	defer func(s string) { journalSocket = s }(journalSocket)
+	journalSocket = filepath.Join(t.TempDir(), "journal.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	h, err := newJournalHandler(slog.LevelInfo, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := slog.New(h).With("request_id", "abc").WithGroup("db")
+	l.Debug("hidden")
+	l.Warn("slow query", "query", "select 1\nfrom t", slog.Group("stats", "rows", 3))
+
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected bytes.Buffer
+	expected.WriteString("MESSAGE=slow query\nPRIORITY=4\nSYSLOG_IDENTIFIER=test\nREQUEST_ID=abc\nDB_QUERY\n")
+	binary.Write(&expected, binary.LittleEndian, uint64(len("select 1\nfrom t")))
+	expected.WriteString("select 1\nfrom t\nDB_STATS_ROWS=3\n")
+	if got := buf[:n]; !bytes.Equal(got, expected.Bytes()) {
+		t.Errorf("got %q\nexpected %q", got, expected.Bytes())
+	}
+}
+
+func TestSyslogHandler(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no syslog")
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	h, err := newSyslogHandler(slog.LevelInfo, "test", "udp!"+conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	slog.New(h).Error("oh no", "err", "disk full", "n", 2)
+
+	buf := make([]byte, 4096)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(buf[:n])
+	// LOG_DAEMON|LOG_ERR = 3<<3|3
+	if !strings.HasPrefix(got, "<27>") || !strings.HasSuffix(strings.TrimSpace(got), `]: oh no err="disk full" n=2`) {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestJournalFieldName(t *testing.T) {
+	for key, expected := range map[string]string{
+		"request_id": "REQUEST_ID",
+		"db.query":   "DB_QUERY",
+		"_hidden":    "HIDDEN",
+		"2fa":        "F_2FA",
+	} {
+		if got := journalFieldName(key); got != expected {
+			t.Errorf("%q: got %q, expected %q", key, got, expected)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+//go:build darwin || freebsd || linux || netbsd || openbsd
+// +build darwin freebsd linux netbsd openbsd
+
+package gas
+
+import (
+	"log/slog"
+	"log/syslog"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// newSyslogHandler makes a handler sending records to the local syslog
+// daemon, or the one at addr ("network!address") if it isn't empty.
+func newSyslogHandler(level slog.Leveler, tag, addr string) (slog.Handler, error) {
+	var network string
+	if addr != "" {
+		network = "udp"
+		if i := strings.Index(addr, "!"); i >= 0 {
+			network, addr = addr[:i], addr[i+1:]
+		}
+	}
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, errors.Wrap(err, "syslog")
+	}
+
+	return &fieldHandler{
+		level: level,
+		emit: func(r slog.Record, attrs []slog.Attr) error {
+			msg := formatFields(r.Message, attrs)
+			switch syslogPriority(r.Level) {
+			case 3:
+				return w.Err(msg)
+			case 4:
+				return w.Warning(msg)
+			case 6:
+				return w.Info(msg)
+			}
+			return w.Debug(msg)
+		},
+	}, nil
+}
@@ -0,0 +1,11 @@
+package gas
+
+import (
+	"log/slog"
+
+	"github.com/pkg/errors"
+)
+
+func newSyslogHandler(level slog.Leveler, tag, addr string) (slog.Handler, error) {
+	return nil, errors.New("syslog is not supported on windows")
+}
@@ -0,0 +1,179 @@
+package gas
+
+// logfile.go writes the logs to a file that is rotated when it gets too big
+// or too old.
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// the suffix given to the old log files, which sorts in order of age
+const logFileTimeFormat = "20060102-150405.000000000"
+
+// logFile is an io.Writer appending to a file at path, which moves the file
+// aside and starts a new one once it reaches maxSize bytes or has been open
+// for interval (if they're nonzero). The old files are named after the time
+// they were rotated, are optionally gzipped, and only the newest keep are
+// kept (unless keep is zero).
+type logFile struct {
+	path     string
+	maxSize  int64
+	interval time.Duration
+	compress bool
+	keep     int
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+
+	// rotated files waiting to be compressed, which happens in the
+	// background along with pruning, one batch at a time and in order
+	pending []string
+	bg      sync.Mutex
+	wg      sync.WaitGroup
+}
+
+func openLogFile(path string, maxSize int64, interval time.Duration, compress bool, keep int) (*logFile, error) {
+	lf := &logFile{
+		path:     path,
+		maxSize:  maxSize,
+		interval: interval,
+		compress: compress,
+		keep:     keep,
+	}
+	if err := lf.open(); err != nil {
+		return nil, err
+	}
+	return lf, nil
+}
+
+func (lf *logFile) open() error {
+	f, err := os.OpenFile(lf.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "log file")
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return errors.Wrap(err, "log file")
+	}
+	lf.f = f
+	lf.size = fi.Size()
+	lf.opened = time.Now()
+	return nil
+}
+
+func (lf *logFile) Write(p []byte) (int, error) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	full := lf.maxSize > 0 && lf.size > 0 && lf.size+int64(len(p)) > lf.maxSize
+	old := lf.interval > 0 && time.Since(lf.opened) >= lf.interval
+	if full || old {
+		if err := lf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := lf.f.Write(p)
+	lf.size += int64(n)
+	return n, err
+}
+
+// rotate moves the current file aside and opens a new one. The caller holds
+// lf.mu.
+func (lf *logFile) rotate() error {
+	if err := lf.f.Close(); err != nil {
+		return errors.Wrap(err, "log file")
+	}
+	rotated := lf.path + "." + time.Now().Format(logFileTimeFormat)
+	if err := os.Rename(lf.path, rotated); err != nil {
+		return errors.Wrap(err, "log file")
+	}
+	if err := lf.open(); err != nil {
+		return err
+	}
+
+	lf.pending = append(lf.pending, rotated)
+	lf.wg.Add(1)
+	go lf.cleanup()
+	return nil
+}
+
+// cleanup compresses the pending rotated files and prunes the old ones.
+func (lf *logFile) cleanup() {
+	defer lf.wg.Done()
+	lf.bg.Lock()
+	defer lf.bg.Unlock()
+
+	lf.mu.Lock()
+	pending := lf.pending
+	lf.pending = nil
+	lf.mu.Unlock()
+
+	if lf.compress {
+		for _, name := range pending {
+			if err := compressFile(name); err != nil {
+				// can't very well log it to the file being rotated
+				os.Stderr.WriteString("gas: compressing " + name + ": " + err.Error() + "\n")
+			}
+		}
+	}
+	lf.prune()
+}
+
+// prune removes all but the newest keep rotated files.
+func (lf *logFile) prune() {
+	if lf.keep <= 0 {
+		return
+	}
+	old, _ := filepath.Glob(lf.path + ".*")
+	sort.Strings(old)
+	for i := 0; i < len(old)-lf.keep; i++ {
+		os.Remove(old[i])
+	}
+}
+
+// Close closes the current file after waiting for old ones to be done with.
+func (lf *logFile) Close() error {
+	lf.wg.Wait()
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	return lf.f.Close()
+}
+
+// compressFile replaces the file at path with a gzipped copy at path + ".gz".
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	_, err = io.Copy(gz, in)
+	if err == nil {
+		err = gz.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,83 @@
+package gas
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	lf, err := openLogFile(path, 10, 0, true, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n", "five\n"} {
+		if _, err := lf.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := lf.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "four\nfive\n" {
+		t.Errorf("current file: got %q", b)
+	}
+
+	old, _ := filepath.Glob(path + ".*")
+	if len(old) != 1 {
+		t.Fatalf("expected 1 old file to be kept, got %v", old)
+	}
+	var contents []string
+	for _, name := range old {
+		if !strings.HasSuffix(name, ".gz") {
+			t.Errorf("%s wasn't compressed", name)
+			continue
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(gz)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, string(b))
+	}
+	if s := strings.Join(contents, ""); s != "three\n" {
+		t.Errorf("old files: got %q", s)
+	}
+}
+
+func TestLogFileInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	lf, err := openLogFile(path, 0, time.Millisecond, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lf.Write([]byte("before\n"))
+	time.Sleep(5 * time.Millisecond)
+	lf.Write([]byte("after\n"))
+	lf.Close()
+
+	if b, _ := os.ReadFile(path); string(b) != "after\n" {
+		t.Errorf("current file: got %q", b)
+	}
+	if old, _ := filepath.Glob(path + ".*"); len(old) != 1 {
+		t.Errorf("expected 1 old file, got %v", old)
+	}
+}
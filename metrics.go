@@ -0,0 +1,271 @@
+package gas
+
+import (
+	"bufio"
+	"crypto/tls"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// connStats counts the connections made to one listener.
+type connStats struct {
+	open, accepted, closed, tlsErrors int64
+}
+
+// ListenerStats is a snapshot of the connection counts of a listener.
+type ListenerStats struct {
+	// The GAS_LISTEN entry of the listener
+	Listener string
+
+	// Connections currently open, and the total accepted and closed
+	// since startup. Hijacked connections (e.g. websockets, h2c) are
+	// counted as closed once the server hands them over.
+	Open     int64
+	Accepted int64
+	Closed   int64
+
+	// Connections that were closed before completing a TLS handshake
+	TLSHandshakeErrors int64
+}
+
+var (
+	statsMu    sync.Mutex
+	statsSpecs []*listenSpec
+)
+
+func init() {
+	expvar.Publish("gas.listeners", expvar.Func(func() interface{} {
+		return Stats()
+	}))
+}
+
+// Stats returns the connection counts of every listener being served by
+// Ignition. They are also published with package expvar as "gas.listeners".
+func Stats() []ListenerStats {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	stats := make([]ListenerStats, len(statsSpecs))
+	for i, spec := range statsSpecs {
+		stats[i] = ListenerStats{
+			Listener:           spec.String(),
+			Open:               atomic.LoadInt64(&spec.stats.open),
+			Accepted:           atomic.LoadInt64(&spec.stats.accepted),
+			Closed:             atomic.LoadInt64(&spec.stats.closed),
+			TLSHandshakeErrors: atomic.LoadInt64(&spec.stats.tlsErrors),
+		}
+	}
+	return stats
+}
+
+// trackStats makes the listeners in specs the ones reported by Stats.
+func trackStats(specs []*listenSpec) {
+	statsMu.Lock()
+	statsSpecs = specs
+	statsMu.Unlock()
+}
+
+// connState returns an http.Server.ConnState func which counts connections
+// for the listener each one came from, chaining to next if it isn't nil.
+func connState(next func(net.Conn, http.ConnState)) func(net.Conn, http.ConnState) {
+	return func(c net.Conn, state http.ConnState) {
+		if next != nil {
+			next(c, state)
+		}
+
+		raw := c
+		tc, isTLS := c.(*tls.Conn)
+		if isTLS {
+			raw = tc.NetConn()
+		}
+		sc, ok := raw.(specConn)
+		if !ok {
+			return
+		}
+		stats := &sc.spec.stats
+
+		switch state {
+		case http.StateNew:
+			atomic.AddInt64(&stats.accepted, 1)
+			atomic.AddInt64(&stats.open, 1)
+		case http.StateClosed, http.StateHijacked:
+			if isTLS && !tc.ConnectionState().HandshakeComplete {
+				atomic.AddInt64(&stats.tlsErrors, 1)
+			}
+			atomic.AddInt64(&stats.closed, 1)
+			atomic.AddInt64(&stats.open, -1)
+		}
+	}
+}
+
+// the upper bounds of the request duration (in seconds) and response size
+// buckets
+var (
+	durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	sizeBuckets     = []float64{100, 1000, 10000, 100000, 1e6, 1e7}
+)
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64 // of observations <= each bucket's bound
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(buckets []float64) histogram {
+	return histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+type routeKey struct {
+	method, route string
+}
+
+// routeMetrics are the counts of the requests for one method and route.
+type routeMetrics struct {
+	codes    map[int]uint64
+	duration histogram
+	size     histogram
+}
+
+var (
+	requestsMu       sync.Mutex
+	requestMetrics   = make(map[routeKey]*routeMetrics)
+	requestsInFlight int64
+)
+
+// whether requests are being counted, which is only while there's somewhere
+// to see the counts
+func metricsEnabled() bool {
+	return Env.MetricsPath != "" && Env.MetricsPath != "-"
+}
+
+// startMetrics counts g as in flight. The returned func adds it to the
+// metrics of its route once the response has been written.
+func startMetrics(g *Gas) func() {
+	now := time.Now()
+	atomic.AddInt64(&requestsInFlight, 1)
+
+	return func() {
+		atomic.AddInt64(&requestsInFlight, -1)
+		code := g.responseCode
+		if code == 0 {
+			code = http.StatusOK
+		}
+		key := routeKey{g.Method, g.pattern}
+
+		requestsMu.Lock()
+		defer requestsMu.Unlock()
+		m := requestMetrics[key]
+		if m == nil {
+			m = &routeMetrics{
+				codes:    make(map[int]uint64),
+				duration: newHistogram(durationBuckets),
+				size:     newHistogram(sizeBuckets),
+			}
+			requestMetrics[key] = m
+		}
+		m.codes[code]++
+		m.duration.observe(time.Since(now).Seconds())
+		m.size.observe(float64(g.written))
+	}
+}
+
+// writeMetrics writes out the request and listener metrics in the Prometheus
+// text format. Requests that didn't match a route have an empty route label.
+func writeMetrics(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	requestsMu.Lock()
+	keys := make([]routeKey, 0, len(requestMetrics))
+	for key := range requestMetrics {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		return keys[i].method < keys[j].method
+	})
+
+	fmt.Fprint(bw, "# HELP gas_http_requests_total Requests answered, by route and status code.\n")
+	fmt.Fprint(bw, "# TYPE gas_http_requests_total counter\n")
+	for _, key := range keys {
+		m := requestMetrics[key]
+		codes := make([]int, 0, len(m.codes))
+		for code := range m.codes {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			fmt.Fprintf(bw, "gas_http_requests_total{%s,code=\"%d\"} %d\n", key.labels(), code, m.codes[code])
+		}
+	}
+	writeHistograms(bw, "gas_http_request_duration_seconds", "How long requests took to answer, by route.", keys, func(m *routeMetrics) *histogram { return &m.duration })
+	writeHistograms(bw, "gas_http_response_size_bytes", "The size of response bodies, by route.", keys, func(m *routeMetrics) *histogram { return &m.size })
+	requestsMu.Unlock()
+
+	fmt.Fprint(bw, "# HELP gas_http_requests_in_flight Requests being answered.\n")
+	fmt.Fprint(bw, "# TYPE gas_http_requests_in_flight gauge\n")
+	fmt.Fprintf(bw, "gas_http_requests_in_flight %d\n", atomic.LoadInt64(&requestsInFlight))
+
+	stats := Stats()
+	for _, metric := range []struct {
+		name, typ, help string
+		value           func(ListenerStats) int64
+	}{
+		{"gas_listener_connections_open", "gauge", "Connections open, by listener.", func(s ListenerStats) int64 { return s.Open }},
+		{"gas_listener_connections_accepted_total", "counter", "Connections accepted, by listener.", func(s ListenerStats) int64 { return s.Accepted }},
+		{"gas_listener_connections_closed_total", "counter", "Connections closed, by listener.", func(s ListenerStats) int64 { return s.Closed }},
+		{"gas_listener_tls_handshake_errors_total", "counter", "Connections closed before completing a TLS handshake, by listener.", func(s ListenerStats) int64 { return s.TLSHandshakeErrors }},
+	} {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.typ)
+		for _, s := range stats {
+			fmt.Fprintf(bw, "%s{listener=\"%s\"} %d\n", metric.name, escapeLabel(s.Listener), metric.value(s))
+		}
+	}
+}
+
+func writeHistograms(bw *bufio.Writer, name, help string, keys []routeKey, get func(*routeMetrics) *histogram) {
+	fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for _, key := range keys {
+		h := get(requestMetrics[key])
+		labels := key.labels()
+		for i, bound := range h.buckets {
+			fmt.Fprintf(bw, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+		}
+		fmt.Fprintf(bw, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+		fmt.Fprintf(bw, "%s_sum{%s} %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(bw, "%s_count{%s} %d\n", name, labels, h.count)
+	}
+}
+
+func (k routeKey) labels() string {
+	return `method="` + escapeLabel(k.method) + `",route="` + escapeLabel(k.route) + `"`
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
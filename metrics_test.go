@@ -0,0 +1,113 @@
+package gas
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConnStats(t *testing.T) {
+	specs, err := parseListen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := specs[0]
+	l, err := spec.listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	srv.Listener.Close()
+	srv.Listener = specListener{l, spec}
+	srv.Config.ConnState = connState(nil)
+	srv.StartTLS()
+	defer srv.Close()
+	trackStats(specs)
+	defer trackStats(nil)
+
+	client := srv.Client()
+	client.Transport.(*http.Transport).DisableKeepAlives = true
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	// a client that doesn't trust the certificate gives up on the handshake
+	c, err := tls.Dial("tcp", srv.Listener.Addr().String(), &tls.Config{})
+	if err == nil {
+		c.Close()
+		t.Fatal("expected handshake to fail")
+	}
+
+	// a plain connection that just goes away
+	nc, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc.Close()
+
+	srv.CloseClientConnections()
+	srv.Close()
+
+	stats := Stats()
+	if len(stats) != 1 {
+		t.Fatalf("expected stats for 1 listener, got %d", len(stats))
+	}
+	expected := ListenerStats{
+		Listener:           spec.String(),
+		Open:               0,
+		Accepted:           5,
+		Closed:             5,
+		TLSHandshakeErrors: 2,
+	}
+	if stats[0] != expected {
+		t.Errorf("expected %+v, got %+v", expected, stats[0])
+	}
+}
+
+// resetMetrics forgets the requests counted so far, which are shared by every
+// router in the process.
+func resetMetrics() {
+	requestsMu.Lock()
+	requestMetrics = make(map[routeKey]*routeMetrics)
+	requestsMu.Unlock()
+}
+
+func TestRequestMetrics(t *testing.T) {
+	defer func(path string) { Env.MetricsPath = path }(Env.MetricsPath)
+	Env.MetricsPath = "/metrics"
+	resetMetrics()
+	defer resetMetrics()
+
+	r := New().Get("/users/{id}", func(g *Gas) (int, Outputter) {
+		g.Write([]byte("hello"))
+		return g.Stop()
+	})
+	h := r.healthHandler(r)
+	for _, path := range []string{"/users/1", "/users/2", "/nope"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		`gas_http_requests_total{method="GET",route="/users/{id}",code="200"} 2`,
+		`gas_http_requests_total{method="GET",route="",code="404"} 1`,
+		`gas_http_response_size_bytes_bucket{method="GET",route="/users/{id}",le="100"} 2`,
+		`gas_http_response_size_bytes_sum{method="GET",route="/users/{id}"} 10`,
+		`gas_http_request_duration_seconds_count{method="GET",route="/users/{id}"} 2`,
+		`gas_http_requests_in_flight 0`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected %s in:\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,476 @@
+package gas
+
+// notify.go is a small event bus: the framework publishes events such as
+// panics and finished requests, and any number of subscribers pick out the
+// types they're interested in.
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ktkr.us/pkg/gas/notify"
+)
+
+// RequestInfo describes the request an event happened during. It's copied out
+// of the *http.Request so subscribers can hold onto it after the request has
+// finished. Secrets in Header, e.g. cookies, are redacted as in captures.
+type RequestInfo struct {
+	Method     string
+	Host       string
+	Path       string
+	Query      string
+	Proto      string
+	RemoteAddr string
+	Header     http.Header
+	TLS        bool
+
+	// tag of the listener the request came in on; see Gas.Listener
+	Listener string
+
+	// the name given to Gas.SetUser, if any
+	User string
+
+	// see Gas.RequestID and Gas.Route
+	ID    string
+	Route string
+}
+
+// RequestInfo returns the description of g's request used in events.
+func (g *Gas) RequestInfo() RequestInfo {
+	return RequestInfo{
+		Method:     g.Method,
+		Host:       g.Host,
+		Path:       g.URL.Path,
+		Query:      g.URL.RawQuery,
+		Proto:      g.Proto,
+		RemoteAddr: g.RemoteAddr,
+		Header:     redactHeader(g.Request.Header),
+		TLS:        g.TLS != nil,
+		Listener:   g.Listener(),
+		User:       g.User(),
+		ID:         g.id,
+		Route:      g.pattern,
+	}
+}
+
+// Panic is published when a handler panics.
+type Panic struct {
+	Time    time.Time
+	Err     error
+	Request RequestInfo
+
+	// The formatted stack trace starting at the panicking call, the same
+	// calls broken down into frames, and the file, line, and lines of source
+	// code around it (where Source[Line] is the panicking one) if the source
+	// could be found.
+	Stack  string
+	Frames []runtime.Frame
+	File   string
+	Line   int
+	Source []string
+}
+
+// ServerError is published when a request is answered with a 5xx status,
+// unless it's because of a panic, which gets a Panic instead.
+type ServerError struct {
+	Time    time.Time // when the response was finished
+	Code    int
+	Request RequestInfo
+
+	// The error given to Gas.SetError, if any, and the stack where it was
+	// made if it has one from github.com/pkg/errors.
+	Err    error
+	Frames []runtime.Frame
+}
+
+// HTTPRequest is published when the router has finished serving a request.
+type HTTPRequest struct {
+	Time     time.Time // when the request started
+	Duration time.Duration
+	Code     int
+	Request  RequestInfo
+}
+
+// Overflow decides what happens to an event published to a subscriber whose
+// queue is full.
+type Overflow int
+
+const (
+	// DropNewest discards the event being published. This is the default,
+	// so that a slow subscriber can never hold up a request.
+	DropNewest Overflow = iota
+
+	// Block waits for the subscriber to make room. Only use this for
+	// subscribers that are guaranteed to keep up.
+	Block
+
+	// DropOldest discards the oldest event in the queue to make room, for
+	// subscribers that care most about what's happening right now.
+	DropOldest
+)
+
+// SubscribeOptions tune the delivery of events to a subscriber.
+type SubscribeOptions struct {
+	// How many events can be waiting to be handled. Defaults to 64.
+	QueueSize int
+
+	Overflow Overflow
+}
+
+// A Subscription is a func receiving events of one type, registered with
+// Subscribe.
+type Subscription struct {
+	typ      reflect.Type
+	fn       reflect.Value
+	overflow Overflow
+	queue    chan interface{}
+	done     chan struct{}
+
+	// held while publishing to queue, so that Close doesn't close it under
+	// a publisher that has already let go of subsMu
+	mu     sync.RWMutex
+	closed bool
+
+	// counters for Stats, updated atomically
+	published, delivered, dropped uint64
+	busySince                     int64 // UnixNano when the current call started, or 0
+}
+
+// SubscriberStats describe how a subscriber is keeping up with its events.
+type SubscriberStats struct {
+	Type      string // of the events it takes
+	Overflow  Overflow
+	QueueLen  int
+	QueueSize int
+
+	// Events queued for it, handled by it, and dropped because its queue
+	// was full
+	Published, Delivered, Dropped uint64
+
+	// How long it's been handling the current event, if it is
+	Busy time.Duration
+
+	// Whether Busy is more than SUBSCRIBER_STALL_TIMEOUT
+	Stalled bool
+}
+
+var (
+	subsMu sync.RWMutex
+	subs   []*Subscription
+)
+
+// Subscribe registers fn to be called with every published event it can take.
+// fn must be a func with a single argument, such as func(*gas.Panic). If the
+// argument is an interface type, fn receives every event that implements it,
+// so func(interface{}) receives everything.
+//
+// fn is called on a goroutine of its own, one event at a time, in the order
+// they were published. Subscribe panics if fn isn't a func of one argument.
+func Subscribe(fn interface{}) *Subscription {
+	return SubscribeWith(fn, SubscribeOptions{})
+}
+
+// SubscribeWith is like Subscribe, with control over how events are queued.
+func SubscribeWith(fn interface{}, opts SubscribeOptions) *Subscription {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.Type().NumIn() != 1 {
+		panic("gas: Subscribe: expected func with one argument, got " + reflect.TypeOf(fn).String())
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 64
+	}
+
+	s := &Subscription{
+		typ:      v.Type().In(0),
+		fn:       v,
+		overflow: opts.Overflow,
+		queue:    make(chan interface{}, opts.QueueSize),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+
+	subsMu.Lock()
+	subs = append(subs[:len(subs):len(subs)], s)
+	subsMu.Unlock()
+	return s
+}
+
+func (s *Subscription) run() {
+	defer close(s.done)
+	for event := range s.queue {
+		atomic.StoreInt64(&s.busySince, time.Now().UnixNano())
+		s.call(event)
+		atomic.StoreInt64(&s.busySince, 0)
+		atomic.AddUint64(&s.delivered, 1)
+	}
+}
+
+// Stats returns the current state of s's queue.
+func (s *Subscription) Stats() SubscriberStats {
+	stats := SubscriberStats{
+		Type:      s.typ.String(),
+		Overflow:  s.overflow,
+		QueueLen:  len(s.queue),
+		QueueSize: cap(s.queue),
+		Published: atomic.LoadUint64(&s.published),
+		Delivered: atomic.LoadUint64(&s.delivered),
+		Dropped:   atomic.LoadUint64(&s.dropped),
+	}
+	if since := atomic.LoadInt64(&s.busySince); since != 0 {
+		stats.Busy = time.Since(time.Unix(0, since))
+		stats.Stalled = Env.SubscriberStallTimeout > 0 && stats.Busy > Env.SubscriberStallTimeout
+	}
+	return stats
+}
+
+// Subscribers returns the stats of every subscription. They are also
+// published with package expvar as "gas.subscribers" and included in the
+// report at STATUS_PATH.
+func Subscribers() []SubscriberStats {
+	subsMu.RLock()
+	defer subsMu.RUnlock()
+	stats := make([]SubscriberStats, len(subs))
+	for i, s := range subs {
+		stats[i] = s.Stats()
+	}
+	return stats
+}
+
+// StalledSubscribers returns the stats of the subscribers that have been
+// stuck on one event for longer than SUBSCRIBER_STALL_TIMEOUT.
+func StalledSubscribers() []SubscriberStats {
+	var stalled []SubscriberStats
+	for _, stats := range Subscribers() {
+		if stats.Stalled {
+			stalled = append(stalled, stats)
+		}
+	}
+	return stalled
+}
+
+func init() {
+	expvar.Publish("gas.subscribers", expvar.Func(func() interface{} {
+		return Subscribers()
+	}))
+}
+
+// call delivers one event, keeping a panicking subscriber from taking the
+// whole process down with it
+func (s *Subscription) call(event interface{}) {
+	defer func() {
+		if nuke := recover(); nuke != nil {
+			Logger().Error("notify: subscriber panicked", "subscriber", s.fn.Type(), "err", nuke)
+		}
+	}()
+	s.fn.Call([]reflect.Value{reflect.ValueOf(event)})
+}
+
+// Close unsubscribes s. Events that were already queued are still delivered
+// before Close returns, so it must not be called from the subscriber itself.
+func (s *Subscription) Close() {
+	subsMu.Lock()
+	for i, sub := range subs {
+		if sub == s {
+			subs = append(subs[:i:i], subs[i+1:]...)
+			break
+		}
+	}
+	subsMu.Unlock()
+
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+	<-s.done
+}
+
+// Publish sends event to every subscriber that takes its type. It doesn't
+// wait for the event to be handled.
+func Publish(event interface{}) {
+	if event == nil {
+		return
+	}
+	typ := reflect.TypeOf(event)
+
+	// subs is only ever replaced, never changed in place, so a Block
+	// subscriber that's behind doesn't hold up Subscribe and Close for
+	// everyone else
+	subsMu.RLock()
+	list := subs
+	subsMu.RUnlock()
+	for _, s := range list {
+		if !typ.AssignableTo(s.typ) {
+			continue
+		}
+		s.enqueue(event)
+	}
+}
+
+func (s *Subscription) enqueue(event interface{}) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	atomic.AddUint64(&s.published, 1)
+	switch s.overflow {
+	case Block:
+		s.queue <- event
+		return
+	case DropOldest:
+		for {
+			select {
+			case s.queue <- event:
+				return
+			default:
+			}
+			select {
+			case <-s.queue:
+				atomic.AddUint64(&s.dropped, 1)
+			default:
+			}
+		}
+	}
+	select {
+	case s.queue <- event:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// subscribed reports whether anything would receive an event of type typ, so
+// that callers can skip building events nobody is listening for.
+func subscribed(typ reflect.Type) bool {
+	subsMu.RLock()
+	defer subsMu.RUnlock()
+	for _, s := range subs {
+		if typ.AssignableTo(s.typ) {
+			return true
+		}
+	}
+	return false
+}
+
+// A Notifiable event can be sent to the sinks in package notify with Forward.
+type Notifiable interface {
+	NotifyEvent() *notify.Event
+}
+
+// NotifyEvent makes p into a "panic" event.
+func (p *Panic) NotifyEvent() *notify.Event {
+	e := &notify.Event{
+		Kind:    "panic",
+		Time:    p.Time,
+		Title:   "panic: " + p.Err.Error(),
+		Error:   p.Err.Error(),
+		Request: p.Request.NotifyRequest(),
+		User:    p.Request.User,
+		Fields:  p.Request.notifyFields(),
+		Frames:  notifyFrames(p.Frames),
+	}
+	if p.File != "" {
+		e.Fields["file"] = p.File + ":" + strconv.Itoa(p.Line)
+	}
+	return e
+}
+
+// NotifyEvent makes e into a "server_error" event.
+func (e *ServerError) NotifyEvent() *notify.Event {
+	ne := &notify.Event{
+		Kind:    "server_error",
+		Time:    e.Time,
+		Title:   fmt.Sprintf("%d %s: %s %s", e.Code, http.StatusText(e.Code), e.Request.Method, e.Request.Path),
+		Request: e.Request.NotifyRequest(),
+		User:    e.Request.User,
+		Fields:  e.Request.notifyFields(),
+		Frames:  notifyFrames(e.Frames),
+	}
+	ne.Fields["status"] = strconv.Itoa(e.Code)
+	if e.Err != nil {
+		ne.Error = e.Err.Error()
+	}
+	return ne
+}
+
+// the fields of an event describing the request info, for the sinks to show
+func (info RequestInfo) notifyFields() map[string]string {
+	fields := map[string]string{}
+	if info.ID != "" {
+		fields["request_id"] = info.ID
+	}
+	if info.Route != "" {
+		fields["route"] = info.Route
+	}
+	if info.Listener != "" {
+		fields["listener"] = info.Listener
+	}
+	return fields
+}
+
+func notifyFrames(frames []runtime.Frame) []notify.Frame {
+	var nf []notify.Frame
+	for _, f := range frames {
+		nf = append(nf, notify.Frame{
+			Function: f.Function,
+			File:     f.File,
+			Line:     f.Line,
+		})
+	}
+	return nf
+}
+
+// NotifyRequest converts info for use in a notify.Event.
+func (info RequestInfo) NotifyRequest() *notify.Request {
+	u := url.URL{
+		Scheme:   "http",
+		Host:     info.Host,
+		Path:     info.Path,
+		RawQuery: info.Query,
+	}
+	if info.TLS {
+		u.Scheme = "https"
+	}
+	return &notify.Request{
+		Method:     info.Method,
+		URL:        u.String(),
+		Header:     info.Header,
+		RemoteAddr: info.RemoteAddr,
+	}
+}
+
+// Forward sends every Notifiable event published from now on to sink, or only
+// the ones of the given kinds if there are any. Errors from the sink are
+// logged.
+func Forward(sink notify.Sink, kinds ...string) *Subscription {
+	return Subscribe(func(n Notifiable) {
+		e := n.NotifyEvent()
+		if len(kinds) > 0 {
+			found := false
+			for _, kind := range kinds {
+				found = found || kind == e.Kind
+			}
+			if !found {
+				return
+			}
+		}
+		if err := notify.Send(sink, e); err != nil {
+			Logger().Error("notify", "kind", e.Kind, "err", err)
+		}
+	})
+}
+
+var (
+	panicType       = reflect.TypeOf((*Panic)(nil))
+	serverErrorType = reflect.TypeOf((*ServerError)(nil))
+	httpRequestType = reflect.TypeOf((*HTTPRequest)(nil))
+)
@@ -0,0 +1,120 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// Chat is a Sink that posts events as short readable messages to a Slack or
+// Discord incoming webhook (or anything that takes the same payloads).
+type Chat struct {
+	URL string
+
+	// "slack" or "discord". If it's empty, discord is assumed for URLs on
+	// discord.com or discordapp.com and slack for anything else.
+	Flavor string
+
+	// The client to deliver with. Defaults to one with a 10 second timeout.
+	Client *http.Client
+}
+
+// how many stack frames end up in a chat message
+const chatFrames = 5
+
+// Send posts a message about e, retrying like a Webhook.
+func (c *Chat) Send(e *Event) error {
+	flavor := c.Flavor
+	if flavor == "" {
+		flavor = "slack"
+		if u, err := url.Parse(c.URL); err == nil {
+			host := strings.TrimPrefix(u.Hostname(), "www.")
+			if host == "discord.com" || host == "discordapp.com" {
+				flavor = "discord"
+			}
+		}
+	}
+
+	var payload interface{}
+	switch flavor {
+	case "slack":
+		payload = struct {
+			Text string `json:"text"`
+		}{chatMessage(e, "*", 0)}
+	case "discord":
+		// Discord refuses messages longer than 2000 characters
+		payload = struct {
+			Content string `json:"content"`
+		}{chatMessage(e, "**", 2000)}
+	default:
+		return errors.Errorf("chat: unknown flavor %q", flavor)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "chat")
+	}
+	return (&Webhook{URL: c.URL, Client: c.Client}).deliver(e.Kind, body)
+}
+
+// chatMessage formats e in the markdown both Slack and Discord understand,
+// apart from bold, keeping it within limit characters if limit isn't zero
+func chatMessage(e *Event, bold string, limit int) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s%s%s on `%s`\n", bold, e.Title, bold, e.Host)
+	if e.Request != nil {
+		fmt.Fprintf(&b, "> %s %s\n", e.Request.Method, e.Request.URL)
+	}
+	if route := e.Fields["route"]; route != "" {
+		fmt.Fprintf(&b, "> route `%s`\n", route)
+	}
+	if e.User != "" {
+		fmt.Fprintf(&b, "> user %s\n", e.User)
+	}
+	if e.Error != "" && !strings.Contains(e.Title, e.Error) {
+		fmt.Fprintf(&b, "> %s\n", e.Error)
+	}
+
+	if len(e.Frames) > 0 {
+		var stack strings.Builder
+		for i, f := range e.Frames {
+			if i == chatFrames {
+				break
+			}
+			fmt.Fprintf(&stack, "%s\n    %s:%d\n", f.Function, f.File, f.Line)
+		}
+		s := stack.String()
+		if room := limit - b.Len() - len("```\n```"); limit > 0 && len(s) > room {
+			if room < 0 {
+				room = 0
+			}
+			s = truncate(s, room)
+		}
+		if s != "" {
+			b.WriteString("```\n" + s + "```")
+		}
+	}
+
+	msg := b.String()
+	if limit > 0 && len(msg) > limit {
+		msg = truncate(msg, limit)
+	}
+	return msg
+}
+
+// truncate cuts s down to at most n bytes without splitting a character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
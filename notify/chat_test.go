@@ -0,0 +1,47 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChat(t *testing.T) {
+	var got map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		json.NewDecoder(req.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	e := &Event{
+		Kind:    "panic",
+		Host:    "web1",
+		Title:   "panic: lol",
+		Error:   "lol",
+		Request: &Request{Method: "GET", URL: "https://example.com/x"},
+		Frames:  []Frame{{"main.handler", "/src/main.go", 12}},
+	}
+
+	if err := Send(&Chat{URL: srv.URL}, e); err != nil {
+		t.Fatal(err)
+	}
+	text := got["text"]
+	for _, s := range []string{"*panic: lol* on `web1`", "GET https://example.com/x", "main.handler", "/src/main.go:12"} {
+		if !strings.Contains(text, s) {
+			t.Errorf("expected %q in slack message %q", s, text)
+		}
+	}
+
+	e.Frames = make([]Frame, 100)
+	for i := range e.Frames {
+		e.Frames[i] = Frame{strings.Repeat("x", 500), "f.go", i}
+	}
+	if err := Send(&Chat{URL: srv.URL, Flavor: "discord"}, e); err != nil {
+		t.Fatal(err)
+	}
+	if content := got["content"]; !strings.HasPrefix(content, "**panic: lol**") || len(content) > 2000 {
+		t.Errorf("unexpected discord message of length %d: %q", len(content), content[:50])
+	}
+}
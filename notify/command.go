@@ -0,0 +1,55 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Command is a Sink that runs a shell command for each event, for alerting
+// through whatever isn't covered by the other sinks. The event is given to
+// the command as JSON on its standard input, and its kind, title, error, and
+// host in the environment variables GAS_EVENT_KIND, GAS_EVENT_TITLE,
+// GAS_EVENT_ERROR, and GAS_EVENT_HOST.
+type Command struct {
+	Command string
+
+	// How long the command may run before it's killed. Defaults to 30
+	// seconds.
+	Timeout time.Duration
+}
+
+// Send runs the command for e, failing if it exits unsuccessfully.
+func (c *Command) Send(e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "command")
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", c.Command)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"GAS_EVENT_KIND="+e.Kind,
+		"GAS_EVENT_TITLE="+e.Title,
+		"GAS_EVENT_ERROR="+e.Error,
+		"GAS_EVENT_HOST="+e.Host,
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if len(out) > 0 {
+			return errors.Wrapf(err, "command: %s", bytes.TrimSpace(out))
+		}
+		return errors.Wrap(err, "command")
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package notify
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestCommand(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "event")
+	c := &Command{Command: `cat > ` + out + ` && test "$GAS_EVENT_KIND" = task_death`}
+	if err := Send(c, &Event{Kind: "task_death", Title: "task web died"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Event
+	if err = json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Kind != "task_death" || got.Title != "task web died" || got.Host == "" {
+		t.Errorf("unexpected payload %+v", got)
+	}
+
+	// the command failing fails the send
+	c.Command = `echo nope; exit 3`
+	if err := Send(c, &Event{Kind: "panic"}); err == nil {
+		t.Error("expected error for failing command")
+	}
+}
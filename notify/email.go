@@ -0,0 +1,115 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Email is a Sink that sends events by email, either one message per event
+// or a digest of everything that happened in a given period.
+type Email struct {
+	// The SMTP server as host:port, and the credentials to log in with
+	// through PLAIN authentication, if any. Go's SMTP client only sends
+	// them over TLS or to localhost.
+	Addr     string
+	Username string
+	Password string
+
+	From string
+	To   []string
+
+	// If nonzero, events are collected and sent together at most this
+	// often instead of right away.
+	Digest time.Duration
+
+	mu      sync.Mutex
+	pending []*Event
+	timer   *time.Timer
+
+	// replaced in tests
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// Send emails e, or queues it up for the next digest.
+func (m *Email) Send(e *Event) error {
+	if m.Digest <= 0 {
+		return m.send("[gas] "+e.Title, e.Details(50))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending = append(m.pending, e)
+	if m.timer == nil {
+		m.timer = time.AfterFunc(m.Digest, func() {
+			if err := m.Flush(); err != nil {
+				logError("notify: email digest", err)
+			}
+		})
+	}
+	return nil
+}
+
+// Flush sends a digest of the events waiting for one right away, if there
+// are any.
+func (m *Email) Flush() error {
+	m.mu.Lock()
+	events := m.pending
+	m.pending = nil
+	if m.timer != nil {
+		m.timer.Stop()
+		m.timer = nil
+	}
+	m.mu.Unlock()
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	var body strings.Builder
+	for i, e := range events {
+		if i > 0 {
+			body.WriteString("\n" + strings.Repeat("-", 72) + "\n\n")
+		}
+		body.WriteString(e.Details(10))
+	}
+	subject := fmt.Sprintf("[gas] %d events on %s", len(events), events[0].Host)
+	if len(events) == 1 {
+		subject = "[gas] " + events[0].Title
+	}
+	return m.send(subject, body.String())
+}
+
+func (m *Email) send(subject, body string) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", m.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		host := m.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	sendMail := m.sendMail
+	if sendMail == nil {
+		sendMail = smtp.SendMail
+	}
+	if err := sendMail(m.Addr, auth, m.From, m.To, msg.Bytes()); err != nil {
+		return fmt.Errorf("email: %v", err)
+	}
+	return nil
+}
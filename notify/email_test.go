@@ -0,0 +1,52 @@
+package notify
+
+import (
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmail(t *testing.T) {
+	var sent []string
+	m := &Email{
+		Addr: "mail.example.com:587",
+		From: "gas@example.com",
+		To:   []string{"ops@example.com", "dev@example.com"},
+		sendMail: func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+			if addr != "mail.example.com:587" || from != "gas@example.com" || len(to) != 2 {
+				t.Errorf("unexpected envelope %s %s %v", addr, from, to)
+			}
+			sent = append(sent, string(msg))
+			return nil
+		},
+	}
+
+	e := &Event{Kind: "task_death", Title: "task web died", Error: "exit status 1", Time: time.Now(), Host: "box"}
+	if err := m.Send(e); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 1 || !strings.Contains(sent[0], "Subject: [gas] task web died\r\n") || !strings.Contains(sent[0], "exit status 1") {
+		t.Fatalf("unexpected message %q", sent)
+	}
+
+	sent = nil
+	m.Digest = time.Hour
+	for i := 0; i < 3; i++ {
+		if err := m.Send(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(sent) != 0 {
+		t.Fatalf("expected digest to wait, got %d messages", len(sent))
+	}
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 1 || !strings.Contains(sent[0], "Subject: [gas] 3 events on box\r\n") {
+		t.Fatalf("unexpected digest %q", sent)
+	}
+	if strings.Count(sent[0], "task web died") != 3 {
+		t.Errorf("expected all 3 events in digest")
+	}
+}
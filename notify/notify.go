@@ -0,0 +1,141 @@
+// Package notify delivers alerts about events such as panics and dying tasks
+// to outside services. It doesn't depend on package gas so that the process
+// manager can use it as well; gas.Forward connects sinks to the event bus of a
+// running server.
+package notify
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// An Event is something that somebody should probably hear about.
+type Event struct {
+	// What kind of event this is, e.g. "panic", so that sinks can be
+	// limited to the kinds they care about.
+	Kind string `json:"kind"`
+
+	Time time.Time `json:"time"`
+
+	// The machine the event happened on. Filled in by Send if it's empty.
+	Host string `json:"host"`
+
+	// A one line summary, and the error message behind it, if any
+	Title string `json:"title"`
+	Error string `json:"error,omitempty"`
+
+	// The stack where the error happened, innermost call first
+	Frames []Frame `json:"frames,omitempty"`
+
+	// The HTTP request being served, if there was one, and the user making it
+	Request *Request `json:"request,omitempty"`
+	User    string   `json:"user,omitempty"`
+
+	// Anything else worth knowing
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// A Frame is one call in a stack trace.
+type Frame struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+}
+
+// Request describes an HTTP request an event happened during.
+type Request struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	Header     http.Header `json:"header,omitempty"`
+	RemoteAddr string      `json:"remote_addr,omitempty"`
+}
+
+// A Sink sends events somewhere. Send may be called from multiple goroutines
+// at once.
+type Sink interface {
+	Send(e *Event) error
+}
+
+// Send sends e to sink after filling in the fields that can be worked out on
+// the spot.
+func Send(sink Sink, e *Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Host == "" {
+		e.Host = hostname
+	}
+	return sink.Send(e)
+}
+
+var hostname, _ = os.Hostname()
+
+var logger atomic.Pointer[slog.Logger]
+
+// SetLogger sets the logger for errors that happen outside of any call to
+// Send, such as a failed email digest. gas.SetLogger also sets it. The default
+// is slog.Default.
+func SetLogger(l *slog.Logger) {
+	logger.Store(l)
+}
+
+func logError(msg string, err error) {
+	l := logger.Load()
+	if l == nil {
+		l = slog.Default()
+	}
+	l.Error(msg, "err", err)
+}
+
+// Details formats e as plain text for people to read, including at most
+// frames lines of its stack trace.
+func (e *Event) Details(frames int) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s\n\n", e.Title)
+	fmt.Fprintf(&b, "Kind:  %s\n", e.Kind)
+	fmt.Fprintf(&b, "Time:  %s\n", e.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Host:  %s\n", e.Host)
+	if e.Error != "" {
+		fmt.Fprintf(&b, "Error: %s\n", e.Error)
+	}
+	if e.User != "" {
+		fmt.Fprintf(&b, "User:  %s\n", e.User)
+	}
+	if e.Request != nil {
+		fmt.Fprintf(&b, "Request: %s %s from %s\n", e.Request.Method, e.Request.URL, e.Request.RemoteAddr)
+	}
+
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		// the likes of log excerpts go on lines of their own
+		if v := e.Fields[k]; strings.Contains(v, "\n") {
+			fmt.Fprintf(&b, "%s:\n    %s\n", k, strings.ReplaceAll(strings.TrimRight(v, "\n"), "\n", "\n    "))
+		} else {
+			fmt.Fprintf(&b, "%s: %s\n", k, v)
+		}
+	}
+
+	if len(e.Frames) > 0 && frames > 0 {
+		b.WriteString("\nStack:\n")
+		for i, f := range e.Frames {
+			if i == frames {
+				fmt.Fprintf(&b, "    ... %d more\n", len(e.Frames)-i)
+				break
+			}
+			fmt.Fprintf(&b, "    %s\n        %s:%d\n", f.Function, f.File, f.Line)
+		}
+	}
+
+	return b.String()
+}
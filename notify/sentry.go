@@ -0,0 +1,235 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	mrand "math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Sentry is a Sink that reports events to Sentry, or anything else that
+// accepts events with the same API, as errors.
+type Sentry struct {
+	// Tags attached to every event
+	Release     string
+	Environment string
+
+	// The fraction of events to send, between 0 and 1. Zero sends all of
+	// them.
+	SampleRate float64
+
+	// The client to send events with. Defaults to one with a 10 second
+	// timeout.
+	Client *http.Client
+
+	endpoint string
+	auth     string
+}
+
+// NewSentry returns a Sentry sink for the project identified by dsn, which
+// looks like "https://<public key>@<host>/<project id>".
+func NewSentry(dsn string) (*Sentry, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, errors.Wrap(err, "sentry: DSN")
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, errors.New("sentry: DSN has no public key")
+	}
+	i := strings.LastIndex(u.Path, "/")
+	project := u.Path[i+1:]
+	if project == "" {
+		return nil, errors.New("sentry: DSN has no project ID")
+	}
+
+	auth := "Sentry sentry_version=7, sentry_client=gas/1.0, sentry_key=" + u.User.Username()
+	if secret, ok := u.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+
+	endpoint := url.URL{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+		Path:   u.Path[:i] + "/api/" + project + "/store/",
+	}
+	return &Sentry{
+		Client:   defaultClient,
+		endpoint: endpoint.String(),
+		auth:     auth,
+	}, nil
+}
+
+type sentryFrame struct {
+	Function string `json:"function,omitempty"`
+	Filename string `json:"filename,omitempty"`
+	Lineno   int    `json:"lineno,omitempty"`
+	InApp    bool   `json:"in_app"`
+}
+
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Message     string            `json:"message,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Extra       map[string]string `json:"extra,omitempty"`
+	Exception   *struct {
+		Values []sentryException `json:"values"`
+	} `json:"exception,omitempty"`
+	Request *sentryRequest `json:"request,omitempty"`
+	User    *sentryUser    `json:"user,omitempty"`
+}
+
+type sentryException struct {
+	Type       string `json:"type"`
+	Value      string `json:"value"`
+	Stacktrace *struct {
+		Frames []sentryFrame `json:"frames"`
+	} `json:"stacktrace,omitempty"`
+}
+
+type sentryRequest struct {
+	URL     string            `json:"url"`
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+}
+
+type sentryUser struct {
+	Username  string `json:"username,omitempty"`
+	IPAddress string `json:"ip_address,omitempty"`
+}
+
+// Send reports e to Sentry, unless it's left out by sampling.
+func (s *Sentry) Send(e *Event) error {
+	if s.SampleRate > 0 && s.SampleRate < 1 && mrand.Float64() >= s.SampleRate {
+		return nil
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return errors.Wrap(err, "sentry")
+	}
+
+	se := &sentryEvent{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   e.Time.UTC().Format("2006-01-02T15:04:05"),
+		Level:       "error",
+		Platform:    "go",
+		Logger:      "gas",
+		ServerName:  e.Host,
+		Release:     s.Release,
+		Environment: s.Environment,
+		Message:     e.Title,
+		Tags:        map[string]string{"kind": e.Kind},
+		Extra:       e.Fields,
+	}
+
+	if e.Error != "" || len(e.Frames) > 0 {
+		ex := sentryException{Type: e.Kind, Value: e.Error}
+		if len(e.Frames) > 0 {
+			ex.Stacktrace = new(struct {
+				Frames []sentryFrame `json:"frames"`
+			})
+			// Sentry wants the outermost call first
+			for i := len(e.Frames) - 1; i >= 0; i-- {
+				f := e.Frames[i]
+				ex.Stacktrace.Frames = append(ex.Stacktrace.Frames, sentryFrame{
+					Function: f.Function,
+					Filename: f.File,
+					Lineno:   f.Line,
+					InApp:    inApp(f.Function),
+				})
+			}
+		}
+		se.Exception = &struct {
+			Values []sentryException `json:"values"`
+		}{[]sentryException{ex}}
+	}
+
+	var ip string
+	if req := e.Request; req != nil {
+		sr := &sentryRequest{
+			URL:     req.URL,
+			Method:  req.Method,
+			Headers: make(map[string]string, len(req.Header)),
+		}
+		for k, v := range req.Header {
+			// don't hand out anybody's credentials
+			if k == "Authorization" || k == "Cookie" {
+				continue
+			}
+			sr.Headers[k] = strings.Join(v, ", ")
+		}
+		if req.RemoteAddr != "" {
+			sr.Env = map[string]string{"REMOTE_ADDR": req.RemoteAddr}
+			ip = req.RemoteAddr
+			if i := strings.LastIndex(ip, ":"); i >= 0 {
+				ip = strings.Trim(ip[:i], "[]")
+			}
+		}
+		se.Request = sr
+	}
+	if e.User != "" || ip != "" {
+		se.User = &sentryUser{Username: e.User, IPAddress: ip}
+	}
+
+	body, err := json.Marshal(se)
+	if err != nil {
+		return errors.Wrap(err, "sentry")
+	}
+	req, err := http.NewRequest("POST", s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "sentry")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", s.auth)
+
+	client := s.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "sentry")
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("sentry: %s", resp.Status)
+	}
+	return nil
+}
+
+// whether a function belongs to the application rather than the runtime, the
+// standard library, or gas itself
+func inApp(function string) bool {
+	pkg := function
+	slash := strings.LastIndex(pkg, "/")
+	if dot := strings.Index(pkg[slash+1:], "."); dot >= 0 {
+		pkg = pkg[:slash+1+dot]
+	}
+	if pkg == "ktkr.us/pkg/gas" || strings.HasPrefix(pkg, "ktkr.us/pkg/gas/") {
+		return false
+	}
+	// standard library packages don't have a dot in their first element
+	first := pkg
+	if i := strings.Index(pkg, "/"); i >= 0 {
+		first = pkg[:i]
+	}
+	return pkg == "main" || strings.Contains(first, ".")
+}
@@ -0,0 +1,91 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewSentry(t *testing.T) {
+	s, err := NewSentry("https://abc123@sentry.example.com/prefix/42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.endpoint != "https://sentry.example.com/prefix/api/42/store/" {
+		t.Errorf("unexpected endpoint %q", s.endpoint)
+	}
+	if !strings.Contains(s.auth, "sentry_key=abc123") {
+		t.Errorf("unexpected auth header %q", s.auth)
+	}
+
+	for _, dsn := range []string{"https://sentry.example.com/42", "https://abc@sentry.example.com/", ":"} {
+		if _, err = NewSentry(dsn); err == nil {
+			t.Errorf("%q: expected error", dsn)
+		}
+	}
+}
+
+func TestSentrySend(t *testing.T) {
+	var got map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/1/store/" || !strings.HasPrefix(req.Header.Get("X-Sentry-Auth"), "Sentry ") {
+			t.Errorf("unexpected request %s with auth %q", req.URL, req.Header.Get("X-Sentry-Auth"))
+		}
+		json.NewDecoder(req.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	s, err := NewSentry(strings.Replace(srv.URL, "http://", "http://key@", 1) + "/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Release = "v1.2.3"
+	s.Environment = "prod"
+
+	err = Send(s, &Event{
+		Kind:  "panic",
+		Time:  time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Title: "panic: lol",
+		Error: "lol",
+		Frames: []Frame{
+			{"main.handler", "/src/main.go", 12},
+			{"net/http.HandlerFunc.ServeHTTP", "/go/src/net/http/server.go", 2000},
+		},
+		Request: &Request{
+			Method:     "GET",
+			URL:        "https://example.com/x",
+			Header:     http.Header{"Cookie": {"s=secret"}, "User-Agent": {"test"}},
+			RemoteAddr: "192.0.2.1:1234",
+		},
+		User: "alice",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got["release"] != "v1.2.3" || got["environment"] != "prod" || got["timestamp"] != "2020-01-02T03:04:05" {
+		t.Errorf("unexpected tags in %v", got)
+	}
+	if user := got["user"].(map[string]interface{}); user["username"] != "alice" || user["ip_address"] != "192.0.2.1" {
+		t.Errorf("unexpected user %v", user)
+	}
+	headers := got["request"].(map[string]interface{})["headers"].(map[string]interface{})
+	if _, ok := headers["Cookie"]; ok || headers["User-Agent"] != "test" {
+		t.Errorf("unexpected request headers %v", headers)
+	}
+	ex := got["exception"].(map[string]interface{})["values"].([]interface{})[0].(map[string]interface{})
+	frames := ex["stacktrace"].(map[string]interface{})["frames"].([]interface{})
+	if len(frames) != 2 {
+		t.Fatalf("expected 2 frames, got %v", frames)
+	}
+	last := frames[1].(map[string]interface{})
+	if last["function"] != "main.handler" || last["in_app"] != true {
+		t.Errorf("expected innermost frame last and in app, got %v", last)
+	}
+	if first := frames[0].(map[string]interface{}); first["in_app"] != false {
+		t.Errorf("expected standard library frame not to be in app, got %v", first)
+	}
+}
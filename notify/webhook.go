@@ -0,0 +1,116 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Webhook is a Sink that POSTs each event as JSON to a URL.
+//
+// If Secret is set, the request carries the header
+//
+//	X-Gas-Signature: sha256=<hex HMAC-SHA256 of the body keyed with Secret>
+//
+// so that the receiver can check where it came from. The kind of event is
+// also given in X-Gas-Event.
+type Webhook struct {
+	URL    string
+	Secret []byte
+
+	// How many more times to try after a failed delivery, and how long to
+	// wait before the first retry. The wait doubles after each one.
+	// Defaults to 3 retries starting at 1 second.
+	Retries int
+	Backoff time.Duration
+
+	// The client to deliver with. Defaults to one with a 10 second timeout.
+	Client *http.Client
+}
+
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// Send delivers e, retrying on network errors and responses that suggest
+// trying again later (5xx and 429). It only returns once it has succeeded
+// or given up.
+func (h *Webhook) Send(e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "webhook")
+	}
+	return h.deliver(e.Kind, body)
+}
+
+// deliver POSTs body until it succeeds or it's time to give up
+func (h *Webhook) deliver(kind string, body []byte) error {
+	retries, backoff := h.Retries, h.Backoff
+	if retries == 0 {
+		retries = 3
+	}
+	if backoff == 0 {
+		backoff = time.Second
+	}
+
+	for i := 0; ; i++ {
+		retry, err := h.post(kind, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || i >= retries {
+			return errors.Wrapf(err, "webhook %s", h.URL)
+		}
+		time.Sleep(backoff << uint(i))
+	}
+}
+
+// post makes a single delivery attempt, reporting whether a failure is worth
+// retrying
+func (h *Webhook) post(kind string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequest("POST", h.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gas-webhook/1.0")
+	req.Header.Set("X-Gas-Event", kind)
+	if len(h.Secret) > 0 {
+		req.Header.Set("X-Gas-Signature", "sha256="+Sign(h.Secret, body))
+	}
+
+	client := h.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("%s", resp.Status)
+	default:
+		return false, fmt.Errorf("%s", resp.Status)
+	}
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of body keyed with secret, as sent
+// in the X-Gas-Signature header of webhooks.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
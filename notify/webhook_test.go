@@ -0,0 +1,59 @@
+package notify
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhook(t *testing.T) {
+	var (
+		attempts int
+		got      Event
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		if sig := req.Header.Get("X-Gas-Signature"); sig != "sha256="+Sign([]byte("hunter2"), body) {
+			t.Errorf("bad signature %q", sig)
+		}
+		if kind := req.Header.Get("X-Gas-Event"); kind != "panic" {
+			t.Errorf("expected event kind panic, got %q", kind)
+		}
+		json.Unmarshal(body, &got)
+	}))
+	defer srv.Close()
+
+	h := &Webhook{URL: srv.URL, Secret: []byte("hunter2"), Backoff: time.Millisecond}
+	if err := Send(h, &Event{Kind: "panic", Title: "panic: lol"}); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts)
+	}
+	if got.Kind != "panic" || got.Title != "panic: lol" || got.Host == "" {
+		t.Errorf("unexpected payload %+v", got)
+	}
+
+	// client errors aren't retried
+	attempts = 0
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer bad.Close()
+	h.URL = bad.URL
+	if err := Send(h, &Event{Kind: "panic"}); err == nil {
+		t.Error("expected error for 400 response")
+	}
+	if attempts != 1 {
+		t.Errorf("expected a single attempt, got %d", attempts)
+	}
+}
@@ -0,0 +1,288 @@
+package gas
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"ktkr.us/pkg/gas/notify"
+)
+
+func TestNotify(t *testing.T) {
+	var (
+		panics   = make(chan *Panic, 1)
+		requests = make(chan *HTTPRequest, 1)
+		all      = make(chan interface{}, 10)
+	)
+	for _, s := range []*Subscription{
+		Subscribe(func(p *Panic) { panics <- p }),
+		Subscribe(func(r *HTTPRequest) { requests <- r }),
+		Subscribe(func(e interface{}) { all <- e }),
+	} {
+		defer s.Close()
+	}
+
+	r := New().Get("/ok", func(g *Gas) (int, Outputter) {
+		return 204, nil
+	}).Get("/panic", func(g *Gas) (int, Outputter) {
+		panic("lol")
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/ok", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/panic?x=1", nil))
+
+	select {
+	case req := <-requests:
+		if req.Code != 204 || req.Request.Path != "/ok" {
+			t.Errorf("unexpected request event: %+v", req)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no request event")
+	}
+	select {
+	case p := <-panics:
+		if p.Err == nil || p.Err.Error() != "lol" || p.Request.Query != "x=1" || p.Stack == "" {
+			t.Errorf("unexpected panic event: %+v", p)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no panic event")
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-all:
+		case <-time.After(time.Second):
+			t.Fatal("catch-all subscriber missed an event")
+		}
+	}
+}
+
+func TestNotifyOverflow(t *testing.T) {
+	type event struct{ n int }
+
+	var (
+		unblock = make(chan struct{})
+		got     []int
+	)
+	s := SubscribeWith(func(e event) {
+		<-unblock
+		got = append(got, e.n)
+	}, SubscribeOptions{QueueSize: 2})
+
+	// the first one is picked up right away and then blocks the subscriber,
+	// so only two more fit in the queue
+	Publish(event{0})
+	time.Sleep(10 * time.Millisecond)
+	for i := 1; i <= 5; i++ {
+		Publish(event{i})
+	}
+	close(unblock)
+	s.Close()
+
+	if len(got) != 3 || got[0] != 0 || got[1] != 1 || got[2] != 2 {
+		t.Errorf("expected to receive [0 1 2] and drop the rest, got %v", got)
+	}
+}
+
+func TestBlockOverflow(t *testing.T) {
+	type event struct{ n int }
+
+	var (
+		unblock = make(chan struct{})
+		got     []int
+	)
+	s := SubscribeWith(func(e event) {
+		<-unblock
+		got = append(got, e.n)
+	}, SubscribeOptions{QueueSize: 1, Overflow: Block})
+
+	published := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			Publish(event{i})
+		}
+		close(published)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// the publisher waiting on s mustn't keep anyone else from subscribing
+	// or unsubscribing
+	done := make(chan struct{})
+	go func() {
+		Subscribe(func(event) {}).Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Subscribe and Close were held up by a blocked publisher")
+	}
+
+	close(unblock)
+	<-published
+	s.Close()
+	if len(got) != 3 {
+		t.Errorf("expected to receive all 3 events, got %v", got)
+	}
+}
+
+func TestDropOldest(t *testing.T) {
+	defer func(d time.Duration) { Env.SubscriberStallTimeout = d }(Env.SubscriberStallTimeout)
+	Env.SubscriberStallTimeout = 5 * time.Millisecond
+
+	type event struct{ n int }
+
+	var (
+		unblock = make(chan struct{})
+		got     []int
+	)
+	s := SubscribeWith(func(e event) {
+		<-unblock
+		got = append(got, e.n)
+	}, SubscribeOptions{QueueSize: 2, Overflow: DropOldest})
+
+	Publish(event{0})
+	time.Sleep(10 * time.Millisecond)
+	for i := 1; i <= 5; i++ {
+		Publish(event{i})
+	}
+
+	stats := s.Stats()
+	if stats.Published != 6 || stats.Dropped != 3 || stats.QueueLen != 2 || stats.QueueSize != 2 || !stats.Stalled {
+		t.Errorf("got stats %+v", stats)
+	}
+	found := false
+	for _, stalled := range StalledSubscribers() {
+		found = found || stalled.Type == stats.Type
+	}
+	if !found {
+		t.Errorf("subscriber missing from StalledSubscribers")
+	}
+
+	close(unblock)
+	s.Close()
+
+	if len(got) != 3 || got[0] != 0 || got[1] != 4 || got[2] != 5 {
+		t.Errorf("expected to receive [0 4 5] and drop the rest, got %v", got)
+	}
+	if stats := s.Stats(); stats.Delivered != 3 || stats.Stalled {
+		t.Errorf("got stats %+v after unblocking", stats)
+	}
+}
+
+type sinkFunc func(e *notify.Event) error
+
+func (f sinkFunc) Send(e *notify.Event) error { return f(e) }
+
+func TestForward(t *testing.T) {
+	events := make(chan *notify.Event, 1)
+	s := Forward(sinkFunc(func(e *notify.Event) error {
+		events <- e
+		return nil
+	}), "panic")
+	defer s.Close()
+
+	r := New().Get("/panic", func(g *Gas) (int, Outputter) {
+		g.SetUser("alice")
+		panic("lol")
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/panic", nil))
+
+	select {
+	case e := <-events:
+		if e.Kind != "panic" || e.Error != "lol" || e.User != "alice" || e.Host == "" {
+			t.Errorf("unexpected event %+v", e)
+		}
+		if e.Request == nil || e.Request.URL != "http://example.com/panic" {
+			t.Errorf("unexpected request %+v", e.Request)
+		}
+		if len(e.Frames) == 0 || !strings.Contains(e.Frames[0].Function, "TestForward") {
+			t.Errorf("expected stack to start at the panic, got %+v", e.Frames)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no event forwarded")
+	}
+}
+
+func TestWebhookRedacted(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		bodies <- body
+	}))
+	defer srv.Close()
+	s := Forward(&notify.Webhook{URL: srv.URL}, "panic")
+	defer s.Close()
+
+	r := New().Get("/panic", func(g *Gas) (int, Outputter) {
+		panic("lol")
+	})
+	req := httptest.NewRequest("GET", "/panic", nil)
+	req.Header.Set("Cookie", "session=hunter2")
+	req.Header.Set("Authorization", "Bearer hunter2")
+	req.Header.Set("X-Api-Token", "hunter2")
+	req.Header.Set("Accept", "text/html")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	select {
+	case body := <-bodies:
+		var e notify.Event
+		if err := json.Unmarshal(body, &e); err != nil || e.Request == nil {
+			t.Fatalf("unexpected payload %s: %v", body, err)
+		}
+		for _, name := range []string{"Cookie", "Authorization", "X-Api-Token"} {
+			if v := e.Request.Header.Get(name); v != redacted {
+				t.Errorf("expected %s to be redacted, got %q", name, v)
+			}
+		}
+		if v := e.Request.Header.Get("Accept"); v != "text/html" {
+			t.Errorf("expected Accept to be left alone, got %q", v)
+		}
+		if strings.Contains(string(body), "hunter2") {
+			t.Errorf("secret leaked into webhook: %s", body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no webhook sent")
+	}
+	if req.Header.Get("Cookie") != "session=hunter2" {
+		t.Error("expected the request's own headers to be left alone")
+	}
+}
+
+func TestServerError(t *testing.T) {
+	events := make(chan *notify.Event, 2)
+	s := Forward(sinkFunc(func(e *notify.Event) error {
+		events <- e
+		return nil
+	}), "server_error")
+	defer s.Close()
+
+	r := New().Get("/fail", func(g *Gas) (int, Outputter) {
+		g.SetError(errors.Wrap(errors.New("connection refused"), "loading posts"))
+		return 500, nil
+	}).Get("/ok", func(g *Gas) (int, Outputter) {
+		return 404, nil
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/ok", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/fail", nil))
+
+	select {
+	case e := <-events:
+		if e.Title != "500 Internal Server Error: GET /fail" || e.Error != "loading posts: connection refused" || e.Fields["route"] != "/fail" {
+			t.Errorf("unexpected event %+v", e)
+		}
+		if len(e.Frames) == 0 || !strings.Contains(e.Frames[0].Function, "TestServerError") {
+			t.Errorf("expected stack to start where the error was made, got %+v", e.Frames)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no event forwarded")
+	}
+	select {
+	case e := <-events:
+		t.Errorf("expected only 5xx responses to be reported, got %+v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
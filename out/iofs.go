@@ -0,0 +1,74 @@
+package out
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"ktkr.us/pkg/vfs"
+)
+
+// TemplateIOFS is like TemplateFS, but takes an fs.FS such as an embed.FS, so
+// that templates can be built into the binary with go:embed. The templates
+// directory must be at the root of fsys:
+//
+//	//go:embed templates
+//	var templates embed.FS
+//
+//	out.TemplateIOFS(templates)
+func TemplateIOFS(fsys fs.FS) {
+	templateFS = ioFS{fsys}
+}
+
+// ioFS makes an fs.FS into a vfs.FileSystem.
+type ioFS struct {
+	fsys fs.FS
+}
+
+func (f ioFS) Open(name string) (vfs.File, error) {
+	file, err := f.fsys.Open(filepath.ToSlash(name))
+	if err != nil {
+		return nil, err
+	}
+	return ioFile{file}, nil
+}
+
+func (f ioFS) Walk(root string, fn filepath.WalkFunc) error {
+	return fs.WalkDir(f.fsys, filepath.ToSlash(root), func(p string, d fs.DirEntry, err error) error {
+		var fi os.FileInfo
+		if d != nil && err == nil {
+			fi, err = d.Info()
+		}
+		return fn(filepath.FromSlash(p), fi, err)
+	})
+}
+
+type ioFile struct {
+	fs.File
+}
+
+func (f ioFile) Seek(offset int64, whence int) (int64, error) {
+	if s, ok := f.File.(io.Seeker); ok {
+		return s.Seek(offset, whence)
+	}
+	return 0, errors.New("seek not supported")
+}
+
+func (f ioFile) Readdir(n int) ([]os.FileInfo, error) {
+	d, ok := f.File.(fs.ReadDirFile)
+	if !ok {
+		return nil, errors.New("not a directory")
+	}
+	entries, err := d.ReadDir(n)
+	fis := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		fi, err := e.Info()
+		if err != nil {
+			return fis, err
+		}
+		fis = append(fis, fi)
+	}
+	return fis, err
+}
@@ -0,0 +1,24 @@
+package out
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"ktkr.us/pkg/gas"
+	"ktkr.us/pkg/gas/testutil"
+)
+
+func TestTemplateIOFS(t *testing.T) {
+	if err := parseTemplates(ioFS{os.DirFS(".")}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := gas.New().Get("/", func(g *gas.Gas) (int, gas.Outputter) {
+		return 200, HTML("a/index/content", "world")
+	})
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	testutil.TestGet(t, srv, "/", "Hello, world! testing!")
+}
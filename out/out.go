@@ -6,7 +6,6 @@ import (
 	"encoding/gob"
 	"encoding/json"
 	"errors"
-	"log"
 	"net/http"
 	"strconv"
 	"time"
@@ -60,10 +59,10 @@ func CheckReroute(g *gas.Gas) (int, gas.Outputter) {
 			if err == nil {
 				g.SetData("_reroute", blob)
 			} else {
-				log.Println("gas: dispatch reroute:", err)
+				gas.Logger().Warn("out: decoding reroute cookie", "err", err)
 			}
 		} else {
-			log.Println("gas: reroute cookie:", err)
+			gas.Logger().Warn("out: reading reroute cookie", "err", err)
 		}
 
 		// Empty the cookie out and toss it back
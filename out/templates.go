@@ -1,20 +1,24 @@
 package out
 
 import (
+	"bytes"
 	"compress/gzip"
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"text/template/parse"
 	"time"
 
 	md "github.com/russross/blackfriday/v2"
@@ -52,8 +56,16 @@ const (
 var (
 	Templates map[string]*template.Template
 
-	templateLock sync.RWMutex
-	templateFS   vfs.FileSystem
+	templateLock    sync.RWMutex
+	templateFS      vfs.FileSystem
+	templateModTime time.Time // newest template file seen at last parse
+
+	// when reloadChangedTemplates last looked for changes, in UnixNano
+	templateChecked atomic.Int64
+
+	// the groups in Templates that call "flashes", which have to load them
+	// before the header is written
+	flashGroups map[*template.Template]bool
 
 	mdExtensions = md.NoIntraEmphasis | md.FencedCode | md.Strikethrough | md.Footnotes
 	mdRenderer   = md.NewHTMLRenderer(md.HTMLRendererParameters{Flags: md.Smartypants})
@@ -76,29 +88,32 @@ var (
 		"datetime": func(t time.Time) string {
 			return t.Format("2006-01-02T15:04:05Z")
 		},
+		"url":     gas.URLFor,
+		"push":    push,
+		"flashes": (*gas.Gas).Flashes,
 	}
 )
 
 func init() {
-	gas.Init(func() {
+	gas.InitErr(func() error {
 		var err error
 		if templateFS == nil {
 			templateFS, err = vfs.Native(".")
 			if err != nil {
-				log.Fatalln("templates:", err)
+				return fmt.Errorf("templates: %v", err)
 			}
 		}
-		err = parseTemplates(templateFS)
-		if err != nil {
-			log.Fatalln("templates: failed to load:", err)
+		if err = parseTemplates(templateFS); err != nil {
+			return fmt.Errorf("templates: failed to load: %v", err)
 		}
+		return nil
 	})
 	gas.Hook(syscall.SIGHUP, func() {
 		err := parseTemplates(templateFS)
 		if err != nil {
-			log.Printf("templates: failed to reload: %v", err)
+			gas.Logger().Error("templates: failed to reload", "err", err)
 		} else {
-			log.Printf("templates: reloaded all templates")
+			gas.Logger().Info("templates: reloaded all templates")
 		}
 	})
 }
@@ -113,6 +128,9 @@ func init() {
 //     "markdown":  func(b []byte) template.HTML
 //     "smarkdown": func(s string) (template.HTML, error)
 //     "datetime":  func(t time.Time) string
+//     "url":       func(name string, args ...interface{}) (string, error)
+//     "push":      func(g *gas.Gas, path string) string
+//     "flashes":   func(g *gas.Gas) []gas.FlashMessage
 func TemplateFunc(name string, f interface{}) {
 	globalFuncmap[name] = f
 }
@@ -153,6 +171,7 @@ func parseTemplates(fs vfs.FileSystem) error {
 		layouts    = template.New("layouts").Funcs(globalFuncmap)
 		layoutDir  = filepath.Join(templateDir, templateLayoutDir)
 		contentDir = filepath.Join(templateDir, templateContentDir)
+		modTime    time.Time
 	)
 
 	err := fs.Walk(layoutDir, func(tmplPath string, fi os.FileInfo, err error) error {
@@ -163,7 +182,10 @@ func parseTemplates(fs vfs.FileSystem) error {
 			return nil
 		}
 
-		log.Printf("templates: loading layout '%s'", tmplPath)
+		gas.Logger().Debug("templates: loading layout", "path", tmplPath)
+		if fi.ModTime().After(modTime) {
+			modTime = fi.ModTime()
+		}
 
 		return parseFile(layouts, fs, tmplPath)
 	})
@@ -189,7 +211,10 @@ func parseTemplates(fs vfs.FileSystem) error {
 			return nil
 		}
 
-		log.Printf("templates: loading content '%s'", tmplPath)
+		gas.Logger().Debug("templates: loading content", "path", tmplPath)
+		if fi.ModTime().After(modTime) {
+			modTime = fi.ModTime()
+		}
 
 		// remove the "templates/content" from the front of the path
 		name, _ := filepath.Rel(contentDir, tmplPath)
@@ -217,12 +242,26 @@ func parseTemplates(fs vfs.FileSystem) error {
 		return err
 	}
 
+	flashes := make(map[*template.Template]bool)
+	for _, t := range templates {
+		for _, tt := range t.Templates() {
+			if tt.Tree != nil && callsFlashes(tt.Tree.Root) {
+				flashes[t] = true
+				break
+			}
+		}
+	}
+
 	templateLock.Lock()
 	Templates = templates
+	templateModTime = modTime
+	flashGroups = flashes
 
-	for k, t := range Templates {
-		for _, tt := range t.Templates() {
-			log.Printf("%s/%s", k, tt.Name())
+	if l := gas.Logger(); l.Enabled(context.Background(), slog.LevelDebug) {
+		for k, t := range Templates {
+			for _, tt := range t.Templates() {
+				l.Debug("templates: loaded", "template", k+"/"+tt.Name())
+			}
 		}
 	}
 	templateLock.Unlock()
@@ -230,6 +269,92 @@ func parseTemplates(fs vfs.FileSystem) error {
 	return nil
 }
 
+// callsFlashes reports whether the "flashes" function is called anywhere
+// under node.
+func callsFlashes(node parse.Node) bool {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, n := range n.Nodes {
+			if callsFlashes(n) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return callsFlashes(n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, cmd := range n.Cmds {
+			if callsFlashes(cmd) {
+				return true
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if callsFlashes(arg) {
+				return true
+			}
+		}
+	case *parse.ChainNode:
+		return callsFlashes(n.Node)
+	case *parse.IdentifierNode:
+		return n.Ident == "flashes"
+	case *parse.IfNode:
+		return callsFlashes(n.Pipe) || callsFlashes(n.List) || callsFlashes(n.ElseList)
+	case *parse.RangeNode:
+		return callsFlashes(n.Pipe) || callsFlashes(n.List) || callsFlashes(n.ElseList)
+	case *parse.WithNode:
+		return callsFlashes(n.Pipe) || callsFlashes(n.List) || callsFlashes(n.ElseList)
+	case *parse.TemplateNode:
+		return callsFlashes(n.Pipe)
+	}
+	return false
+}
+
+var errTemplatesChanged = errors.New("templates changed")
+
+// how often reloadChangedTemplates looks for changes, however many templates
+// are rendered in the meantime
+const templateCheckInterval = time.Second
+
+// reparse all templates if any template file has been modified since the last
+// parse. Used when the profile has ReloadTemplates set.
+func reloadChangedTemplates(fs vfs.FileSystem) error {
+	if fs == nil {
+		return nil
+	}
+
+	now := time.Now().UnixNano()
+	checked := templateChecked.Load()
+	if now-checked < int64(templateCheckInterval) || !templateChecked.CompareAndSwap(checked, now) {
+		return nil
+	}
+
+	templateLock.RLock()
+	last := templateModTime
+	templateLock.RUnlock()
+
+	err := fs.Walk(templateDir, func(tmplPath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() && filepath.Ext(tmplPath) == ".tmpl" && fi.ModTime().After(last) {
+			return errTemplatesChanged
+		}
+		return nil
+	})
+	if err != errTemplatesChanged {
+		return err
+	}
+
+	gas.Logger().Info("templates: change detected, reloading")
+	return parseTemplates(fs)
+}
+
 func parseFile(t *template.Template, fs vfs.FileSystem, tmplPath string) error {
 	f, err := fs.Open(tmplPath)
 	if err != nil {
@@ -297,6 +422,17 @@ func HTML(path string, data interface{}) gas.Outputter {
 	return &templateOutputter{parseTemplatePath(path), data}
 }
 
+// push starts an HTTP/2 server push of path and returns it, so that layouts can
+// push their assets where they link to them:
+//
+//     <link rel="stylesheet" href="{{ push $.G "/static/site.css" }}">
+func push(g *gas.Gas, path string) string {
+	if err := g.Push(path, nil); err != nil {
+		g.Log().Debug("templates: push failed", "path", path, "err", err)
+	}
+	return path
+}
+
 // Context is passed to every template execution for holding global and local
 // state relevant to the rendering.
 type Context struct {
@@ -312,13 +448,21 @@ func (c *Context) Content() (string, error) {
 }
 
 func (o *templateOutputter) Output(code int, g *gas.Gas) {
+	profile := gas.CurrentProfile()
+	if profile.ReloadTemplates {
+		if err := reloadChangedTemplates(templateFS); err != nil {
+			gas.Logger().Error("templates: failed to reload", "err", err)
+		}
+	}
+
 	templateLock.RLock()
 	group := Templates[o.path]
+	loadFlashes := flashGroups[group]
 	templateLock.RUnlock()
 	var t *template.Template
 
 	if group == nil {
-		log.Printf("templates: failed to access template group \"%s\"", o.path)
+		gas.Logger().Error("templates: template group not found", "group", o.path)
 		g.WriteHeader(500)
 		fmt.Fprintf(g, "Error: template group \"%s\" not found. Did it fail to compile?", o.path)
 		return
@@ -338,7 +482,7 @@ func (o *templateOutputter) Output(code int, g *gas.Gas) {
 	}
 
 	if t == nil {
-		log.Printf("No such template: %s/%s", o.path, o.name)
+		gas.Logger().Error("templates: no such template", "template", o.path+"/"+o.name)
 		g.WriteHeader(500)
 		fmt.Fprintf(g, "Error: no such template: %s/%s", o.path, o.name)
 		return
@@ -359,24 +503,86 @@ func (o *templateOutputter) Output(code int, g *gas.Gas) {
 		w = g
 	}
 
-	g.WriteHeader(code)
-
 	ctx := &Context{
 		G:    g,
 		Data: o.data,
 	}
+	w = ctxWriter{w, g.Context()}
 
-	if err := t.Execute(w, ctx); err != nil {
-		t = Templates[o.path].Lookup(o.name + "-error")
-
-		if t == nil {
-			log.Printf("out: %v", err)
-			fmt.Fprintf(w, "%v\n", err)
-			msg := fmt.Sprintf("out: %[1]s/%[2]s: %[2]s-error template not found", o.path, o.name)
-			log.Println(msg)
-			fmt.Fprintln(w, msg)
-		} else if err = t.Execute(w, err); err != nil {
-			fmt.Fprintf(g, "Error: failed to serve error page for %s/%s (%v)", o.path, o.name, err)
+	// reading the flashes clears their cookie, which can't be done once the
+	// header has gone out without the output being buffered
+	if loadFlashes {
+		g.Flashes()
+	}
+
+	if profile.BufferOutput {
+		// render everything up front so that a failed execution can still
+		// be given a proper status code
+		buf := new(bytes.Buffer)
+		if err := o.execute(t, ctxWriter{buf, g.Context()}, ctx); err != nil {
+			code = 500
+			buf.Reset()
+			o.executeError(group, buf, err)
 		}
+		g.WriteHeader(code)
+		w.Write(buf.Bytes())
+		return
+	}
+
+	g.WriteHeader(code)
+
+	if err := o.execute(t, w, ctx); err != nil {
+		o.executeError(group, w, err)
+	}
+}
+
+// ctxWriter stops a template partway through once the request is cancelled,
+// since there's nobody left to read it
+type ctxWriter struct {
+	io.Writer
+	ctx context.Context
+}
+
+func (w ctxWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.Writer.Write(p)
+}
+
+// TemplateRendered is published on the gas event bus (see gas.Subscribe) after
+// each template is executed in answer to a request.
+type TemplateRendered struct {
+	Time     time.Time // when execution started
+	Duration time.Duration
+	Name     string // the group and template, e.g. "users/profile"
+	Err      error
+}
+
+func (o *templateOutputter) execute(t *template.Template, w io.Writer, ctx *Context) error {
+	start := time.Now()
+	err := t.Execute(w, ctx)
+	gas.Publish(&TemplateRendered{
+		Time:     start,
+		Duration: time.Since(start),
+		Name:     o.path + "/" + t.Name(),
+		Err:      err,
+	})
+	return err
+}
+
+// render the "<name>-error" template of the group in place of a template that
+// failed to execute, or a plain message if there is none
+func (o *templateOutputter) executeError(group *template.Template, w io.Writer, err error) {
+	t := group.Lookup(o.name + "-error")
+
+	if t == nil {
+		fmt.Fprintf(w, "%v\n", err)
+		msg := fmt.Sprintf("out: %[1]s/%[2]s: %[2]s-error template not found", o.path, o.name)
+		gas.Logger().Error("out: executing template", "template", o.path+"/"+o.name, "err", err)
+		gas.Logger().Error(msg)
+		fmt.Fprintln(w, msg)
+	} else if err = t.Execute(w, err); err != nil {
+		fmt.Fprintf(w, "Error: failed to serve error page for %s/%s (%v)", o.path, o.name, err)
 	}
 }
@@ -1,9 +1,13 @@
 package out
 
 import (
+	"context"
 	"fmt"
 	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"ktkr.us/pkg/gas"
 	"ktkr.us/pkg/gas/testutil"
@@ -96,3 +100,132 @@ func TestReroute(t *testing.T) {
 	defer srv.Close()
 	testutil.TestGet(t, srv, "/reroute1", "ok")
 }
+
+func TestTemplateRendered(t *testing.T) {
+	fs, err := vfs.Native(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = parseTemplates(fs); err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan *TemplateRendered, 1)
+	sub := gas.Subscribe(func(e *TemplateRendered) { events <- e })
+	defer sub.Close()
+
+	r := gas.New().Get("/", func(g *gas.Gas) (int, gas.Outputter) {
+		return 200, HTML("a/index/content", "world")
+	})
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	testutil.TestGet(t, srv, "/", "Hello, world! testing!")
+	e := <-events
+	if e.Name != "a/index/content" || e.Err != nil || e.Duration <= 0 {
+		t.Errorf("got %+v", e)
+	}
+}
+
+func TestTemplateCancelled(t *testing.T) {
+	fs, err := vfs.Native(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = parseTemplates(fs); err != nil {
+		t.Fatal(err)
+	}
+
+	r := gas.New().Get("/", func(g *gas.Gas) (int, gas.Outputter) {
+		ctx, cancel := context.WithCancel(g.Context())
+		g.SetContext(ctx)
+		cancel()
+		return 200, HTML("a/index/content", "world")
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if strings.Contains(w.Body.String(), "Hello") {
+		t.Errorf("expected nothing to be rendered for a cancelled request, got %q", w.Body)
+	}
+}
+
+type walkCounter struct {
+	vfs.FileSystem
+	walks int
+}
+
+func (fs *walkCounter) Walk(root string, fn filepath.WalkFunc) error {
+	fs.walks++
+	return fs.FileSystem.Walk(root, fn)
+}
+
+func TestReloadChangedTemplatesThrottled(t *testing.T) {
+	native, err := vfs.Native(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := &walkCounter{FileSystem: native}
+	if err = parseTemplates(fs); err != nil {
+		t.Fatal(err)
+	}
+	fs.walks = 0
+	templateChecked.Store(0)
+
+	for i := 0; i < 5; i++ {
+		if err = reloadChangedTemplates(fs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fs.walks != 1 {
+		t.Errorf("expected the templates to be looked at once, got %d", fs.walks)
+	}
+
+	templateChecked.Store(time.Now().Add(-templateCheckInterval).UnixNano())
+	reloadChangedTemplates(fs)
+	if fs.walks != 2 {
+		t.Errorf("expected another look after %v, got %d", templateCheckInterval, fs.walks)
+	}
+}
+
+func TestTemplateFlashes(t *testing.T) {
+	defer func(env string) { gas.Env.Env = env }(gas.Env.Env)
+	gas.Env.Env = "dev"
+	if gas.CurrentProfile().BufferOutput {
+		t.Fatal("expected the dev profile not to buffer output")
+	}
+
+	fs, err := vfs.Native(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = parseTemplates(fs); err != nil {
+		t.Fatal(err)
+	}
+
+	r := gas.New().
+		Get("/set", func(g *gas.Gas) (int, gas.Outputter) {
+			g.Flash("info", "hi")
+			return 204, nil
+		}).
+		Get("/", func(g *gas.Gas) (int, gas.Outputter) {
+			return 200, HTML("flash/page", nil)
+		})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/set", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected a flash cookie, got %v", cookies)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if body := w.Body.String(); body != "hi;" {
+		t.Errorf("expected the message to be shown, got %q", body)
+	}
+	if c := w.Result().Cookies(); len(c) != 1 || c[0].MaxAge >= 0 {
+		t.Errorf("expected the cookie to be cleared, got %v", c)
+	}
+}
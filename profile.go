@@ -0,0 +1,78 @@
+package gas
+
+import "strings"
+
+// A Profile is a set of defaults selected with GAS_ENV. Packages in gas
+// consult the current profile for behavior that should differ between
+// development and production deployments.
+type Profile struct {
+	Name string
+
+	// Render the stack trace and offending source code when a handler
+	// panics. Otherwise, a plain 500 page is served.
+	DebugPanics bool
+
+	// Reparse templates whenever they change on disk.
+	ReloadTemplates bool
+
+	// Log at the debug level, which includes extra information such as each
+	// template as it is loaded, unless GAS_LOG_LEVEL says otherwise.
+	Verbose bool
+
+	// Send the StrictHeaders (nosniff, framing, referrer policy, and HSTS
+	// over TLS) on every response unless a handler overrides them.
+	StrictHeaders bool
+
+	// Render output into a buffer before writing it out, so that a failure
+	// partway through can still result in a clean error response.
+	BufferOutput bool
+
+	// Make up an in-memory self-signed certificate for TLS listeners when
+	// GAS_TLS_CERT isn't set, so HTTPS can be tried out locally.
+	SelfSignedTLS bool
+}
+
+var profiles = map[string]Profile{
+	"dev": {
+		Name:            "dev",
+		DebugPanics:     true,
+		ReloadTemplates: true,
+		Verbose:         true,
+		SelfSignedTLS:   true,
+	},
+	"staging": {
+		Name:          "staging",
+		Verbose:       true,
+		StrictHeaders: true,
+		BufferOutput:  true,
+	},
+	"prod": {
+		Name:          "prod",
+		StrictHeaders: true,
+		BufferOutput:  true,
+	},
+}
+
+// CurrentProfile returns the profile selected by Env.Env. An unknown profile
+// name falls back to "dev".
+func CurrentProfile() Profile {
+	if p, ok := profiles[strings.ToLower(Env.Env)]; ok {
+		return p
+	}
+	return profiles["dev"]
+}
+
+// IsDev reports whether the server is running with the dev profile.
+func IsDev() bool { return CurrentProfile().Name == "dev" }
+
+// IsStaging reports whether the server is running with the staging profile.
+func IsStaging() bool { return CurrentProfile().Name == "staging" }
+
+// IsProd reports whether the server is running with the prod profile.
+func IsProd() bool { return CurrentProfile().Name == "prod" }
+
+// set the security headers implied by Profile.StrictHeaders. Handlers further
+// down the chain can still override any of them.
+func setStrictHeaders(g *Gas) {
+	StrictHeaders.set(g)
+}
@@ -0,0 +1,89 @@
+package gas
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// A ProxyOption changes how a Proxy handler passes requests on.
+type ProxyOption func(*proxy)
+
+type proxy struct {
+	target       *url.URL
+	stripPrefix  string
+	preserveHost bool
+	transport    http.RoundTripper
+}
+
+// ProxyStripPrefix removes prefix from the start of the request path before
+// it's added onto the target's, so that e.g. /api/users under a route for
+// /api/{path...} can be sent to the backend as /users.
+func ProxyStripPrefix(prefix string) ProxyOption {
+	return func(p *proxy) { p.stripPrefix = prefix }
+}
+
+// ProxyPreserveHost sends the Host header the client gave instead of the
+// target's host, for backends that serve more than one site.
+func ProxyPreserveHost() ProxyOption {
+	return func(p *proxy) { p.preserveHost = true }
+}
+
+// ProxyTransport makes requests to the backend with rt instead of
+// http.DefaultTransport.
+func ProxyTransport(rt http.RoundTripper) ProxyOption {
+	return func(p *proxy) { p.transport = rt }
+}
+
+// Proxy returns a handler that passes requests on to target and sends back
+// whatever it answers with. The request path is added onto the target's path,
+// and its query onto the target's query. X-Forwarded-For is added to (or set,
+// if the client didn't send one) along with X-Forwarded-Host and
+// X-Forwarded-Proto, and the Host header is the target's unless
+// ProxyPreserveHost is given. If the backend can't be reached, the response is
+// 502 Bad Gateway.
+//
+//	u, _ := url.Parse("http://localhost:8081")
+//	r.Any("/api/{path...}", gas.Proxy(u, gas.ProxyStripPrefix("/api")))
+func Proxy(target *url.URL, opts ...ProxyOption) Handler {
+	p := &proxy{target: target}
+	for _, opt := range opts {
+		opt(p)
+	}
+	rp := &httputil.ReverseProxy{
+		Rewrite:   p.rewrite,
+		Transport: p.transport,
+	}
+
+	return func(g *Gas) (int, Outputter) {
+		rp := *rp
+		rp.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+			g.Log().Error("proxy", "target", target.String(), "err", err)
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		rp.ServeHTTP(g, g.Request)
+		return g.Stop()
+	}
+}
+
+func (p *proxy) rewrite(pr *httputil.ProxyRequest) {
+	if p.stripPrefix != "" {
+		u := *pr.Out.URL
+		u.Path = strings.TrimPrefix(u.Path, p.stripPrefix)
+		u.RawPath = ""
+		if u.Path == "" || u.Path[0] != '/' {
+			u.Path = "/" + u.Path
+		}
+		pr.Out.URL = &u
+	}
+
+	// Rewrite starts out without the forwarding headers, but Gas.ClientIP
+	// trusts them, so keep the chain going
+	pr.Out.Header["X-Forwarded-For"] = pr.In.Header["X-Forwarded-For"]
+	pr.SetXForwarded()
+	pr.SetURL(p.target)
+	if p.preserveHost {
+		pr.Out.Host = pr.In.Host
+	}
+}
@@ -0,0 +1,46 @@
+package gas
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestProxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "%s %s %s %s %s", req.Method, req.URL, req.Host,
+			req.Header.Get("X-Forwarded-For"), req.Header.Get("X-Forwarded-Host"))
+	}))
+	defer backend.Close()
+	target, _ := url.Parse(backend.URL + "/base")
+	dead, _ := url.Parse("http://127.0.0.1:1")
+
+	r := New().
+		Any("/api/{path...}", Proxy(target, ProxyStripPrefix("/api"))).
+		Get("/host/{path...}", Proxy(target, ProxyPreserveHost())).
+		Get("/dead", Proxy(dead))
+
+	for _, test := range []struct {
+		method, url, fwd string
+		code             int
+		body             string
+	}{
+		{"GET", "/api/users?page=2", "", 200, "GET /base/users?page=2 " + target.Host + " 192.0.2.1 example.com"},
+		{"POST", "/api/users", "203.0.113.9", 200, "POST /base/users " + target.Host + " 203.0.113.9, 192.0.2.1 example.com"},
+		{"GET", "/host/x", "", 200, "GET /base/host/x example.com 192.0.2.1 example.com"},
+		{"GET", "/dead", "", 502, ""},
+	} {
+		req := httptest.NewRequest(test.method, "http://example.com"+test.url, nil)
+		if test.fwd != "" {
+			req.Header.Set("X-Forwarded-For", test.fwd)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != test.code || strings.TrimSpace(w.Body.String()) != test.body {
+			t.Errorf("%s %s: expected %d %q, got %d %q", test.method, test.url, test.code, test.body, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package gas
+
+// proxyproto.go implements the receiving end of the HAProxy PROXY protocol,
+// versions 1 and 2, as described in
+// https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	proxyV1Prefix = []byte("PROXY ")
+	proxyV2Sig    = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	errProxyHeader = errors.New("proxy protocol: invalid header")
+)
+
+// how long a client has to send the PROXY header after connecting
+const proxyHeaderTimeout = 5 * time.Second
+
+// proxyListener wraps a listener whose connections are all expected to begin
+// with a PROXY protocol header.
+type proxyListener struct {
+	net.Listener
+}
+
+func (l proxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: c, r: bufio.NewReader(c)}, nil
+}
+
+// proxyConn reads the PROXY header lazily on the first Read or address
+// lookup, so that a slow client can't hold up the Accept loop.
+type proxyConn struct {
+	net.Conn
+	r *bufio.Reader
+
+	once   sync.Once
+	err    error
+	remote net.Addr
+	local  net.Addr
+
+	// the read deadline set by the server, which is put back once the header
+	// has been read
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.mu.Lock()
+		d := time.Now().Add(proxyHeaderTimeout)
+		if !c.deadline.IsZero() && c.deadline.Before(d) {
+			d = c.deadline
+		}
+		c.mu.Unlock()
+		c.Conn.SetReadDeadline(d)
+		c.remote, c.local, c.err = readProxyHeader(c.r)
+		c.mu.Lock()
+		c.Conn.SetReadDeadline(c.deadline)
+		c.mu.Unlock()
+	})
+}
+
+func (c *proxyConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *proxyConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(p)
+}
+
+// RemoteAddr returns the client address given in the PROXY header, or the
+// address of the proxy itself if none was given.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination address given in the PROXY header, if
+// any.
+func (c *proxyConn) LocalAddr() net.Addr {
+	c.init()
+	if c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+// read a v1 or v2 header. Nil addresses with a nil error mean that the header
+// was valid but carried no address information (UNKNOWN or LOCAL).
+func readProxyHeader(r *bufio.Reader) (remote, local net.Addr, err error) {
+	sig, err := r.Peek(len(proxyV1Prefix))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "proxy protocol")
+	}
+	if bytes.Equal(sig, proxyV1Prefix) {
+		return readProxyV1(r)
+	}
+
+	sig, err = r.Peek(len(proxyV2Sig))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "proxy protocol")
+	}
+	if bytes.Equal(sig, proxyV2Sig) {
+		return readProxyV2(r)
+	}
+
+	return nil, nil, errProxyHeader
+}
+
+func readProxyV1(r *bufio.Reader) (remote, local net.Addr, err error) {
+	// the longest possible v1 header is 107 bytes including the CRLF
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "proxy protocol")
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, errProxyHeader
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, errProxyHeader
+	}
+
+	srcIP, dstIP := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	srcPort, err1 := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, err2 := strconv.ParseUint(fields[5], 10, 16)
+	if srcIP == nil || dstIP == nil || err1 != nil || err2 != nil {
+		return nil, nil, errProxyHeader
+	}
+
+	return &net.TCPAddr{IP: srcIP, Port: int(srcPort)},
+		&net.TCPAddr{IP: dstIP, Port: int(dstPort)}, nil
+}
+
+func readProxyV2(r *bufio.Reader) (remote, local net.Addr, err error) {
+	hdr := make([]byte, 16)
+	if _, err = io.ReadFull(r, hdr); err != nil {
+		return nil, nil, errors.Wrap(err, "proxy protocol")
+	}
+
+	var (
+		verCmd = hdr[12]
+		fam    = hdr[13]
+		length = binary.BigEndian.Uint16(hdr[14:16])
+		body   = make([]byte, length)
+	)
+
+	if verCmd>>4 != 2 {
+		return nil, nil, errProxyHeader
+	}
+	if _, err = io.ReadFull(r, body); err != nil {
+		return nil, nil, errors.Wrap(err, "proxy protocol")
+	}
+
+	switch verCmd & 0xf {
+	case 0x0: // LOCAL: health checks from the proxy itself
+		return nil, nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, nil, errProxyHeader
+	}
+
+	// only the address family matters here; the TLVs after the addresses are
+	// ignored
+	switch fam >> 4 {
+	case 0x1: // AF_INET
+		if len(body) < 12 {
+			return nil, nil, errProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))},
+			&net.TCPAddr{IP: net.IP(body[4:8]), Port: int(binary.BigEndian.Uint16(body[10:12]))}, nil
+	case 0x2: // AF_INET6
+		if len(body) < 36 {
+			return nil, nil, errProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))},
+			&net.TCPAddr{IP: net.IP(body[16:32]), Port: int(binary.BigEndian.Uint16(body[34:36]))}, nil
+	case 0x3: // AF_UNIX
+		if len(body) < 216 {
+			return nil, nil, errProxyHeader
+		}
+		return &net.UnixAddr{Name: string(bytes.TrimRight(body[0:108], "\x00")), Net: "unix"},
+			&net.UnixAddr{Name: string(bytes.TrimRight(body[108:216], "\x00")), Net: "unix"}, nil
+	default: // AF_UNSPEC
+		return nil, nil, nil
+	}
+}
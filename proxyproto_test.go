@@ -0,0 +1,118 @@
+package gas
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	v2 := append([]byte{}, proxyV2Sig...)
+	v2 = append(v2, 0x21, 0x11, 0, 12)
+	v2 = append(v2, 192, 0, 2, 1, 198, 51, 100, 7, 0x30, 0x39, 0x01, 0xbb)
+
+	v2local := append([]byte{}, proxyV2Sig...)
+	v2local = append(v2local, 0x20, 0x00, 0, 0)
+
+	tests := []struct {
+		in     []byte
+		remote string
+		ok     bool
+	}{
+		{[]byte("PROXY TCP4 192.0.2.1 198.51.100.7 12345 443\r\nGET /"), "192.0.2.1:12345", true},
+		{[]byte("PROXY TCP6 2001:db8::1 2001:db8::2 12345 443\r\nGET /"), "[2001:db8::1]:12345", true},
+		{[]byte("PROXY UNKNOWN\r\nGET /"), "", true},
+		{append(v2, "GET /"...), "192.0.2.1:12345", true},
+		{append(v2local, "GET /"...), "", true},
+		{[]byte("PROXY TCP4 192.0.2.1\r\nGET /"), "", false},
+		{[]byte("GET / HTTP/1.1\r\n"), "", false},
+	}
+
+	for _, test := range tests {
+		r := bufio.NewReader(bytes.NewReader(test.in))
+		remote, _, err := readProxyHeader(r)
+		if (err == nil) != test.ok {
+			t.Errorf("%q: expected ok=%v, got err=%v", test.in, test.ok, err)
+			continue
+		}
+		if !test.ok {
+			continue
+		}
+		if (remote == nil && test.remote != "") || (remote != nil && remote.String() != test.remote) {
+			t.Errorf("%q: expected remote %q, got %v", test.in, test.remote, remote)
+		}
+		rest, _ := ioutil.ReadAll(r)
+		if string(rest) != "GET /" {
+			t.Errorf("%q: expected the rest of the stream intact, got %q", test.in, rest)
+		}
+	}
+}
+
+func TestProxyConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go client.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.7 12345 443\r\nhello"))
+
+	c := &proxyConn{Conn: server, r: bufio.NewReader(server)}
+	defer c.Close()
+
+	if addr := c.RemoteAddr().String(); addr != "192.0.2.1:12345" {
+		t.Errorf("expected remote address from header, got %s", addr)
+	}
+	buf := make([]byte, 5)
+	if _, err := c.Read(buf); err != nil || string(buf) != "hello" {
+		t.Errorf("expected %q, got %q (%v)", "hello", buf, err)
+	}
+}
+
+func TestProxyConnDeadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go client.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.7 12345 443\r\n"))
+
+	// a deadline set before the header is read is kept afterwards
+	c := &proxyConn{Conn: server, r: bufio.NewReader(server)}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Read(make([]byte, 1))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			t.Errorf("expected a timeout, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("read deadline was cleared by the header")
+	}
+
+	// and a server's ReadTimeout still applies to a client that stalls after
+	// the header
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{ReadTimeout: 100 * time.Millisecond, Handler: http.NotFoundHandler()}
+	go srv.Serve(proxyListener{l})
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.7 12345 443\r\nGET / HTTP/1.1\r\n"))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := ioutil.ReadAll(conn); err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			t.Error("expected the server to hang up on a stalled client")
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package gas
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A RateLimit is middleware that limits how often each client can make
+// requests with a token bucket: every request takes a token, the bucket holds
+// up to Burst of them, and it's refilled at Rate tokens per second. A request
+// that finds the bucket empty is answered with 429 Too Many Requests and a
+// Retry-After saying when the next token will be there.
+//
+//	limit := &gas.RateLimit{Rate: 5, Burst: 20}
+//	api := r.Group("/api", limit.Handle)
+type RateLimit struct {
+	Rate  float64
+	Burst int
+
+	// Key picks the bucket for a request. It defaults to Gas.ClientIP, so
+	// behind a reverse proxy, Env.TrustedProxies has to include it for
+	// clients not to all share the proxy's bucket.
+	Key func(g *Gas) string
+
+	// Store keeps the buckets. It defaults to one in memory, which only
+	// limits the requests to this process; a store shared by several
+	// servers, e.g. in Redis, can be plugged in here.
+	Store RateStore
+
+	once sync.Once
+}
+
+// A RateStore keeps the token buckets of a RateLimit. It must be safe for
+// concurrent use.
+type RateStore interface {
+	// Take takes a token from the bucket for key at the time now, where
+	// the bucket holds up to burst tokens and gains rate of them a second.
+	// If the bucket is empty, it returns false and how long it will be
+	// until there's a token.
+	Take(key string, rate float64, burst int, now time.Time) (ok bool, wait time.Duration, err error)
+}
+
+// Handle is the middleware.
+func (l *RateLimit) Handle(g *Gas) (int, Outputter) {
+	l.once.Do(func() {
+		if l.Key == nil {
+			l.Key = (*Gas).ClientIP
+		}
+		if l.Store == nil {
+			l.Store = NewMemoryRateStore()
+		}
+	})
+
+	ok, wait, err := l.Store.Take(l.Key(g), l.Rate, l.Burst, time.Now())
+	if err != nil {
+		// rather let requests through than take the site down with the
+		// store
+		g.Log().Error("rate limit", "err", err)
+		return g.Continue()
+	}
+	if !ok {
+		g.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(g, "429 too many requests", http.StatusTooManyRequests)
+		return g.Stop()
+	}
+	return g.Continue()
+}
+
+// MemoryRateStore is a RateStore that keeps the buckets in memory.
+type MemoryRateStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewMemoryRateStore makes an empty MemoryRateStore.
+func NewMemoryRateStore() *MemoryRateStore {
+	return &MemoryRateStore{buckets: make(map[string]*bucket)}
+}
+
+// how often to throw away the buckets that have filled back up
+const rateSweepInterval = time.Minute
+
+// Take implements RateStore.
+func (s *MemoryRateStore) Take(key string, rate float64, burst int, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.swept) > rateSweepInterval {
+		for k, b := range s.buckets {
+			if b.fill(rate, burst, now) >= float64(burst) {
+				delete(s.buckets, k)
+			}
+		}
+		s.swept = now
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		s.buckets[key] = b
+	}
+	if b.fill(rate, burst, now) < 1 {
+		if rate <= 0 {
+			return false, rateSweepInterval, nil
+		}
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second)), nil
+	}
+	b.tokens--
+	return true, 0, nil
+}
+
+// fill adds the tokens gained since the bucket was last looked at and returns
+// how many there are.
+func (b *bucket) fill(rate float64, burst int, now time.Time) float64 {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(burst), b.tokens+elapsed*rate)
+		b.last = now
+	}
+	return b.tokens
+}
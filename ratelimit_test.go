@@ -0,0 +1,66 @@
+package gas
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMemoryRateStore(t *testing.T) {
+	s := NewMemoryRateStore()
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if ok, _, _ := s.Take("a", 2, 3, now); !ok {
+			t.Fatalf("take %d: expected a token", i)
+		}
+	}
+	ok, wait, _ := s.Take("a", 2, 3, now)
+	if ok || wait != 500*time.Millisecond {
+		t.Errorf("expected to wait 500ms for an empty bucket, got ok=%v wait=%v", ok, wait)
+	}
+	if ok, _, _ := s.Take("b", 2, 3, now); !ok {
+		t.Error("expected another key to have its own bucket")
+	}
+	if ok, _, _ := s.Take("a", 2, 3, now.Add(500*time.Millisecond)); !ok {
+		t.Error("expected a token after the bucket refilled")
+	}
+
+	s.Take("c", 2, 3, now.Add(2*rateSweepInterval))
+	if _, ok := s.buckets["b"]; ok {
+		t.Error("expected full buckets to be swept")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	limit := &RateLimit{
+		Rate:  1,
+		Burst: 2,
+		Key:   func(g *Gas) string { return g.Request.Header.Get("X-Key") },
+	}
+	r := New().Use(limit.Handle).Get("/", func(g *Gas) (int, Outputter) {
+		g.Write([]byte("ok"))
+		return g.Stop()
+	})
+
+	serve := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Key", key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := serve("a"); w.Code != 200 {
+			t.Fatalf("request %d: expected 200, got %d", i, w.Code)
+		}
+	}
+	w := serve("a")
+	if w.Code != 429 || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("expected 429 with Retry-After 1, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := serve("b"); w.Code != 200 {
+		t.Errorf("expected another client to get through, got %d", w.Code)
+	}
+}
@@ -0,0 +1,46 @@
+package gas
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ACME HTTP-01 challenges have to be answered over plain HTTP, so they are
+// passed through to the router rather than redirected.
+const acmeChallengePrefix = "/.well-known/acme-challenge/"
+
+// httpsRedirect returns a handler that redirects every request to the same
+// path on https://, using Env.TLSHost as the host if it's set and the host
+// the client asked for otherwise. ACME challenges are given to next.
+func httpsRedirect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, acmeChallengePrefix) {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		host := Env.TLSHost
+		if host == "" {
+			host = req.Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+		}
+
+		u := *req.URL
+		u.Scheme = "https"
+		u.Host = host
+
+		http.Redirect(w, req, u.String(), redirectCode(req))
+	})
+}
+
+// 308 keeps the method and body intact; stick with 301 for the methods that
+// don't have one
+func redirectCode(req *http.Request) int {
+	if req.Method == "GET" || req.Method == "HEAD" {
+		return http.StatusMovedPermanently
+	}
+	return http.StatusPermanentRedirect
+}
@@ -0,0 +1,46 @@
+package gas
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPSRedirect(t *testing.T) {
+	defer func(host string) { Env.TLSHost = host }(Env.TLSHost)
+
+	r := New().Get("/.well-known/acme-challenge/{token}", func(g *Gas) (int, Outputter) {
+		g.Write([]byte(g.Arg("token")))
+		return g.Stop()
+	})
+	h := httpsRedirect(r)
+
+	tests := []struct {
+		method   string
+		url      string
+		tlsHost  string
+		code     int
+		location string
+	}{
+		{"GET", "http://example.com/a/b?c=d", "", 301, "https://example.com/a/b?c=d"},
+		{"GET", "http://example.com:8080/", "", 301, "https://example.com/"},
+		{"POST", "http://example.com/form", "", 308, "https://example.com/form"},
+		{"GET", "http://10.0.0.1/x", "www.example.com", 301, "https://www.example.com/x"},
+		{"GET", "http://example.com/.well-known/acme-challenge/abc", "", 200, ""},
+	}
+
+	for _, test := range tests {
+		Env.TLSHost = test.tlsHost
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(test.method, test.url, nil))
+		if w.Code != test.code {
+			t.Errorf("%s %s: expected %d, got %d", test.method, test.url, test.code, w.Code)
+		}
+		if loc := w.Header().Get("Location"); loc != test.location {
+			t.Errorf("%s %s: expected Location %q, got %q", test.method, test.url, test.location, loc)
+		}
+		if test.code == http.StatusOK && w.Body.String() != "abc" {
+			t.Errorf("expected ACME challenge to reach the router, got %q", w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package gas
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// These write a response straight away and return the same as Stop, so that
+// handlers can answer without an Outputter:
+//
+//	return g.JSON(200, user)
+//
+// They can also be used from middleware that has already taken over the
+// request.
+
+// Redirect redirects the client to url, which may be relative to the request
+// path, with code, e.g. 303 See Other after a form is posted.
+func (g *Gas) Redirect(code int, url string) (int, Outputter) {
+	http.Redirect(g, g.Request, url, code)
+	return g.Stop()
+}
+
+// JSON writes the JSON encoding of v with code. If v can't be encoded, the
+// response is 500 Internal Server Error instead, and the error is recorded
+// with SetError.
+func (g *Gas) JSON(code int, v interface{}) (int, Outputter) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		g.SetError(err)
+		http.Error(g, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return g.Stop()
+	}
+	h := g.Header()
+	if _, ok := h["Content-Type"]; !ok {
+		h.Set("Content-Type", "application/json; charset=utf-8")
+	}
+	g.WriteHeader(code)
+	g.Write(append(b, '\n'))
+	return g.Stop()
+}
+
+// Text writes s as plain text with code.
+func (g *Gas) Text(code int, s string) (int, Outputter) {
+	h := g.Header()
+	if _, ok := h["Content-Type"]; !ok {
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	g.WriteHeader(code)
+	io.WriteString(g, s)
+	return g.Stop()
+}
+
+// NoContent answers with 204 No Content.
+func (g *Gas) NoContent() (int, Outputter) {
+	g.WriteHeader(http.StatusNoContent)
+	return g.Stop()
+}
@@ -0,0 +1,45 @@
+package gas
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResponses(t *testing.T) {
+	r := New().
+		Get("/redirect", func(g *Gas) (int, Outputter) { return g.Redirect(303, "/there") }).
+		Get("/json", func(g *Gas) (int, Outputter) { return g.JSON(201, map[string]int{"id": 1}) }).
+		Get("/badjson", func(g *Gas) (int, Outputter) { return g.JSON(200, func() {}) }).
+		Get("/text", func(g *Gas) (int, Outputter) { return g.Text(404, "nope") }).
+		Get("/csv", func(g *Gas) (int, Outputter) {
+			g.Header().Set("Content-Type", "text/csv")
+			return g.Text(200, "a,b")
+		}).
+		Get("/none", func(g *Gas) (int, Outputter) { return g.NoContent() })
+
+	tests := []struct {
+		path, contentType, body string
+		code                    int
+	}{
+		{"/redirect", "text/html; charset=utf-8", "<a href=\"/there\">See Other</a>.\n\n", 303},
+		{"/json", "application/json; charset=utf-8", "{\"id\":1}\n", 201},
+		{"/badjson", "text/plain; charset=utf-8", "Internal Server Error\n", 500},
+		{"/text", "text/plain; charset=utf-8", "nope", 404},
+		{"/csv", "text/csv", "a,b", 200},
+		{"/none", "", "", 204},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if w.Code != test.code || w.Header().Get("Content-Type") != test.contentType || w.Body.String() != test.body {
+			t.Errorf("%s: expected %d %q %q, got %d %q %q", test.path, test.code, test.contentType, test.body,
+				w.Code, w.Header().Get("Content-Type"), w.Body.String())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/redirect", nil))
+	if loc := w.Header().Get("Location"); loc != "/there" {
+		t.Errorf("expected a redirect to /there, got %q", loc)
+	}
+}
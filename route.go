@@ -3,23 +3,24 @@ package gas
 import (
 	"bytes"
 	"context"
-	"crypto/tls"
 	"fmt"
 	"html/template"
 	"io"
 	"io/ioutil"
-	"log"
 	"math"
 	"net"
 	"net/http"
 	"net/http/fcgi"
+	"net/url"
 	"os"
 	"os/signal"
-	"path"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"text/tabwriter"
 	"time"
 
@@ -52,10 +53,19 @@ type matcher struct {
 	// means that the captured string should include the entire trailing
 	// portion of the string (the entire string passed into match())
 	next byte
+	// if the pattern constrained the capture with {name:regexp}, the capture
+	// is the longest match of it at the start of the remaining string
+	re *regexp.Regexp
+	// set for a catch-all {name...}, which can capture slashes; otherwise the
+	// capture stops at the end of the path segment
+	rest bool
 }
 
 func (m matcher) String() string {
-	return fmt.Sprintf("[s='%s' name='%s' next='%c']", m.s, m.name, m.next)
+	if m.re != nil {
+		return fmt.Sprintf("[s='%s' name='%s' re='%s' next='%c']", m.s, m.name, m.re, m.next)
+	}
+	return fmt.Sprintf("[s='%s' name='%s' rest=%t next='%c']", m.s, m.name, m.rest, m.next)
 }
 
 // Try to capture a segment of the remaining path fragment.
@@ -67,11 +77,18 @@ func (m matcher) match(s string) string {
 		}
 		return m.s
 	}
-	if m.next == 0 {
+	if m.re != nil {
+		loc := m.re.FindStringIndex(s)
+		if loc == nil || (m.next != 0 && (loc[1] == len(s) || s[loc[1]] != m.next)) {
+			return ""
+		}
+		return s[:loc[1]]
+	}
+	if m.rest && m.next == 0 {
 		return s
 	}
 	for i := 0; i < len(s); i++ {
-		if s[i] == m.next {
+		if s[i] == m.next || (!m.rest && s[i] == '/') {
 			return s[:i]
 		}
 	}
@@ -80,38 +97,82 @@ func (m matcher) match(s string) string {
 
 type route struct {
 	method   string
+	pattern  string
 	matchers []matcher
 	handlers []Handler
+
+	// the router or group the route was added to, whose middleware runs
+	// before the handlers
+	router *Router
+
+	// the order the route was added to its router in; of two routes that
+	// match a request equally well, the first one added handles it
+	index int
+
+	// how long the handlers have to finish, if set with Router.Timeout
+	timeout    time.Duration
+	hasTimeout bool
+
+	// whether to leave the route's requests out of the access log
+	noAccessLog bool
 }
 
-// Compile a route string into a usable format.
+// the literal text every URL matched by the route has to start with
+func (r *route) literal() string {
+	if len(r.matchers) > 0 && len(r.matchers[0].name) == 0 {
+		return r.matchers[0].s
+	}
+	return ""
+}
+
+// Compile a route string into a usable format. A parameter {name} captures up
+// to the end of its path segment, and a catch-all {name...}, meant for the end
+// of the pattern, captures the rest of the path, slashes and all. A parameter
+// can instead be constrained with a regular expression as {name:regexp},
+// which may have braces of its own as long as they're balanced. It panics if
+// the regular expression doesn't compile.
 func newRoute(method, pattern string, handlers []Handler) (r *route) {
 	r = new(route)
 	r.method = method
+	r.pattern = pattern
 	r.matchers = make([]matcher, 0)
 	r.handlers = handlers
 
-	last := 0
+	last, depth := 0, 0
 	for i := 0; i < len(pattern); i++ {
 		switch pattern[i] {
 		case '{':
-			if i > 0 {
-				s := pattern[last:i]
-				r.matchers = append(r.matchers, matcher{s, "", 0})
+			if depth++; depth > 1 {
+				continue
+			}
+			if i > last {
+				r.matchers = append(r.matchers, matcher{s: pattern[last:i]})
 			}
 			last = i + 1
 		case '}':
-			s := pattern[last:i]
-			var next byte
-			if i+1 < len(pattern) {
-				next = pattern[i+1]
+			if depth--; depth > 0 {
+				continue
+			}
+			m := matcher{name: pattern[last:i]}
+			if j := strings.IndexByte(m.name, ':'); j >= 0 {
+				expr := m.name[j+1:]
+				m.name = m.name[:j]
+				m.re = regexp.MustCompile(`^(?:` + expr + `)`)
+				m.re.Longest()
+			} else if strings.HasSuffix(m.name, "...") {
+				m.name = strings.TrimSuffix(m.name, "...")
+				m.rest = true
+			}
+			// the next byte is only known if it's not another parameter
+			if i+1 < len(pattern) && pattern[i+1] != '{' {
+				m.next = pattern[i+1]
 			}
 			last = i + 1
-			r.matchers = append(r.matchers, matcher{"", s, next})
+			r.matchers = append(r.matchers, m)
 		}
 	}
 	if last < len(pattern) {
-		r.matchers = append(r.matchers, matcher{pattern[last:], "", 0})
+		r.matchers = append(r.matchers, matcher{s: pattern[last:]})
 	}
 	return
 }
@@ -120,35 +181,167 @@ func (r *route) String() string {
 	return fmt.Sprintf("[%s] %v", r.method, r.matchers)
 }
 
+// An arg is the value captured from a URL by one of a route's parameters.
+type arg struct {
+	name, value string
+}
+
 // match this route against an incoming url and return args if it matches
 func (r *route) match(method, url string) (map[string]string, bool) {
-	if method != r.method {
+	args, _, ok := r.capture(method, url, nil, nil)
+	if !ok {
 		return nil, false
 	}
-	values := make(map[string]string)
+	values := make(map[string]string, len(args))
+	for _, a := range args {
+		values[a.name] = a.value
+	}
+	return values, true
+}
+
+// capture is like match, but appends the args to args[:0] and where in url
+// each parameter's capture starts to starts[:0], so that matching doesn't have
+// to allocate if they're big enough already
+func (r *route) capture(method, url string, args []arg, starts []int) ([]arg, []int, bool) {
+	if method != r.method {
+		return nil, nil, false
+	}
+	args, starts = args[:0], starts[:0]
 	i := 0
 	for _, m := range r.matchers {
 		if s := m.match(url[i:]); len(s) > 0 {
 			if len(m.name) != 0 {
-				values[m.name] = s
+				args = append(args, arg{m.name, s})
+				starts = append(starts, i)
 			}
 			i += len(s)
 		} else {
-			return nil, false
+			return nil, nil, false
 		}
 	}
 	// don't match if there was still more url left
 	if len(url[i:]) > 0 {
-		return nil, false
+		return nil, nil, false
 	}
-	return values, true
+	return args, starts, true
+}
+
+// the parameters of the route, in order
+func (r *route) params() []matcher {
+	var params []matcher
+	for _, m := range r.matchers {
+		if len(m.name) != 0 {
+			params = append(params, m)
+		}
+	}
+	return params
+}
+
+// how specific a parameter is: a regular expression beats a plain parameter,
+// which beats a catch-all
+func (m matcher) rank() int {
+	switch {
+	case m.re != nil:
+		return 2
+	case m.rest:
+		return 0
+	}
+	return 1
 }
 
+// moreSpecific is whether route a, whose parameters matched a URL starting at
+// the offsets in as, is a better match for it than route b (see Router.Add).
+// With nothing to tell them apart, b wins, being the one added first.
+func moreSpecific(a *route, as []int, b *route, bs []int) bool {
+	ap, bp := a.params(), b.params()
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		switch {
+		case i >= len(as):
+			return true
+		case i >= len(bs):
+			return false
+		case as[i] != bs[i]:
+			return as[i] > bs[i]
+		case ap[i].rank() != bp[i].rank():
+			return ap[i].rank() > bp[i].rank()
+		}
+	}
+	return false
+}
+
+// shape describes the URLs a route matches, ignoring the names of its
+// parameters. Two routes with the same method and shape would match exactly the
+// same requests.
+func (r *route) shape() string {
+	var buf bytes.Buffer
+	for _, m := range r.matchers {
+		switch {
+		case len(m.name) == 0:
+			buf.WriteString(m.s)
+		case m.re != nil:
+			buf.WriteString("{" + m.re.String() + "}")
+		case m.rest:
+			buf.WriteString("{...}")
+		default:
+			buf.WriteString("{}")
+		}
+	}
+	return buf.String()
+}
+
+// build fills in this route's parameters with args, in order, to make a URL
+// path. An argument must satisfy its parameter's regular expression, if it
+// has one.
+func (r *route) build(args []interface{}) (string, error) {
+	var buf bytes.Buffer
+	n := 0
+	for _, m := range r.matchers {
+		if len(m.name) == 0 {
+			buf.WriteString(m.s)
+			continue
+		}
+		if n >= len(args) {
+			return "", errors.Errorf("route %s: missing value for {%s}", r.pattern, m.name)
+		}
+		v := fmt.Sprint(args[n])
+		n++
+		if v == "" {
+			return "", errors.Errorf("route %s: empty value for {%s}", r.pattern, m.name)
+		}
+		if m.re != nil {
+			if loc := m.re.FindStringIndex(v); loc == nil || loc[1] != len(v) {
+				return "", errors.Errorf("route %s: %q doesn't match {%s:%s}", r.pattern, v, m.name, m.re)
+			}
+		} else if !m.rest && strings.IndexByte(v, '/') >= 0 {
+			return "", errors.Errorf("route %s: %q has a slash but {%s} isn't a catch-all", r.pattern, v, m.name)
+		}
+		buf.WriteString((&url.URL{Path: v}).EscapedPath())
+	}
+	if n < len(args) {
+		return "", errors.Errorf("route %s: %d values given for %d parameters", r.pattern, len(args), n)
+	}
+	return buf.String(), nil
+}
+
+// named routes from every router, so that templates can build URLs without
+// knowing which router a route is on
+var namedRoutes = struct {
+	sync.RWMutex
+	m map[string]*route
+}{m: make(map[string]*route)}
+
 // Router is the URL router. Attached methods may be chained for easy adding of
 // routes.
 type Router struct {
 	routes []*route
 
+	// the routes indexed by method, then by their leading literal text
+	trees map[string]*node
+
+	// the pattern of each route by its method and shape, to catch routes
+	// that could never be reached
+	shapes map[string]string
+
 	// these will be executed in order on every request made to this router
 	middleware []Handler
 
@@ -158,6 +351,41 @@ type Router struct {
 
 	// quit can be used to close the server
 	quit chan struct{}
+
+	// the bare listening sockets from GAS_LISTEN, kept for handing over to a
+	// new process on restart
+	listeners []net.Listener
+
+	// routers serving listeners with the ";router=" option, by name
+	bound map[string]*Router
+
+	// set to 1 once shutdown has begun, failing the readiness check
+	draining int32
+
+	// routes given a name with Name
+	names map[string]*route
+
+	// run after the middleware for requests that no route matches
+	notFound []Handler
+
+	// the cross-origin requests allowed, if any
+	cors *CORS
+
+	// what to do about a path that only matches with or without its
+	// trailing slash
+	slash SlashPolicy
+
+	// called with each request instead of writing the usual access log
+	// record, if set with AccessLog
+	accessLog func(e *AccessEntry)
+
+	// answers requests whose handlers panicked, if set with PanicHandler
+	panicHandler func(g *Gas, p *Panic)
+
+	// for a group made with Group, the router it belongs to and the prefix
+	// of its routes, including those of any groups it's inside of
+	parent *Router
+	prefix string
 }
 
 // New creates a new router onto which routes may be added.
@@ -178,6 +406,107 @@ func (r *Router) UseMore(middleware ...Handler) *Router {
 	return r
 }
 
+// Group returns a router whose routes are added to r with their patterns
+// prefixed by prefix. The group has its own middleware stack, starting with
+// middleware, which runs after that of r for the group's routes only. Groups
+// can be nested.
+//
+// Only the routing methods are of use on a group; it's r that should be served.
+// Settings for the whole server, e.g. NotFound and Quit, are passed on to r.
+func (r *Router) Group(prefix string, middleware ...Handler) *Router {
+	return &Router{
+		parent:     r,
+		prefix:     r.prefix + strings.TrimSuffix(prefix, "/"),
+		middleware: middleware,
+	}
+}
+
+// the router that routes are actually added to, which is r unless it's a group
+func (r *Router) root() *Router {
+	for r.parent != nil {
+		r = r.parent
+	}
+	return r
+}
+
+// build the handler chain for a route: the middleware of each router from the
+// outermost down to the one the route was added to, then the route's own
+// handlers
+func (route *route) chain() []Handler {
+	var routers []*Router
+	for r := route.router; r != nil; r = r.parent {
+		routers = append(routers, r)
+	}
+	var handlers []Handler
+	for i := len(routers) - 1; i >= 0; i-- {
+		handlers = append(handlers, routers[i].middleware...)
+	}
+	return append(handlers, route.handlers...)
+}
+
+// PanicHandler sets f to answer requests whose handlers panic, in place of the
+// stack trace page or plain 500 (see GAS_PANIC_PAGE). p describes the panic
+// and is also published as an event. Anything the handlers wrote before they
+// panicked has already gone out to the client.
+func (r *Router) PanicHandler(f func(g *Gas, p *Panic)) *Router {
+	r.root().panicHandler = f
+	return r
+}
+
+// NotFound sets the handlers for requests that don't match any route, which
+// run after the router's middleware like those of any other route. Without
+// them, a plain 404 page is sent. On a group, they're set for the router the
+// group belongs to.
+func (r *Router) NotFound(handlers ...Handler) *Router {
+	r.root().notFound = handlers
+	return r
+}
+
+// A SlashPolicy says what a router does with a request whose path doesn't
+// match any route as it is, but would with a trailing slash added or taken
+// away.
+type SlashPolicy int
+
+const (
+	// StrictSlash treats "/foo" and "/foo/" as different paths. It's the
+	// default.
+	StrictSlash SlashPolicy = iota
+
+	// RedirectSlash redirects the request to the path that has a route.
+	RedirectSlash
+
+	// IgnoreSlash serves the request with the route for the other path.
+	IgnoreSlash
+)
+
+// TrailingSlash sets how r deals with a path that only has a route with or
+// without its trailing slash.
+func (r *Router) TrailingSlash(p SlashPolicy) *Router {
+	r.slash = p
+	return r
+}
+
+// matchSlash matches the request's path with its trailing slash added or
+// taken away, returning either the route to use or the path to redirect to,
+// according to the router's SlashPolicy.
+func (r *Router) matchSlash(req *http.Request) ([]arg, *route, string) {
+	path := req.URL.Path
+	if r.slash == StrictSlash || path == "/" || path == "" {
+		return nil, nil, ""
+	}
+	if strings.HasSuffix(path, "/") {
+		path = strings.TrimSuffix(path, "/")
+	} else {
+		path += "/"
+	}
+
+	values, route := r.matchPath(req.Method, path)
+	if route == nil || r.slash == IgnoreSlash {
+		return values, route, ""
+	}
+	return nil, nil, path
+}
+
 // SetServer allows a user to attach a server to the router inline with other
 // chained setup method calls.
 func (r *Router) SetServer(srv *http.Server) *Router {
@@ -185,19 +514,120 @@ func (r *Router) SetServer(srv *http.Server) *Router {
 	return r
 }
 
-// match each route against incoming url and return args
-func (r *Router) match(req *http.Request) (map[string]string, []Handler) {
-	for _, route := range r.routes {
-		if values, ok := route.match(req.Method, req.URL.Path); ok {
-			return values, route.handlers
+// match each route that might fit against incoming url and return args of
+// the most specific one
+func (r *Router) match(req *http.Request) ([]arg, *route) {
+	return r.matchPath(req.Method, req.URL.Path)
+}
+
+// how many args matching can capture before it has to allocate more room
+const argsBufSize = 8
+
+func (r *Router) matchPath(method, path string) ([]arg, *route) {
+	tree := r.trees[method]
+	if tree == nil {
+		return nil, nil
+	}
+	var (
+		routesBuf            [16]*route
+		tryArgs, bestArgsBuf [argsBufSize]arg
+		tryStarts, bestBuf   [argsBufSize]int
+
+		best       *route
+		bestArgs   []arg
+		bestStarts []int
+	)
+	for _, route := range tree.lookup(path, routesBuf[:0]) {
+		args, starts, ok := route.capture(method, path, tryArgs[:0], tryStarts[:0])
+		if ok && (best == nil || moreSpecific(route, starts, best, bestStarts)) {
+			best = route
+			bestArgs = append(bestArgsBuf[:0], args...)
+			bestStarts = append(bestBuf[:0], starts...)
 		}
 	}
-	return nil, nil
+	if len(bestArgs) == 0 {
+		return nil, best
+	}
+	// the buffers don't outlive the call
+	return append([]arg(nil), bestArgs...), best
 }
 
-// Add a route to the router using the given method.
+// Name gives the most recently added route a name that URLFor can build URLs
+// from. It panics if no routes have been added yet or if the name is already
+// taken on this router.
+func (r *Router) Name(name string) *Router {
+	root := r.root()
+	if len(root.routes) == 0 {
+		panic("gas: Name called before any routes were added")
+	}
+	last := root.routes[len(root.routes)-1]
+
+	if _, ok := root.names[name]; ok {
+		panic("gas: duplicate route name " + strconv.Quote(name))
+	}
+	if root.names == nil {
+		root.names = make(map[string]*route)
+	}
+	root.names[name] = last
+
+	namedRoutes.Lock()
+	namedRoutes.m[name] = last
+	namedRoutes.Unlock()
+	return r
+}
+
+// URLFor builds the path of the route on r called name, filling in its
+// parameters with args in the order they appear in the pattern. Each argument
+// is formatted as with fmt.Sprint and escaped.
+func (r *Router) URLFor(name string, args ...interface{}) (string, error) {
+	route, ok := r.root().names[name]
+	if !ok {
+		return "", errors.Errorf("no route named %q", name)
+	}
+	return route.build(args)
+}
+
+// URLFor is like Router.URLFor, but looks up the name among the routes of
+// every router, using the one named last if more than one router has it. It's
+// available as the "url" template func in package out.
+func URLFor(name string, args ...interface{}) (string, error) {
+	namedRoutes.RLock()
+	route, ok := namedRoutes.m[name]
+	namedRoutes.RUnlock()
+	if !ok {
+		return "", errors.Errorf("no route named %q", name)
+	}
+	return route.build(args)
+}
+
+// Add a route to the router using the given method. When more than one route
+// matches a request, the one with the most literal text before its first
+// parameter handles it, so "/files/special" wins over "/files/{name}" no matter
+// which was added first. Ties are broken by how specific the parameters are
+// (a regular expression, then a plain parameter, then a catch-all) and then
+// by going on to the next parameter. It panics if the route would match
+// exactly the same requests as one added before it.
 func (r *Router) Add(pattern string, method string, handlers ...Handler) *Router {
-	r.routes = append(r.routes, newRoute(method, pattern, handlers))
+	route := newRoute(method, r.prefix+pattern, handlers)
+	route.router = r
+	root := r.root()
+	key := method + " " + route.shape()
+	if other, ok := root.shapes[key]; ok {
+		panic(fmt.Sprintf("gas: route %s %s conflicts with %s", method, route.pattern, other))
+	}
+	if root.shapes == nil {
+		root.shapes = make(map[string]string)
+	}
+	root.shapes[key] = route.pattern
+	route.index = len(root.routes)
+	root.routes = append(root.routes, route)
+	if root.trees == nil {
+		root.trees = make(map[string]*node)
+	}
+	if root.trees[method] == nil {
+		root.trees[method] = new(node)
+	}
+	root.trees[method].insert(route.literal(), route)
 	return r
 }
 
@@ -226,28 +656,56 @@ func (r *Router) Delete(pattern string, handlers ...Handler) *Router {
 	return r.Add(pattern, "DELETE", handlers...)
 }
 
-// StaticHandler adds a handler that serves static files from a directory
-// called "static" in `root` (relative to the working directory). The route
-// path is determined by joining `prefix` with "static" (so e.g. register a
-// handler "/static" by passing in "/" as the prefix).
-//
-// If `root` is an empty string and files have been registered in package
-// bindata, that will be used instead of the physical filesystem. Otherwise, no
-// handlers are added to the router.
-func (r *Router) StaticHandler(urlpath string, dir http.FileSystem) *Router {
-	fs := http.StripPrefix(urlpath, http.FileServer(dir))
-	return r.Get(path.Join(urlpath, "{file}"), func(g *Gas) (int, Outputter) {
-		fs.ServeHTTP(g, g.Request)
-		return g.Stop()
-	})
+// Patch adds a route that responds to PATCH requests.
+func (r *Router) Patch(pattern string, handlers ...Handler) *Router {
+	return r.Add(pattern, "PATCH", handlers...)
+}
+
+// Options adds a route that responds to OPTIONS requests. It takes precedence
+// over the preflight answers given with CORS.
+func (r *Router) Options(pattern string, handlers ...Handler) *Router {
+	return r.Add(pattern, "OPTIONS", handlers...)
+}
+
+// Method adds routes that respond to each of the given methods.
+func (r *Router) Method(verbs []string, pattern string, handlers ...Handler) *Router {
+	for _, verb := range verbs {
+		r.Add(pattern, verb, handlers...)
+	}
+	return r
+}
+
+// the methods routes added with Any respond to
+var anyMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// Any adds routes that respond to GET, HEAD, POST, PUT, PATCH, DELETE, and
+// OPTIONS requests.
+func (r *Router) Any(pattern string, handlers ...Handler) *Router {
+	return r.Method(anyMethods, pattern, handlers...)
 }
 
 // Quit closes all of the listeners in r and causes Ignition to return. It can
-// be used to close the server from another goroutine.
+// be used to close the server from another goroutine, including through any of
+// its groups.
 func (r *Router) Quit() {
+	r = r.root()
+	atomic.StoreInt32(&r.draining, 1)
 	close(r.quit)
 }
 
+// With bundles middleware into a single handler that runs each of them in
+// turn before carrying on down the chain it's in. It can be used to share a
+// stack between routes without attaching it to the whole router:
+//
+//	admin := gas.With(checkLogin, checkAdmin)
+//	r.Get("/admin", admin, dashboard).Post("/admin/users", admin, addUser)
+func With(middleware ...Handler) Handler {
+	return func(g *Gas) (int, Outputter) {
+		g.handlers = append(append([]Handler(nil), middleware...), g.handlers...)
+		return g.Continue()
+	}
+}
+
 // Continue instructs the request context to advance to the next handler in the
 // chain. It is an error to call Continue when no more handlers exist down the
 // chain.
@@ -259,6 +717,13 @@ func (g *Gas) Continue() (int, Outputter) {
 		})
 	}
 
+	// nobody's waiting for the rest, whether the client went away or the
+	// request timed out
+	if err := g.Context().Err(); err != nil {
+		g.Log().Debug("request cancelled", "err", err)
+		return g.Stop()
+	}
+
 	handler := g.handlers[0]
 	g.handlers = g.handlers[1:]
 	return handler(g)
@@ -270,80 +735,173 @@ func (g *Gas) Stop() (int, Outputter) {
 	return -1, nil
 }
 
+// run the handler chain and write out what it returns
+func (g *Gas) run() {
+	code, outputter := g.Continue()
+	if outputter == nil {
+		if code > 0 {
+			g.WriteHeader(code)
+		}
+	} else {
+		outputter.Output(code, g)
+	}
+}
+
 // ServeHTTP satisfies the http.Handler interface.
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	g := newGas(w, req)
+	defer g.release()
+	w.Header().Set("X-Request-ID", g.id)
+
+	if Env.CaptureRequests > 0 {
+		defer startCapture(g)()
+	}
+	if metricsEnabled() {
+		defer startMetrics(g)()
+	}
+
 	defer func() {
 		if nuke := recover(); nuke != nil {
-			log.Printf("panic: %s %s %s%s: %v", req.RemoteAddr, req.Method, req.Host, req.URL.Path, nuke)
+			var pcs []uintptr
+			if tp, ok := nuke.(*timedPanic); ok {
+				nuke, pcs = tp.value, tp.pcs
+			}
+			g.Log().Error("panic", "err", nuke, "method", req.Method,
+				"host", req.Host, "path", req.URL.Path)
 
 			err, ok := nuke.(error)
 			if !ok {
 				err = fmt.Errorf("%v", nuke)
 			}
-			g := &Gas{w: w, Request: req}
-			notifyPanic(g, err)
+			notifyPanic(g, err, pcs, r.root().panicHandler)
 		}
 	}()
 	defer req.Body.Close()
 
-	g := &Gas{
-		w:       w,
-		Request: req,
+	now := time.Now()
+
+	if CurrentProfile().StrictHeaders {
+		setStrictHeaders(g)
 	}
 
-	now := time.Now()
+	values, route := r.match(req)
+	redirect := ""
+	if route == nil {
+		values, route, redirect = r.matchSlash(req)
+	}
 
-	if values, handlers := r.match(req); handlers != nil {
+	switch {
+	case redirect != "":
+		u := *req.URL
+		u.Path = redirect
+		http.Redirect(g, req, u.String(), redirectCode(req))
+	case route == nil && r.cors.preflight(g, r):
+	case route != nil:
+		r.cors.allow(g)
 		g.args = values
-		g.handlers = append(r.middleware, handlers...)
-
-		code, outputter := g.Continue()
-		if outputter == nil {
-			if code > 0 {
-				g.WriteHeader(code)
-			}
+		g.pattern = route.pattern
+		g.handlers = route.chain()
+		if d := route.timeLimit(); d > 0 {
+			g.runTimeout(d)
 		} else {
-			outputter.Output(code, g)
+			g.run()
 		}
-	} else {
+	case r.notFound != nil:
+		g.handlers = append(append([]Handler(nil), r.middleware...), r.notFound...)
+		g.run()
+	default:
 		http.NotFound(g, g.Request)
 	}
 
-	host, _, _ := net.SplitHostPort(g.Host)
+	r.logAccess(g, route, now)
 
-	remote := g.Request.Header.Get("X-Forwarded-For")
-	if remote == "" {
-		remote, _, _ = net.SplitHostPort(g.RemoteAddr)
+	if subscribed(httpRequestType) {
+		Publish(&HTTPRequest{
+			Time:     now,
+			Duration: time.Since(now),
+			Code:     g.responseCode,
+			Request:  g.RequestInfo(),
+		})
+	}
+	if g.responseCode >= 500 && subscribed(serverErrorType) {
+		Publish(&ServerError{
+			Time:    time.Now(),
+			Code:    g.responseCode,
+			Request: g.RequestInfo(),
+			Err:     g.err,
+			Frames:  errorFrames(g.err),
+		})
 	}
-	log.Printf("[%s] %15s %8s %7s (%d) %s%s", fmtDuration(time.Since(now)),
-		remote, g.Proto, g.Method, g.responseCode, host, g.URL.Path)
 }
 
 // TODO: write tests for listen code, including for TLS and all network types
 
 // Ignition starts the server. Should be called after everything else is set up.
+// It blocks until the server stops, returning nil if it was shut down with
+// Quit or a signal and the error otherwise, including any configuration
+// errors found on startup. SIGINT and SIGTERM shut it down gracefully, the
+// same way Quit does.
 func (r *Router) Ignition() error {
+	return r.IgnitionContext(context.Background())
+}
+
+// IgnitionContext is like Ignition, but also shuts the server down gracefully
+// and returns nil once ctx is done.
+func (r *Router) IgnitionContext(ctx context.Context) error {
 	var (
 		now = time.Now()
-		c   = make(chan error)
+		c   = make(chan error, 2)
 	)
 
-	if initFuncs != nil {
-		for _, f := range initFuncs {
-			f()
+	if envErr != nil {
+		return errors.Wrap(envErr, "envconf")
+	}
+	stopSinks, err := setupSinks()
+	if err != nil {
+		return err
+	}
+	defer stopSinks()
+
+	for _, f := range initFuncs {
+		if err := f(); err != nil {
+			return err
 		}
 	}
 
-	go handleSignals(sigchan)
+	if len(signalFuncs) > 0 {
+		sigs := make([]os.Signal, 0, len(signalFuncs))
+		for sig := range signalFuncs {
+			sigs = append(sigs, sig)
+		}
+		done := make(chan struct{})
+		signal.Notify(sigchan, sigs...)
+		defer func() {
+			signal.Stop(sigchan)
+			close(done)
+		}()
+		go handleSignals(sigchan, done)
+	}
+	ctx, stopSignals := signal.NotifyContext(ctx, shutdownSignals...)
+	defer stopSignals()
 
-	log.Printf("Initialization took %v", time.Now().Sub(now))
-	log.Printf("=== Session: %s =========================", now.Format("2006-01-02 15:04"))
+	Logger().Info("initialized", "duration", time.Since(now), "profile", CurrentProfile().Name)
 
 	if Env.Listen != "" {
-		return r.listen(Env.Listen)
+		return r.listen(ctx, Env.Listen)
+	}
+
+	if files := activationFiles(); len(files) > 0 {
+		specs := make([]string, len(files))
+		for i, f := range files {
+			specs[i] = "fd!" + strconv.Itoa(int(f.Fd()))
+		}
+		Logger().Info("using sockets passed in by the service manager", "count", len(files))
+		return r.listen(ctx, strings.Join(specs, ","))
 	}
 
-	log.Print("GAS_PORT, GAS_TLS_PORT, and GAS_FAST_CGI are deprecated, please use GAS_LISTEN")
+	Logger().Warn("GAS_PORT, GAS_TLS_PORT, and GAS_FAST_CGI are deprecated, please use GAS_LISTEN")
+
+	var closer io.Closer
 
 	if Env.FastCGI != "" {
 		parts := strings.SplitN(Env.FastCGI, ":", 2)
@@ -368,154 +926,53 @@ func (r *Router) Ignition() error {
 			s += port
 		}
 		if err != nil {
-			log.Fatalf("gas: fcgi: %v", err)
+			return errors.Wrap(err, "fcgi")
 		}
 
-		log.Printf("FastCGI listening on %s", s)
+		Logger().Info("serving FastCGI", "addr", s)
 		go func() {
-			select {
-			case c <- fcgi.Serve(l, r):
-			case <-r.quit:
-				l.Close()
-			}
+			c <- fcgi.Serve(l, r)
 		}()
+		closer = l
 	} else {
 		if Env.Port < 0 && Env.TLSPort < 0 {
-			log.Fatalf("must have at least one of either GAS_PORT or GAS_TLS_PORT set")
+			return errors.New("must have at least one of either GAS_PORT or GAS_TLS_PORT set")
 		}
 		if r.Server == nil {
-			r.Server = &http.Server{
-			//ReadTimeout:  60 * time.Second,
-			//WriteTimeout: 10 * time.Second,
-			}
+			r.Server = &http.Server{}
 		}
 
-		r.Server.Handler = r
+		r.Server.Handler = r.healthHandler(limitRequests(r))
+		configureServer(r.Server)
 
 		if Env.TLSPort > 0 {
-			go listenTLS(r.Server, c, r.quit)
+			go listenTLS(ctx, r.Server, c)
 		}
 
 		if Env.Port > 0 {
-			go listen(r.Server, c, r.quit)
+			go listen(r.Server, c)
 		}
 	}
 
 	select {
 	case err := <-c:
-		return err
-	case <-r.quit:
-		return nil
-	}
-}
-
-func (r *Router) listen(listenenv string) error {
-	var (
-		addrs   = strings.Split(listenenv, ",")
-		ll      = make([]net.Listener, len(addrs))
-		cfg     *tls.Config
-		srv     *http.Server
-		errchan chan error
-	)
-
-	if r.Server != nil {
-		srv = r.Server
-	} else {
-		srv = &http.Server{}
-	}
-
-	srv.Handler = r
-
-	for i, addr := range addrs {
-		var (
-			optTLS  bool
-			network string
-			laddr   string
-		)
-
-		addropt := strings.SplitN(addr, ";", 2)
-		if len(addropt) == 2 {
-			switch addropt[1] {
-			case "tls":
-				optTLS = true
-			default:
-				return errors.Errorf("GAS_LISTEN: invalid option: %q", addropt[1])
-			}
-		}
-
-		netaddr := strings.SplitN(strings.TrimSpace(addr), "!", 2)
-		switch len(netaddr) {
-		case 1:
-			network = "tcp"
-			laddr = netaddr[0]
-		case 2:
-			network, laddr = netaddr[0], netaddr[1]
-		default:
-			return errors.Errorf("GAS_LISTEN: invalid listen syntax: %q", listenenv)
-		}
-
-		l, err := net.Listen(network, laddr)
-		if err != nil {
-			return err
-		}
-
-		if optTLS {
-			if cfg == nil {
-				cfg, err = tlsConfig(Env.TLSCert, Env.TLSKey, Env.TLSHost)
-				if err != nil {
-					return err
-				}
-			}
-			l = tls.NewListener(l, cfg)
+		if closer != nil {
+			closer.Close()
+		} else {
+			r.Server.Close()
 		}
-
-		ll[i] = l
-	}
-
-	for _, l := range ll {
-		go func(l net.Listener) {
-			log.Printf("serving on %v", l.Addr())
-			err := srv.Serve(l)
-			log.Printf("%v: %v", l.Addr(), err)
-			select {
-			case errchan <- err:
-			default:
-			}
-		}(l)
-	}
-
-	var (
-		err error
-		sig = make(chan os.Signal)
-		ctx = context.Background()
-	)
-
-	signal.Notify(sig, os.Interrupt)
-
-	select {
-	case err = <-errchan:
-	case <-sig:
-		// TODO: attempt graceful shutdown upon first ^C, force on second
+		return err
 	case <-r.quit:
+	case <-ctx.Done():
 	}
 
-	srv.Shutdown(ctx)
-	return err
-}
-
-func fmtDuration(d time.Duration) string {
-	switch {
-	case d <= time.Microsecond:
-		return fmt.Sprintf("% 4dns", d)
-	case d <= time.Millisecond:
-		return fmt.Sprintf("% 4dµs", d/time.Microsecond)
-	case d <= time.Second:
-		return fmt.Sprintf("% 4dms", d/time.Millisecond)
-	case d <= time.Minute:
-		return fmt.Sprintf("% 2.2fs", float64(d)/float64(time.Second))
-	default:
-		return fmt.Sprintf("% 6s", d.String())
+	r.drain()
+	if closer != nil {
+		return closer.Close()
 	}
+	sctx, cancel := context.WithTimeout(context.Background(), Env.ShutdownTimeout)
+	defer cancel()
+	return r.Server.Shutdown(sctx)
 }
 
 // number of lines of context to show around panicking code
@@ -524,10 +981,19 @@ const amountOfContext = 5
 // format the current goroutine's stack nicely, optionally returning the lines
 // of code around and including the panicking line
 func fmtStack(skip, count int, showSource bool) (source []string, actualLine int, panickingFile string, stack *bytes.Buffer) {
-	stack = new(bytes.Buffer)
+	return fmtCallers(callers(skip+1, count), showSource)
+}
+
+// the program counters of the current goroutine's stack, skipping as many
+// calls as runtime.Callers would if it were called in place of this
+func callers(skip, count int) []uintptr {
 	pcs := make([]uintptr, count)
-	s := runtime.Callers(skip, pcs)
-	pcs = pcs[:s]
+	return pcs[:runtime.Callers(skip+1, pcs)]
+}
+
+// fmtStack for a stack already taken with callers
+func fmtCallers(pcs []uintptr, showSource bool) (source []string, actualLine int, panickingFile string, stack *bytes.Buffer) {
+	stack = new(bytes.Buffer)
 	tw := tabwriter.NewWriter(stack, 4, 8, 1, ' ', 0)
 
 	for i, pc := range pcs {
@@ -583,28 +1049,112 @@ func fmtStack(skip, count int, showSource bool) (source []string, actualLine int
 	return
 }
 
+// the calls on the current goroutine's stack, innermost first
+func stackFrames(skip, count int) []runtime.Frame {
+	return callerFrames(callers(skip+1, count))
+}
+
+func callerFrames(pcs []uintptr) []runtime.Frame {
+	frames := runtime.CallersFrames(pcs)
+
+	var stack []runtime.Frame
+	for {
+		f, more := frames.Next()
+		stack = append(stack, f)
+		if !more {
+			return stack
+		}
+	}
+}
+
+// the calls on the stack where err was made, innermost first, if it or an
+// error it wraps has a stack trace from github.com/pkg/errors
+func errorFrames(err error) []runtime.Frame {
+	var trace errors.StackTrace
+	for ; err != nil; err = errors.Unwrap(err) {
+		if st, ok := err.(interface{ StackTrace() errors.StackTrace }); ok {
+			trace = st.StackTrace()
+		}
+	}
+	if len(trace) == 0 {
+		return nil
+	}
+
+	pcs := make([]uintptr, len(trace))
+	for i, f := range trace {
+		pcs[i] = uintptr(f)
+	}
+	frames := runtime.CallersFrames(pcs)
+	var stack []runtime.Frame
+	for {
+		f, more := frames.Next()
+		stack = append(stack, f)
+		if !more {
+			return stack
+		}
+	}
+}
+
 func printStack(skip, count int) {
 	_, _, _, buf := fmtStack(skip+1, count, false)
 	io.Copy(os.Stderr, buf)
 }
 
-func notifyPanic(g *Gas, err error) {
-	// here we skip 5 because we know the last calls are guaranteed:
-	//     0 runtime.panic
-	//     1 func·NNN (the deferred recover)
-	//     2 runtime.Callers
-	//     3 fmtStack
-	//     4 notifyPanic
-	// that way we can get right to the source of it with less noise
-	source, lineNum, file, stack := fmtStack(5, 10, true)
+// notifyPanic reports a panic recovered while serving g. pcs is the stack
+// the panic happened on, if it was taken elsewhere, e.g. in the goroutine of
+// runTimeout; otherwise it's the current one.
+func notifyPanic(g *Gas, err error, pcs []uintptr, handler func(g *Gas, p *Panic)) {
+	if pcs == nil {
+		// here we skip 4 because we know the last calls are guaranteed:
+		//     0 callers
+		//     1 notifyPanic
+		//     2 func·NNN (the deferred recover)
+		//     3 runtime.gopanic
+		// that way we can get right to the source of it with less noise
+		pcs = callers(4, 32)
+	}
+	short := pcs
+	if len(short) > 10 {
+		short = short[:10]
+	}
+	source, lineNum, file, stack := fmtCallers(short, true)
+
+	p := &Panic{
+		Time:    time.Now(),
+		Err:     err,
+		Request: g.RequestInfo(),
+		Stack:   stack.String(),
+		Frames:  callerFrames(pcs),
+		File:    file,
+		Line:    lineNum,
+		Source:  source,
+	}
+	if subscribed(panicType) {
+		Publish(p)
+	}
+
+	if handler != nil {
+		handler(g, p)
+		return
+	}
+
+	if !debugPanics() {
+		io.Copy(os.Stderr, stack)
+		if g.Header().Get("Content-Type") == "" {
+			g.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			g.WriteHeader(500)
+		}
+		fmt.Fprintln(g, "500 internal server error")
+		return
+	}
 
 	// don't write header if panic happened in outputter
-	if g.w.Header().Get("Content-Type") == "" {
-		g.w.Header().Set("Content-Type", "text/html; encoding=utf-8")
-		g.w.WriteHeader(500)
+	if g.Header().Get("Content-Type") == "" {
+		g.Header().Set("Content-Type", "text/html; encoding=utf-8")
+		g.WriteHeader(500)
 	}
 
-	tmplErr := panicTemplate.Execute(g.w, &struct {
+	tmplErr := panicTemplate.Execute(g, &struct {
 		Err    error
 		Stack  string
 		File   string
@@ -617,6 +1167,18 @@ func notifyPanic(g *Gas, err error) {
 	}
 }
 
+// whether to show the stack trace page for panics, per GAS_PANIC_PAGE or else
+// the profile
+func debugPanics() bool {
+	switch strings.ToLower(Env.PanicPage) {
+	case "":
+		return CurrentProfile().DebugPanics
+	case "debug":
+		return true
+	}
+	return false
+}
+
 var panicTemplate = template.Must(template.New("panic").Parse(`
 <!DOCTYPE html>
 <html>
@@ -1,8 +1,10 @@
 package gas
 
 import (
+	"io/ioutil"
 	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
 
 	"ktkr.us/pkg/gas/testutil"
@@ -34,16 +36,39 @@ var tests = []Test{
 	{"/blog/view/{id}", "/files/manga/manga.html", nil, false},
 	{"/blog/view/{id}", "/blog/view", nil, false},
 	{"/blog/view/{id}", "/blog/view/123", map[string]string{"id": "123"}, true},
-	{"/blog/view/{id}", "/blog/view/asdf/asdf", map[string]string{"id": "asdf/asdf"}, true}, // XXX: is this behavior really desired?
+	{"/blog/view/{id}", "/blog/view/asdf/asdf", nil, false},
 	{"/files", "/files", nil, true},
 	{"/files{path}", "/blog/view/123", nil, false},
-	// XXX: work on trailing slash handling
-	{"/files{path}", "/files/", map[string]string{"path": "/"}, true},
-	{"/files{path}", "/files/lol", map[string]string{"path": "/lol"}, true},
-	{"/files/{a}/{b}/{c}", "/files/a/b/c/asdf/日本語/index.html", map[string]string{"a": "a", "b": "b", "c": "c/asdf/日本語/index.html"}, true},
+	{"/files{path...}", "/files/", map[string]string{"path": "/"}, true},
+	{"/files{path...}", "/files/lol", map[string]string{"path": "/lol"}, true},
+	{"/files/{a}/{b}/{c...}", "/files/a/b/c/asdf/日本語/index.html", map[string]string{"a": "a", "b": "b", "c": "c/asdf/日本語/index.html"}, true},
 	{"/test/{id}/asdf", "/test/a", nil, false},
 	{"/test/{id}/asdf", "/test/a/a", nil, false},
 	{"/test/{id}/asdf", "/test/b/asdf", map[string]string{"id": "b"}, true},
+	{"/blog/view/{id:[0-9]+}", "/blog/view/123", map[string]string{"id": "123"}, true},
+	{"/blog/view/{id:[0-9]+}", "/blog/view/abc", nil, false},
+	{"/blog/view/{id:[0-9]+}", "/blog/view/123/asdf", nil, false},
+	{"/blog/view/{id:[0-9]+}", "/blog/view/123abc", nil, false},
+	{"/blog/{id:[0-9]+}/edit", "/blog/42/edit", map[string]string{"id": "42"}, true},
+	{"/blog/{id:[0-9]+}/edit", "/blog/42x/edit", nil, false},
+	{"/archive/{year:[0-9]{4}}/{slug}", "/archive/2016/hello", map[string]string{"year": "2016", "slug": "hello"}, true},
+	{"/archive/{year:[0-9]{4}}/{slug}", "/archive/16/hello", nil, false},
+	{"/files/{name:[^/]+}", "/files/a/b", nil, false},
+	{"/{a:[a-z]+}{b:[0-9]+}", "/abc123", map[string]string{"a": "abc", "b": "123"}, true},
+	{"/blog/view/{id...}", "/blog/view/asdf/asdf", map[string]string{"id": "asdf/asdf"}, true},
+	{"/files{path}", "/files/lol", nil, false},
+	{"/files/{name}.{ext}", "/files/a.tar.gz", map[string]string{"name": "a", "ext": "tar.gz"}, true},
+	{"/files/{name}.{ext}", "/files/a/b.txt", nil, false},
+	{"/files/{path...}/edit", "/files/a/edit", map[string]string{"path": "a"}, true},
+}
+
+func TestBadPatternPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for an invalid regexp")
+		}
+	}()
+	newRoute("GET", "/blog/{id:[0-9}", nil)
 }
 
 func TestMatch(t *testing.T) {
@@ -120,6 +145,279 @@ func TestDispatch(t *testing.T) {
 	}
 }
 
+func TestURLFor(t *testing.T) {
+	h := func(g *Gas) (int, Outputter) { return g.Stop() }
+	r := New().
+		Get("/", h).Name("urlfor-index").
+		Get("/blog/{id:[0-9]+}/edit", h).Name("urlfor-edit").
+		Get("/files/{path...}", h).Name("urlfor-files").
+		Get("/users/{name}", h).Name("urlfor-user")
+
+	for _, test := range []struct {
+		name string
+		args []interface{}
+		url  string
+		ok   bool
+	}{
+		{"urlfor-index", nil, "/", true},
+		{"urlfor-edit", []interface{}{42}, "/blog/42/edit", true},
+		{"urlfor-edit", []interface{}{"abc"}, "", false},
+		{"urlfor-edit", nil, "", false},
+		{"urlfor-edit", []interface{}{1, 2}, "", false},
+		{"urlfor-files", []interface{}{"a b/日本語"}, "/files/a%20b/%E6%97%A5%E6%9C%AC%E8%AA%9E", true},
+		{"urlfor-user", []interface{}{"a/b"}, "", false},
+		{"urlfor-nope", nil, "", false},
+	} {
+		url, err := r.URLFor(test.name, test.args...)
+		if (err == nil) != test.ok || url != test.url {
+			t.Errorf("URLFor(%q, %v): expected %q (ok=%v), got %q, %v", test.name, test.args, test.url, test.ok, url, err)
+		}
+	}
+
+	if url, err := URLFor("urlfor-edit", 7); err != nil || url != "/blog/7/edit" {
+		t.Errorf("package URLFor: got %q, %v", url, err)
+	}
+
+	// another router can use the same names without taking them from r
+	other := New().Get("/other", h).Name("urlfor-index")
+	if url, err := other.URLFor("urlfor-index"); err != nil || url != "/other" {
+		t.Errorf("other router URLFor: got %q, %v", url, err)
+	}
+	if url, err := r.URLFor("urlfor-index"); err != nil || url != "/" {
+		t.Errorf("URLFor after another router used the name: got %q, %v", url, err)
+	}
+	if url, err := URLFor("urlfor-index"); err != nil || url != "/other" {
+		t.Errorf("package URLFor after another router used the name: got %q, %v", url, err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for a duplicate route name")
+		}
+	}()
+	r.Get("/again", h).Name("urlfor-index")
+}
+
+func TestGroup(t *testing.T) {
+	write := func(s string) Handler {
+		return func(g *Gas) (int, Outputter) {
+			g.Write([]byte(s))
+			return g.Continue()
+		}
+	}
+	stop := func(g *Gas) (int, Outputter) { return g.Stop() }
+
+	r := New().Use(write("root,"))
+	api := r.Group("/api/v1/", write("api,"))
+	api.Get("/users", write("users"), stop).Name("group-users")
+	api.Group("/admin", write("admin,")).Get("/{id}", write("id"), stop)
+	r.Get("/", write("index"), stop)
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	testutil.TestGet(t, srv, "/api/v1/users", "root,api,users")
+	testutil.TestGet(t, srv, "/api/v1/admin/3", "root,api,admin,id")
+	testutil.TestGet(t, srv, "/", "root,index")
+
+	if url, err := r.URLFor("group-users"); err != nil || url != "/api/v1/users" {
+		t.Errorf("URLFor: got %q, %v", url, err)
+	}
+}
+
+func TestGroupSettings(t *testing.T) {
+	r := New()
+	api := r.Group("/api")
+	api.Get("/users", func(g *Gas) (int, Outputter) { return g.Stop() })
+	api.NotFound(func(g *Gas) (int, Outputter) {
+		g.WriteHeader(404)
+		g.Write([]byte("nope"))
+		return g.Stop()
+	})
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/api/posts", nil))
+	if rec.Code != 404 || rec.Body.String() != "nope" {
+		t.Errorf("expected the group's NotFound handler, got %d %q", rec.Code, rec.Body)
+	}
+
+	api.Quit()
+	select {
+	case <-r.quit:
+	default:
+		t.Error("expected Quit on a group to quit its router")
+	}
+	if r.Ready() || api.Ready() {
+		t.Error("expected the router to stop being ready")
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	r := New().
+		Use(func(g *Gas) (int, Outputter) {
+		g.SetData("middleware", "yes")
+		return g.Continue()
+	}).
+		Get("/", func(g *Gas) (int, Outputter) { return g.Stop() }).
+		NotFound(func(g *Gas) (int, Outputter) {
+		return 404, OutputFunc(func(code int, g *Gas) {
+			g.WriteHeader(code)
+			g.Write([]byte("missing " + g.URL.Path + " " + g.Data("middleware").(string)))
+		})
+	})
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	resp, err := testutil.Client.Get(srv.URL + "/nope")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 404 || string(body) != "missing /nope yes" {
+		t.Errorf("expected 404 \"missing /nope yes\", got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestWith(t *testing.T) {
+	write := func(s string) Handler {
+		return func(g *Gas) (int, Outputter) {
+			g.Write([]byte(s))
+			return g.Continue()
+		}
+	}
+	stop := func(g *Gas) (int, Outputter) { return g.Stop() }
+	deny := func(g *Gas) (int, Outputter) {
+		g.Write([]byte("denied"))
+		return g.Stop()
+	}
+
+	r := New().
+		Get("/public", write("public"), stop).
+		Get("/admin", With(write("a,"), write("b,")), write("admin"), stop).
+		Get("/secret", With(deny), write("secret"), stop)
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	testutil.TestGet(t, srv, "/public", "public")
+	testutil.TestGet(t, srv, "/admin", "a,b,admin")
+	testutil.TestGet(t, srv, "/secret", "denied")
+}
+
+func TestMethods(t *testing.T) {
+	h := func(s string) Handler {
+		return func(g *Gas) (int, Outputter) {
+			g.Write([]byte(s))
+			return g.Stop()
+		}
+	}
+	r := New().
+		Patch("/patch", h("patch")).
+		Options("/options", h("options")).
+		Method([]string{"PUT", "DELETE"}, "/method", h("method")).
+		Any("/any", h("any"))
+
+	for _, test := range []struct {
+		method, url, body string
+	}{
+		{"PATCH", "/patch", "patch"},
+		{"GET", "/patch", "404 page not found\n"},
+		{"OPTIONS", "/options", "options"},
+		{"PUT", "/method", "method"},
+		{"DELETE", "/method", "method"},
+		{"POST", "/method", "404 page not found\n"},
+		{"GET", "/any", "any"},
+		{"PATCH", "/any", "any"},
+		{"OPTIONS", "/any", "any"},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(test.method, test.url, nil))
+		if w.Body.String() != test.body {
+			t.Errorf("%s %s: expected %q, got %q", test.method, test.url, test.body, w.Body.String())
+		}
+	}
+}
+
+func TestSpecificity(t *testing.T) {
+	h := func(s string) Handler {
+		return func(g *Gas) (int, Outputter) {
+			g.Write([]byte(s))
+			return g.Stop()
+		}
+	}
+	r := New().
+		Get("/files/{path...}", h("rest")).
+		Get("/files/{name}", h("name")).
+		Get("/files/{id:[0-9]+}", h("id")).
+		Get("/files/special", h("special")).
+		Get("/{a}/{b}/edit", h("edit")).
+		Get("/{a}/{b}/{c}", h("abc"))
+
+	for url, want := range map[string]string{
+		"/files/special": "special",
+		"/files/12":      "id",
+		"/files/x":       "name",
+		"/files/x/y":     "rest",
+		"/blog/1/edit":   "edit",
+		"/blog/1/view":   "abc",
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		if w.Body.String() != want {
+			t.Errorf("%s: expected %q, got %q", url, want, w.Body.String())
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for a conflicting route")
+		}
+	}()
+	r.Get("/files/{other}", h("other"))
+}
+
+func TestTrailingSlash(t *testing.T) {
+	h := func(g *Gas) (int, Outputter) {
+		g.Write([]byte(g.URL.Path))
+		return g.Stop()
+	}
+	r := New().Get("/foo", h).Get("/bar/", h)
+
+	serve := func(method, url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, url, nil))
+		return w
+	}
+
+	if w := serve("GET", "/foo/"); w.Code != 404 {
+		t.Errorf("strict: expected 404, got %d", w.Code)
+	}
+
+	r.TrailingSlash(RedirectSlash)
+	for _, test := range []struct {
+		method, url, location string
+		code                  int
+	}{
+		{"GET", "/foo/?a=1", "/foo?a=1", 301},
+		{"GET", "/bar", "/bar/", 301},
+		{"HEAD", "/bar", "/bar/", 301},
+		{"GET", "/foo", "", 200},
+		{"GET", "/baz/", "", 404},
+	} {
+		w := serve(test.method, test.url)
+		if w.Code != test.code || w.Header().Get("Location") != test.location {
+			t.Errorf("redirect %s: expected %d %q, got %d %q", test.url, test.code, test.location, w.Code, w.Header().Get("Location"))
+		}
+	}
+
+	r.TrailingSlash(IgnoreSlash)
+	if w := serve("GET", "/foo/"); w.Code != 200 || w.Body.String() != "/foo/" {
+		t.Errorf("ignore: expected 200 /foo/, got %d %q", w.Code, w.Body.String())
+	}
+}
+
 type Bench struct {
 	route *route
 	url   string
@@ -161,3 +459,38 @@ func BenchmarkMatchSingle14(b *testing.B) {
 		r.route.match("GET", r.url)
 	}
 }
+
+func TestPanicHandler(t *testing.T) {
+	defer func(env, page string) { Env.Env, Env.PanicPage = env, page }(Env.Env, Env.PanicPage)
+	Env.Env = "dev"
+
+	r := New().Get("/panic", func(g *Gas) (int, Outputter) {
+		panic("lol")
+	})
+
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/panic", nil))
+		return w
+	}
+
+	if w := get(); w.Code != 500 || !strings.Contains(w.Body.String(), "The server is panicking!") {
+		t.Errorf("expected the debug page in dev, got %d %q", w.Code, w.Body)
+	}
+	Env.PanicPage = "plain"
+	if w := get(); w.Code != 500 || w.Body.String() != "500 internal server error\n" {
+		t.Errorf("expected a plain 500, got %d %q", w.Code, w.Body)
+	}
+
+	var got *Panic
+	r.PanicHandler(func(g *Gas, p *Panic) {
+		got = p
+		g.WriteHeader(503)
+	})
+	if w := get(); w.Code != 503 {
+		t.Errorf("expected the panic handler's status, got %d", w.Code)
+	}
+	if got == nil || got.Err.Error() != "lol" || got.Request.Route != "/panic" || got.Stack == "" {
+		t.Errorf("unexpected panic: %+v", got)
+	}
+}
@@ -0,0 +1,83 @@
+package gas
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+)
+
+// RouteInfo describes a route added to a router.
+type RouteInfo struct {
+	Method  string
+	Pattern string
+	Name    string // given with Router.Name, if any
+
+	// The names of the functions that handle the route, including the
+	// middleware of its router and any groups it's in, in the order they
+	// run, e.g. "main.checkLogin".
+	Handlers []string
+}
+
+// Routes lists the routes on r, including those added through its groups, in
+// the order they were added.
+func (r *Router) Routes() []RouteInfo {
+	root := r.root()
+	names := make(map[*route]string, len(root.names))
+	for name, route := range root.names {
+		names[route] = name
+	}
+
+	routes := make([]RouteInfo, len(root.routes))
+	for i, route := range root.routes {
+		chain := route.chain()
+		routes[i] = RouteInfo{
+			Method:   route.method,
+			Pattern:  route.pattern,
+			Name:     names[route],
+			Handlers: make([]string, len(chain)),
+		}
+		for j, h := range chain {
+			routes[i].Handlers[j] = handlerName(h)
+		}
+	}
+	return routes
+}
+
+func handlerName(h Handler) string {
+	f := runtime.FuncForPC(reflect.ValueOf(h).Pointer())
+	if f == nil {
+		return "?"
+	}
+	return f.Name()
+}
+
+// RoutesHandler shows the table of routes on r, as JSON if the client asks for
+// it and as plain text otherwise. It should only be served on an internal or
+// otherwise protected router:
+//
+//	admin.Get("/routes", r.RoutesHandler)
+func (r *Router) RoutesHandler(g *Gas) (int, Outputter) {
+	routes := r.Routes()
+	g.Header().Set("Cache-Control", "no-store")
+	if g.Wants() == "application/json" {
+		g.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(g).Encode(routes)
+		return g.Stop()
+	}
+
+	g.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	tw := tabwriter.NewWriter(g, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "METHOD\tPATTERN\tNAME\tHANDLERS")
+	for _, route := range routes {
+		name := route.Name
+		if name == "" {
+			name = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", route.Method, route.Pattern, name, strings.Join(route.Handlers, ", "))
+	}
+	tw.Flush()
+	return g.Stop()
+}
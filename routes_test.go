@@ -0,0 +1,35 @@
+package gas
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func routesTestMiddleware(g *Gas) (int, Outputter) { return g.Continue() }
+
+func routesTestHandler(g *Gas) (int, Outputter) { return g.Stop() }
+
+func TestRoutes(t *testing.T) {
+	r := New().Use(routesTestMiddleware).
+		Post("/login", routesTestHandler).Name("routes-login")
+	r.Group("/api").Put("/users/{id}", routesTestHandler)
+
+	mw, h := "ktkr.us/pkg/gas.routesTestMiddleware", "ktkr.us/pkg/gas.routesTestHandler"
+	want := []RouteInfo{
+		{"POST", "/login", "routes-login", []string{mw, h}},
+		{"PUT", "/api/users/{id}", "", []string{mw, h}},
+	}
+	if got := r.Routes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+
+	w := httptest.NewRecorder()
+	g := &Gas{w: w, Request: httptest.NewRequest("GET", "/routes", nil)}
+	r.RoutesHandler(g)
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "POST") || !strings.Contains(lines[2], "/api/users/{id}") {
+		t.Errorf("unexpected table:\n%s", w.Body)
+	}
+}
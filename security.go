@@ -0,0 +1,79 @@
+package gas
+
+import (
+	"strconv"
+	"time"
+)
+
+// SecurityHeaders is middleware that sets security related response headers.
+// Empty fields leave their header alone, and "-" (or a negative HSTS)
+// removes one set further up the chain, so that a route can override what
+// the router sets for everything:
+//
+//	r.Use((&gas.SecurityHeaders{
+//		HSTS:                  365 * 24 * time.Hour,
+//		ContentTypeOptions:    "nosniff",
+//		FrameOptions:          "DENY",
+//		ContentSecurityPolicy: "default-src 'self'",
+//	}).Handle)
+//	r.Get("/embed", (&gas.SecurityHeaders{FrameOptions: "-"}).Handle, embed)
+type SecurityHeaders struct {
+	// The max-age of Strict-Transport-Security, which is only sent over
+	// TLS, and whether it covers subdomains and asks to be preloaded.
+	HSTS           time.Duration
+	HSTSSubdomains bool
+	HSTSPreload    bool
+
+	ContentTypeOptions    string // X-Content-Type-Options
+	FrameOptions          string // X-Frame-Options
+	ReferrerPolicy        string // Referrer-Policy
+	ContentSecurityPolicy string // Content-Security-Policy
+}
+
+// StrictHeaders are the headers set on every response when the profile has
+// StrictHeaders.
+var StrictHeaders = SecurityHeaders{
+	HSTS:               2 * 365 * 24 * time.Hour,
+	HSTSSubdomains:     true,
+	ContentTypeOptions: "nosniff",
+	FrameOptions:       "SAMEORIGIN",
+	ReferrerPolicy:     "strict-origin-when-cross-origin",
+}
+
+// Handle is the middleware.
+func (s *SecurityHeaders) Handle(g *Gas) (int, Outputter) {
+	s.set(g)
+	return g.Continue()
+}
+
+func (s *SecurityHeaders) set(g *Gas) {
+	h := g.Header()
+	for name, v := range map[string]string{
+		"X-Content-Type-Options":  s.ContentTypeOptions,
+		"X-Frame-Options":         s.FrameOptions,
+		"Referrer-Policy":         s.ReferrerPolicy,
+		"Content-Security-Policy": s.ContentSecurityPolicy,
+	} {
+		switch v {
+		case "":
+		case "-":
+			h.Del(name)
+		default:
+			h.Set(name, v)
+		}
+	}
+
+	switch {
+	case s.HSTS < 0:
+		h.Del("Strict-Transport-Security")
+	case s.HSTS > 0 && g.TLS != nil:
+		v := "max-age=" + strconv.FormatInt(int64(s.HSTS/time.Second), 10)
+		if s.HSTSSubdomains {
+			v += "; includeSubDomains"
+		}
+		if s.HSTSPreload {
+			v += "; preload"
+		}
+		h.Set("Strict-Transport-Security", v)
+	}
+}
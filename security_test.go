@@ -0,0 +1,55 @@
+package gas
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	r := New().
+		Use((&SecurityHeaders{
+			HSTS:                  time.Hour,
+			HSTSSubdomains:        true,
+			HSTSPreload:           true,
+			ContentTypeOptions:    "nosniff",
+			FrameOptions:          "DENY",
+			ContentSecurityPolicy: "default-src 'self'",
+		}).Handle).
+		Get("/", func(g *Gas) (int, Outputter) { return g.Stop() }).
+		Get("/embed", (&SecurityHeaders{FrameOptions: "-", HSTS: -1}).Handle, func(g *Gas) (int, Outputter) { return g.Stop() })
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.TLS = &tls.ConnectionState{}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	for name, want := range map[string]string{
+		"Strict-Transport-Security": "max-age=3600; includeSubDomains; preload",
+		"X-Content-Type-Options":    "nosniff",
+		"X-Frame-Options":           "DENY",
+		"Content-Security-Policy":   "default-src 'self'",
+		"Referrer-Policy":           "",
+	} {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("%s: expected %q, got %q", name, want, got)
+		}
+	}
+
+	req = httptest.NewRequest("GET", "/embed", nil)
+	req.TLS = &tls.ConnectionState{}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Header().Get("X-Frame-Options") != "" || w.Header().Get("Strict-Transport-Security") != "" {
+		t.Errorf("expected the route to remove headers, got %v", w.Header())
+	}
+	if w.Header().Get("Content-Security-Policy") == "" {
+		t.Error("expected the route to keep the headers it doesn't override")
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Header().Get("Strict-Transport-Security") != "" {
+		t.Error("expected no HSTS without TLS")
+	}
+}
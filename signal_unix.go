@@ -8,11 +8,16 @@ import (
 )
 
 var signalFuncs = map[os.Signal][]func(){
-	syscall.SIGINT:  {stop},
 	syscall.SIGQUIT: {stop},
-	syscall.SIGTERM: {stop},
+	syscall.SIGUSR1: {cycleLogLevel},
 }
 
+// signals that shut the server down gracefully, like Quit
+var shutdownSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+
+// signals that make the server restart itself (see Env.Listen)
+var restartSignals = []os.Signal{syscall.SIGUSR2}
+
 func stop() {
 	println()
 	exit(0)
@@ -0,0 +1,71 @@
+//go:build darwin || freebsd || linux || netbsd || openbsd
+// +build darwin freebsd linux netbsd openbsd
+
+package gas
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestShutdownSignal(t *testing.T) {
+	defer func(listen string, delay time.Duration, funcs []func() error) {
+		Env.Listen, Env.DrainDelay, initFuncs = listen, delay, funcs
+	}(Env.Listen, Env.DrainDelay, initFuncs)
+	initFuncs = nil
+
+	sock := filepath.Join(t.TempDir(), "gas.sock")
+	Env.Listen = "unix!" + sock
+	Env.DrainDelay = 300 * time.Millisecond
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+		DisableKeepAlives: true,
+	}}
+	readyz := func() int {
+		resp, err := client.Get("http://gas/readyz")
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- New().Ignition() }()
+	for i := 0; readyz() != 200; i++ {
+		if i > 100 {
+			t.Fatal("server didn't become ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	code := 200
+	for deadline := time.Now().Add(time.Second); code == 200 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		code = readyz()
+	}
+	if code != 503 {
+		t.Errorf("expected readiness to fail while draining, got %d", code)
+	}
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("expected nil error after SIGTERM, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Ignition didn't return after SIGTERM")
+	}
+	if code := readyz(); code != 0 {
+		t.Errorf("expected the listener to be closed, got %d", code)
+	}
+}
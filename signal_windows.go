@@ -3,3 +3,7 @@ package gas
 import "os"
 
 var signalFuncs = make(map[os.Signal][]func())
+
+var shutdownSignals = []os.Signal{os.Interrupt}
+
+var restartSignals []os.Signal
@@ -0,0 +1,78 @@
+package gas
+
+import (
+	"strings"
+
+	"ktkr.us/pkg/gas/notify"
+)
+
+// setupSinks forwards events to the notification sinks configured in Env
+// until the returned func is called, which delivers the events still queued
+// for them and then flushes the ones holding on to events for a while.
+func setupSinks() (func(), error) {
+	var (
+		subs     []*Subscription
+		flushers []interface{ Flush() error }
+	)
+	forward := func(sink notify.Sink, kinds ...string) {
+		subs = append(subs, Forward(sink, kinds...))
+	}
+
+	if Env.SentryDSN != "" {
+		s, err := notify.NewSentry(Env.SentryDSN)
+		if err != nil {
+			return nil, err
+		}
+		s.Release = Env.Release
+		s.Environment = CurrentProfile().Name
+		s.SampleRate = Env.SentrySampleRate
+		forward(s, "panic", "server_error")
+	}
+
+	for _, u := range splitList(Env.WebhookURL) {
+		forward(&notify.Webhook{URL: u, Secret: Env.WebhookSecret}, splitList(Env.WebhookEvents)...)
+	}
+
+	for _, u := range splitList(Env.ChatWebhookURL) {
+		forward(&notify.Chat{URL: u}, splitList(Env.ChatEvents)...)
+	}
+
+	if Env.SMTPAddr != "" && Env.EmailTo != "" {
+		m := &notify.Email{
+			Addr:     Env.SMTPAddr,
+			Username: Env.SMTPUsername,
+			Password: Env.SMTPPassword,
+			From:     Env.EmailFrom,
+			To:       splitList(Env.EmailTo),
+			Digest:   Env.EmailDigest,
+		}
+		forward(m, splitList(Env.EmailEvents)...)
+		flushers = append(flushers, m)
+	}
+
+	if Env.AlertCommand != "" {
+		forward(&notify.Command{Command: Env.AlertCommand}, splitList(Env.AlertCommandEvents)...)
+	}
+
+	return func() {
+		for _, s := range subs {
+			s.Close()
+		}
+		for _, f := range flushers {
+			if err := f.Flush(); err != nil {
+				Logger().Error("notify", "err", err)
+			}
+		}
+	}, nil
+}
+
+// the non-empty elements of a comma separated list
+func splitList(list string) []string {
+	var elems []string
+	for _, elem := range strings.Split(list, ",") {
+		if elem = strings.TrimSpace(elem); elem != "" {
+			elems = append(elems, elem)
+		}
+	}
+	return elems
+}
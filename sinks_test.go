@@ -0,0 +1,117 @@
+package gas
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSetupSinks(t *testing.T) {
+	var sent int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&sent, 1)
+	}))
+	defer srv.Close()
+	defer func(u string) { Env.WebhookURL = u }(Env.WebhookURL)
+	Env.WebhookURL = srv.URL
+
+	before := len(Subscribers())
+	for i := 0; i < 2; i++ {
+		stop, err := setupSinks()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := len(Subscribers()) - before; n != 1 {
+			t.Fatalf("expected 1 subscriber for the webhook, got %d", n)
+		}
+		Publish(&Panic{Time: time.Now(), Err: errors.New("lol")})
+		stop()
+
+		if n := len(Subscribers()) - before; n != 0 {
+			t.Errorf("expected the webhook to be unsubscribed, got %d subscribers left", n)
+		}
+		if n := atomic.LoadInt32(&sent); n != int32(i+1) {
+			t.Errorf("expected %d webhooks sent, got %d", i+1, n)
+		}
+	}
+}
+
+// smtpServer accepts mail on a local address, sending the data of each
+// message to msgs.
+func smtpServer(t *testing.T, msgs chan<- string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				tc := textproto.NewConn(c)
+				tc.PrintfLine("220 localhost")
+				for {
+					line, err := tc.ReadLine()
+					if err != nil {
+						return
+					}
+					switch cmd := strings.ToUpper(strings.Fields(line + " ")[0]); cmd {
+					case "DATA":
+						tc.PrintfLine("354 go ahead")
+						data, err := tc.ReadDotBytes()
+						if err != nil {
+							return
+						}
+						msgs <- string(data)
+						tc.PrintfLine("250 ok")
+					case "QUIT":
+						tc.PrintfLine("221 bye")
+						return
+					default:
+						tc.PrintfLine("250 ok")
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestSetupSinksFlush(t *testing.T) {
+	msgs := make(chan string, 1)
+	saved := Env
+	defer func() { Env = saved }()
+	Env.SMTPAddr = smtpServer(t, msgs)
+	Env.EmailFrom = "gas@example.com"
+	Env.EmailTo = "ops@example.com"
+	Env.EmailDigest = time.Hour
+	Env.EmailEvents = "panic"
+
+	stop, err := setupSinks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// published right before shutdown, so it's still queued when stop is
+	// called
+	Publish(&Panic{Time: time.Now(), Err: errors.New("lol")})
+	stop()
+
+	select {
+	case msg := <-msgs:
+		if !strings.Contains(msg, "lol") {
+			t.Errorf("expected the panic in the digest, got %q", msg)
+		}
+	default:
+		t.Fatal("expected the digest to be sent when the sinks were stopped")
+	}
+}
@@ -0,0 +1,171 @@
+package gas
+
+import (
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Static configures how Router.Static serves files.
+type Static struct {
+	// The files to serve. An fs.FS such as an embed.FS can be served with
+	// http.FS; see StaticFS.
+	FS http.FileSystem
+
+	// The Cache-Control header to send with every file, e.g.
+	// "public, max-age=86400". Empty sends none.
+	CacheControl string
+
+	// The file to serve in place of a directory, if it has one. It defaults
+	// to "index.html"; set it to "-" to never serve one.
+	Index string
+
+	// Whether to list the files in a directory that has no index file.
+	// Without listings, such directories are 403 Forbidden.
+	Listings bool
+}
+
+// StaticHandler serves the files in dir under urlpath, e.g. a request for
+// /static/css/site.css with a urlpath of "/static" gets css/site.css from dir.
+// Directories are served with their index.html or listed otherwise. See
+// Static for more options.
+func (r *Router) StaticHandler(urlpath string, dir http.FileSystem) *Router {
+	return r.Static(urlpath, &Static{FS: dir, Listings: true})
+}
+
+// StaticFS is like StaticHandler, but serves the files in fsys, such as an
+// embed.FS, so that they can be built into the binary. Files embedded from a
+// directory are under that directory's name, which fs.Sub can strip off:
+//
+//	//go:embed static
+//	var static embed.FS
+//
+//	sub, _ := fs.Sub(static, "static")
+//	r.StaticFS("/static", sub)
+func (r *Router) StaticFS(urlpath string, fsys fs.FS) *Router {
+	return r.StaticHandler(urlpath, http.FS(fsys))
+}
+
+// Static serves files under urlpath as configured by s. Files that don't exist
+// are 404 Not Found and ones that can't be read are 403 Forbidden. Requests
+// for a directory without a trailing slash are redirected to add it, so that
+// relative links from its index file work. Range and conditional requests are
+// handled as with http.ServeContent. With a urlpath of "/", the root itself is
+// left for another route, such as a home page, to handle.
+func (r *Router) Static(urlpath string, s *Static) *Router {
+	root := strings.TrimSuffix(urlpath, "/") + "/"
+	handler := func(g *Gas) (int, Outputter) {
+		s.serve(g, path.Clean("/"+g.Arg("file")))
+		return g.Stop()
+	}
+	if root == "/" {
+		return r.Get("/{file...}", handler)
+	}
+	return r.Get(strings.TrimSuffix(root, "/"), handler).
+		Get(root, handler).
+		Get(root+"{file...}", handler)
+}
+
+func (s *Static) serve(g *Gas, name string) {
+	f, err := s.FS.Open(name)
+	if err != nil {
+		staticError(g, err)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		staticError(g, err)
+		return
+	}
+
+	if fi.IsDir() {
+		if !strings.HasSuffix(g.URL.Path, "/") {
+			u := *g.URL
+			u.Path += "/"
+			http.Redirect(g, g.Request, u.String(), http.StatusMovedPermanently)
+			return
+		}
+
+		index := s.Index
+		if index == "" {
+			index = "index.html"
+		}
+		if index != "-" {
+			if ff, err := s.FS.Open(path.Join(name, index)); err == nil {
+				defer ff.Close()
+				if ffi, err := ff.Stat(); err == nil && !ffi.IsDir() {
+					f, fi = ff, ffi
+				}
+			}
+		}
+		if fi.IsDir() {
+			if !s.Listings {
+				http.Error(g, "403 forbidden", http.StatusForbidden)
+				return
+			}
+			s.list(g, f)
+			return
+		}
+	}
+
+	if s.CacheControl != "" {
+		g.Header().Set("Cache-Control", s.CacheControl)
+	}
+	http.ServeContent(g, g.Request, fi.Name(), fi.ModTime(), f)
+}
+
+func staticError(g *Gas, err error) {
+	switch {
+	case os.IsNotExist(err):
+		http.Error(g, "404 page not found", http.StatusNotFound)
+	case os.IsPermission(err):
+		http.Error(g, "403 forbidden", http.StatusForbidden)
+	default:
+		g.Log().Error("static", "err", err)
+		http.Error(g, "500 internal server error", http.StatusInternalServerError)
+	}
+}
+
+func (s *Static) list(g *Gas, dir http.File) {
+	fis, err := dir.Readdir(-1)
+	if err != nil {
+		staticError(g, err)
+		return
+	}
+	sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+
+	names := make([]string, len(fis))
+	for i, fi := range fis {
+		names[i] = fi.Name()
+		if fi.IsDir() {
+			names[i] += "/"
+		}
+	}
+	g.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if s.CacheControl != "" {
+		g.Header().Set("Cache-Control", s.CacheControl)
+	}
+	listingTemplate.Execute(g, struct {
+		Path  string
+		Names []string
+	}{g.URL.Path, names})
+}
+
+var listingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+	<head><title>{{ .Path }}</title></head>
+	<body>
+		<h1>{{ .Path }}</h1>
+		<ul>
+			{{- range .Names }}
+			<li><a href="{{ . }}">{{ . }}</a></li>
+			{{- end }}
+		</ul>
+	</body>
+</html>
+`))
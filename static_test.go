@@ -0,0 +1,114 @@
+package gas
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestStatic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gas-static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, content := range map[string]string{
+		"a.txt":            "a",
+		"css/site.css":     "body{}",
+		"docs/index.html":  "<p>docs</p>",
+		"empty/.gitignore": "",
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err = ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := New().
+		Static("/static", &Static{FS: http.Dir(dir), CacheControl: "max-age=60"}).
+		StaticHandler("/files/", http.Dir(dir))
+
+	for _, test := range []struct {
+		url, body, location string
+		code                int
+	}{
+		{"/static/a.txt", "a", "", 200},
+		{"/static/css/site.css", "body{}", "", 200},
+		{"/static/docs/", "<p>docs</p>", "", 200},
+		{"/static/docs", "", "/static/docs/", 301},
+		{"/static", "", "/static/", 301},
+		{"/static/empty/", "", "", 403},
+		{"/static/nope.txt", "", "", 404},
+		{"/static/../route.go", "", "", 404},
+		{"/files/empty/", ".gitignore", "", 200},
+		{"/files/", "css/", "", 200},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", test.url, nil))
+		if w.Code != test.code || !strings.Contains(w.Body.String(), test.body) || w.Header().Get("Location") != test.location {
+			t.Errorf("%s: expected %d %q (location %q), got %d %q (location %q)",
+				test.url, test.code, test.body, test.location, w.Code, w.Body, w.Header().Get("Location"))
+		}
+		if test.code == 200 && strings.HasPrefix(test.url, "/static") && w.Header().Get("Cache-Control") != "max-age=60" {
+			t.Errorf("%s: expected Cache-Control, got %q", test.url, w.Header().Get("Cache-Control"))
+		}
+	}
+}
+
+func TestStaticFS(t *testing.T) {
+	r := New().StaticFS("/assets", fstest.MapFS{
+		"app.js":          {Data: []byte("run()")},
+		"img/index.html":  {Data: []byte("<p>images</p>")},
+		"img/favicon.ico": {Data: []byte("ico")},
+	})
+
+	for _, test := range []struct {
+		url, body string
+		code      int
+	}{
+		{"/assets/app.js", "run()", 200},
+		{"/assets/img/", "<p>images</p>", 200},
+		{"/assets/img/favicon.ico", "ico", 200},
+		{"/assets/nope.js", "", 404},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", test.url, nil))
+		if w.Code != test.code || !strings.Contains(w.Body.String(), test.body) {
+			t.Errorf("%s: expected %d %q, got %d %q", test.url, test.code, test.body, w.Code, w.Body)
+		}
+	}
+}
+
+func TestStaticRoot(t *testing.T) {
+	home := func(g *Gas) (int, Outputter) {
+		g.Write([]byte("home"))
+		return g.Stop()
+	}
+	r := New().Get("/", home).StaticFS("/", fstest.MapFS{
+		"robots.txt":      {Data: []byte("User-agent: *")},
+		"docs/index.html": {Data: []byte("<p>docs</p>")},
+	})
+
+	for _, test := range []struct {
+		url, body string
+		code      int
+	}{
+		{"/", "home", 200},
+		{"/robots.txt", "User-agent: *", 200},
+		{"/docs/", "<p>docs</p>", 200},
+		{"/nope.txt", "", 404},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", test.url, nil))
+		if w.Code != test.code || !strings.Contains(w.Body.String(), test.body) {
+			t.Errorf("%s: expected %d %q, got %d %q", test.url, test.code, test.body, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package gas
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Timeout sets how long the handlers of the most recently added route (both
+// of them, after Get) have to finish before the request is answered with 503
+// Service Unavailable, overriding GAS_HANDLER_TIMEOUT. Zero means no limit.
+//
+//	r.Post("/upload", upload).Timeout(5 * time.Minute)
+func (r *Router) Timeout(d time.Duration) *Router {
+	for _, route := range r.lastRoutes() {
+		route.timeout, route.hasTimeout = d, true
+	}
+	return r
+}
+
+// the routes added by the last call to Add or one of its wrappers, which all
+// have the same pattern
+func (r *Router) lastRoutes() []*route {
+	routes := r.root().routes
+	if len(routes) == 0 {
+		panic("gas: no routes have been added yet")
+	}
+	i := len(routes) - 1
+	for i > 0 && routes[i-1].pattern == routes[i].pattern {
+		i--
+	}
+	return routes[i:]
+}
+
+func (route *route) timeLimit() time.Duration {
+	if route.hasTimeout {
+		return route.timeout
+	}
+	return Env.HandlerTimeout
+}
+
+// runTimeout is like run, but gives up on the handlers after d. They run in
+// a goroutine on a copy of g, with its own copy of the data, whose context is
+// cancelled when time runs out, and their response is held back until they're
+// done so that it can be thrown away if they aren't finished in time. A panic
+// in the handlers is passed on to the caller as a *timedPanic.
+func (g *Gas) runTimeout(d time.Duration) {
+	ctx, cancel := context.WithTimeout(g.Request.Context(), d)
+	defer cancel()
+
+	var (
+		tw    = &timeoutWriter{h: make(http.Header)}
+		hg    = *g
+		done  = make(chan struct{})
+		nuked = make(chan *timedPanic, 1)
+	)
+	hg.w = tw
+	hg.Request = g.Request.WithContext(ctx)
+	// after a timeout, the handlers may keep setting data while the access
+	// log and events read g's
+	hg.data = maps.Clone(g.data)
+
+	go func() {
+		defer func() {
+			if nuke := recover(); nuke != nil {
+				// skipping callers, this func, and runtime.gopanic
+				nuked <- &timedPanic{nuke, callers(3, 32)}
+			}
+		}()
+		hg.run()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		w := g.w
+		*g = hg
+		g.w = w
+		tw.flush(w)
+	case nuke := <-nuked:
+		panic(nuke)
+	case <-ctx.Done():
+		tw.mu.Lock()
+		tw.timedOut = true
+		tw.mu.Unlock()
+		// the handlers are still going with hg, so g can't go back in the
+		// pool for another request to pick up
+		g.retained = true
+		g.Log().Warn("handler timed out", "timeout", d, "route", g.pattern)
+		g.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		g.WriteHeader(http.StatusServiceUnavailable)
+		g.Write([]byte("handler timed out\n"))
+	}
+}
+
+// timedPanic is a panic in handlers run by runTimeout, carried over to the
+// request's goroutine with the stack it happened on.
+type timedPanic struct {
+	value interface{}
+	pcs   []uintptr
+}
+
+// timeoutWriter holds a response until the handlers writing it are done.
+type timeoutWriter struct {
+	mu       sync.Mutex
+	h        http.Header
+	buf      bytes.Buffer
+	code     int
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.h
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.code == 0 {
+		tw.code = code
+	}
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	return tw.buf.Write(p)
+}
+
+// flush sends the held response to w.
+func (tw *timeoutWriter) flush(w http.ResponseWriter) {
+	for k, v := range tw.h {
+		w.Header()[k] = v
+	}
+	if tw.code != 0 {
+		w.WriteHeader(tw.code)
+	}
+	w.Write(tw.buf.Bytes())
+}
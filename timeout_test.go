@@ -0,0 +1,137 @@
+package gas
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	saved := Env.HandlerTimeout
+	defer func() { Env.HandlerTimeout = saved }()
+	Env.HandlerTimeout = 20 * time.Millisecond
+
+	cancelled := make(chan bool, 1)
+	slow := func(g *Gas) (int, Outputter) {
+		select {
+		case <-g.Context().Done():
+			cancelled <- true
+		case <-time.After(100 * time.Millisecond):
+			cancelled <- false
+		}
+		g.Write([]byte("slow"))
+		return g.Stop()
+	}
+	fast := func(g *Gas) (int, Outputter) {
+		g.Header().Set("X-Fast", "yes")
+		g.WriteHeader(201)
+		g.Write([]byte("fast"))
+		return g.Stop()
+	}
+
+	r := New().
+		Get("/slow", slow).
+		Get("/patient", slow).Timeout(0).
+		Get("/fast", fast).Timeout(time.Second).
+		Get("/panic", func(g *Gas) (int, Outputter) { panic("lol") })
+
+	serve := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+
+	if w := serve("/slow"); w.Code != 503 {
+		t.Errorf("/slow: expected 503, got %d %q", w.Code, w.Body)
+	}
+	if !<-cancelled {
+		t.Error("expected the context of a timed out handler to be cancelled")
+	}
+
+	if w := serve("/patient"); w.Code != 200 || w.Body.String() != "slow" {
+		t.Errorf("/patient: expected 200 slow, got %d %q", w.Code, w.Body)
+	}
+	<-cancelled
+
+	if w := serve("/fast"); w.Code != 201 || w.Body.String() != "fast" || w.Header().Get("X-Fast") != "yes" {
+		t.Errorf("/fast: expected 201 fast, got %d %q %v", w.Code, w.Body, w.Header())
+	}
+
+	if w := serve("/panic"); w.Code != 500 {
+		t.Errorf("/panic: expected 500, got %d", w.Code)
+	}
+}
+
+func TestTimeoutDataRace(t *testing.T) {
+	finished := make(chan struct{})
+	r := New().Get("/", func(g *Gas) (int, Outputter) {
+		g.SetUser("before")
+		return g.Continue()
+	}, func(g *Gas) (int, Outputter) {
+		defer close(finished)
+		<-g.Context().Done()
+		// still going after the deadline, while the request is answered
+		for i := 0; i < 1000; i++ {
+			g.SetUser("after")
+			g.SetData("i", i)
+		}
+		return g.Stop()
+	}).Timeout(10*time.Millisecond).
+		Get("/warm", func(g *Gas) (int, Outputter) {
+			// leaves a data map on the Gas going back into the pool
+			g.SetData("warm", true)
+			return 204, nil
+		})
+
+	var user string
+	r.AccessLog(func(e *AccessEntry) { user = e.User })
+
+	for i := 0; i < 5; i++ {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/warm", nil))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != 503 {
+			t.Errorf("expected 503, got %d", w.Code)
+		}
+		if user == "after" {
+			t.Error("expected the access log not to see data set after the timeout")
+		}
+		<-finished
+		finished = make(chan struct{})
+	}
+}
+
+func TestTimeoutPanic(t *testing.T) {
+	saved := Env.HandlerTimeout
+	defer func() { Env.HandlerTimeout = saved }()
+
+	var line int
+	var got *Panic
+	r := New().Get("/", func(g *Gas) (int, Outputter) {
+		_, _, line, _ = runtime.Caller(0)
+		panic("lol")
+	}).PanicHandler(func(g *Gas, p *Panic) {
+		got = p
+		g.WriteHeader(500)
+	})
+
+	// the panic is reported where it happened, whether or not the handler
+	// runs in a goroutine of its own
+	for _, d := range []time.Duration{0, time.Second} {
+		Env.HandlerTimeout = d
+		got = nil
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		if got == nil {
+			t.Fatalf("%v: expected a panic", d)
+		}
+		if filepath.Base(got.File) != "timeout_test.go" || got.Line != amountOfContext || !strings.Contains(got.Source[got.Line], "panic(") {
+			t.Errorf("%v: expected the panic at timeout_test.go:%d, got %s:%d %q", d, line+1, got.File, got.Line, got.Source)
+		}
+		if len(got.Frames) == 0 || got.Frames[0].Line != line+1 || !strings.Contains(got.Frames[0].Function, "TestTimeoutPanic") {
+			t.Errorf("%v: expected the frames to start at the handler, got %+v", d, got.Frames)
+		}
+	}
+}
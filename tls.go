@@ -0,0 +1,284 @@
+package gas
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsClientAuth = map[string]tls.ClientAuthType{
+	"request": tls.RequestClientCert,
+	"verify":  tls.VerifyClientCertIfGiven,
+	"require": tls.RequireAndVerifyClientCert,
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+// defaultCipherSuites are the TLS 1.2 suites used unless GAS_TLS_CIPHER_SUITES
+// says otherwise: only forward secret AEAD ones. TLS 1.3 suites can't be
+// configured.
+var defaultCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+func tlsConfig(certPath, keyPath, hostName string) (*tls.Config, error) {
+	var (
+		cfg  = &tls.Config{}
+		cert tls.Certificate
+		err  error
+	)
+
+	if certPath == "" && keyPath == "" && Env.TLSCertDir == "" && CurrentProfile().SelfSignedTLS {
+		Logger().Warn("tls: GAS_TLS_CERT isn't set, using a self-signed certificate")
+		if cert, err = selfSignedCert(hostName); err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	} else if cfg.Certificates, err = loadCerts(certPath, keyPath, Env.TLSCertDir); err != nil {
+		return nil, err
+	}
+	cfg.ServerName = hostName
+	cfg.BuildNameToCertificate()
+
+	if cfg.NextProtos == nil {
+		cfg.NextProtos = []string{"h2", "http/1.1"}
+	}
+
+	if err = tlsOptions(cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// loadCerts loads the certificate and key pairs from the lists of paths in
+// certPaths and keyPaths, separated by os.PathListSeparator, followed by those
+// in dir, where each name.crt has a name.key next to it. The client's SNI
+// picks which one is used, falling back to the first.
+func loadCerts(certPaths, keyPaths, dir string) ([]tls.Certificate, error) {
+	var certs, keys []string
+	if certPaths != "" || keyPaths != "" {
+		certs = filepath.SplitList(certPaths)
+		keys = filepath.SplitList(keyPaths)
+		if len(certs) != len(keys) {
+			return nil, errors.Errorf("tls: %d certificates given with %d keys", len(certs), len(keys))
+		}
+	}
+
+	if dir != "" {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.crt"))
+		if err != nil {
+			return nil, errors.Wrap(err, "tls")
+		}
+		if len(paths) == 0 {
+			return nil, errors.Errorf("tls: no certificates in %s", dir)
+		}
+		for _, path := range paths {
+			certs = append(certs, path)
+			keys = append(keys, strings.TrimSuffix(path, ".crt")+".key")
+		}
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("tls: no certificates given")
+	}
+
+	pairs := make([]tls.Certificate, len(certs))
+	for i := range certs {
+		cert, err := tls.LoadX509KeyPair(certs[i], keys[i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "tls: %s", certs[i])
+		}
+		pairs[i] = cert
+	}
+	return pairs, nil
+}
+
+// tlsOptions applies the protocol settings from Env to cfg.
+func tlsOptions(cfg *tls.Config) error {
+	var ok bool
+
+	if cfg.MinVersion, ok = tlsVersions[Env.TLSMinVersion]; !ok {
+		return errors.Errorf("GAS_TLS_MIN_VERSION: unknown TLS version %q", Env.TLSMinVersion)
+	}
+	if Env.TLSMaxVersion != "" {
+		if cfg.MaxVersion, ok = tlsVersions[Env.TLSMaxVersion]; !ok {
+			return errors.Errorf("GAS_TLS_MAX_VERSION: unknown TLS version %q", Env.TLSMaxVersion)
+		}
+		if cfg.MaxVersion < cfg.MinVersion {
+			return errors.New("GAS_TLS_MAX_VERSION is lower than GAS_TLS_MIN_VERSION")
+		}
+	}
+
+	cfg.CipherSuites = defaultCipherSuites
+	if Env.TLSCipherSuites != "" {
+		suites := make(map[string]uint16)
+		for _, s := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+			suites[s.Name] = s.ID
+		}
+		cfg.CipherSuites = nil
+		for _, name := range strings.Split(Env.TLSCipherSuites, ",") {
+			id, ok := suites[strings.TrimSpace(name)]
+			if !ok {
+				return errors.Errorf("GAS_TLS_CIPHER_SUITES: unknown cipher suite %q", name)
+			}
+			cfg.CipherSuites = append(cfg.CipherSuites, id)
+		}
+	}
+
+	if Env.TLSCurves != "" {
+		for _, name := range strings.Split(Env.TLSCurves, ",") {
+			id, ok := tlsCurves[strings.TrimSpace(name)]
+			if !ok {
+				return errors.Errorf("GAS_TLS_CURVES: unknown curve %q", name)
+			}
+			cfg.CurvePreferences = append(cfg.CurvePreferences, id)
+		}
+	}
+
+	return clientAuth(cfg)
+}
+
+// clientAuth sets up client certificate checking on cfg from
+// GAS_TLS_CLIENT_CA and GAS_TLS_CLIENT_AUTH.
+func clientAuth(cfg *tls.Config) error {
+	mode := Env.TLSClientAuth
+	if mode == "" {
+		if Env.TLSClientCA == "" {
+			return nil
+		}
+		mode = "require"
+	}
+	auth, ok := tlsClientAuth[mode]
+	if !ok {
+		return errors.Errorf("GAS_TLS_CLIENT_AUTH: unknown mode %q", mode)
+	}
+	cfg.ClientAuth = auth
+	if auth == tls.RequestClientCert {
+		return nil
+	}
+
+	if Env.TLSClientCA == "" {
+		return errors.Errorf("GAS_TLS_CLIENT_AUTH=%s needs GAS_TLS_CLIENT_CA", mode)
+	}
+	b, err := ioutil.ReadFile(Env.TLSClientCA)
+	if err != nil {
+		return errors.Wrap(err, "GAS_TLS_CLIENT_CA")
+	}
+	cfg.ClientCAs = x509.NewCertPool()
+	if !cfg.ClientCAs.AppendCertsFromPEM(b) {
+		return errors.Errorf("GAS_TLS_CLIENT_CA: no certificates in %s", Env.TLSClientCA)
+	}
+	return nil
+}
+
+// rotateTicketKeys replaces the session ticket key of cfg with a new random
+// one every GAS_TLS_TICKET_KEY_ROTATION until ctx is done, if it's set.
+// Tickets issued with the previous key are still accepted until it is
+// replaced in turn, so resumption keeps working across one rotation.
+func rotateTicketKeys(ctx context.Context, cfg *tls.Config) error {
+	interval := Env.TLSTicketKeyRotation
+	if interval <= 0 {
+		return nil
+	}
+
+	newKey := func() (key [32]byte, err error) {
+		_, err = rand.Read(key[:])
+		return
+	}
+
+	current, err := newKey()
+	if err != nil {
+		return errors.Wrap(err, "tls: session ticket key")
+	}
+	cfg.SetSessionTicketKeys([][32]byte{current})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			next, err := newKey()
+			if err != nil {
+				Logger().Error("tls: rotating session ticket keys", "err", err)
+				continue
+			}
+			cfg.SetSessionTicketKeys([][32]byte{next, current})
+			current = next
+		}
+	}()
+	return nil
+}
+
+// selfSignedCert makes up a certificate for development use that is valid
+// for localhost, the loopback addresses, and host if it's given.
+func selfSignedCert(host string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, errors.Wrap(err, "tls: self-signed certificate")
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, errors.Wrap(err, "tls: self-signed certificate")
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"gas development"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(0, 1, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if host != "" {
+		if ip := net.ParseIP(host); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, errors.Wrap(err, "tls: self-signed certificate")
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,286 @@
+package gas
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestTLSOptions(t *testing.T) {
+	saved := Env
+	defer func() { Env = saved }()
+
+	cfg := &tls.Config{}
+	if err := tlsOptions(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MinVersion != tls.VersionTLS12 || cfg.MaxVersion != 0 {
+		t.Errorf("expected TLS 1.2 and up by default, got %x-%x", cfg.MinVersion, cfg.MaxVersion)
+	}
+	if !reflect.DeepEqual(cfg.CipherSuites, defaultCipherSuites) {
+		t.Errorf("expected default cipher suites, got %v", cfg.CipherSuites)
+	}
+
+	Env.TLSMinVersion = "1.3"
+	Env.TLSMaxVersion = "1.3"
+	Env.TLSCipherSuites = "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_RSA_WITH_AES_128_CBC_SHA"
+	Env.TLSCurves = "X25519,P384"
+	cfg = &tls.Config{}
+	if err := tlsOptions(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MinVersion != tls.VersionTLS13 || cfg.MaxVersion != tls.VersionTLS13 {
+		t.Errorf("expected TLS 1.3 only, got %x-%x", cfg.MinVersion, cfg.MaxVersion)
+	}
+	suites := []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_AES_128_CBC_SHA}
+	if !reflect.DeepEqual(cfg.CipherSuites, suites) {
+		t.Errorf("expected cipher suites %v, got %v", suites, cfg.CipherSuites)
+	}
+	curves := []tls.CurveID{tls.X25519, tls.CurveP384}
+	if !reflect.DeepEqual(cfg.CurvePreferences, curves) {
+		t.Errorf("expected curves %v, got %v", curves, cfg.CurvePreferences)
+	}
+
+	for _, test := range []struct{ min, max, suites, curves string }{
+		{"1.4", "", "", ""},
+		{"1.3", "1.2", "", ""},
+		{"1.2", "", "TLS_BOGUS", ""},
+		{"1.2", "", "", "P128"},
+	} {
+		Env.TLSMinVersion, Env.TLSMaxVersion = test.min, test.max
+		Env.TLSCipherSuites, Env.TLSCurves = test.suites, test.curves
+		if err := tlsOptions(&tls.Config{}); err == nil {
+			t.Errorf("%+v: expected error", test)
+		}
+	}
+}
+
+func TestRotateTicketKeys(t *testing.T) {
+	defer func(d time.Duration) { Env.TLSTicketKeyRotation = d }(Env.TLSTicketKeyRotation)
+	Env.TLSTicketKeyRotation = time.Millisecond
+
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := rotateTicketKeys(ctx, &tls.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	cancel()
+
+	for i := 0; runtime.NumGoroutine() > before; i++ {
+		if i > 100 {
+			t.Fatal("keys still being rotated after ctx is done")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSelfSignedCert(t *testing.T) {
+	cert, err := selfSignedCert("dev.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"localhost", "127.0.0.1", "::1", "dev.example.com"} {
+		if err = leaf.VerifyHostname(host); err != nil {
+			t.Error(err)
+		}
+	}
+
+	saved := Env
+	defer func() { Env = saved }()
+	Env.Env = "prod"
+	if _, err = tlsConfig("", "", ""); err == nil {
+		t.Error("expected error for missing certificate outside dev")
+	}
+	Env.Env = "dev"
+	cfg, err := tlsConfig("", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Certificates) != 1 {
+		t.Errorf("expected a self-signed certificate in dev, got %d", len(cfg.Certificates))
+	}
+}
+
+func TestLoadCerts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gas-certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, host string) {
+		cert, err := selfSignedCert(host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+		keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key})
+		if err = ioutil.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a", "a.example.com")
+	write("b", "b.example.com")
+
+	path := func(name string) string { return filepath.Join(dir, name) }
+	list := path("a.crt") + string(os.PathListSeparator) + path("b.crt")
+
+	if _, err = loadCerts(list, path("a.key"), ""); err == nil {
+		t.Error("expected an error for mismatched lists")
+	}
+	if _, err = loadCerts("", "", ""); err == nil {
+		t.Error("expected an error for no certificates")
+	}
+
+	certs, err := loadCerts(path("a.crt"), path("a.key"), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 3 {
+		t.Fatalf("expected 3 certificates, got %d", len(certs))
+	}
+
+	saved := Env
+	defer func() { Env = saved }()
+	Env.TLSCertDir = dir
+	cfg, err := tlsConfig("", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"a.example.com", "b.example.com"} {
+		client, server := net.Pipe()
+		go tls.Server(server, cfg).Handshake()
+		conn := tls.Client(client, &tls.Config{ServerName: host, InsecureSkipVerify: true})
+		if err = conn.Handshake(); err != nil {
+			t.Fatal(err)
+		}
+		if err = conn.ConnectionState().PeerCertificates[0].VerifyHostname(host); err != nil {
+			t.Error(err)
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestClientAuth(t *testing.T) {
+	saved := Env
+	defer func() { Env = saved }()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "alice"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, &clientKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ioutil.TempFile("", "gas-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	f.Close()
+
+	Env.TLSClientAuth = "verify"
+	if err = clientAuth(&tls.Config{}); err == nil {
+		t.Error("expected an error for verify without a CA")
+	}
+	Env.TLSClientAuth = "bogus"
+	if err = clientAuth(&tls.Config{}); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+	Env.TLSClientAuth = ""
+	Env.TLSClientCA = f.Name()
+
+	srv := httptest.NewUnstartedServer(New().Get("/", func(g *Gas) (int, Outputter) {
+		if cert := g.ClientCert(); cert != nil {
+			g.Write([]byte(cert.Subject.CommonName))
+		}
+		return g.Stop()
+	}))
+	if srv.TLS, err = tlsConfig("", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if srv.TLS.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("expected client certificates to be required, got %v", srv.TLS.ClientAuth)
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	client := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			Certificates:       certs,
+		}}}
+	}
+
+	resp, err := client(tls.Certificate{Certificate: [][]byte{clientDER}, PrivateKey: clientKey}).Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "alice" {
+		t.Errorf("expected the client certificate to be alice's, got %q", body)
+	}
+
+	if _, err = client().Get(srv.URL); err == nil {
+		t.Error("expected a client without a certificate to be turned away")
+	}
+}
@@ -0,0 +1,85 @@
+package gas
+
+// A node is part of a radix tree indexing routes by the literal text at the
+// start of their patterns, which is the part every URL they match has to start
+// with. Looking up a URL only turns up the routes that could possibly match
+// it, so they don't all have to be tried in turn.
+type node struct {
+	prefix   string
+	children []*node
+
+	// the routes whose literal text ends at this node
+	routes []*route
+}
+
+// insert adds r to the tree under key.
+func (n *node) insert(key string, r *route) {
+	for {
+		if key == "" {
+			n.routes = append(n.routes, r)
+			return
+		}
+
+		var child *node
+		for _, c := range n.children {
+			if c.prefix[0] == key[0] {
+				child = c
+				break
+			}
+		}
+		if child == nil {
+			n.children = append(n.children, &node{prefix: key, routes: []*route{r}})
+			return
+		}
+
+		i := commonPrefix(key, child.prefix)
+		if i < len(child.prefix) {
+			// split the child so that its prefix is the part in common
+			split := &node{
+				prefix:   child.prefix[i:],
+				children: child.children,
+				routes:   child.routes,
+			}
+			child.prefix = child.prefix[:i]
+			child.children = []*node{split}
+			child.routes = nil
+		}
+		n, key = child, key[i:]
+	}
+}
+
+// lookup appends the routes that could match path to routes, in the order
+// they were added.
+func (n *node) lookup(path string, routes []*route) []*route {
+	for {
+		routes = append(routes, n.routes...)
+
+		var child *node
+		for _, c := range n.children {
+			if len(path) >= len(c.prefix) && path[:len(c.prefix)] == c.prefix {
+				child = c
+				break
+			}
+		}
+		if child == nil {
+			break
+		}
+		n, path = child, path[len(child.prefix):]
+	}
+	// an insertion sort, since there are only ever a few and sort.Slice
+	// allocates
+	for i := 1; i < len(routes); i++ {
+		for j := i; j > 0 && routes[j].index < routes[j-1].index; j-- {
+			routes[j], routes[j-1] = routes[j-1], routes[j]
+		}
+	}
+	return routes
+}
+
+func commonPrefix(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,125 @@
+package gas
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestTreeLookup(t *testing.T) {
+	var (
+		tree   = new(node)
+		routes []*route
+	)
+	for i, pat := range []string{"/blog/{id}", "/", "/blog", "/bl{x}", "/blog/view/{id}", "/files{path}", "{all}"} {
+		r := newRoute("GET", pat, nil)
+		r.index = i
+		routes = append(routes, r)
+		tree.insert(r.literal(), r)
+	}
+
+	for _, test := range []struct {
+		path string
+		want []int
+	}{
+		{"/blog/view/1", []int{0, 1, 2, 3, 4, 6}},
+		{"/blob", []int{1, 3, 6}},
+		{"/files/a", []int{1, 5, 6}},
+		{"x", []int{6}},
+	} {
+		got := tree.lookup(test.path, nil)
+		ok := len(got) == len(test.want)
+		for i := 0; ok && i < len(got); i++ {
+			ok = got[i] == routes[test.want[i]]
+		}
+		if !ok {
+			t.Errorf("%s: expected routes %v, got %v", test.path, test.want, got)
+		}
+	}
+}
+
+func TestRouterPrecedence(t *testing.T) {
+	h := func(s string) Handler {
+		return func(g *Gas) (int, Outputter) {
+			g.Write([]byte(s))
+			return g.Stop()
+		}
+	}
+	r := New().
+		Get("/blog/{id}", h("id")).
+		Get("/blog/new", h("new")).
+		Get("/b{rest}", h("rest")).
+		Post("/blog/new", h("post"))
+
+	for url, want := range map[string]string{
+		"/blog/new": "new",
+		"/blog/3":   "id",
+		"/bar":      "rest",
+	} {
+		req := httptest.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Body.String() != want {
+			t.Errorf("%s: expected %q, got %q", url, want, w.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest("PUT", "/blog/new", nil)
+	if _, route := r.match(req); route != nil {
+		t.Errorf("PUT matched %v", route)
+	}
+}
+
+func BenchmarkRouterMatch(b *testing.B) {
+	r := New()
+	h := func(g *Gas) (int, Outputter) { return g.Stop() }
+	for i := 0; i < 500; i++ {
+		r.Get("/section"+strconv.Itoa(i)+"/view/{id}", h)
+	}
+	req := httptest.NewRequest("GET", "/section499/view/123", nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.match(req)
+	}
+}
+
+func BenchmarkRouterMatchStatic(b *testing.B) {
+	r := New()
+	h := func(g *Gas) (int, Outputter) { return g.Stop() }
+	for i := 0; i < 500; i++ {
+		r.Get("/section"+strconv.Itoa(i)+"/view/{id}", h)
+		r.Get("/section"+strconv.Itoa(i)+"/about", h)
+	}
+	req := httptest.NewRequest("GET", "/section499/about", nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.match(req)
+	}
+}
+
+func TestMatchAllocs(t *testing.T) {
+	h := func(g *Gas) (int, Outputter) { return g.Stop() }
+	r := New().
+		Get("/about", h).
+		Get("/users/{id}", h).
+		Get("/users/{id}/posts/{post:[0-9]+}", h)
+
+	for _, test := range []struct {
+		path   string
+		allocs float64
+	}{
+		{"/about", 0},
+		{"/users/1", 1},
+	} {
+		req := httptest.NewRequest("GET", test.path, nil)
+		if n := testing.AllocsPerRun(100, func() { r.match(req) }); n != test.allocs {
+			t.Errorf("%s: expected %v allocations, got %v", test.path, test.allocs, n)
+		}
+	}
+	args, _ := r.matchPath("GET", "/users/1/posts/2")
+	if len(args) != 2 || args[0] != (arg{"id", "1"}) || args[1] != (arg{"post", "2"}) {
+		t.Errorf("unexpected args %v", args)
+	}
+}
@@ -0,0 +1,267 @@
+package gas
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A Validator checks itself for anything the validate tags can't express,
+// e.g. that two fields match. Validate calls it after checking the tags. A
+// ValidationErrors or *FieldError it returns is kept as is, and any other
+// error becomes a FieldError without a field.
+type Validator interface {
+	Validate() error
+}
+
+// A FieldError is a value that didn't pass a rule.
+type FieldError struct {
+	Field   string `json:"field"`           // the name, as in the form, or dotted inside a nested struct
+	Rule    string `json:"rule"`            // e.g. "required" or "min"
+	Param   string `json:"param,omitempty"` // what came after the = in the rule
+	Message string `json:"message"`         // for showing to users, e.g. "must be at least 3 characters"
+}
+
+func (e *FieldError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + " " + e.Message
+}
+
+// ValidationErrors are all of the fields that failed validation, in the order
+// of the struct. It can be given to out.JSON as is, or put into a template's
+// data to show each field's message next to it:
+//
+//	{{with .Errors.Field "email"}}<p class="error">{{.}}</p>{{end}}
+type ValidationErrors []FieldError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i := range errs {
+		msgs[i] = errs[i].Error()
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+// Field returns the message of the first error for the named field, or "" if
+// it passed.
+func (errs ValidationErrors) Field(name string) string {
+	for _, e := range errs {
+		if e.Field == name {
+			return e.Message
+		}
+	}
+	return ""
+}
+
+// Validate checks the fields of the struct v points to against the rules in
+// their "validate" tags, then calls its Validate method if it's a Validator.
+// Failures are returned together as ValidationErrors, with fields named by
+// their "json" tags, or else their names. UnmarshalForm and UnmarshalArgs call
+// it themselves, naming fields as they're named in the form or route.
+//
+// Rules are separated by commas:
+//
+//	required    must not be the zero value
+//	min=n       at least n: characters of a string, items of a slice or map,
+//	            or the value of a number
+//	max=n       at most n, likewise
+//	len=n       exactly n characters or items
+//	email       an email address
+//	url         an absolute URL
+//	oneof=a b   one of the values separated by spaces
+//
+// Rules other than required are skipped for fields left empty, so that
+// optional fields only have to be valid when they're given. Nested structs and
+// non-nil pointers to structs are checked as well.
+//
+//	type signup struct {
+//		Name  string `form:"name" validate:"required,min=3,max=32"`
+//		Email string `form:"email" validate:"required,email"`
+//		Plan  string `form:"plan" validate:"oneof=free pro"`
+//	}
+func Validate(v interface{}) error {
+	return validate(v, "json")
+}
+
+func validate(v interface{}, tag string) error {
+	dv := reflect.ValueOf(v)
+	if dv.Kind() != reflect.Ptr || dv.Elem().Kind() != reflect.Struct {
+		return errors.New("Validate: v must be a pointer to a struct value")
+	}
+	var errs ValidationErrors
+	if err := validateStruct(dv.Elem(), "", tag, &errs); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateStruct(dv reflect.Value, prefix, tag string, errs *ValidationErrors) error {
+	dt := dv.Type()
+
+	for i := 0; i < dv.NumField(); i++ {
+		field := dv.Field(i)
+		tf := dt.Field(i)
+		if tf.PkgPath != "" {
+			continue // unexported
+		}
+		name, _, _ := strings.Cut(tf.Tag.Get(tag), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = tf.Name
+		}
+		name = prefix + name
+
+		if rules := tf.Tag.Get("validate"); rules != "" {
+			if err := validateField(field, name, rules, errs); err != nil {
+				return err
+			}
+		}
+
+		if nested(field.Type()) {
+			if field.Kind() == reflect.Ptr {
+				if field.IsNil() {
+					continue
+				}
+				field = field.Elem()
+			}
+			if err := validateStruct(field, name+".", tag, errs); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !dv.CanAddr() {
+		return nil
+	}
+	v, ok := dv.Addr().Interface().(Validator)
+	if !ok {
+		return nil
+	}
+	var found ValidationErrors
+	switch err := v.Validate().(type) {
+	case nil:
+	case ValidationErrors:
+		found = err
+	case *FieldError:
+		found = ValidationErrors{*err}
+	default:
+		found = ValidationErrors{{Rule: "custom", Message: err.Error()}}
+	}
+	// the names a nested struct gives are under its own
+	for _, e := range found {
+		if e.Field == "" {
+			e.Field = strings.TrimSuffix(prefix, ".")
+		} else {
+			e.Field = prefix + e.Field
+		}
+		*errs = append(*errs, e)
+	}
+	return nil
+}
+
+// validateField checks field against its rules, adding the first one that
+// fails to errs. An error is only returned for a rule that can't be used.
+func validateField(field reflect.Value, name, rules string, errs *ValidationErrors) error {
+	empty := field.IsZero()
+	for _, rule := range strings.Split(rules, ",") {
+		rule, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		if rule == "required" {
+			if empty {
+				*errs = append(*errs, FieldError{name, rule, "", "is required"})
+				return nil
+			}
+			continue
+		}
+		if empty {
+			continue
+		}
+		msg, err := checkRule(field, rule, param)
+		if err != nil {
+			return fmt.Errorf("Validate: field '%s': %v", name, err)
+		}
+		if msg != "" {
+			*errs = append(*errs, FieldError{name, rule, param, msg})
+			return nil
+		}
+	}
+	return nil
+}
+
+// checkRule returns the message for field failing rule, or "" if it passes.
+func checkRule(field reflect.Value, rule, param string) (string, error) {
+	for field.Kind() == reflect.Ptr {
+		field = field.Elem()
+	}
+	switch rule {
+	case "min", "max", "len":
+		n, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid %s %q", rule, param)
+		}
+		x, unit, ok := measure(field)
+		if !ok {
+			return "", fmt.Errorf("%s can't be used on %s", rule, field.Type())
+		}
+		switch {
+		case rule == "min" && x < n:
+			return "must be at least " + param + unit, nil
+		case rule == "max" && x > n:
+			return "must be at most " + param + unit, nil
+		case rule == "len" && x != n:
+			return "must be exactly " + param + unit, nil
+		}
+	case "email", "url", "oneof":
+		if field.Kind() != reflect.String {
+			return "", fmt.Errorf("%s can't be used on %s", rule, field.Type())
+		}
+		s := field.String()
+		switch rule {
+		case "email":
+			if a, err := mail.ParseAddress(s); err != nil || a.Address != s {
+				return "must be an email address", nil
+			}
+		case "url":
+			if u, err := url.Parse(s); err != nil || u.Scheme == "" || u.Host == "" {
+				return "must be a URL", nil
+			}
+		case "oneof":
+			options := strings.Fields(param)
+			if !contains(options, s) {
+				return "must be one of " + strings.Join(options, ", "), nil
+			}
+		}
+	default:
+		return "", fmt.Errorf("unknown rule %q", rule)
+	}
+	return "", nil
+}
+
+// measure gives what min, max, and len compare against for v, and the unit
+// to put in messages.
+func measure(v reflect.Value) (x float64, unit string, ok bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), " characters", true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), " items", true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), "", true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), "", true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), "", true
+	}
+	return 0, "", false
+}
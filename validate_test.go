@@ -0,0 +1,108 @@
+package gas
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type signup struct {
+	Name     string   `json:"name" form:"name" validate:"required,min=3,max=8"`
+	Email    string   `json:"email" form:"email" validate:"required,email"`
+	Site     string   `json:"site" form:"site" validate:"url"`
+	Plan     string   `json:"plan" form:"plan" validate:"oneof=free pro"`
+	Age      int      `json:"age" form:"age" validate:"min=13"`
+	Tags     []string `json:"tags" form:"tags" validate:"max=2"`
+	Password string   `json:"-" form:"password"`
+	Confirm  string   `json:"-" form:"confirm"`
+	Address  struct {
+		Zip string `json:"zip" form:"zip" validate:"len=5"`
+	} `json:"address" form:"address"`
+}
+
+func (s *signup) Validate() error {
+	if s.Password != s.Confirm {
+		return &FieldError{Field: "confirm", Rule: "match", Message: "must match the password"}
+	}
+	return nil
+}
+
+func TestValidate(t *testing.T) {
+	valid := signup{Name: "alice", Email: "alice@example.com", Age: 20}
+	if err := Validate(&valid); err != nil {
+		t.Errorf("expected no errors, got %v", err)
+	}
+
+	v := signup{
+		Name:     "al",
+		Email:    "alice",
+		Site:     "example.com",
+		Plan:     "gold",
+		Age:      12,
+		Tags:     []string{"a", "b", "c"},
+		Password: "x",
+	}
+	v.Address.Zip = "123"
+	err := Validate(&v)
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected ValidationErrors, got %v", err)
+	}
+	expected := ValidationErrors{
+		{"name", "min", "3", "must be at least 3 characters"},
+		{"email", "email", "", "must be an email address"},
+		{"site", "url", "", "must be a URL"},
+		{"plan", "oneof", "free pro", "must be one of free, pro"},
+		{"age", "min", "13", "must be at least 13"},
+		{"tags", "max", "2", "must be at most 2 items"},
+		{"address.zip", "len", "5", "must be exactly 5 characters"},
+		{"confirm", "match", "", "must match the password"},
+	}
+	if !reflect.DeepEqual(errs, expected) {
+		t.Errorf("got %+v, expected %+v", errs, expected)
+	}
+	if msg := errs.Field("email"); msg != "must be an email address" {
+		t.Errorf("unexpected message for email: %q", msg)
+	}
+	if msg := errs.Field("age "); msg != "" {
+		t.Errorf("expected no message, got %q", msg)
+	}
+	b, _ := json.Marshal(errs[:1])
+	if s := string(b); s != `[{"field":"name","rule":"min","param":"3","message":"must be at least 3 characters"}]` {
+		t.Errorf("unexpected JSON: %s", s)
+	}
+
+	if err := Validate(&signup{}); err.(ValidationErrors).Field("name") != "is required" {
+		t.Errorf("expected name to be required, got %v", err)
+	}
+
+	var bad struct {
+		N int `validate:"email"`
+	}
+	bad.N = 1
+	if err := Validate(&bad); err == nil || errors.As(err, &errs) {
+		t.Errorf("expected an error for a misused rule, got %v", err)
+	}
+}
+
+func TestUnmarshalFormValidate(t *testing.T) {
+	var err error
+	r := New().Get("/", func(g *Gas) (int, Outputter) {
+		var v signup
+		err = g.UnmarshalForm(&v)
+		return g.Stop()
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?name=alice&email=alice@example.com&address[zip]=12345", nil))
+	if err != nil {
+		t.Errorf("expected no errors, got %v", err)
+	}
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?name=alice&email=alice@example.com&address[zip]=1&password=a", nil))
+	var errs ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 2 || errs.Field("address.zip") == "" || errs.Field("confirm") == "" {
+		t.Errorf("expected errors for address.zip and confirm, got %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package gas
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/websocket"
+)
+
+// Websocket upgrades requests to WebSocket connections and hands them to its
+// Handler. The connection stays part of the request, so middleware runs
+// before the upgrade like for any other route, and the access log entry is
+// written once the handler returns and the connection is closed, with a
+// status of 101.
+//
+// Routes with a timeout (see Router.Timeout and Env.HandlerTimeout) or behind
+// the ETags middleware can't be upgraded, since their responses are buffered.
+type Websocket struct {
+	// The origins allowed to connect besides the request's own host, e.g.
+	// "https://example.com", or "*" for any origin. Requests without an
+	// Origin header, which browsers always send, are allowed.
+	Origins []string
+
+	// Handler is given the connection once it's been upgraded. The
+	// connection is closed when it returns.
+	Handler func(g *Gas, ws *websocket.Conn)
+}
+
+// WebsocketHandler returns a handler that upgrades same-origin requests to
+// WebSocket connections and passes them to f.
+//
+//	r.Get("/echo", gas.WebsocketHandler(func(g *gas.Gas, ws *websocket.Conn) {
+//		io.Copy(ws, ws)
+//	}))
+func WebsocketHandler(f func(g *Gas, ws *websocket.Conn)) Handler {
+	return (&Websocket{Handler: f}).Handle
+}
+
+// Handle is a Handler that upgrades the request. Requests that aren't
+// WebSocket handshakes are 400 Bad Request, and ones from an origin that isn't
+// allowed are 403 Forbidden.
+func (s *Websocket) Handle(g *Gas) (int, Outputter) {
+	if !headerHas(g.Request.Header, "Connection", "upgrade") ||
+		!strings.EqualFold(g.Request.Header.Get("Upgrade"), "websocket") {
+		http.Error(g, "expected a WebSocket handshake", http.StatusBadRequest)
+		return g.Stop()
+	}
+	if !s.allowsOrigin(g) {
+		http.Error(g, "origin not allowed", http.StatusForbidden)
+		return g.Stop()
+	}
+
+	websocket.Server{
+		Handler: func(ws *websocket.Conn) { s.Handler(g, ws) },
+	}.ServeHTTP(g, g.Request)
+	return g.Stop()
+}
+
+func (s *Websocket) allowsOrigin(g *Gas) bool {
+	origin := g.Request.Header.Get("Origin")
+	if origin == "" || contains(s.Origins, "*") || contains(s.Origins, origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, g.Request.Host)
+}
+
+// headerHas is whether the comma-separated header name has token in it.
+func headerHas(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package gas
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestWebsocket(t *testing.T) {
+	var pattern string
+	r := New().
+		Use(func(g *Gas) (int, Outputter) {
+			g.SetData("user", "alice")
+			return g.Continue()
+		}).
+		Get("/echo/{room}", WebsocketHandler(func(g *Gas, ws *websocket.Conn) {
+			pattern = g.pattern
+			io.WriteString(ws, g.Arg("room")+" "+g.Data("user").(string)+": ")
+			io.Copy(ws, ws)
+		})).
+		Get("/open", (&Websocket{
+			Origins: []string{"https://example.com"},
+			Handler: func(g *Gas, ws *websocket.Conn) { io.WriteString(ws, "hi") },
+		}).Handle)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	ws, err := websocket.Dial(wsURL+"/echo/lobby", "", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(ws, "hello")
+	buf := make([]byte, 64)
+	n, err := io.ReadAtLeast(ws, buf, len("lobby alice: hello"))
+	if err != nil || string(buf[:n]) != "lobby alice: hello" {
+		t.Errorf("expected echo, got %q (%v)", buf[:n], err)
+	}
+	ws.Close()
+	if pattern != "/echo/{room}" {
+		t.Errorf("expected the route's pattern, got %q", pattern)
+	}
+
+	if _, err = websocket.Dial(wsURL+"/echo/lobby", "", "https://evil.example"); err == nil {
+		t.Error("expected a cross-origin connection to fail")
+	}
+	ws, err = websocket.Dial(wsURL+"/open", "", "https://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, _ = io.ReadAtLeast(ws, buf, 2)
+	if string(buf[:n]) != "hi" {
+		t.Errorf("expected hi, got %q", buf[:n])
+	}
+	ws.Close()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/echo/lobby", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected a plain request to be %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}